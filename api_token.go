@@ -0,0 +1,272 @@
+package csnotes
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Every personal access token starts with this prefix, which is how
+	// they are told apart from JWTs.
+	API_TOKEN_PREFIX = "pat_"
+
+	// The layout MySQL uses for DATETIME values.
+	DATETIME_FORMAT = "2006-01-02 15:04:05"
+)
+
+// VALID_SCOPES lists every scope that a personal access token may be given.
+// A write scope implies the matching read scope.
+var VALID_SCOPES = []string {
+	"notes:read", "notes:write",
+	"tags:read", "tags:write",
+	"users:read", "users:write",
+}
+
+// SCOPE_RESOURCES maps the first segment of an /api path, or the third for
+// nested paths, to the resource name its scopes use. Paths not listed here
+// cannot be reached with a personal access token at all.
+var SCOPE_RESOURCES = map[string]string {
+	"export": "notes",
+	"graph": "notes",
+	"import": "notes",
+	"note": "notes",
+	"reminder": "notes",
+	"tag": "tags",
+	"user": "users",
+
+	// Parts of a note, under /note/{id}.
+	"attachment": "notes",
+	"backlinks": "notes",
+	"convert": "notes",
+	"cover": "notes",
+	"item": "notes",
+	"links": "notes",
+}
+
+// APIToken is a long-lived personal access token that a user creates for
+// scripts and integrations. Only a hash of the token is stored.
+type APIToken struct {
+	Resource
+	Name string `json:"name"`
+	Scopes []string `json:"scopes"`
+	Expires sql.NullString `json:"expires"`
+	LastUsed sql.NullString `json:"last_used"`
+	Created string `json:"created"`
+	UserID int64 `json:"-"`
+
+	// The plain token. This is only set when the token is created, since it
+	// cannot be recovered from the hash.
+	Token string `json:"token,omitempty"`
+
+	hash string
+}
+
+// NewAPIToken creates a new token model with no ID or any fields set.
+func NewAPIToken(db *sql.DB) APIToken {
+	return APIToken {
+		Resource: Resource {
+			DB: db,
+			Table: "api_tokens",
+		},
+	}
+}
+
+// LoadAPIToken attempts to load a token's fields from the database, given
+// its ID.
+func LoadAPIToken(id int64, db *sql.DB) (t APIToken, err error) {
+	t = NewAPIToken(db)
+	t.ID = id
+	err = t.Load()
+
+	return
+}
+
+func (t *APIToken) Load() error {
+	var scopes string
+	err := t.Select([]string{"name", "token_hash", "scopes", "expires", "last_used", "created", "user_id"},
+		&t.Name, &t.hash, &scopes, &t.Expires, &t.LastUsed, &t.Created, &t.UserID)
+	t.Scopes = splitScopes(scopes)
+
+	return err
+}
+
+// Save stores the token. New tokens are given a random secret, which is
+// placed in the Token field so that it can be shown to the user once.
+func (t *APIToken) Save() error {
+	if len(t.hash) == 0 {
+		secret, err := RandomToken(32)
+		if err != nil {
+			return err
+		}
+		t.Token = API_TOKEN_PREFIX + secret
+		t.hash = hashAPIToken(t.Token)
+	}
+
+	return t.Sync([]string{"name", "token_hash", "scopes", "expires", "created", "user_id"},
+		t.Name, t.hash, strings.Join(t.Scopes, ","), t.Expires, t.Created, t.UserID)
+}
+
+// LoadUserAPITokens retrieves all the tokens belonging to a user.
+func LoadUserAPITokens(uID int64, db *sql.DB) (ts []APIToken, err error) {
+	ts = []APIToken{}
+
+	rows, err := db.Query("SELECT id FROM api_tokens WHERE user_id=? ORDER BY id", uID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			continue
+		}
+
+		t, err := LoadAPIToken(id, db)
+		if err != nil {
+			continue
+		}
+
+		ts = append(ts, t)
+	}
+
+	return
+}
+
+// IsAPIToken reports whether a bearer token is a personal access token.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, API_TOKEN_PREFIX)
+}
+
+// hashAPIToken hashes a token for storage and lookup. Tokens are long and
+// random, so a fast hash is sufficient.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitScopes parses a comma-separated list of scopes.
+func splitScopes(s string) []string {
+	scopes := []string{}
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.TrimSpace(scope)
+		if len(scope) > 0 {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+// ValidateScopes makes sure every scope in a list is one that tokens may be
+// given.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		valid := false
+		for _, v := range VALID_SCOPES {
+			valid = valid || scope == v
+		}
+		if !valid {
+			return fmt.Errorf("Unknown scope %q.", scope)
+		}
+	}
+
+	return nil
+}
+
+// AuthenticateAPIToken looks up a personal access token, makes sure it has
+// not expired, and records that it was used. The principal it returns is
+// limited to the token's scopes.
+func AuthenticateAPIToken(token string, now time.Time, db *sql.DB) (p Principal, err error) {
+	var id, uID int64
+	var scopes string
+	var expires sql.NullString
+	row := db.QueryRow("SELECT id, user_id, scopes, expires FROM api_tokens WHERE token_hash=?", hashAPIToken(token))
+	err = row.Scan(&id, &uID, &scopes, &expires)
+	if err != nil {
+		err = errors.New("Token invalid.")
+		return
+	}
+
+	// Check the expiration date, if there is one.
+	if expires.Valid {
+		var exp time.Time
+		exp, err = time.Parse(DATETIME_FORMAT, expires.String)
+		if err != nil || !now.UTC().Before(exp) {
+			err = errors.New("Token expired.")
+			return
+		}
+	}
+
+	// Admin status comes from the user, so that it takes effect immediately
+	// if it changes.
+	u, err := LoadUser(uID, db)
+	if err != nil {
+		return
+	}
+
+	_, err = db.Exec("UPDATE api_tokens SET last_used=? WHERE id=?", now.UTC().Format(DATETIME_FORMAT), id)
+	if err != nil {
+		return
+	}
+
+	p = Principal {
+		UserID: u.ID,
+		Admin: u.Admin,
+		TokenID: fmt.Sprintf("pat-%d", id),
+		Scopes: splitScopes(scopes),
+	}
+
+	return
+}
+
+// RequiredScope works out the scope needed for a request to an /api path.
+// Reads need the read scope, and anything else needs the write scope. The
+// second value is false for paths that tokens may not reach.
+func RequiredScope(method, path string) (string, bool) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api"), "/"), "/")
+
+	// Nested collections, such as /user/{id}/note, belong to the nested
+	// resource.
+	resource := segments[0]
+	if len(segments) > 2 {
+		resource = segments[2]
+	}
+
+	name, ok := SCOPE_RESOURCES[resource]
+	if !ok {
+		return "", false
+	}
+
+	if method == "GET" || method == "HEAD" {
+		return name + ":read", true
+	}
+	return name + ":write", true
+}
+
+// HasScope reports whether a principal was granted a scope. Principals
+// authenticated by a login token have no scope restrictions.
+func (p Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+
+		// A write scope implies read access to the same resource.
+		if strings.HasSuffix(scope, ":read") && s == strings.TrimSuffix(scope, ":read") + ":write" {
+			return true
+		}
+	}
+
+	return false
+}
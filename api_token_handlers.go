@@ -0,0 +1,168 @@
+package csnotes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetAPITokens lists the logged in user's personal access tokens. The tokens
+// themselves are never included, only their metadata.
+func GetAPITokens(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Get the logged in user's ID.
+		currentUserID, _, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+
+		ts, err := LoadUserAPITokens(currentUserID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load tokens."
+			return
+		}
+
+		// Add the tokens to the response.
+		for _, t := range ts {
+			resp.Models = append(resp.Models, t)
+		}
+	}
+}
+
+// PostAPIToken creates a personal access token for the logged in user. The
+// response is the only time the token is shown.
+func PostAPIToken(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Retrieve form values.
+		name := r.FormValue("name")
+		scopes := splitScopes(r.FormValue("scopes"))
+		expiresIn := r.FormValue("expires_in")
+
+		// Perform validation on the form values.
+		if len(name) == 0 {
+			resp.Fields["name"] = "Name must be specified."
+		}
+
+		if len(scopes) == 0 {
+			resp.Fields["scopes"] = "At least one scope must be specified."
+		} else if err := ValidateScopes(scopes); err != nil {
+			resp.Fields["scopes"] = err.Error()
+		}
+
+		var days int
+		if len(expiresIn) > 0 {
+			var err error
+			days, err = strconv.Atoi(expiresIn)
+			if err != nil || days < 1 {
+				resp.Fields["expires_in"] = "Expiration must be a positive number of days."
+			}
+		}
+
+		if len(resp.Fields) > 0 {
+			return
+		}
+
+		// Get the logged in user's ID.
+		currentUserID, _, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+
+		// Create the token model.
+		now := context.Now().UTC()
+		t := NewAPIToken(context.DB)
+		t.Name = name
+		t.Scopes = scopes
+		t.Created = now.Format(DATETIME_FORMAT)
+		t.UserID = currentUserID
+		if days > 0 {
+			t.Expires.String = now.Add(time.Hour * 24 * time.Duration(days)).Format(DATETIME_FORMAT)
+			t.Expires.Valid = true
+		}
+
+		// Save the new token.
+		err = t.Save()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save token."
+			return
+		}
+
+		// Add the token model to the response.
+		resp.Models = append(resp.Models, t)
+	}
+}
+
+// DeleteAPIToken revokes a personal access token. Users may only revoke
+// their own tokens, unless they are admin.
+func DeleteAPIToken(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Retrieve the ID from the URL.
+		tID, ok := GetURLID(r, &resp)
+		if !ok {
+			return
+		}
+
+		// Check for the token's existence.
+		if e, err := CheckExistence(tID, "api_tokens", context.DB); !e {
+			if err == nil {
+				resp.StatusCode = 404
+				resp.ErrorMessage = "Token not found."
+			} else {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not verify token's existence."
+			}
+			return
+		}
+
+		// Load the data of the user that's logged in.
+		currentUserID, currentUserAdmin, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+
+		t, err := LoadAPIToken(tID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve token."
+			return
+		}
+
+		// Make sure the user is either admin or the owner of the token.
+		if t.UserID != currentUserID && !currentUserAdmin {
+			resp.StatusCode = 403
+			resp.ErrorMessage = "Must be admin or owner of token."
+			return
+		}
+
+		// Delete the token.
+		err = t.Delete()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not revoke token."
+			return
+		}
+
+		// Add the old token's data to the response.
+		resp.Models = append(resp.Models, t)
+	}
+}
@@ -0,0 +1,139 @@
+package csnotes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRequiredScope ensures that API paths map to the right scopes, and that
+// paths outside the known resources cannot be reached with a token.
+func TestRequiredScope(t *testing.T) {
+	cases := []struct {
+		method string
+		path string
+		scope string
+		ok bool
+	} {
+		{"GET", "/api/note", "notes:read", true},
+		{"PUT", "/api/note/4", "notes:write", true},
+		{"GET", "/api/user/2/note", "notes:read", true},
+		{"GET", "/api/user/2", "users:read", true},
+		{"GET", "/api/note/4/item", "notes:read", true},
+		{"POST", "/api/note/4/item/7/toggle", "notes:write", true},
+		{"PUT", "/api/note/4/item/order", "notes:write", true},
+		{"POST", "/api/note/4/convert", "notes:write", true},
+		{"GET", "/api/note/4/attachment", "notes:read", true},
+		{"POST", "/api/note/4/attachment", "notes:write", true},
+		{"GET", "/api/note/4/attachment/9", "notes:read", true},
+		{"DELETE", "/api/note/4/attachment/9", "notes:write", true},
+		{"GET", "/api/note/4/cover", "notes:read", true},
+		{"PUT", "/api/note/4/cover", "notes:write", true},
+		{"DELETE", "/api/note/4/cover", "notes:write", true},
+		{"GET", "/api/note/4/links", "notes:read", true},
+		{"GET", "/api/note/4/backlinks", "notes:read", true},
+		{"POST", "/api/token", "", false},
+		{"POST", "/api/user/2/totp", "", false},
+	}
+
+	for _, c := range cases {
+		scope, ok := RequiredScope(c.method, c.path)
+		AssertEqual(c.scope, scope, t)
+		AssertEqual(c.ok, ok, t)
+	}
+}
+
+// TestPrincipalHasScope ensures that write scopes imply read scopes, and
+// that login tokens are unrestricted.
+func TestPrincipalHasScope(t *testing.T) {
+	p := Principal{Scopes: []string{"notes:write", "tags:read"}}
+	AssertEqual(true, p.HasScope("notes:read"), t)
+	AssertEqual(true, p.HasScope("notes:write"), t)
+	AssertEqual(true, p.HasScope("tags:read"), t)
+	AssertEqual(false, p.HasScope("tags:write"), t)
+	AssertEqual(false, p.HasScope("users:read"), t)
+
+	AssertEqual(true, Principal{}.HasScope("users:write"), t)
+}
+
+// TestAPITokenNoteRoutes ensures that a token with the notes scopes can reach
+// the parts of a note, which are nested under its path.
+func TestAPITokenNoteRoutes(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	context := newTestAuthContext(t, &now)
+	context.DB = db
+	router := CreateRouter(context)
+
+	pat := NewAPIToken(db)
+	pat.Name = "script"
+	pat.Scopes = []string{"notes:write"}
+	pat.Created = now.UTC().Format(DATETIME_FORMAT)
+	pat.UserID = ids["user.nonadmin"]
+	err = pat.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method, path, contentType string, body io.Reader, status int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer " + pat.Token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("%s %s: got %d: %s", method, path, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	note := fmt.Sprintf("/api/note/%d", ids["note.note1"])
+	cases := []struct {
+		method string
+		path string
+		form url.Values
+	} {
+		{"POST", note + "/convert", url.Values{"type": {"checklist"}}},
+		{"GET", note + "/item", nil},
+		{"POST", note + "/item", url.Values{"text": {"Milk"}}},
+		{"GET", note + "/attachment", nil},
+		{"GET", note + "/links", nil},
+		{"GET", note + "/backlinks", nil},
+	}
+	for _, c := range cases {
+		send(c.method, c.path, "application/x-www-form-urlencoded", strings.NewReader(c.form.Encode()), 200)
+	}
+
+	// Attachments can be uploaded and downloaded.
+	context.Blobs = &LocalBlobStore{Dir: t.TempDir()}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "list.txt")
+	part.Write([]byte("Milk and eggs"))
+	mw.Close()
+
+	rec := send("POST", note + "/attachment", mw.FormDataContentType(), &body, 200)
+	var uploaded struct {
+		Models []Attachment `json:"models"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &uploaded)
+	if len(uploaded.Models) != 1 {
+		t.Fatalf("Upload returned %s", rec.Body.String())
+	}
+	rec = send("GET", fmt.Sprintf("%s/attachment/%d", note, uploaded.Models[0].ID), "", nil, 200)
+	AssertEqual("Milk and eggs", rec.Body.String(), t)
+
+	// The note has no cover, but the token may look for one.
+	send("GET", note + "/cover", "", nil, 404)
+}
@@ -1,42 +1,82 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/smtp"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/cpgillem/csnotes"
 	"github.com/urfave/negroni"
-	"github.com/dgrijalva/jwt-go"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 const (
-	PRIVATE_KEY_PATH = "./keys/app.rsa"
-	PUBLIC_KEY_PATH = "./keys/app.rsa.pub"
+	KEY_DIR = "./keys"
+	SIGNING_KEY_ENV = "NOTES_SIGNING_KEY"
+	ISSUER_ENV = "NOTES_ISSUER"
+	AUDIENCE_ENV = "NOTES_AUDIENCE"
+
+	// Single sign-on is enabled when the issuer is set.
+	OIDC_ISSUER_ENV = "NOTES_OIDC_ISSUER"
+	OIDC_CLIENT_ID_ENV = "NOTES_OIDC_CLIENT_ID"
+	OIDC_CLIENT_SECRET_ENV = "NOTES_OIDC_CLIENT_SECRET"
+	OIDC_REDIRECT_URL_ENV = "NOTES_OIDC_REDIRECT_URL"
+	OIDC_ADMIN_GROUP_ENV = "NOTES_OIDC_ADMIN_GROUP"
+
+	// LDAP authentication is enabled when the URL is set.
+	LDAP_URL_ENV = "NOTES_LDAP_URL"
+	LDAP_BIND_DN_ENV = "NOTES_LDAP_BIND_DN"
+	LDAP_BIND_PASSWORD_ENV = "NOTES_LDAP_BIND_PASSWORD"
+	LDAP_BASE_DN_ENV = "NOTES_LDAP_BASE_DN"
+	LDAP_USER_FILTER_ENV = "NOTES_LDAP_USER_FILTER"
+	LDAP_ADMIN_GROUP_ENV = "NOTES_LDAP_ADMIN_GROUP"
+
+	// Outgoing email. Without an SMTP server, emails are printed to the
+	// console instead.
+	BASE_URL_ENV = "NOTES_BASE_URL"
+	SMTP_ADDR_ENV = "NOTES_SMTP_ADDR"
+	SMTP_USERNAME_ENV = "NOTES_SMTP_USERNAME"
+	SMTP_PASSWORD_ENV = "NOTES_SMTP_PASSWORD"
+	MAIL_FROM_ENV = "NOTES_MAIL_FROM"
+	DEFAULT_MAIL_FROM = "notes@localhost"
+
+	// Where attachments are stored. An S3-compatible service is used if its
+	// endpoint is set; otherwise files are kept on disk.
+	BLOB_DIR_ENV = "NOTES_BLOB_DIR"
+	DEFAULT_BLOB_DIR = "./blobs"
+	S3_ENDPOINT_ENV = "NOTES_S3_ENDPOINT"
+	S3_REGION_ENV = "NOTES_S3_REGION"
+	S3_BUCKET_ENV = "NOTES_S3_BUCKET"
+	S3_ACCESS_KEY_ENV = "NOTES_S3_ACCESS_KEY"
+	S3_SECRET_KEY_ENV = "NOTES_S3_SECRET_KEY"
+	DEFAULT_S3_REGION = "us-east-1"
+
+	// Defaults for the token issuer and audience claims.
+	DEFAULT_ISSUER = "notes-app"
+	DEFAULT_AUDIENCE = "notes-app"
+
+	// How far token times may drift between servers.
+	CLOCK_SKEW = 30 * time.Second
+
+	// How long in-flight requests are given to finish after a shutdown signal
+	// is received.
+	SHUTDOWN_TIMEOUT = 10 * time.Second
 )
 
 func main() {
-	// Load the RSA keys.
-	signRaw, err := ioutil.ReadFile(PRIVATE_KEY_PATH)
-	if err != nil {
-		panic(err)
-	}
-	signKey, err := jwt.ParseRSAPrivateKeyFromPEM(signRaw)
-	if err != nil {
-		panic(err)
-	}
-
-	verifyRaw, err := ioutil.ReadFile(PUBLIC_KEY_PATH)
-	if err != nil {
-		panic(err)
-	}
-	verifyKey, err := jwt.ParseRSAPublicKeyFromPEM(verifyRaw)
+	// Load the RSA keys. The active signing key can be overridden from the
+	// environment, which allows switching keys without touching the disk.
+	keys, err := csnotes.LoadKeySet(KEY_DIR, os.Getenv(SIGNING_KEY_ENV))
 	if err != nil {
 		panic(err)
 	}
@@ -59,26 +99,174 @@ func main() {
 		panic(err)
 	}
 
+	// Background work runs until this is cancelled at shutdown.
+	background, stopBackground := context.WithCancel(context.Background())
+
 	// Create a context variable to pass around.
 	context := csnotes.Context {
 		DB: db,
-		SignKey: signKey,
-		VerifyKey: verifyKey,
+		SignKeyID: keys.SignKeyID,
+		SignKey: keys.SignKey,
+		VerifyKeys: keys.VerifyKeys,
+		Issuer: envOrDefault(ISSUER_ENV, DEFAULT_ISSUER),
+		Audience: envOrDefault(AUDIENCE_ENV, DEFAULT_AUDIENCE),
+		ClockSkew: CLOCK_SKEW,
+		LoginThrottle: csnotes.NewLoginThrottle(),
+		BaseURL: strings.TrimSuffix(envOrDefault(BASE_URL_ENV, "http://127.0.0.1:" + port), "/"),
+		Mailer: &csnotes.LogMailer{Out: os.Stdout},
+		Blobs: &csnotes.LocalBlobStore{Dir: envOrDefault(BLOB_DIR_ENV, DEFAULT_BLOB_DIR)},
+		Markdown: csnotes.NewMarkdownCache(csnotes.MARKDOWN_CACHE_SIZE),
+	}
+
+	// Store attachments in S3, if it is configured.
+	if s3Endpoint := os.Getenv(S3_ENDPOINT_ENV); len(s3Endpoint) > 0 {
+		context.Blobs = &csnotes.S3BlobStore {
+			Endpoint: s3Endpoint,
+			Region: envOrDefault(S3_REGION_ENV, DEFAULT_S3_REGION),
+			Bucket: os.Getenv(S3_BUCKET_ENV),
+			AccessKey: os.Getenv(S3_ACCESS_KEY_ENV),
+			SecretKey: os.Getenv(S3_SECRET_KEY_ENV),
+			Client: &http.Client{Timeout: time.Minute},
+		}
+	}
+
+	// Send email through an SMTP server, if one is configured.
+	if smtpAddr := os.Getenv(SMTP_ADDR_ENV); len(smtpAddr) > 0 {
+		mailer := &csnotes.SMTPMailer {
+			Addr: smtpAddr,
+			From: envOrDefault(MAIL_FROM_ENV, DEFAULT_MAIL_FROM),
+		}
+		if username := os.Getenv(SMTP_USERNAME_ENV); len(username) > 0 {
+			host, _, _ := net.SplitHostPort(smtpAddr)
+			mailer.Auth = smtp.PlainAuth("", username, os.Getenv(SMTP_PASSWORD_ENV), host)
+		}
+		context.Mailer = mailer
 	}
 
+	// Check passwords against LDAP after local accounts, if configured.
+	if ldapURL := os.Getenv(LDAP_URL_ENV); len(ldapURL) > 0 {
+		context.Authenticators = []csnotes.Authenticator {
+			csnotes.PasswordAuthenticator{},
+			csnotes.NewLDAPAuthenticator(csnotes.LDAPConfig {
+				URL: ldapURL,
+				BindDN: os.Getenv(LDAP_BIND_DN_ENV),
+				BindPassword: os.Getenv(LDAP_BIND_PASSWORD_ENV),
+				BaseDN: os.Getenv(LDAP_BASE_DN_ENV),
+				UserFilter: os.Getenv(LDAP_USER_FILTER_ENV),
+				AdminGroupDN: os.Getenv(LDAP_ADMIN_GROUP_ENV),
+			}),
+		}
+	}
+
+	// Discover the single sign-on provider, if one is configured.
+	if issuer := os.Getenv(OIDC_ISSUER_ENV); len(issuer) > 0 {
+		context.OIDC, err = csnotes.DiscoverOIDCProvider(csnotes.OIDCConfig {
+			IssuerURL: issuer,
+			ClientID: os.Getenv(OIDC_CLIENT_ID_ENV),
+			ClientSecret: os.Getenv(OIDC_CLIENT_SECRET_ENV),
+			RedirectURL: os.Getenv(OIDC_REDIRECT_URL_ENV),
+			AdminGroup: os.Getenv(OIDC_ADMIN_GROUP_ENV),
+			HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		})
+		if err != nil {
+			log.Fatalf("Could not discover single sign-on provider. [%v]", err)
+		}
+	}
+
+	// Fire reminders in the background, delivering them to the event stream,
+	// by email, or to webhooks.
+	context.ReminderEvents = csnotes.NewReminderBroker()
+	context.Reminders = &csnotes.ReminderScheduler {
+		DB: db,
+		Channels: map[string]csnotes.ReminderChannel {
+			"events": context.ReminderEvents,
+			"email": &csnotes.EmailReminderChannel {
+				DB: db,
+				Mailer: context.Mailer,
+				BaseURL: context.BaseURL,
+			},
+			"webhook": &csnotes.WebhookReminderChannel {
+				Client: csnotes.NewWebhookClient(10 * time.Second),
+			},
+		},
+	}
+	schedulerDone := make(chan struct{})
+	go func() {
+		context.Reminders.Run(background)
+		close(schedulerDone)
+	}()
+
+	// Make cover thumbnails in the background.
+	context.Thumbnails = csnotes.NewThumbnailer(db, context.Blobs, csnotes.THUMBNAIL_WORKERS)
+	thumbnailerDone := make(chan struct{})
+	go func() {
+		context.Thumbnails.Run(background)
+		close(thumbnailerDone)
+	}()
+
 	// Define the routes.
 	router := csnotes.CreateRouter(&context)
 	n := negroni.Classic()
 	n.UseHandler(router)
 
-	// Define a server object.
+	// Define a server object. Routes that stream large responses lift its
+	// timeouts through the writer it passes in.
 	server := &http.Server {
-		Handler:		n,
+		Handler:		csnotes.KeepServerWriter(n),
 		Addr:			"127.0.0.1:" + port,
 		WriteTimeout:	15 * time.Second,
 		ReadTimeout:	15 * time.Second,
 	}
 
-	// Start the server.
-	log.Fatal(server.ListenAndServe())
+	// End open event streams when shutting down, rather than waiting on them.
+	server.RegisterOnShutdown(context.ReminderEvents.Close)
+
+	// Start the server in the background, so that the main goroutine is free
+	// to wait for a shutdown signal.
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	waitForShutdown(server, db, func() {
+		stopBackground()
+		<-schedulerDone
+		<-thumbnailerDone
+	})
+}
+
+// waitForShutdown blocks until the process receives SIGINT or SIGTERM, then
+// drains in-flight requests, stops background work and closes the database
+// connection. stopBackground must return once background work has stopped.
+func waitForShutdown(server *http.Server, db *sql.DB, stopBackground func()) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Println("Shutting down...")
+
+	// Stop accepting connections and wait for active requests to finish.
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Could not shut down gracefully. [%v]", err)
+	}
+
+	stopBackground()
+
+	// Close the database only after nothing else can use it.
+	if err := db.Close(); err != nil {
+		log.Printf("Could not close database. [%v]", err)
+	}
+}
+
+// envOrDefault reads an environment variable, falling back to a default if
+// it is unset.
+func envOrDefault(name, def string) string {
+	if v := os.Getenv(name); len(v) > 0 {
+		return v
+	}
+	return def
 }
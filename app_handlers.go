@@ -1,12 +1,12 @@
 package csnotes
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
-
-	"github.com/dgrijalva/jwt-go"
 )
 
 // GetLogin should take the user's credentials and create a
@@ -18,48 +18,217 @@ func PostLogin(context *Context) http.HandlerFunc {
 
 		// Validate the data.
 		if len(username) == 0 {
-			http.Error(w, "No username.", http.StatusInternalServerError)
+			http.Error(w, "No username.", http.StatusBadRequest)
 			return
 		}
 
 		if len(password) == 0 {
-			http.Error(w, "No password.", http.StatusInternalServerError)
+			http.Error(w, "No password.", http.StatusBadRequest)
+			return
+		}
+
+		// Refuse the attempt if the account or the client's address has
+		// failed too many times recently.
+		userKey := UsernameThrottleKey(username)
+		addressKey := AddressThrottleKey(r)
+		if !checkLoginThrottle(w, context, userKey, addressKey) {
 			return
 		}
 
 		// Validate the credentials against the database.
-		user, err := ValidateUser(username, password, context.DB)
+		user, err := ValidateUser(username, password, context.DB, context.Authenticators...)
 		if err != nil {
 			fmt.Println(err)
+			context.LoginThrottle.Fail(context.Now(), userKey, addressKey)
 			http.Error(w, "Could not authenticate user.", http.StatusForbidden)
 			return
 		}
 
-		// Create a token.
-		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims {
-			"iss": "admin",
-			"exp": time.Now().Add(time.Minute * 20).Unix(),
-			"user_id": user.ID,
-			"user_admin": user.Admin,
-		})
+		// Users who signed up themselves must verify their email first.
+		if user.Email.Valid && !user.EmailVerified {
+			http.Error(w, "Email address has not been verified.", http.StatusForbidden)
+			return
+		}
+
+		// If the user has two-factor authentication enabled, the password
+		// alone only earns a challenge token, which must be sent to
+		// /login/totp along with a code.
+		status, err := LoadTOTPStatus(user.ID, context.DB)
+		if err != nil {
+			http.Error(w, "Could not check two-factor status.", http.StatusInternalServerError)
+			return
+		}
+		if status.Enabled {
+			challenge, err := context.IssueChallenge(user.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			writeJSON(w, struct {
+				Challenge string `json:"challenge"`
+				SecondFactor string `json:"second_factor"`
+			} {challenge, "totp"})
+			return
+		}
+
+		context.LoginThrottle.Succeed(userKey)
+		respondWithToken(w, context, user)
+	}
+}
 
-		tokenString, err := token.SignedString(context.SignKey)
+// PostLoginTOTP completes a login for a user with two-factor authentication.
+// It takes the challenge token from PostLogin and either a TOTP code or a
+// single-use recovery code.
+func PostLoginTOTP(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		challenge := r.FormValue("challenge")
+		code := r.FormValue("code")
+		recoveryCode := r.FormValue("recovery_code")
 
+		// Validate the data.
+		if len(code) == 0 && len(recoveryCode) == 0 {
+			http.Error(w, "No code.", http.StatusBadRequest)
+			return
+		}
+
+		// Make sure the password step was completed.
+		uID, err := context.ParseChallenge(challenge)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Invalid challenge.", http.StatusForbidden)
+			return
 		}
 
-		response := struct {
-			Token string `json:"token"`
-		} {tokenString}
+		user, err := LoadUser(uID, context.DB)
+		if err != nil {
+			http.Error(w, "Could not load user.", http.StatusInternalServerError)
+			return
+		}
+
+		// Codes are guessable, so they are throttled like passwords.
+		userKey := UsernameThrottleKey(user.Username)
+		addressKey := AddressThrottleKey(r)
+		if !checkLoginThrottle(w, context, userKey, addressKey) {
+			return
+		}
+
+		ok, err := CheckSecondFactor(uID, code, recoveryCode, context.Now(), context.DB)
+		if !ok || err != nil {
+			context.LoginThrottle.Fail(context.Now(), userKey, addressKey)
+			http.Error(w, "Could not authenticate user.", http.StatusForbidden)
+			return
+		}
+
+		context.LoginThrottle.Succeed(userKey)
+		respondWithToken(w, context, user)
+	}
+}
+
+// checkLoginThrottle responds with a 429 if a login attempt must wait, and
+// returns whether the attempt may proceed.
+func checkLoginThrottle(w http.ResponseWriter, context *Context, keys ...string) bool {
+	wait := context.LoginThrottle.Wait(context.Now(), keys...)
+	if wait <= 0 {
+		return true
+	}
 
-		// Turn the token into a json string.
-		json, err := json.Marshal(response)
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, "Too many failed login attempts.", http.StatusTooManyRequests)
+	return false
+}
+
+// PostLoginRefresh exchanges a login token that has not expired yet for a new
+// one, so that clients can stay logged in without sending the password again.
+// The user is loaded again, so that a change to their admin status is picked
+// up. A login cannot be refreshed for longer than SESSION_LIFETIME, or once
+// the user's password or two-factor authentication has changed since.
+func PostLoginRefresh(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		tokenString, err := bearerToken(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// Personal access tokens last as long as they were created for.
+		if IsAPIToken(tokenString) {
+			http.Error(w, "Personal access tokens cannot be refreshed.", http.StatusBadRequest)
+			return
+		}
+
+		p, err := context.ParseToken(tokenString)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if context.Now().After(p.AuthTime.Add(SESSION_LIFETIME)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Login expired.", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := LoadUser(p.UserID, context.DB)
+		if err == sql.ErrNoRows {
+			http.Error(w, "User no longer exists.", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Could not load user.", http.StatusInternalServerError)
+			return
+		}
+
+		changed, err := CredentialsChanged(user.ID, context.DB)
+		if err != nil {
+			http.Error(w, "Could not load user.", http.StatusInternalServerError)
+			return
+		}
+		if p.AuthTime.Before(changed) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Credentials changed since logging in.", http.StatusUnauthorized)
+			return
+		}
+
+		// The new token keeps the time of the login.
+		tokenString, err = context.issueToken(user, p.AuthTime)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(json)
+		writeJSON(w, struct {
+			Token string `json:"token"`
+		} {tokenString})
 	}
 }
+
+// respondWithToken issues a login token for a user and writes it as the
+// response.
+func respondWithToken(w http.ResponseWriter, context *Context, user User) {
+	// Create a token.
+	tokenString, err := context.IssueToken(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Token string `json:"token"`
+	} {tokenString})
+}
+
+// writeJSON writes any value as a JSON response, outside of the usual
+// JSONResponse envelope.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
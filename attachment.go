@@ -0,0 +1,250 @@
+package csnotes
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The largest file that may be attached to a note, in bytes.
+const MAX_ATTACHMENT_SIZE = 10 << 20
+
+// ATTACHMENT_CONTENT_TYPES lists the kinds of file that may be attached. The
+// type is detected from the file's contents, not taken from the client.
+var ATTACHMENT_CONTENT_TYPES = []string {
+	"image/png", "image/jpeg", "image/gif", "image/webp",
+	"application/pdf", "text/plain",
+}
+
+var (
+	// ErrAttachmentTooLarge is returned for files over MAX_ATTACHMENT_SIZE.
+	ErrAttachmentTooLarge = errors.New("File is too large.")
+
+	// ErrAttachmentType is returned for files of a type that is not allowed.
+	ErrAttachmentType = errors.New("File type is not allowed.")
+)
+
+// Attachment is a file attached to a note. Its contents live in a blob
+// store, under StorageKey.
+type Attachment struct {
+	Resource
+	NoteID int64 `json:"note_id"`
+	UserID int64 `json:"-"`
+	Filename string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size int64 `json:"size"`
+	SHA256 string `json:"sha256"`
+	StorageKey string `json:"-"`
+	Created string `json:"created"`
+}
+
+// NewAttachment creates a new attachment model with no ID or any fields set.
+func NewAttachment(db *sql.DB) Attachment {
+	return Attachment {
+		Resource: Resource {
+			DB: db,
+			Table: "attachments",
+		},
+	}
+}
+
+// LoadAttachment attempts to load an attachment's fields from the database,
+// given its ID.
+func LoadAttachment(id int64, db *sql.DB) (a Attachment, err error) {
+	a = NewAttachment(db)
+	a.ID = id
+	err = a.Load()
+
+	return
+}
+
+func (a *Attachment) Load() error {
+	return a.Select([]string{"note_id", "user_id", "filename", "content_type", "size", "sha256", "storage_key", "created"},
+		&a.NoteID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.StorageKey, &a.Created)
+}
+
+func (a *Attachment) Save() error {
+	return a.Sync([]string{"note_id", "user_id", "filename", "content_type", "size", "sha256", "storage_key", "created"},
+		a.NoteID, a.UserID, a.Filename, a.ContentType, a.Size, a.SHA256, a.StorageKey, a.Created)
+}
+
+// Attachments retrieves the files attached to the note, oldest first.
+func (n *Note) Attachments() (as []Attachment, err error) {
+	as = []Attachment{}
+
+	rows, err := n.DB.Query("SELECT id FROM attachments WHERE note_id=? ORDER BY id", n.ID)
+	if err != nil {
+		return
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		a, err := LoadAttachment(id, n.DB)
+		if err != nil {
+			return as, err
+		}
+		as = append(as, a)
+	}
+
+	return
+}
+
+// Attach stores a file and attaches it to the note. The file is spooled to
+// disk first, which checks its size, detects its type and computes its
+// checksum before anything is written to the store.
+func (n *Note) Attach(store BlobStore, filename string, r io.Reader, now time.Time) (a Attachment, err error) {
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Copy one byte more than allowed, to tell whether the file is too big.
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, MAX_ATTACHMENT_SIZE + 1))
+	if err != nil {
+		return
+	}
+	if size > MAX_ATTACHMENT_SIZE {
+		return a, ErrAttachmentTooLarge
+	}
+
+	// Detect the type from the start of the file.
+	head := make([]byte, 512)
+	m, err := tmp.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return
+	}
+	contentType := DetectAttachmentType(head[:m])
+	if len(contentType) == 0 {
+		return a, ErrAttachmentType
+	}
+
+	key, err := RandomToken(16)
+	if err != nil {
+		return
+	}
+
+	a = NewAttachment(n.DB)
+	a.NoteID = n.ID
+	a.UserID = n.UserID
+	a.Filename = CleanFilename(filename)
+	a.ContentType = contentType
+	a.Size = size
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	a.StorageKey = "attachments/" + key
+	a.Created = now.UTC().Format(DATETIME_FORMAT)
+
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	err = store.Put(a.StorageKey, tmp, a.Size, a.ContentType)
+	if err != nil {
+		return
+	}
+
+	// Don't leave an orphaned blob if the record cannot be saved.
+	err = a.Save()
+	if err != nil {
+		store.Delete(a.StorageKey)
+	}
+
+	return
+}
+
+// Remove deletes the attachment and its blob. If it is a note's cover, its
+// thumbnails and the cover go with it.
+func (a *Attachment) Remove(store BlobStore) error {
+	err := store.Delete(a.StorageKey)
+	if err != nil {
+		return err
+	}
+
+	for _, size := range THUMBNAIL_SIZES {
+		err = store.Delete(ThumbnailKey(*a, size.Name))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = a.DB.Exec("DELETE FROM note_covers WHERE attachment_id=?", a.ID)
+	if err != nil {
+		return err
+	}
+
+	return a.Delete()
+}
+
+// RemoveAttachments deletes every attachment of the note, with their blobs.
+func (n *Note) RemoveAttachments(store BlobStore) error {
+	as, err := n.Attachments()
+	if err != nil {
+		return err
+	}
+
+	for _, a := range as {
+		err = a.Remove(store)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DetectAttachmentType finds the type of a file from its first bytes. It
+// returns an empty string if the type is not allowed.
+func DetectAttachmentType(head []byte) string {
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+
+	for _, t := range ATTACHMENT_CONTENT_TYPES {
+		if t == detected {
+			return t
+		}
+	}
+
+	return ""
+}
+
+// CleanFilename keeps only the base name of an uploaded file, without any
+// characters that could break a Content-Disposition header.
+func CleanFilename(name string) string {
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+
+	// Keep the name within the column.
+	for len(name) > 191 || !utf8.ValidString(name) {
+		name = name[:len(name) - 1]
+	}
+
+	if len(name) == 0 || name == "." || name == ".." {
+		return "attachment"
+	}
+	return name
+}
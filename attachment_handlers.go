@@ -0,0 +1,235 @@
+package csnotes
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GetNoteAttachments lists the files attached to a note.
+func GetNoteAttachments(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		as, err := n.Attachments()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load attachments."
+			return
+		}
+
+		// Add the attachments to the response.
+		for _, a := range as {
+			resp.Models = append(resp.Models, a)
+		}
+	}
+}
+
+// PostNoteAttachment attaches a file to a note. The file is uploaded as the
+// "file" field of a multipart form.
+func PostNoteAttachment(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		if context.Blobs == nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Attachments are disabled."
+			return
+		}
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		// Leave room for the multipart framing around the file.
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_ATTACHMENT_SIZE + 1 << 20)
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			resp.Fields["file"] = "File must be uploaded as multipart/form-data."
+			return
+		}
+
+		// Find the file's part, skipping any others.
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				resp.Fields["file"] = "File must be specified."
+				return
+			}
+			if err != nil {
+				resp.Fields["file"] = "Could not read upload."
+				return
+			}
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+
+			a, err := n.Attach(context.Blobs, part.FileName(), part, context.Now())
+			part.Close()
+			switch err {
+			case nil:
+				resp.Models = append(resp.Models, a)
+			case ErrAttachmentTooLarge:
+				resp.StatusCode = 413
+				resp.ErrorMessage = fmt.Sprintf("Files may be at most %d bytes.", MAX_ATTACHMENT_SIZE)
+			case ErrAttachmentType:
+				resp.StatusCode = 415
+				resp.ErrorMessage = "Files must be one of: " + strings.Join(ATTACHMENT_CONTENT_TYPES, ", ") + "."
+			default:
+				fmt.Println(err)
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not store attachment."
+			}
+			return
+		}
+	}
+}
+
+// GetNoteAttachment downloads an attached file. Images are shown inline;
+// other files are downloaded.
+func GetNoteAttachment(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Errors are answered in JSON, but the file itself is not.
+		resp := NewJSONResponse()
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			resp.Respond(w)
+			return
+		}
+
+		a, ok := loadNoteAttachment(context, r, &resp, n)
+		if !ok {
+			resp.Respond(w)
+			return
+		}
+
+		// A cover image keeps its metadata, such as where it was taken,
+		// until it has been processed, so it isn't served until then.
+		status, err := AttachmentCoverStatus(a.ID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load attachment."
+			resp.Respond(w)
+			return
+		}
+		switch status {
+		case COVER_STATUS_PROCESSING:
+			resp.StatusCode = 409
+			resp.ErrorMessage = "Cover image is still being processed."
+			resp.Respond(w)
+			return
+		case COVER_STATUS_FAILED:
+			resp.StatusCode = 409
+			resp.ErrorMessage = "Cover image could not be processed."
+			resp.Respond(w)
+			return
+		}
+
+		etag := `"` + a.SHA256 + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if context.Blobs == nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Attachments are disabled."
+			resp.Respond(w)
+			return
+		}
+
+		blob, err := context.Blobs.Get(a.StorageKey)
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not read attachment."
+			resp.Respond(w)
+			return
+		}
+		defer blob.Close()
+
+		disposition := "attachment"
+		if strings.HasPrefix(a.ContentType, "image/") {
+			disposition = "inline"
+		}
+
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string {"filename": a.Filename}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("ETag", etag)
+
+		_, err = io.Copy(w, blob)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// DeleteNoteAttachment removes a file from a note.
+func DeleteNoteAttachment(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		if context.Blobs == nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Attachments are disabled."
+			return
+		}
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		a, ok := loadNoteAttachment(context, r, &resp, n)
+		if !ok {
+			return
+		}
+
+		err := a.Remove(context.Blobs)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not delete attachment."
+			return
+		}
+
+		resp.Models = append(resp.Models, a)
+	}
+}
+
+// loadNoteAttachment loads the attachment named in the URL, as long as it
+// belongs to the note.
+func loadNoteAttachment(context *Context, r *http.Request, resp *JSONResponse, n Note) (a Attachment, ok bool) {
+	aID, ok := GetURLVarID(r, resp, "attachment_id")
+	if !ok {
+		return
+	}
+
+	a, err := LoadAttachment(aID, context.DB)
+	if err != nil || a.NoteID != n.ID {
+		resp.StatusCode = 404
+		resp.ErrorMessage = "Attachment not found."
+		return a, false
+	}
+
+	return a, true
+}
@@ -0,0 +1,283 @@
+package csnotes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// How long a login token is valid for.
+const TOKEN_LIFETIME = time.Minute * 20
+
+// How long a login lasts, however often its token is refreshed.
+const SESSION_LIFETIME = time.Hour * 24 * 7
+
+// Principal describes whoever made an authenticated request.
+type Principal struct {
+	UserID int64
+	Admin bool
+
+	// The "jti" claim of the token that authenticated the request, or the
+	// ID of the personal access token.
+	TokenID string
+
+	// The scopes of a personal access token. This is nil for login tokens,
+	// which may do anything their user can.
+	Scopes []string
+
+	// When the user logged in, for login tokens. Refreshed tokens keep the
+	// time of the login they came from.
+	AuthTime time.Time
+}
+
+// principalKey is the request context key under which the authenticated
+// principal is stored.
+type principalKey struct{}
+
+// WithPrincipal returns a copy of a request that carries a principal.
+func WithPrincipal(r *http.Request, p Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// CurrentPrincipal retrieves the principal stored on a request by the
+// authentication middleware. The second value is false if the request was
+// not authenticated.
+func CurrentPrincipal(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Now returns the current time according to the context's clock. Tests can
+// replace the clock to control token expiration.
+func (c *Context) Now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+// IssueToken creates a signed login token for a user who has just logged in.
+func (c *Context) IssueToken(u User) (string, error) {
+	return c.issueToken(u, c.Now())
+}
+
+// issueToken creates a signed login token for a user who logged in at
+// authTime.
+func (c *Context) issueToken(u User, authTime time.Time) (string, error) {
+	return c.signClaims(jwt.MapClaims {
+		"user_id": u.ID,
+		"user_admin": u.Admin,
+		"auth_time": authTime.Unix(),
+	}, TOKEN_LIFETIME)
+}
+
+// signClaims adds the standard registered claims to a set of claims and
+// signs them with the active key.
+func (c *Context) signClaims(claims jwt.MapClaims, lifetime time.Duration) (string, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := c.Now()
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(lifetime).Unix()
+	claims["jti"] = jti
+	if len(c.Issuer) > 0 {
+		claims["iss"] = c.Issuer
+	}
+	if len(c.Audience) > 0 {
+		claims["aud"] = c.Audience
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = c.SignKeyID
+
+	return token.SignedString(c.SignKey)
+}
+
+// ParseToken verifies a login token's signature and claims, and returns the
+// principal it describes.
+func (c *Context) ParseToken(tokenString string) (p Principal, err error) {
+	claims, err := c.parseClaims(tokenString)
+	if err != nil {
+		return
+	}
+
+	// Tokens issued for another purpose, such as login challenges, cannot
+	// be used to authenticate.
+	if _, ok := claims["purpose"]; ok {
+		err = errors.New("Token cannot be used for authentication.")
+		return
+	}
+
+	// Extract the user ID from the claims.
+	id, ok := claims["user_id"].(float64)
+	if !ok {
+		err = errors.New("Could not load user data from token.")
+		return
+	}
+	p.UserID = int64(id)
+
+	// Extract the admin status.
+	p.Admin, ok = claims["user_admin"].(bool)
+	if !ok {
+		err = errors.New("Could not load user data from token.")
+		return
+	}
+
+	p.TokenID, _ = claims["jti"].(string)
+
+	// Tokens from before logins were timed count from when they were issued.
+	p.AuthTime, ok = claimTime(claims, "auth_time")
+	if !ok {
+		p.AuthTime, _ = claimTime(claims, "iat")
+	}
+
+	return
+}
+
+// parseClaims verifies a token's signature, then checks its time, issuer
+// and audience claims. Time claims are allowed to be off by the context's
+// clock skew, to tolerate servers whose clocks disagree slightly.
+func (c *Context) parseClaims(tokenString string) (claims jwt.MapClaims, err error) {
+	// The library's own claim validation has no skew tolerance, so it is
+	// skipped in favor of the checks below.
+	parser := jwt.Parser {
+		ValidMethods: []string{jwt.SigningMethodRS256.Alg()},
+		SkipClaimsValidation: true,
+	}
+	token, err := parser.Parse(tokenString, c.VerificationKey)
+	if err != nil {
+		return
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		err = errors.New("Token invalid.")
+		return
+	}
+
+	now := c.Now()
+
+	// Check the expiration time. It is required.
+	exp, ok := claimTime(claims, "exp")
+	if !ok {
+		err = errors.New("Token has no expiration.")
+		return
+	}
+	if now.After(exp.Add(c.ClockSkew)) {
+		err = errors.New("Token expired.")
+		return
+	}
+
+	// Check the not-before and issued-at times, if present.
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Add(c.ClockSkew).Before(nbf) {
+		err = errors.New("Token not valid yet.")
+		return
+	}
+	if iat, ok := claimTime(claims, "iat"); ok && now.Add(c.ClockSkew).Before(iat) {
+		err = errors.New("Token issued in the future.")
+		return
+	}
+
+	// Check the issuer and audience, if configured.
+	if len(c.Issuer) > 0 {
+		if iss, _ := claims["iss"].(string); iss != c.Issuer {
+			err = errors.New("Token issuer invalid.")
+			return
+		}
+	}
+	if len(c.Audience) > 0 && !claimHasAudience(claims, c.Audience) {
+		err = errors.New("Token audience invalid.")
+		return
+	}
+
+	return
+}
+
+// claimTime reads a NumericDate claim as a time.
+func claimTime(claims jwt.MapClaims, name string) (time.Time, bool) {
+	seconds, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// claimHasAudience checks the "aud" claim, which may be a single string or
+// an array of strings.
+func claimHasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bearerToken extracts the token from a request's Authorization header.
+func bearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+
+	// Check for an authorization header.
+	if len(authHeader) < 8 {
+		return "", errors.New("Authorization header not found.")
+	}
+
+	// Check for a token.
+	if strings.Index(authHeader, "Bearer ") != 0 {
+		return "", errors.New("No token found in authorization header.")
+	}
+
+	return authHeader[7:], nil
+}
+
+// AuthMiddleware authenticates a request by its bearer token, which may be
+// a login token or a personal access token. The token is parsed once, and
+// the resulting principal is stored on the request for handlers to read
+// through LoggedInUser or CurrentPrincipal. Requests without a valid token
+// are rejected with a 401, and requests outside a token's scopes with a 403.
+func (c *Context) AuthMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	tokenString, err := bearerToken(r)
+	if err == nil {
+		var p Principal
+		if IsAPIToken(tokenString) {
+			p, err = AuthenticateAPIToken(tokenString, c.Now(), c.DB)
+		} else {
+			p, err = c.ParseToken(tokenString)
+		}
+		if err == nil {
+			// Personal access tokens are limited to their scopes.
+			if p.Scopes != nil {
+				scope, ok := RequiredScope(r.Method, r.URL.Path)
+				if !ok || !p.HasScope(scope) {
+					resp := NewJSONResponse()
+					resp.StatusCode = 403
+					resp.ErrorMessage = "Token does not have the required scope."
+					resp.Respond(w)
+					return
+				}
+			}
+
+			next(w, WithPrincipal(r, p))
+			return
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	resp := NewJSONResponse()
+	resp.StatusCode = 401
+	resp.ErrorMessage = err.Error()
+	resp.Respond(w)
+}
@@ -0,0 +1,174 @@
+package csnotes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestAuthContext creates a context with a fresh signing key and a clock
+// that can be moved by the test.
+func newTestAuthContext(t *testing.T, now *time.Time) *Context {
+	context, _ := NewTestApp(t, nil, now)
+	return context
+}
+
+// TestTokenClaims ensures that issued tokens round trip, and that their time
+// claims are checked with the configured skew.
+func TestTokenClaims(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	context := newTestAuthContext(t, &now)
+
+	u := User{Resource: Resource{ID: 5}, Admin: true}
+	token, err := context.IssueToken(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := context.ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(int64(5), p.UserID, t)
+	AssertEqual(true, p.Admin, t)
+	AssertUnequal("", p.TokenID, t)
+	AssertEqual(now.Unix(), p.AuthTime.Unix(), t)
+
+	// Slightly before the token was issued is within the skew.
+	now = now.Add(-30 * time.Second)
+	_, err = context.ParseToken(token)
+	AssertEqual(nil, err, t)
+
+	// Just past expiration is still within the skew.
+	now = now.Add(TOKEN_LIFETIME + time.Minute)
+	_, err = context.ParseToken(token)
+	AssertEqual(nil, err, t)
+
+	// Well past expiration is not.
+	now = now.Add(time.Minute)
+	_, err = context.ParseToken(token)
+	AssertUnequal(nil, err, t)
+}
+
+// TestTokenAudience ensures that tokens for another audience or issuer are
+// rejected.
+func TestTokenAudience(t *testing.T) {
+	now := time.Now()
+	context := newTestAuthContext(t, &now)
+
+	token, err := context.IssueToken(User{Resource: Resource{ID: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context.Audience = "other"
+	_, err = context.ParseToken(token)
+	AssertUnequal(nil, err, t)
+
+	context.Audience = "audience"
+	context.Issuer = "other"
+	_, err = context.ParseToken(token)
+	AssertUnequal(nil, err, t)
+}
+
+// TestAuthMiddleware ensures that the middleware stores the principal on the
+// request and rejects requests without a valid token.
+func TestAuthMiddleware(t *testing.T) {
+	now := time.Now()
+	context := newTestAuthContext(t, &now)
+
+	token, err := context.IssueToken(User{Resource: Resource{ID: 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seenID int64
+	next := func(w http.ResponseWriter, r *http.Request) {
+		seenID, _, _ = context.LoggedInUser(r)
+	}
+
+	// A valid token reaches the handler.
+	req := httptest.NewRequest("GET", "/api/note", nil)
+	req.Header.Set("Authorization", "Bearer " + token)
+	rec := httptest.NewRecorder()
+	context.AuthMiddleware(rec, req, next)
+	AssertEqual(200, rec.Code, t)
+	AssertEqual(int64(7), seenID, t)
+
+	// A missing token does not.
+	req = httptest.NewRequest("GET", "/api/note", nil)
+	rec = httptest.NewRecorder()
+	context.AuthMiddleware(rec, req, next)
+	AssertEqual(401, rec.Code, t)
+}
+
+// TestLoginRefresh ensures that refreshed tokens keep the time of their
+// login, and that logins stop being refreshed once they are too old or the
+// user's credentials have changed.
+func TestLoginRefresh(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	context, router := NewTestApp(t, db, &now)
+	u, err := LoadUser(ids["user.nonadmin"], db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(token string) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest("POST", "/login/refresh", nil)
+		req.Header.Set("Authorization", "Bearer " + token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec, body.Token
+	}
+
+	token, err := context.IssueToken(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := now
+
+	// A refreshed token counts from the same login.
+	now = now.Add(10 * time.Minute)
+	rec, token := refresh(token)
+	AssertEqual(rec.Code, 200, t)
+	p, err := context.ParseToken(token)
+	AssertEqual(err, nil, t)
+	AssertEqual(p.AuthTime.Unix(), login.Unix(), t)
+
+	// A login that is too old is not refreshed.
+	old, _ := context.issueToken(u, now.Add(-SESSION_LIFETIME - time.Minute))
+	rec, _ = refresh(old)
+	AssertEqual(rec.Code, 401, t)
+
+	// Nor is one from before the password was changed.
+	now = now.Add(time.Minute)
+	AssertEqual(StorePassword(u.ID, "new password", now, db), nil, t)
+	rec, _ = refresh(token)
+	AssertEqual(rec.Code, 401, t)
+	AssertContains(rec.Body.String(), "Credentials changed", t)
+
+	// Or from before two-factor authentication was turned off.
+	token, _ = context.IssueToken(u)
+	now = now.Add(time.Minute)
+	AssertEqual(ResetTOTP(u.ID, now, db), nil, t)
+	rec, _ = refresh(token)
+	AssertEqual(rec.Code, 401, t)
+
+	// Logging in again works.
+	token, _ = context.IssueToken(u)
+	rec, _ = refresh(token)
+	AssertEqual(rec.Code, 200, t)
+}
@@ -0,0 +1,72 @@
+package csnotes
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrUnknownUser is returned by an authenticator that has no record of a
+// username, so that the next authenticator can be tried.
+var ErrUnknownUser = errors.New("Unknown user.")
+
+// Authenticator checks a username and password against some source of
+// accounts, and returns the matching user model.
+type Authenticator interface {
+	Authenticate(username, password string, db *sql.DB) (User, error)
+}
+
+// PasswordAuthenticator checks passwords against the bcrypt hashes stored in
+// the users table.
+type PasswordAuthenticator struct{}
+
+func (PasswordAuthenticator) Authenticate(username, password string, db *sql.DB) (u User, err error) {
+	// Create a new user model with an ID. If the user was not found,
+	// return an empty user and and error.
+	u = NewUser(db)
+	row := db.QueryRow("SELECT id FROM users WHERE username=?", username)
+	err = row.Scan(&u.ID)
+	if err == sql.ErrNoRows {
+		// Spend as long as a real password check would, so that response
+		// times do not reveal which usernames exist.
+		ComparePasswordDummy(password)
+		return u, ErrUnknownUser
+	}
+	if err != nil {
+		return u, err
+	}
+
+	// Validate the user's password. If the password is not valid, do not load 
+	// the model but return an error.
+	valid, err := CheckPassword(u.ID, password, db)
+	if !valid || err != nil {
+		return u, err
+	}
+
+	// Load the user model.
+	err = u.Load()
+	if err != nil {
+		return u, err
+	}
+
+	return
+}
+
+// ValidateUser takes a username and password and attempts to load a 
+// user model from this information. The authenticators are tried in order
+// until one accepts the credentials; if none are given, only stored
+// passwords are checked. If no authenticator accepts the credentials, the
+// last error is returned.
+func ValidateUser(username, password string, db *sql.DB, authenticators ...Authenticator) (u User, err error) {
+	if len(authenticators) == 0 {
+		authenticators = []Authenticator{PasswordAuthenticator{}}
+	}
+
+	for _, a := range authenticators {
+		u, err = a.Authenticate(username, password, db)
+		if err == nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,729 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A backup is a zip archive holding a JSON array of rows for each table it
+// covers, and a manifest describing them.
+const (
+	BACKUP_FORMAT = "csnotes-backup"
+
+	// The version of the backup format. Backups of a newer version than this
+	// can't be restored.
+	BACKUP_VERSION = 1
+
+	BACKUP_MANIFEST = "manifest.json"
+)
+
+// The files of a backup, in the order they are restored.
+const (
+	BACKUP_USERS = "users.json"
+	BACKUP_NOTES = "notes.json"
+	BACKUP_ITEMS = "checklist_items.json"
+	BACKUP_TAGS = "tags.json"
+	BACKUP_NOTE_TAGS = "note_tag.json"
+)
+
+var BACKUP_FILES = []string {
+	BACKUP_USERS, BACKUP_NOTES, BACKUP_ITEMS, BACKUP_TAGS, BACKUP_NOTE_TAGS,
+}
+
+// ErrBackupNotEmpty is returned when a backup would be restored, without
+// merging, into a database that already has users or notes.
+var ErrBackupNotEmpty = errors.New("Database is not empty. Restore with merging to add to it.")
+
+// BackupManifest describes a backup and each of its files.
+type BackupManifest struct {
+	Format string `json:"format"`
+	Version int `json:"version"`
+	Created string `json:"created"`
+	Files map[string]BackupFile `json:"files"`
+}
+
+// BackupFile is how many rows a file of a backup holds, and its checksum.
+type BackupFile struct {
+	Rows int `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupUser is a user's row in a backup, including their password hash and
+// second factor, so that they can still log in once it is restored.
+type BackupUser struct {
+	ID int64 `json:"id"`
+	Username string `json:"username"`
+	Name *string `json:"name"`
+	Email *string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
+	OIDCSubject *string `json:"oidc_subject"`
+	AuthSource string `json:"auth_source"`
+	Password string `json:"password"`
+	Salt string `json:"salt"`
+	Admin bool `json:"admin"`
+	TOTPSecret *string `json:"totp_secret"`
+	TOTPEnabled bool `json:"totp_enabled"`
+	TOTPLastStep int64 `json:"totp_last_step"`
+	CalendarToken *string `json:"calendar_token"`
+}
+
+// BackupNote is a note's row in a backup.
+type BackupNote struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	Title string `json:"title"`
+	Type string `json:"type"`
+	Content *string `json:"content"`
+	Time *string `json:"time"`
+	Pinned bool `json:"pinned"`
+	Archived bool `json:"archived"`
+	Color string `json:"color"`
+	Created *string `json:"created"`
+	Updated *string `json:"updated"`
+}
+
+// BackupItem is a checklist item's row in a backup.
+type BackupItem struct {
+	ID int64 `json:"id"`
+	NoteID int64 `json:"note_id"`
+	Text string `json:"text"`
+	Checked bool `json:"checked"`
+	Position int `json:"position"`
+}
+
+// BackupTag is a tag's row in a backup.
+type BackupTag struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	Title string `json:"title"`
+}
+
+// BackupNoteTag is a row of note_tag in a backup.
+type BackupNoteTag struct {
+	NoteID int64 `json:"note_id"`
+	TagID int64 `json:"tag_id"`
+}
+
+// RestoreReport sums up a restore.
+type RestoreReport struct {
+	Users int `json:"users"`
+
+	// The users of the backup who were matched, by username, to users
+	// already in the database when merging.
+	MergedUsers int `json:"merged_users"`
+
+	Notes int `json:"notes"`
+
+	// The notes of the backup that a merged user already had, with the same
+	// title and creation time, and that were left out when merging.
+	SkippedNotes int `json:"skipped_notes"`
+
+	Items int `json:"items"`
+	Tags int `json:"tags"`
+	NoteTags int `json:"note_tags"`
+
+	// What could not be kept as it was, such as the email of a user that
+	// another user already has.
+	Warnings []string `json:"warnings"`
+}
+
+// WriteBackup writes every user, with their notes, checklist items and tags,
+// to a backup archive. Attachments, reminders and other data are not backed
+// up. Rows are streamed a table at a time, all read from one snapshot of the
+// database so that the backup is consistent while the app is running.
+func WriteBackup(out io.Writer, db *sql.DB, now time.Time) (m BackupManifest, err error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	m = BackupManifest {
+		Format: BACKUP_FORMAT,
+		Version: BACKUP_VERSION,
+		Created: now.UTC().Format(time.RFC3339),
+		Files: map[string]BackupFile{},
+	}
+
+	zw := zip.NewWriter(out)
+
+	for _, table := range []struct {
+		name string
+		query string
+		scan func(rows *sql.Rows) (interface{}, error)
+	} {
+		{BACKUP_USERS, `SELECT id, username, name, email, email_verified, oidc_subject, auth_source,
+			password, salt, admin, totp_secret, totp_enabled, totp_last_step, calendar_token
+			FROM users ORDER BY id`, scanBackupUser},
+		{BACKUP_NOTES, `SELECT id, user_id, title, type, content, time, pinned, archived, color, created, updated
+			FROM notes ORDER BY id`, scanBackupNote},
+		{BACKUP_ITEMS, "SELECT id, note_id, text, checked, position FROM checklist_items ORDER BY id", scanBackupItem},
+		{BACKUP_TAGS, "SELECT id, user_id, title FROM tags ORDER BY id", scanBackupTag},
+		{BACKUP_NOTE_TAGS, "SELECT note_id, tag_id FROM note_tag ORDER BY note_id, tag_id", scanBackupNoteTag},
+	} {
+		m.Files[table.name], err = writeBackupFile(zw, table.name, tx, table.query, table.scan, now)
+		if err != nil {
+			return
+		}
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader {
+		Name: BACKUP_MANIFEST,
+		Method: zip.Deflate,
+		Modified: now,
+	})
+	if err != nil {
+		return
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		return
+	}
+
+	err = zw.Close()
+	return
+}
+
+// writeBackupFile writes a table's rows to a file of a backup as a JSON
+// array, one row per line.
+func writeBackupFile(zw *zip.Writer, name string, tx *sql.Tx, query string, scan func(*sql.Rows) (interface{}, error), now time.Time) (bf BackupFile, err error) {
+	f, err := zw.CreateHeader(&zip.FileHeader {
+		Name: name,
+		Method: zip.Deflate,
+		Modified: now,
+	})
+	if err != nil {
+		return
+	}
+
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	io.WriteString(w, "[")
+	for rows.Next() {
+		row, err := scan(rows)
+		if err != nil {
+			return bf, err
+		}
+		b, err := json.Marshal(row)
+		if err != nil {
+			return bf, err
+		}
+
+		if bf.Rows > 0 {
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, "\n")
+		w.Write(b)
+		bf.Rows++
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	_, err = io.WriteString(w, "\n]\n")
+
+	bf.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+// nullStringPtr converts a nullable column for a backup.
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func scanBackupUser(rows *sql.Rows) (interface{}, error) {
+	var u BackupUser
+	var name, email, subject, secret, calendar sql.NullString
+	err := rows.Scan(&u.ID, &u.Username, &name, &email, &u.EmailVerified, &subject, &u.AuthSource,
+		&u.Password, &u.Salt, &u.Admin, &secret, &u.TOTPEnabled, &u.TOTPLastStep, &calendar)
+	u.Name = nullStringPtr(name)
+	u.Email = nullStringPtr(email)
+	u.OIDCSubject = nullStringPtr(subject)
+	u.TOTPSecret = nullStringPtr(secret)
+	u.CalendarToken = nullStringPtr(calendar)
+	return u, err
+}
+
+func scanBackupNote(rows *sql.Rows) (interface{}, error) {
+	var n BackupNote
+	var content, t, created, updated sql.NullString
+	err := rows.Scan(&n.ID, &n.UserID, &n.Title, &n.Type, &content, &t, &n.Pinned, &n.Archived, &n.Color, &created, &updated)
+	n.Content = nullStringPtr(content)
+	n.Time = nullStringPtr(t)
+	n.Created = nullStringPtr(created)
+	n.Updated = nullStringPtr(updated)
+	return n, err
+}
+
+func scanBackupItem(rows *sql.Rows) (interface{}, error) {
+	var i BackupItem
+	err := rows.Scan(&i.ID, &i.NoteID, &i.Text, &i.Checked, &i.Position)
+	return i, err
+}
+
+func scanBackupTag(rows *sql.Rows) (interface{}, error) {
+	var t BackupTag
+	err := rows.Scan(&t.ID, &t.UserID, &t.Title)
+	return t, err
+}
+
+func scanBackupNoteTag(rows *sql.Rows) (interface{}, error) {
+	var nt BackupNoteTag
+	err := rows.Scan(&nt.NoteID, &nt.TagID)
+	return nt, err
+}
+
+// VerifyBackup checks a backup's integrity before it is restored: that it is
+// of a version that can be restored, that every file matches its checksum
+// and row count, and that every row refers to rows that are in the backup.
+func VerifyBackup(zr *zip.Reader) (m BackupManifest, err error) {
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	mf, ok := files[BACKUP_MANIFEST]
+	if !ok {
+		return m, errors.New("Backup has no manifest.")
+	}
+	r, err := mf.Open()
+	if err != nil {
+		return
+	}
+	err = json.NewDecoder(io.LimitReader(r, 1 << 20)).Decode(&m)
+	r.Close()
+	if err != nil || m.Format != BACKUP_FORMAT {
+		return m, errors.New("Backup's manifest is not valid.")
+	}
+	if m.Version < 1 || m.Version > BACKUP_VERSION {
+		return m, fmt.Errorf("Backup is of version %d, but only versions up to %d can be restored.", m.Version, BACKUP_VERSION)
+	}
+
+	// Check each file's checksum.
+	for _, name := range BACKUP_FILES {
+		bf, ok := m.Files[name]
+		f, found := files[name]
+		if !ok || !found {
+			return m, fmt.Errorf("Backup is missing %s.", name)
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return m, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, r)
+		r.Close()
+		if err != nil {
+			return m, fmt.Errorf("Could not read %s: %v", name, err)
+		}
+		if hex.EncodeToString(hash.Sum(nil)) != bf.SHA256 {
+			return m, fmt.Errorf("%s does not match its checksum.", name)
+		}
+	}
+
+	// Check that rows refer to each other, and count them.
+	users := map[int64]bool{}
+	usernames := map[string]bool{}
+	notes := map[int64]bool{}
+	tags := map[int64]bool{}
+	items := map[int64]bool{}
+	noteTags := map[BackupNoteTag]bool{}
+	counts := map[string]int{}
+
+	check := func(name string, each func(d *json.Decoder) error) error {
+		return readBackupFile(files[name], func(d *json.Decoder) error {
+			counts[name]++
+			return each(d)
+		})
+	}
+
+	err = check(BACKUP_USERS, func(d *json.Decoder) error {
+		var u BackupUser
+		if err := d.Decode(&u); err != nil {
+			return err
+		}
+		if users[u.ID] {
+			return fmt.Errorf("User %d is in the backup twice.", u.ID)
+		}
+		if usernames[u.Username] {
+			return fmt.Errorf("Username %q is in the backup twice.", u.Username)
+		}
+		users[u.ID] = true
+		usernames[u.Username] = true
+		return nil
+	})
+	if err == nil {
+		err = check(BACKUP_NOTES, func(d *json.Decoder) error {
+			var n BackupNote
+			if err := d.Decode(&n); err != nil {
+				return err
+			}
+			if notes[n.ID] {
+				return fmt.Errorf("Note %d is in the backup twice.", n.ID)
+			}
+			if !users[n.UserID] {
+				return fmt.Errorf("Note %d belongs to user %d, who is not in the backup.", n.ID, n.UserID)
+			}
+			notes[n.ID] = true
+			return nil
+		})
+	}
+	if err == nil {
+		err = check(BACKUP_ITEMS, func(d *json.Decoder) error {
+			var i BackupItem
+			if err := d.Decode(&i); err != nil {
+				return err
+			}
+			if items[i.ID] {
+				return fmt.Errorf("Item %d is in the backup twice.", i.ID)
+			}
+			if !notes[i.NoteID] {
+				return fmt.Errorf("Item %d belongs to note %d, which is not in the backup.", i.ID, i.NoteID)
+			}
+			items[i.ID] = true
+			return nil
+		})
+	}
+	if err == nil {
+		err = check(BACKUP_TAGS, func(d *json.Decoder) error {
+			var t BackupTag
+			if err := d.Decode(&t); err != nil {
+				return err
+			}
+			if tags[t.ID] {
+				return fmt.Errorf("Tag %d is in the backup twice.", t.ID)
+			}
+			if !users[t.UserID] {
+				return fmt.Errorf("Tag %d belongs to user %d, who is not in the backup.", t.ID, t.UserID)
+			}
+			tags[t.ID] = true
+			return nil
+		})
+	}
+	if err == nil {
+		err = check(BACKUP_NOTE_TAGS, func(d *json.Decoder) error {
+			var nt BackupNoteTag
+			if err := d.Decode(&nt); err != nil {
+				return err
+			}
+			if !notes[nt.NoteID] || !tags[nt.TagID] {
+				return fmt.Errorf("Note %d's tag %d is not in the backup.", nt.NoteID, nt.TagID)
+			}
+			if noteTags[nt] {
+				return fmt.Errorf("Note %d's tag %d is in the backup twice.", nt.NoteID, nt.TagID)
+			}
+			noteTags[nt] = true
+			return nil
+		})
+	}
+	if err != nil {
+		return
+	}
+
+	for _, name := range BACKUP_FILES {
+		if counts[name] != m.Files[name].Rows {
+			return m, fmt.Errorf("%s has %d rows, but should have %d.", name, counts[name], m.Files[name].Rows)
+		}
+	}
+
+	return
+}
+
+// readBackupFile decodes a file of a backup's JSON array a row at a time,
+// calling each to decode every row.
+func readBackupFile(f *zip.File, each func(d *json.Decoder) error) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	d := json.NewDecoder(r)
+	if t, err := d.Token(); err != nil || t != json.Delim('[') {
+		return fmt.Errorf("%s is not a JSON array.", f.Name)
+	}
+	for d.More() {
+		err = each(d)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	if _, err := d.Token(); err != nil {
+		return fmt.Errorf("%s is not a JSON array.", f.Name)
+	}
+
+	return nil
+}
+
+// RestoreBackup restores a backup in a single transaction, after verifying
+// it. Without merging, the database must have no users or notes, and rows
+// keep their IDs. When merging, rows are added to what is already there with
+// new IDs: users are matched by username to existing users, whose accounts
+// are left as they are, tags are matched by title to a user's existing tags,
+// and notes a user already has, with the same title and creation time, are
+// left out with their items. Restoring the same backup twice therefore adds
+// nothing the second time.
+func RestoreBackup(r io.ReaderAt, size int64, db *sql.DB, merge bool) (report RestoreReport, err error) {
+	report.Warnings = []string{}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return report, errors.New("Backup is not a zip archive.")
+	}
+	_, err = VerifyBackup(zr)
+	if err != nil {
+		return
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if !merge {
+		var count int
+		err = tx.QueryRow("SELECT (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM notes)").Scan(&count)
+		if err != nil {
+			return
+		}
+		if count > 0 {
+			return report, ErrBackupNotEmpty
+		}
+	}
+
+	// The IDs rows were given, by their IDs in the backup.
+	userIDs := map[int64]int64{}
+	noteIDs := map[int64]int64{}
+	tagIDs := map[int64]int64{}
+
+	// The notes of the backup that were already there, by their IDs in the
+	// backup.
+	skipped := map[int64]bool{}
+
+	// Insert a row, keeping its ID unless merging.
+	insert := func(query string, id int64, args ...interface{}) (int64, error) {
+		if !merge {
+			_, err := tx.Exec(query, append([]interface{}{id}, args...)...)
+			return id, err
+		}
+		res, err := tx.Exec(query, append([]interface{}{nil}, args...)...)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+
+	err = readBackupFile(files[BACKUP_USERS], func(d *json.Decoder) error {
+		var u BackupUser
+		if err := d.Decode(&u); err != nil {
+			return err
+		}
+
+		if merge {
+			var id int64
+			err := tx.QueryRow("SELECT id FROM users WHERE username=?", u.Username).Scan(&id)
+			if err == nil {
+				userIDs[u.ID] = id
+				report.MergedUsers++
+				return nil
+			}
+			if err != sql.ErrNoRows {
+				return err
+			}
+
+			// Leave out anything another user already has.
+			for _, field := range []struct {
+				column string
+				value **string
+			} {
+				{"email", &u.Email},
+				{"oidc_subject", &u.OIDCSubject},
+				{"calendar_token", &u.CalendarToken},
+			} {
+				if *field.value == nil {
+					continue
+				}
+				var taken bool
+				err := tx.QueryRow("SELECT COUNT(*) > 0 FROM users WHERE " + field.column + "=?", **field.value).Scan(&taken)
+				if err != nil {
+					return err
+				}
+				if taken {
+					report.Warnings = append(report.Warnings, fmt.Sprintf("User %s's %s is already taken, and was left out.", u.Username, field.column))
+					*field.value = nil
+				}
+			}
+		}
+
+		id, err := insert(`INSERT INTO users (id, username, name, email, email_verified, oidc_subject, auth_source,
+			password, salt, admin, totp_secret, totp_enabled, totp_last_step, calendar_token)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, u.ID,
+			u.Username, u.Name, u.Email, u.EmailVerified, u.OIDCSubject, u.AuthSource,
+			u.Password, u.Salt, u.Admin, u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep, u.CalendarToken)
+		if err != nil {
+			return err
+		}
+		userIDs[u.ID] = id
+		report.Users++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = readBackupFile(files[BACKUP_NOTES], func(d *json.Decoder) error {
+		var n BackupNote
+		if err := d.Decode(&n); err != nil {
+			return err
+		}
+
+		// A merged user may already have the note.
+		if merge {
+			var id int64
+			err := tx.QueryRow("SELECT id FROM notes WHERE user_id=? AND title=? AND created<=>? ORDER BY id LIMIT 1",
+				userIDs[n.UserID], n.Title, n.Created).Scan(&id)
+			if err == nil {
+				noteIDs[n.ID] = id
+				skipped[n.ID] = true
+				report.SkippedNotes++
+				return nil
+			}
+			if err != sql.ErrNoRows {
+				return err
+			}
+		}
+
+		id, err := insert(`INSERT INTO notes (id, user_id, title, type, content, time, pinned, archived, color, created, updated)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, n.ID,
+			userIDs[n.UserID], n.Title, n.Type, n.Content, n.Time, n.Pinned, n.Archived, n.Color, n.Created, n.Updated)
+		if err != nil {
+			return err
+		}
+		noteIDs[n.ID] = id
+		report.Notes++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = readBackupFile(files[BACKUP_ITEMS], func(d *json.Decoder) error {
+		var i BackupItem
+		if err := d.Decode(&i); err != nil {
+			return err
+		}
+		if skipped[i.NoteID] {
+			return nil
+		}
+
+		_, err := insert("INSERT INTO checklist_items (id, note_id, text, checked, position) VALUES (?, ?, ?, ?, ?)", i.ID,
+			noteIDs[i.NoteID], i.Text, i.Checked, i.Position)
+		if err != nil {
+			return err
+		}
+		report.Items++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = readBackupFile(files[BACKUP_TAGS], func(d *json.Decoder) error {
+		var t BackupTag
+		if err := d.Decode(&t); err != nil {
+			return err
+		}
+
+		// A merged user's tag may already be there.
+		if merge {
+			var id int64
+			err := tx.QueryRow("SELECT id FROM tags WHERE user_id=? AND title=? ORDER BY id LIMIT 1", userIDs[t.UserID], t.Title).Scan(&id)
+			if err == nil {
+				tagIDs[t.ID] = id
+				return nil
+			}
+			if err != sql.ErrNoRows {
+				return err
+			}
+		}
+
+		id, err := insert("INSERT INTO tags (id, user_id, title) VALUES (?, ?, ?)", t.ID, userIDs[t.UserID], t.Title)
+		if err != nil {
+			return err
+		}
+		tagIDs[t.ID] = id
+		report.Tags++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = readBackupFile(files[BACKUP_NOTE_TAGS], func(d *json.Decoder) error {
+		var nt BackupNoteTag
+		if err := d.Decode(&nt); err != nil {
+			return err
+		}
+
+		// Two tags of the backup may have been merged into one.
+		_, err := tx.Exec("INSERT IGNORE INTO note_tag (note_id, tag_id) VALUES (?, ?)", noteIDs[nt.NoteID], tagIDs[nt.TagID])
+		if err != nil {
+			return err
+		}
+		report.NoteTags++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	// Links between notes aren't backed up, so find them again now that the
+	// notes can be seen.
+	for backupID, id := range noteIDs {
+		if skipped[backupID] {
+			continue
+		}
+		n, err := LoadNote(id, db)
+		if err == nil {
+			err = n.SyncLinks()
+		}
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Could not find note %d's links.", id))
+		}
+	}
+
+	return report, nil
+}
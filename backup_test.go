@@ -0,0 +1,175 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// testBackup builds a backup archive from the contents of its files, with a
+// manifest that matches them.
+func testBackup(version int, files map[string]string) *zip.Reader {
+	m := BackupManifest {
+		Format: BACKUP_FORMAT,
+		Version: version,
+		Files: map[string]BackupFile{},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range BACKUP_FILES {
+		content, ok := files[name]
+		if !ok {
+			content = "[]"
+		}
+		var rows []json.RawMessage
+		json.Unmarshal([]byte(content), &rows)
+		sum := sha256.Sum256([]byte(content))
+		m.Files[name] = BackupFile{len(rows), hex.EncodeToString(sum[:])}
+
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	f, _ := zw.Create(BACKUP_MANIFEST)
+	json.NewEncoder(f).Encode(m)
+	zw.Close()
+
+	zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	return zr
+}
+
+func TestVerifyBackup(t *testing.T) {
+	files := map[string]string {
+		BACKUP_USERS: `[{"id": 1, "username": "a"}, {"id": 2, "username": "b"}]`,
+		BACKUP_NOTES: `[{"id": 5, "user_id": 2, "title": "note"}]`,
+		BACKUP_ITEMS: `[{"id": 1, "note_id": 5, "text": "item"}]`,
+		BACKUP_TAGS: `[{"id": 3, "user_id": 1, "title": "tag"}]`,
+		BACKUP_NOTE_TAGS: `[{"note_id": 5, "tag_id": 3}]`,
+	}
+	m, err := VerifyBackup(testBackup(BACKUP_VERSION, files))
+	AssertEqual(err, nil, t)
+	AssertEqual(m.Files[BACKUP_USERS].Rows, 2, t)
+
+	// Newer backups can't be restored.
+	_, err = VerifyBackup(testBackup(BACKUP_VERSION + 1, files))
+	AssertUnequal(err, nil, t)
+
+	// Rows must refer to rows in the backup.
+	for name, content := range map[string]string {
+		BACKUP_USERS: `[{"id": 1, "username": "a"}, {"id": 2, "username": "a"}]`,
+		BACKUP_NOTES: `[{"id": 5, "user_id": 9, "title": "note"}]`,
+		BACKUP_NOTE_TAGS: `[{"note_id": 5, "tag_id": 4}]`,
+	} {
+		broken := map[string]string{}
+		for k, v := range files {
+			broken[k] = v
+		}
+		broken[name] = content
+		_, err = VerifyBackup(testBackup(BACKUP_VERSION, broken))
+		AssertUnequal(err, nil, t)
+	}
+}
+
+func TestVerifyBackupChecksum(t *testing.T) {
+	zr := testBackup(BACKUP_VERSION, map[string]string {
+		BACKUP_USERS: `[{"id": 1, "username": "a"}]`,
+	})
+
+	// Swap in a different file under the same manifest.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		w, _ := zw.Create(f.Name)
+		if f.Name == BACKUP_USERS {
+			w.Write([]byte(`[{"id": 1, "username": "b"}]`))
+			continue
+		}
+		r, _ := f.Open()
+		var b bytes.Buffer
+		b.ReadFrom(r)
+		r.Close()
+		w.Write(b.Bytes())
+	}
+	zw.Close()
+
+	tampered, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	_, err := VerifyBackup(tampered)
+	AssertUnequal(err, nil, t)
+	if err != nil {
+		AssertContains(err.Error(), "checksum", t)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	m, err := WriteBackup(&buf, db, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(m.Files[BACKUP_USERS].Rows, 2, t)
+	AssertEqual(m.Files[BACKUP_NOTES].Rows, 2, t)
+	backup := bytes.NewReader(buf.Bytes())
+
+	// A backup is only restored over an empty database, unless merging.
+	_, err = RestoreBackup(backup, backup.Size(), db, false)
+	AssertEqual(err, ErrBackupNotEmpty, t)
+
+	// Merging matches users by name, and leaves out the notes they already
+	// have.
+	report, err := RestoreBackup(backup, backup.Size(), db, true)
+	AssertEqual(err, nil, t)
+	AssertEqual(report.MergedUsers, 2, t)
+	AssertEqual(report.Users, 0, t)
+	AssertEqual(report.Notes, 0, t)
+	AssertEqual(report.SkippedNotes, 2, t)
+	AssertEqual(report.Tags, 0, t)
+	u, _ := LoadUser(ids["user.nonadmin"], db)
+	ns, _ := u.Notes()
+	AssertEqual(len(ns), 2, t)
+
+	// A note that was renamed since the backup is added back.
+	n, _ := LoadNote(ids["note.note1"], db)
+	n.Title = "renamed"
+	n.Save(time.Now())
+	report, err = RestoreBackup(backup, backup.Size(), db, true)
+	AssertEqual(err, nil, t)
+	AssertEqual(report.Notes, 1, t)
+	AssertEqual(report.SkippedNotes, 1, t)
+	ns, _ = u.Notes()
+	AssertEqual(len(ns), 3, t)
+
+	// Restoring into an empty database keeps the IDs and passwords.
+	TearDownDB(db)
+	SetUpDB(db)
+	report, err = RestoreBackup(backup, backup.Size(), db, false)
+	AssertEqual(err, nil, t)
+	AssertEqual(report.Users, 2, t)
+	AssertEqual(report.NoteTags, 4, t)
+
+	n, err = LoadNote(ids["note.note1"], db)
+	AssertEqual(err, nil, t)
+	AssertEqual(n.Title, "note1", t)
+	AssertEqual(n.UserID, ids["user.nonadmin"], t)
+	ok, _ := CheckPassword(ids["user.nonadmin"], "password", db)
+	AssertEqual(ok, true, t)
+
+	// A backup that fails part of the way through leaves nothing behind.
+	TearDownDB(db)
+	SetUpDB(db)
+	db.Exec("DROP TABLE note_tag")
+	_, err = RestoreBackup(backup, backup.Size(), db, false)
+	AssertUnequal(err, nil, t)
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	AssertEqual(count, 0, t)
+}
@@ -0,0 +1,111 @@
+package csnotes
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBlobNotFound is returned when a blob does not exist in a store.
+var ErrBlobNotFound = errors.New("Blob not found.")
+
+// BlobStore keeps the contents of uploaded files, by key. Keys are made of
+// letters, digits and slashes. Implementations must be safe for concurrent
+// use.
+type BlobStore interface {
+	// Put stores a blob of a known size, replacing any with the same key.
+	Put(key string, r io.Reader, size int64, contentType string) error
+
+	// Get opens a blob for reading. The caller must close it.
+	Get(key string) (io.ReadCloser, error)
+
+	// Delete removes a blob. Deleting a blob that does not exist is not an
+	// error.
+	Delete(key string) error
+}
+
+// validBlobKey checks that a key cannot refer to anything outside a store.
+func validBlobKey(key string) bool {
+	if len(key) == 0 || strings.HasPrefix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if len(part) == 0 || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalBlobStore keeps blobs as files under a directory.
+type LocalBlobStore struct {
+	Dir string
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", errors.New("Invalid blob key.")
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalBlobStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a failed write never leaves
+	// a partial blob behind.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return errors.New("Blob size does not match.")
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
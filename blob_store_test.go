@@ -0,0 +1,143 @@
+package csnotes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	store := &LocalBlobStore{Dir: t.TempDir()}
+	testBlobStore(store, t)
+
+	// Keys must not escape the directory.
+	err := store.Put("../outside", strings.NewReader("x"), 1, "text/plain")
+	AssertUnequal(err, nil, t)
+
+	// The size must match what was written.
+	err = store.Put("short", strings.NewReader("abc"), 5, "text/plain")
+	AssertUnequal(err, nil, t)
+	_, err = store.Get("short")
+	AssertEqual(err, ErrBlobNotFound, t)
+}
+
+func TestS3BlobStore(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(newFakeS3("AKID", "secret", "us-east-1", now, t))
+	defer server.Close()
+
+	store := &S3BlobStore {
+		Endpoint: server.URL,
+		Region: "us-east-1",
+		Bucket: "notes",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		Client: server.Client(),
+		Clock: func() time.Time { return now },
+	}
+	testBlobStore(store, t)
+
+	// A wrong secret is refused by the service.
+	store.SecretKey = "wrong"
+	err := store.Put("a/b", strings.NewReader("x"), 1, "text/plain")
+	AssertUnequal(err, nil, t)
+}
+
+func TestDetectAttachmentType(t *testing.T) {
+	AssertEqual(DetectAttachmentType([]byte("\x89PNG\r\n\x1a\n")), "image/png", t)
+	AssertEqual(DetectAttachmentType([]byte("%PDF-1.4")), "application/pdf", t)
+	AssertEqual(DetectAttachmentType([]byte("just some text")), "text/plain", t)
+	AssertEqual(DetectAttachmentType([]byte("<html><body></body></html>")), "", t)
+	AssertEqual(DetectAttachmentType([]byte("MZ\x90\x00\x03\x00\x00\x00")), "", t)
+}
+
+func TestCleanFilename(t *testing.T) {
+	AssertEqual(CleanFilename("photo.png"), "photo.png", t)
+	AssertEqual(CleanFilename("C:\\Users\\me\\photo.png"), "photo.png", t)
+	AssertEqual(CleanFilename("../../etc/passwd"), "passwd", t)
+	AssertEqual(CleanFilename("a\"b\r\n.txt"), "ab.txt", t)
+	AssertEqual(CleanFilename(".."), "attachment", t)
+	AssertEqual(CleanFilename(""), "attachment", t)
+}
+
+// testBlobStore checks the behavior every blob store must share.
+func testBlobStore(store BlobStore, t *testing.T) {
+	_, err := store.Get("attachments/missing")
+	AssertEqual(err, ErrBlobNotFound, t)
+
+	err = store.Put("attachments/one", strings.NewReader("hello"), 5, "text/plain")
+	AssertEqual(err, nil, t)
+
+	r, err := store.Get("attachments/one")
+	AssertEqual(err, nil, t)
+	if err == nil {
+		b, _ := io.ReadAll(r)
+		r.Close()
+		AssertEqual(string(b), "hello", t)
+	}
+
+	// Putting the same key replaces the blob.
+	err = store.Put("attachments/one", strings.NewReader("bye"), 3, "text/plain")
+	AssertEqual(err, nil, t)
+	r, err = store.Get("attachments/one")
+	AssertEqual(err, nil, t)
+	if err == nil {
+		b, _ := io.ReadAll(r)
+		r.Close()
+		AssertEqual(string(b), "bye", t)
+	}
+
+	err = store.Delete("attachments/one")
+	AssertEqual(err, nil, t)
+	_, err = store.Get("attachments/one")
+	AssertEqual(err, ErrBlobNotFound, t)
+
+	// Deleting twice is not an error.
+	err = store.Delete("attachments/one")
+	AssertEqual(err, nil, t)
+}
+
+// newFakeS3 stands in for an S3 service. It keeps objects in memory and
+// checks each request's signature by signing a copy of it again.
+func newFakeS3(accessKey, secretKey, region string, now time.Time, t *testing.T) http.HandlerFunc {
+	var lock sync.Mutex
+	objects := map[string][]byte{}
+
+	return func (w http.ResponseWriter, r *http.Request) {
+		check, _ := http.NewRequest(r.Method, "http://" + r.Host + r.URL.RequestURI(), nil)
+		SignS3Request(check, r.Header.Get("X-Amz-Content-Sha256"), accessKey, secretKey, region, now)
+		if check.Header.Get("Authorization") != r.Header.Get("Authorization") {
+			http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case "PUT":
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = b
+		case "GET":
+			b, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			io.Copy(w, strings.NewReader(string(b)))
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected method %s.", r.Method)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package csnotes
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// Identifies the app as the producer of calendar feeds.
+	CALENDAR_PRODID = "-//csnotes//Notes App//EN"
+
+	// The layout of UTC times in iCalendar.
+	ICAL_TIME_FORMAT = "20060102T150405Z"
+
+	// Lines longer than this many bytes are folded, as RFC 5545 requires.
+	ICAL_LINE_LENGTH = 75
+)
+
+// CalendarItem is a dated note, as it appears in a calendar feed.
+type CalendarItem struct {
+	NoteID int64
+	Title string
+	Description string
+	Start time.Time
+
+	// How the note's reminder repeats, if it has one.
+	Recurrence Recurrence
+}
+
+// hashCalendarToken hashes a calendar feed token for storage.
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateCalendarToken gives a user a new calendar feed token, replacing any
+// they had, so that the old feed URL stops working. Only a hash is stored.
+func CreateCalendarToken(uID int64, db *sql.DB) (string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec("UPDATE users SET calendar_token=? WHERE id=?", hashCalendarToken(token), uID)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeCalendarToken turns off a user's calendar feed.
+func RevokeCalendarToken(uID int64, db *sql.DB) error {
+	_, err := db.Exec("UPDATE users SET calendar_token=NULL WHERE id=?", uID)
+	return err
+}
+
+// AuthenticateCalendarToken finds the user a calendar feed token belongs to.
+func AuthenticateCalendarToken(token string, db *sql.DB) (uID int64, err error) {
+	if len(token) == 0 {
+		return 0, errors.New("No calendar token.")
+	}
+
+	err = db.QueryRow("SELECT id FROM users WHERE calendar_token=?", hashCalendarToken(token)).Scan(&uID)
+	return
+}
+
+// LoadCalendarItems loads a user's notes that have a time or a reminder.
+// Notes with a reminder appear at its first occurrence, repeating as it
+// does. If tags are given, only notes with at least one of them are loaded.
+func LoadCalendarItems(uID int64, tags []string, db *sql.DB) (items []CalendarItem, err error) {
+	items = []CalendarItem{}
+
+	query := `SELECT notes.id, notes.title, notes.content, notes.time, reminders.starts, reminders.recurrence
+		FROM notes LEFT JOIN reminders ON reminders.note_id=notes.id
+		WHERE notes.user_id=? AND (notes.time IS NOT NULL OR reminders.id IS NOT NULL)`
+	args := []interface{}{uID}
+	if len(tags) > 0 {
+		query += ` AND notes.id IN (SELECT note_tag.note_id FROM note_tag JOIN tags ON tags.id=note_tag.tag_id
+			WHERE tags.user_id=? AND tags.title IN (?` + strings.Repeat(", ?", len(tags) - 1) + `))`
+		args = append(args, uID)
+		for _, t := range tags {
+			args = append(args, t)
+		}
+	}
+	query += " ORDER BY notes.id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item CalendarItem
+		var content, noteTime, starts, recurrence sql.NullString
+		err = rows.Scan(&item.NoteID, &item.Title, &content, &noteTime, &starts, &recurrence)
+		if err != nil {
+			return
+		}
+		item.Description = content.String
+
+		// Prefer the reminder's schedule, since it may repeat.
+		var perr error
+		if starts.Valid {
+			item.Start, perr = time.Parse(DATETIME_FORMAT, starts.String)
+			if perr == nil {
+				item.Recurrence, perr = ParseRecurrence(recurrence.String)
+			}
+		} else {
+			item.Start, perr = time.Parse(DATETIME_FORMAT, noteTime.String)
+		}
+
+		// Skip notes whose times cannot be read, such as zero dates.
+		if perr != nil || item.Start.Year() < 1 {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	return
+}
+
+// WriteCalendar writes items as an iCalendar feed. Each becomes a VEVENT or,
+// if component is "VTODO", a to-do. UIDs are derived from note IDs and the
+// domain, so that clients update items rather than duplicating them.
+func WriteCalendar(out io.Writer, name, domain, component string, items []CalendarItem, now time.Time) error {
+	if component != "VTODO" {
+		component = "VEVENT"
+	}
+
+	w := bufio.NewWriter(out)
+	line := func(l string) {
+		writeICalLine(w, l)
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:" + CALENDAR_PRODID)
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:" + escapeICalText(name))
+
+	stamp := now.UTC().Format(ICAL_TIME_FORMAT)
+	for _, item := range items {
+		start := item.Start.UTC().Format(ICAL_TIME_FORMAT)
+
+		line("BEGIN:" + component)
+		line(fmt.Sprintf("UID:note-%d@%s", item.NoteID, domain))
+		line("DTSTAMP:" + stamp)
+		line("DTSTART:" + start)
+		if component == "VTODO" {
+			line("DUE:" + start)
+		}
+		if rrule := item.Recurrence.String(); len(rrule) > 0 {
+			line("RRULE:" + rrule)
+		}
+		line("SUMMARY:" + escapeICalText(item.Title))
+		if len(item.Description) > 0 {
+			line("DESCRIPTION:" + escapeICalText(item.Description))
+		}
+		line("END:" + component)
+	}
+
+	line("END:VCALENDAR")
+
+	return w.Flush()
+}
+
+// escapeICalText escapes a TEXT value.
+func escapeICalText(s string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\",
+		";", "\\;",
+		",", "\\,",
+		"\r\n", "\\n",
+		"\n", "\\n",
+		"\r", "",
+	).Replace(s)
+}
+
+// writeICalLine writes a content line ending in CRLF, folding it into
+// continuation lines where it is too long. Lines are only folded between
+// characters, never within one.
+func writeICalLine(w *bufio.Writer, l string) {
+	width := 0
+	for _, r := range l {
+		size := len(string(r))
+		if width + size > ICAL_LINE_LENGTH {
+			w.WriteString("\r\n ")
+			width = 1
+		}
+		w.WriteRune(r)
+		width += size
+	}
+	w.WriteString("\r\n")
+}
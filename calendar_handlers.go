@@ -0,0 +1,148 @@
+package csnotes
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// CalendarFeed is returned when a calendar feed is turned on. The URL holds
+// the feed's token, so it is only shown this once.
+type CalendarFeed struct {
+	URL string `json:"url"`
+}
+
+// GetCalendarFeed serves a user's dated notes as an iCalendar feed. Calendar
+// apps cannot send an authorization header, so the feed is found by the
+// token in its URL instead. Any number of "tag" parameters limit the feed to
+// notes with those tags, and "component=VTODO" lists the notes as to-dos.
+func GetCalendarFeed(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		uID, err := AuthenticateCalendarToken(mux.Vars(r)["token"], context.DB)
+		if err != nil {
+			http.Error(w, "Calendar not found.", http.StatusNotFound)
+			return
+		}
+
+		component := strings.ToUpper(r.FormValue("component"))
+		if len(component) > 0 && component != "VEVENT" && component != "VTODO" {
+			http.Error(w, "Component must be VEVENT or VTODO.", http.StatusBadRequest)
+			return
+		}
+
+		u, err := LoadUser(uID, context.DB)
+		if err != nil {
+			http.Error(w, "Could not load user.", http.StatusInternalServerError)
+			return
+		}
+
+		items, err := LoadCalendarItems(uID, r.URL.Query()["tag"], context.DB)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Could not load notes.", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		err = WriteCalendar(w, "Notes of " + u.Username, calendarDomain(context), component, items, context.Now())
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// PostUserCalendar turns on a user's calendar feed and returns its URL. If
+// the feed was already on, its old URL stops working.
+func PostUserCalendar(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		uID, ok := loadCalendarOwner(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		token, err := CreateCalendarToken(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not create calendar token."
+			return
+		}
+
+		resp.Models = append(resp.Models, CalendarFeed {
+			URL: context.BaseURL + "/calendar/" + token + ".ics",
+		})
+	}
+}
+
+// DeleteUserCalendar turns off a user's calendar feed.
+func DeleteUserCalendar(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		uID, ok := loadCalendarOwner(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		err := RevokeCalendarToken(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not revoke calendar token."
+			return
+		}
+	}
+}
+
+// loadCalendarOwner reads the user ID from the URL, as long as the logged in
+// user is that user or an admin.
+func loadCalendarOwner(context *Context, r *http.Request, resp *JSONResponse) (uID int64, ok bool) {
+	// Retrieve the user ID.
+	uID, ok = GetURLID(r, resp)
+	if !ok {
+		return
+	}
+
+	// Get the logged in user's data.
+	currentUserID, currentUserAdmin, err := context.LoggedInUser(r)
+	if err != nil {
+		resp.StatusCode = 500
+		resp.ErrorMessage = "Could not retrieve logged in user."
+		return uID, false
+	}
+	if !currentUserAdmin && currentUserID != uID {
+		resp.StatusCode = 403
+		resp.ErrorMessage = "Access denied."
+		return uID, false
+	}
+
+	// Check for the user's existence.
+	if e, err := CheckExistence(uID, "users", context.DB); !e {
+		if err == nil {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "User not found."
+		} else {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not verify user's existence."
+		}
+		return uID, false
+	}
+
+	return uID, true
+}
+
+// calendarDomain is the domain that feed UIDs are made unique with. It comes
+// from the app's public URL, so it stays the same across restarts.
+func calendarDomain(context *Context) string {
+	if u, err := url.Parse(context.BaseURL); err == nil && len(u.Hostname()) > 0 {
+		return u.Hostname()
+	}
+	return "csnotes"
+}
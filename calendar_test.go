@@ -0,0 +1,99 @@
+package csnotes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteCalendar ensures that notes are written as events with stable
+// UIDs, recurrences, escaped text and folded lines.
+func TestWriteCalendar(t *testing.T) {
+	weekly, _ := ParseRecurrence("FREQ=WEEKLY;BYDAY=MO,WE")
+	items := []CalendarItem {
+		{
+			NoteID: 7,
+			Title: "Groceries; milk, eggs",
+			Description: "Line one\nLine two",
+			Start: time.Date(2017, 11, 1, 9, 0, 0, 0, time.UTC),
+			Recurrence: weekly,
+		},
+		{
+			NoteID: 8,
+			Title: strings.Repeat("long ", 30),
+			Start: time.Date(2017, 11, 2, 9, 0, 0, 0, time.UTC),
+		},
+	}
+
+	var b bytes.Buffer
+	now := time.Date(2017, 11, 1, 8, 0, 0, 0, time.UTC)
+	err := WriteCalendar(&b, "Notes", "notes.example.com", "", items, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cal := b.String()
+
+	AssertContains(cal, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n", t)
+	AssertContains(cal, "BEGIN:VEVENT\r\nUID:note-7@notes.example.com\r\n", t)
+	AssertContains(cal, "DTSTAMP:20171101T080000Z\r\n", t)
+	AssertContains(cal, "DTSTART:20171101T090000Z\r\n", t)
+	AssertContains(cal, "RRULE:FREQ=WEEKLY;BYDAY=MO,WE\r\n", t)
+	AssertContains(cal, "SUMMARY:Groceries\\; milk\\, eggs\r\n", t)
+	AssertContains(cal, "DESCRIPTION:Line one\\nLine two\r\n", t)
+	AssertEqual(true, strings.HasSuffix(cal, "END:VCALENDAR\r\n"), t)
+
+	// No line is longer than the limit, and folded lines continue with a
+	// space.
+	for _, l := range strings.Split(cal, "\r\n") {
+		if len(l) > ICAL_LINE_LENGTH {
+			t.Errorf("Line is too long: %s", l)
+		}
+	}
+	AssertContains(cal, "long lo\r\n ng long", t)
+
+	// To-dos are due when the note is.
+	b.Reset()
+	err = WriteCalendar(&b, "Notes", "notes.example.com", "VTODO", items[1:], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertContains(b.String(), "BEGIN:VTODO\r\nUID:note-8@notes.example.com\r\n", t)
+	AssertContains(b.String(), "DUE:20171102T090000Z\r\n", t)
+}
+
+// TestLoadCalendarItems ensures that dated notes are found, and that they
+// can be filtered by tag.
+func TestLoadCalendarItems(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := LoadCalendarItems(ids["user.nonadmin"], nil, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUnequal(0, len(items), t)
+	AssertEqual(ids["note.note1"], items[0].NoteID, t)
+
+	items, err = LoadCalendarItems(ids["user.nonadmin"], []string{"no such tag"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(0, len(items), t)
+
+	// Feed tokens find their user until they are revoked.
+	token, err := CreateCalendarToken(ids["user.nonadmin"], db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uID, err := AuthenticateCalendarToken(token, db)
+	AssertEqual(nil, err, t)
+	AssertEqual(ids["user.nonadmin"], uID, t)
+
+	RevokeCalendarToken(ids["user.nonadmin"], db)
+	_, err = AuthenticateCalendarToken(token, db)
+	AssertUnequal(nil, err, t)
+}
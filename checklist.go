@@ -0,0 +1,325 @@
+package csnotes
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ChecklistItem is one line of a checklist note. Items are ordered by
+// position, which runs from zero without gaps.
+type ChecklistItem struct {
+	Resource
+	NoteID int64 `json:"note_id"`
+	Text string `json:"text"`
+	Checked bool `json:"checked"`
+	Position int `json:"position"`
+}
+
+// NewChecklistItem creates a new item model with no ID or any fields set.
+func NewChecklistItem(db *sql.DB) ChecklistItem {
+	return ChecklistItem {
+		Resource: Resource {
+			DB: db,
+			Table: "checklist_items",
+		},
+	}
+}
+
+// LoadChecklistItem attempts to load an item's fields from the database,
+// given its ID.
+func LoadChecklistItem(id int64, db *sql.DB) (i ChecklistItem, err error) {
+	i = NewChecklistItem(db)
+	i.ID = id
+	err = i.Load()
+
+	return
+}
+
+func (i *ChecklistItem) Load() error {
+	return i.Select([]string{"note_id", "text", "checked", "position"}, &i.NoteID, &i.Text, &i.Checked, &i.Position)
+}
+
+func (i *ChecklistItem) Save() error {
+	return i.Sync([]string{"note_id", "text", "checked", "position"}, i.NoteID, i.Text, i.Checked, i.Position)
+}
+
+// Items retrieves the note's checklist items in order.
+func (n *Note) Items() (is []ChecklistItem, err error) {
+	is = []ChecklistItem{}
+
+	rows, err := n.DB.Query("SELECT id, text, checked, position FROM checklist_items WHERE note_id=? ORDER BY position", n.ID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		i := NewChecklistItem(n.DB)
+		i.NoteID = n.ID
+		err = rows.Scan(&i.ID, &i.Text, &i.Checked, &i.Position)
+		if err != nil {
+			return
+		}
+		is = append(is, i)
+	}
+
+	err = rows.Err()
+	return
+}
+
+// CountItems fills in how many of the note's items are checked, out of how
+// many.
+func (n *Note) CountItems() error {
+	return n.DB.QueryRow("SELECT COUNT(*), COALESCE(SUM(checked), 0) FROM checklist_items WHERE note_id=?", n.ID).
+		Scan(&n.ItemsTotal, &n.ItemsChecked)
+}
+
+// AddItem inserts an item at a position, moving later items down. A negative
+// position, or one past the end, appends the item.
+func (n *Note) AddItem(text string, checked bool, position int) (i ChecklistItem, err error) {
+	tx, err := n.DB.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM checklist_items WHERE note_id=?", n.ID).Scan(&count)
+	if err != nil {
+		return
+	}
+	if position < 0 || position > count {
+		position = count
+	}
+
+	_, err = tx.Exec("UPDATE checklist_items SET position=position+1 WHERE note_id=? AND position>=?", n.ID, position)
+	if err != nil {
+		return
+	}
+
+	res, err := tx.Exec("INSERT INTO checklist_items (note_id, text, checked, position) VALUES (?, ?, ?, ?)",
+		n.ID, text, checked, position)
+	if err != nil {
+		return
+	}
+
+	i = NewChecklistItem(n.DB)
+	i.ID, err = res.LastInsertId()
+	if err != nil {
+		return
+	}
+	i.NoteID = n.ID
+	i.Text = text
+	i.Checked = checked
+	i.Position = position
+
+	err = tx.Commit()
+	return
+}
+
+// RemoveItem deletes an item from the note, moving later items up.
+func (n *Note) RemoveItem(i ChecklistItem) error {
+	tx, err := n.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM checklist_items WHERE id=? AND note_id=?", i.ID, n.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE checklist_items SET position=position-1 WHERE note_id=? AND position>?", n.ID, i.Position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReorderItems puts the note's items in the order of the given IDs, which
+// must name every item exactly once.
+func (n *Note) ReorderItems(ids []int64) error {
+	is, err := n.Items()
+	if err != nil {
+		return err
+	}
+
+	// Make sure the IDs are exactly the note's items.
+	if len(ids) != len(is) {
+		return errors.New("Every item must be listed exactly once.")
+	}
+	seen := map[int64]bool{}
+	for _, i := range is {
+		seen[i.ID] = false
+	}
+	for _, id := range ids {
+		if done, ok := seen[id]; !ok || done {
+			return errors.New("Every item must be listed exactly once.")
+		}
+		seen[id] = true
+	}
+
+	tx, err := n.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, id := range ids {
+		_, err = tx.Exec("UPDATE checklist_items SET position=? WHERE id=?", position, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ConvertToChecklist turns a text note into a checklist, with one item for
+// each non-blank line of its content. The items and the note are changed in
+// one transaction, so a failure leaves the note as it was. The note is marked
+// as updated at now.
+func (n *Note) ConvertToChecklist(now time.Time) error {
+	if n.IsChecklist() {
+		return nil
+	}
+
+	tx, err := n.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Append the items after any the note already has.
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM checklist_items WHERE note_id=?", n.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	for position, i := range ParseChecklistText(n.Content.String) {
+		_, err = tx.Exec("INSERT INTO checklist_items (note_id, text, checked, position) VALUES (?, ?, ?, ?)",
+			n.ID, i.Text, i.Checked, count + position)
+		if err != nil {
+			return err
+		}
+	}
+
+	updated := sql.NullString{String: now.UTC().Format(DATETIME_FORMAT), Valid: true}
+	_, err = tx.Exec("UPDATE notes SET type=?, content='', updated=? WHERE id=?", NOTE_TYPE_CHECKLIST, updated, n.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	n.Type = NOTE_TYPE_CHECKLIST
+	n.Content.String = ""
+	n.Content.Valid = false
+	n.Updated = updated
+
+	return nil
+}
+
+// ConvertToText turns a checklist into a text note, writing its items as
+// Markdown task list lines. Like ConvertToChecklist, it changes the note and
+// its items in one transaction.
+func (n *Note) ConvertToText(now time.Time) error {
+	if !n.IsChecklist() {
+		return nil
+	}
+
+	is, err := n.Items()
+	if err != nil {
+		return err
+	}
+	content := FormatChecklistText(is)
+
+	tx, err := n.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updated := sql.NullString{String: now.UTC().Format(DATETIME_FORMAT), Valid: true}
+	_, err = tx.Exec("UPDATE notes SET type=?, content=?, updated=? WHERE id=?", NOTE_TYPE_TEXT, content, updated, n.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM checklist_items WHERE note_id=?", n.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	n.Type = NOTE_TYPE_TEXT
+	n.Content.String = content
+	n.Content.Valid = len(content) > 0
+	n.Updated = updated
+
+	return nil
+}
+
+// ParseChecklistText reads items from text, one per non-blank line. Lines
+// may be written as Markdown list items or task list items, such as
+// "- [x] Milk", in which case the markers are removed.
+func ParseChecklistText(text string) (is []ChecklistItem) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		// Remove a list marker.
+		for _, marker := range []string{"- ", "* ", "+ "} {
+			if strings.HasPrefix(line, marker) {
+				line = strings.TrimSpace(line[len(marker):])
+				break
+			}
+		}
+
+		// Remove a task box, noting whether it was checked.
+		checked := false
+		switch {
+		case strings.HasPrefix(line, "[ ]"):
+			line = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "[x]"), strings.HasPrefix(line, "[X]"):
+			checked = true
+			line = strings.TrimSpace(line[3:])
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		is = append(is, ChecklistItem {
+			Text: line,
+			Checked: checked,
+			Position: len(is),
+		})
+	}
+
+	return
+}
+
+// FormatChecklistText writes items as Markdown task list lines.
+func FormatChecklistText(is []ChecklistItem) string {
+	var lines []string
+	for _, i := range is {
+		box := "[ ]"
+		if i.Checked {
+			box = "[x]"
+		}
+		lines = append(lines, "- " + box + " " + i.Text)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,321 @@
+package csnotes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GetNoteItems retrieves the items of a checklist note, in order.
+func GetNoteItems(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		is, err := n.Items()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load items."
+			return
+		}
+
+		// Add the items to the response.
+		for _, i := range is {
+			resp.Models = append(resp.Models, i)
+		}
+	}
+}
+
+// PostNoteItem adds an item to a checklist note. It is appended unless a
+// "position" is given.
+func PostNoteItem(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Retrieve and validate the form values.
+		text := cleanItemText(r.FormValue("text"))
+		if len(text) == 0 {
+			resp.Fields["text"] = "Text must be specified."
+		}
+
+		position := -1
+		if p := r.FormValue("position"); len(p) > 0 {
+			var err error
+			position, err = strconv.Atoi(p)
+			if err != nil || position < 0 {
+				resp.Fields["position"] = "Invalid position."
+			}
+		}
+
+		checked := r.FormValue("checked") == "true"
+
+		if len(resp.Fields) > 0 {
+			return
+		}
+
+		n, ok := loadChecklist(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		i, err := n.AddItem(text, checked, position)
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not add item."
+			return
+		}
+
+		resp.Models = append(resp.Models, i)
+	}
+}
+
+// PutNoteItem changes an item's "text" or "checked" state. Fields left out
+// of the form are not changed.
+func PutNoteItem(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		r.ParseForm()
+
+		n, ok := loadChecklist(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		i, ok := loadNoteItem(context, r, &resp, n)
+		if !ok {
+			return
+		}
+
+		// Update the fields that were sent.
+		if _, ok := r.Form["text"]; ok {
+			i.Text = cleanItemText(r.FormValue("text"))
+			if len(i.Text) == 0 {
+				resp.Fields["text"] = "Text must not be empty."
+				return
+			}
+		}
+		if _, ok := r.Form["checked"]; ok {
+			i.Checked = r.FormValue("checked") == "true"
+		}
+
+		err := i.Save()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save item."
+			return
+		}
+
+		resp.Models = append(resp.Models, i)
+	}
+}
+
+// PostNoteItemToggle flips an item between checked and unchecked.
+func PostNoteItemToggle(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadChecklist(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		i, ok := loadNoteItem(context, r, &resp, n)
+		if !ok {
+			return
+		}
+
+		// Flip the state in the database, so that two toggles at once
+		// cancel out rather than both setting the same state.
+		_, err := context.DB.Exec("UPDATE checklist_items SET checked=NOT checked WHERE id=?", i.ID)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not toggle item."
+			return
+		}
+
+		err = i.Load()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load item."
+			return
+		}
+
+		resp.Models = append(resp.Models, i)
+	}
+}
+
+// DeleteNoteItem removes an item from a checklist note.
+func DeleteNoteItem(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadChecklist(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		i, ok := loadNoteItem(context, r, &resp, n)
+		if !ok {
+			return
+		}
+
+		err := n.RemoveItem(i)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not delete item."
+			return
+		}
+
+		resp.Models = append(resp.Models, i)
+	}
+}
+
+// PutNoteItemOrder reorders a checklist note's items. The "ids" form value
+// lists every item's ID, comma-separated, in the new order.
+func PutNoteItemOrder(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Read the IDs.
+		ids, err := ParseIDList(r.FormValue("ids"))
+		if err != nil {
+			resp.Fields["ids"] = "IDs must be a comma-separated list of numbers."
+			return
+		}
+
+		n, ok := loadChecklist(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		err = n.ReorderItems(ids)
+		if err != nil {
+			resp.Fields["ids"] = err.Error()
+			return
+		}
+
+		is, err := n.Items()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load items."
+			return
+		}
+
+		// Add the items to the response, in their new order.
+		for _, i := range is {
+			resp.Models = append(resp.Models, i)
+		}
+	}
+}
+
+// PostNoteConvert converts a note to the "type" in the form. Text notes
+// become checklists with an item per line, and checklists become text with
+// a Markdown task list line per item.
+func PostNoteConvert(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		noteType := r.FormValue("type")
+		if noteType != NOTE_TYPE_TEXT && noteType != NOTE_TYPE_CHECKLIST {
+			resp.Fields["type"] = "Type must be text or checklist."
+			return
+		}
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		var err error
+		if noteType == NOTE_TYPE_CHECKLIST {
+			err = n.ConvertToChecklist(context.Now())
+		} else {
+			err = n.ConvertToText(context.Now())
+		}
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not convert note."
+			return
+		}
+
+		// Checklists have no content to link from, so their links come and
+		// go with conversion.
+		err = n.SyncLinks()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save note's links."
+			return
+		}
+
+		err = n.CountItems()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not count items."
+			return
+		}
+
+		resp.Models = append(resp.Models, n)
+	}
+}
+
+// loadChecklist loads the note named in the URL like loadOwnedNote, and
+// also requires it to be a checklist.
+func loadChecklist(context *Context, r *http.Request, resp *JSONResponse) (n Note, ok bool) {
+	n, ok = loadOwnedNote(context, r, resp)
+	if !ok {
+		return
+	}
+
+	if !n.IsChecklist() {
+		resp.StatusCode = 409
+		resp.ErrorMessage = "Note is not a checklist."
+		return n, false
+	}
+
+	return n, true
+}
+
+// loadNoteItem loads the item named in the URL, as long as it belongs to
+// the note.
+func loadNoteItem(context *Context, r *http.Request, resp *JSONResponse, n Note) (i ChecklistItem, ok bool) {
+	iID, ok := GetURLVarID(r, resp, "item_id")
+	if !ok {
+		return
+	}
+
+	i, err := LoadChecklistItem(iID, context.DB)
+	if err != nil || i.NoteID != n.ID {
+		resp.StatusCode = 404
+		resp.ErrorMessage = "Item not found."
+		return i, false
+	}
+
+	return i, true
+}
+
+// cleanItemText keeps an item to a single line.
+func cleanItemText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
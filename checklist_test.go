@@ -0,0 +1,98 @@
+package csnotes
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseChecklistText ensures that list and task markers are removed from
+// lines, and that blank lines are skipped.
+func TestParseChecklistText(t *testing.T) {
+	is := ParseChecklistText("Milk\n\n- Eggs\n* [x] Bread\n- [ ]  Butter \n")
+
+	AssertEqual(4, len(is), t)
+	AssertEqual("Milk", is[0].Text, t)
+	AssertEqual("Eggs", is[1].Text, t)
+	AssertEqual("Bread", is[2].Text, t)
+	AssertEqual(true, is[2].Checked, t)
+	AssertEqual("Butter", is[3].Text, t)
+	AssertEqual(false, is[3].Checked, t)
+	AssertEqual(3, is[3].Position, t)
+
+	// Formatting and parsing again gives the same items.
+	again := ParseChecklistText(FormatChecklistText(is))
+	AssertEqual(len(is), len(again), t)
+	for n := range is {
+		AssertEqual(is[n].Text, again[n].Text, t)
+		AssertEqual(is[n].Checked, again[n].Checked, t)
+	}
+}
+
+// TestNoteItems ensures that items keep their positions as they are added,
+// reordered and removed, and that notes convert between forms.
+func TestNoteItems(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := LoadNote(ids["note.note1"], db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Content.String = "Milk\n- [x] Eggs"
+	n.Content.Valid = true
+
+	// Converting makes an item per line.
+	err = n.ConvertToChecklist(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(NOTE_TYPE_CHECKLIST, n.Type, t)
+
+	// Items can be inserted anywhere.
+	bread, err := n.AddItem("Bread", false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := n.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(3, len(is), t)
+	AssertEqual(bread.ID, is[0].ID, t)
+	AssertEqual("Milk", is[1].Text, t)
+
+	// Reordering needs every item.
+	AssertUnequal(nil, n.ReorderItems([]int64{is[2].ID, is[1].ID}), t)
+	err = n.ReorderItems([]int64{is[2].ID, is[1].ID, is[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing an item closes the gap.
+	err = n.RemoveItem(is[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err = n.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(2, len(is), t)
+	AssertEqual("Eggs", is[0].Text, t)
+	AssertEqual(1, is[1].Position, t)
+
+	err = n.CountItems()
+	AssertEqual(nil, err, t)
+	AssertEqual(2, n.ItemsTotal, t)
+	AssertEqual(1, n.ItemsChecked, t)
+
+	// Converting back writes a task list.
+	err = n.ConvertToText(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual("- [x] Eggs\n- [ ] Bread", n.Content.String, t)
+}
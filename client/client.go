@@ -0,0 +1,381 @@
+// Package client is a client for the notes app's REST API.
+//
+// A Client logs in once and keeps its token fresh by itself:
+//
+//	c := client.New("https://notes.example.com")
+//	err := c.Login(ctx, "nonadmin", "password")
+//	notes, err := c.ListNotes(ctx, false)
+//
+// Requests that fail with a server error are retried with backoff, except for
+// POST requests that may have been carried out before failing, such as
+// creating a note or using a second factor's code. Fields the server rejects
+// are returned as a *ValidationError, and other refusals as an *Error.
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The defaults of a new client's retry settings.
+	DEFAULT_MAX_RETRIES = 3
+	DEFAULT_BACKOFF = 200 * time.Millisecond
+	DEFAULT_MAX_BACKOFF = 5 * time.Second
+
+	// How long before a login token expires that it is refreshed.
+	DEFAULT_REFRESH_WINDOW = 2 * time.Minute
+
+	// The prefix of personal access tokens, which are never refreshed.
+	API_TOKEN_PREFIX = "pat_"
+)
+
+// Client makes requests to the API. It is safe for concurrent use.
+type Client struct {
+	// The URL the app is served at, such as https://notes.example.com.
+	BaseURL string
+
+	// The client requests are sent through. If nil, http.DefaultClient is
+	// used.
+	HTTP *http.Client
+
+	// How many times a request that failed with a server or network error
+	// is retried, and how long to wait before the first retry. The wait is
+	// doubled after each retry, up to MaxBackoff, unless the server says how
+	// long to wait with Retry-After.
+	MaxRetries int
+	Backoff time.Duration
+	MaxBackoff time.Duration
+
+	// How long before a login token expires that it is refreshed, before the
+	// next request. Zero disables refreshing.
+	RefreshWindow time.Duration
+
+	// Called with each new token, after logging in or refreshing, so that it
+	// can be cached.
+	OnToken func(token string)
+
+	// The source of the current time. If nil, the system clock is used.
+	Clock func() time.Time
+
+	mu sync.Mutex
+	token string
+
+	// Held while refreshing, so that concurrent requests refresh once.
+	refreshMu sync.Mutex
+}
+
+// New creates a client for the app at a URL, with the default settings.
+func New(baseURL string) *Client {
+	return &Client {
+		BaseURL: baseURL,
+		MaxRetries: DEFAULT_MAX_RETRIES,
+		Backoff: DEFAULT_BACKOFF,
+		MaxBackoff: DEFAULT_MAX_BACKOFF,
+		RefreshWindow: DEFAULT_REFRESH_WINDOW,
+	}
+}
+
+// Token returns the token requests are made with.
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// SetToken sets the token requests are made with, such as a cached login
+// token or a personal access token.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// Login logs in with a username and password. If the user has two-factor
+// authentication enabled, a *SecondFactorError is returned, and the login is
+// finished with LoginTOTP or LoginRecoveryCode.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	var login struct {
+		Token string `json:"token"`
+		Challenge string `json:"challenge"`
+	}
+	form := url.Values{"username": {username}, "password": {password}}
+	err := c.public(ctx, "/login", "", form, true, &login)
+	if err != nil {
+		return err
+	}
+
+	if len(login.Challenge) > 0 {
+		return &SecondFactorError{login.Challenge}
+	}
+
+	c.setLoginToken(login.Token)
+	return nil
+}
+
+// LoginTOTP finishes a login with the challenge from Login and a code from
+// the user's authenticator app.
+func (c *Client) LoginTOTP(ctx context.Context, challenge, code string) error {
+	return c.secondFactor(ctx, url.Values{"challenge": {challenge}, "code": {code}})
+}
+
+// LoginRecoveryCode finishes a login with the challenge from Login and one of
+// the user's single-use recovery codes.
+func (c *Client) LoginRecoveryCode(ctx context.Context, challenge, code string) error {
+	return c.secondFactor(ctx, url.Values{"challenge": {challenge}, "recovery_code": {code}})
+}
+
+func (c *Client) secondFactor(ctx context.Context, form url.Values) error {
+	var login struct {
+		Token string `json:"token"`
+	}
+	// Codes can only be used once, so a code the server may have accepted
+	// is not sent again.
+	err := c.public(ctx, "/login/totp", "", form, false, &login)
+	if err != nil {
+		return err
+	}
+
+	c.setLoginToken(login.Token)
+	return nil
+}
+
+// Refresh exchanges the login token for a new one. Requests do this by
+// themselves once the token is about to expire.
+func (c *Client) Refresh(ctx context.Context) error {
+	token := c.Token()
+	if len(token) == 0 {
+		return ErrNoToken
+	}
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	err := c.public(ctx, "/login/refresh", token, nil, true, &login)
+	if err != nil {
+		return err
+	}
+
+	c.setLoginToken(login.Token)
+	return nil
+}
+
+func (c *Client) setLoginToken(token string) {
+	c.SetToken(token)
+	if c.OnToken != nil {
+		c.OnToken(token)
+	}
+}
+
+// refreshIfDue refreshes a login token that is about to expire. Tokens that
+// have already expired can't be refreshed, so they are left to fail.
+func (c *Client) refreshIfDue(ctx context.Context) error {
+	if c.RefreshWindow <= 0 {
+		return nil
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	expires, ok := tokenExpiry(c.Token())
+	now := c.now()
+	if !ok || now.After(expires) || now.Add(c.RefreshWindow).Before(expires) {
+		return nil
+	}
+	return c.Refresh(ctx)
+}
+
+// tokenExpiry reads the expiration of a login token, without verifying it.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if strings.HasPrefix(token, API_TOKEN_PREFIX) || len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+func (c *Client) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+// response is the envelope of every API response. Models are decoded later,
+// since their type depends on the endpoint.
+type response struct {
+	Models json.RawMessage `json:"models"`
+	Fields map[string]string `json:"fields"`
+	Errors []string `json:"errors"`
+}
+
+// do makes an API request and decodes the models of the response into
+// models, which should point to a slice, or be nil. Form values are sent as
+// the body of POST and PUT requests, and in the query string otherwise.
+func (c *Client) do(ctx context.Context, method, path string, form url.Values, models interface{}) error {
+	err := c.refreshIfDue(ctx)
+	if err != nil {
+		return err
+	}
+
+	token := c.Token()
+	if len(token) == 0 {
+		return ErrNoToken
+	}
+
+	// POST requests are not retried, since the server may have created
+	// something before failing.
+	res, err := c.send(ctx, method, "/api" + path, token, form, method != "POST")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var resp response
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Fields) > 0 {
+		return &ValidationError{resp.Fields}
+	}
+	if len(resp.Errors) > 0 {
+		return &Error{res.StatusCode, strings.Join(resp.Errors, " ")}
+	}
+
+	if models == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Models, models)
+}
+
+// singleModel decodes the first model of a response, for requests about a
+// single resource.
+type singleModel struct {
+	model interface{}
+}
+
+func (s *singleModel) UnmarshalJSON(b []byte) error {
+	var models []json.RawMessage
+	err := json.Unmarshal(b, &models)
+	if err != nil {
+		return err
+	}
+
+	if len(models) == 0 {
+		return errors.New("client: the response has no model")
+	}
+	return json.Unmarshal(models[0], s.model)
+}
+
+// public posts a form to one of the routes outside the API, which respond with
+// a plain JSON object. It is retried on server errors if retry is set.
+func (c *Client) public(ctx context.Context, path, token string, form url.Values, retry bool, v interface{}) error {
+	res, err := c.send(ctx, "POST", path, token, form, retry)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// send makes a request, retrying it with backoff on server and network errors
+// if retry is set. Any response other than a 200 is returned as an error.
+func (c *Client) send(ctx context.Context, method, path, token string, form url.Values, retry bool) (*http.Response, error) {
+	u := strings.TrimRight(c.BaseURL, "/") + path
+	body := ""
+	if method == "POST" || method == "PUT" {
+		body = form.Encode()
+	} else if len(form) > 0 {
+		u += "?" + form.Encode()
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if method == "POST" || method == "PUT" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer " + token)
+		}
+
+		res, err := c.httpClient().Do(req)
+		if err == nil && res.StatusCode == http.StatusOK {
+			return res, nil
+		}
+
+		// A canceled request is not retried.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if res != nil {
+				res.Body.Close()
+			}
+			return nil, ctxErr
+		}
+
+		// Errors other than server errors are final.
+		wait := backoff
+		if err == nil {
+			msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+			err = &Error{res.StatusCode, strings.TrimSpace(string(msg))}
+
+			if res.StatusCode < 500 {
+				return nil, err
+			}
+			if seconds, perr := strconv.Atoi(res.Header.Get("Retry-After")); perr == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+
+		if !retry || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cpgillem/csnotes"
+)
+
+// testToken makes a login token that expires at a time. Clients don't verify
+// tokens, so it is not signed.
+func testToken(expires time.Time) string {
+	payload, _ := json.Marshal(map[string]int64{"exp": expires.Unix()})
+	return "header." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+// newTestClient creates a client for a server that retries without waiting.
+func newTestClient(url string) *Client {
+	c := New(url)
+	c.Backoff = time.Millisecond
+	c.SetToken("pat_test")
+	return c
+}
+
+func TestRetries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			http.Error(w, "Unavailable.", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"models": [{"id": 1, "title": "a"}], "fields": {}, "errors": []}`))
+	}))
+	defer server.Close()
+	c := newTestClient(server.URL)
+
+	// Server errors are retried until the request succeeds.
+	ns, err := c.ListNotes(context.Background(), false)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(len(ns), 1, t)
+	csnotes.AssertEqual(atomic.LoadInt32(&attempts), int32(3), t)
+
+	// Or until the retries run out.
+	atomic.StoreInt32(&attempts, -10)
+	_, err = c.ListNotes(context.Background(), false)
+	csnotes.AssertEqual(StatusCode(err), http.StatusServiceUnavailable, t)
+	csnotes.AssertEqual(atomic.LoadInt32(&attempts), int32(-10 + 1 + DEFAULT_MAX_RETRIES), t)
+
+	// Requests that may have created something are tried once.
+	atomic.StoreInt32(&attempts, 0)
+	_, err = c.CreateNote(context.Background(), NewNote{Title: "a"})
+	csnotes.AssertEqual(StatusCode(err), http.StatusServiceUnavailable, t)
+	csnotes.AssertEqual(atomic.LoadInt32(&attempts), int32(1), t)
+
+	// So are second factors, whose codes can only be used once.
+	atomic.StoreInt32(&attempts, 0)
+	err = c.LoginTOTP(context.Background(), "challenge", "123456")
+	csnotes.AssertEqual(StatusCode(err), http.StatusServiceUnavailable, t)
+	csnotes.AssertEqual(atomic.LoadInt32(&attempts), int32(1), t)
+
+	// Waiting for a retry stops when the request is canceled.
+	atomic.StoreInt32(&attempts, 0)
+	c.Backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	_, err = c.ListNotes(ctx, false)
+	csnotes.AssertEqual(err, context.DeadlineExceeded, t)
+}
+
+func TestErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/note":
+			w.Write([]byte(`{"models": [], "fields": {"title": "Title must be specified.", "color": "Unknown color."}, "errors": []}`))
+		default:
+			http.Error(w, "Note not found.", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	c := newTestClient(server.URL)
+
+	// Invalid fields are reported by name.
+	_, err := c.CreateNote(context.Background(), NewNote{})
+	var verr *ValidationError
+	csnotes.AssertEqual(errors.As(err, &verr), true, t)
+	if verr != nil {
+		csnotes.AssertEqual(verr.Fields["title"], "Title must be specified.", t)
+		csnotes.AssertEqual(verr.Error(), "client: invalid fields: color: Unknown color.; title: Title must be specified.", t)
+	}
+
+	// Other refusals keep their status and message.
+	_, err = c.GetNote(context.Background(), 5)
+	var e *Error
+	csnotes.AssertEqual(errors.As(err, &e), true, t)
+	if e != nil {
+		csnotes.AssertEqual(e.StatusCode, http.StatusNotFound, t)
+		csnotes.AssertEqual(e.Message, "Note not found.", t)
+	}
+
+	// Nothing is sent without a token.
+	c.SetToken("")
+	_, err = c.GetNote(context.Background(), 5)
+	csnotes.AssertEqual(err, ErrNoToken, t)
+}
+
+func TestRefresh(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	var refreshes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login/refresh" {
+			atomic.AddInt32(&refreshes, 1)
+			fmt.Fprintf(w, `{"token": %q}`, testToken(now.Add(20 * time.Minute)))
+			return
+		}
+		w.Write([]byte(`{"models": [], "fields": {}, "errors": []}`))
+	}))
+	defer server.Close()
+
+	c := newTestClient(server.URL)
+	c.Clock = func() time.Time { return now }
+	var cached string
+	c.OnToken = func(token string) { cached = token }
+
+	// A token that isn't about to expire is kept.
+	c.SetToken(testToken(now.Add(10 * time.Minute)))
+	c.ListNotes(context.Background(), false)
+	csnotes.AssertEqual(atomic.LoadInt32(&refreshes), int32(0), t)
+
+	// One that is, is refreshed first.
+	c.SetToken(testToken(now.Add(time.Minute)))
+	_, err := c.ListNotes(context.Background(), false)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(atomic.LoadInt32(&refreshes), int32(1), t)
+	csnotes.AssertEqual(c.Token(), testToken(now.Add(20 * time.Minute)), t)
+	csnotes.AssertEqual(cached, c.Token(), t)
+
+	// Personal access tokens never are.
+	c.SetToken("pat_test")
+	c.ListNotes(context.Background(), false)
+	csnotes.AssertEqual(atomic.LoadInt32(&refreshes), int32(1), t)
+}
+
+func TestNoteJSON(t *testing.T) {
+	var n Note
+	err := json.Unmarshal([]byte(`{
+		"id": 3,
+		"title": "Trip",
+		"content": {"String": "Pack", "Valid": true},
+		"time": {"String": "", "Valid": false},
+		"created": {"String": "2017-01-01 12:00:00", "Valid": true},
+		"pinned": true
+	}`), &n)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(n.ID, int64(3), t)
+	csnotes.AssertEqual(n.Content, "Pack", t)
+	csnotes.AssertEqual(n.Pinned, true, t)
+	csnotes.AssertEqual(n.Created, time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC), t)
+	csnotes.AssertEqual(n.Updated.IsZero(), true, t)
+
+	// Notes encoded by the client, as the command line client prints them,
+	// are read back the same.
+	b, err := json.Marshal(n)
+	csnotes.AssertEqual(err, nil, t)
+	var again Note
+	err = json.Unmarshal(b, &again)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(again, n, t)
+}
+
+func TestClient(t *testing.T) {
+	db, ids, err := csnotes.SeededTestDB()
+	defer csnotes.TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	_, router := csnotes.NewTestApp(t, db, &now)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL)
+	c.Clock = func() time.Time { return now }
+
+	// Logging in with the wrong password is refused.
+	err = c.Login(ctx, "nonadmin", "wrong")
+	csnotes.AssertEqual(StatusCode(err), http.StatusForbidden, t)
+
+	err = c.Login(ctx, "nonadmin", "password")
+	csnotes.AssertEqual(err, nil, t)
+
+	// Notes.
+	ns, err := c.ListNotes(ctx, false)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(len(ns), 2, t)
+
+	_, err = c.CreateNote(ctx, NewNote{Content: "No title"})
+	var verr *ValidationError
+	csnotes.AssertEqual(errors.As(err, &verr), true, t)
+	if verr != nil {
+		csnotes.AssertUnequal(verr.Fields["title"], "", t)
+	}
+
+	n, err := c.CreateNote(ctx, NewNote{Title: "Trip", Content: "Pack", Pinned: true})
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(n.Content, "Pack", t)
+	csnotes.AssertEqual(n.Pinned, true, t)
+	csnotes.AssertEqual(n.Created.IsZero(), false, t)
+
+	archived := true
+	n, err = c.UpdateNote(ctx, n.ID, NoteUpdate{Title: "Trip", Content: "Pack light", Archived: &archived})
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(n.Archived, true, t)
+	n, err = c.GetNote(ctx, n.ID)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(n.Content, "Pack light", t)
+
+	ns, _ = c.ListNotes(ctx, true)
+	csnotes.AssertEqual(len(ns), 3, t)
+
+	// Tags.
+	tag, err := c.TagNote(ctx, n.ID, "TAG1")
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(tag.ID, ids["tag.tag1"], t)
+	_, err = c.TagNote(ctx, n.ID, "travel")
+	csnotes.AssertEqual(err, nil, t)
+
+	ts, err := c.NoteTags(ctx, n.ID)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(len(ts), 2, t)
+
+	err = c.UntagNote(ctx, n.ID, tag.ID)
+	csnotes.AssertEqual(err, nil, t)
+	ts, _ = c.NoteTags(ctx, n.ID)
+	csnotes.AssertEqual(len(ts), 1, t)
+	err = c.UntagNote(ctx, n.ID, tag.ID)
+	csnotes.AssertEqual(StatusCode(err), http.StatusNotFound, t)
+
+	// Users.
+	u, err := c.UpdateUserName(ctx, ids["user.nonadmin"], "Non Admin")
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(u.Name, "Non Admin", t)
+	_, err = c.ListUsers(ctx)
+	csnotes.AssertEqual(StatusCode(err), http.StatusForbidden, t)
+	_, err = c.UserNotes(ctx, ids["user.admin"], false)
+	csnotes.AssertEqual(StatusCode(err), http.StatusForbidden, t)
+
+	err = c.DeleteNote(ctx, n.ID)
+	csnotes.AssertEqual(err, nil, t)
+	_, err = c.GetNote(ctx, n.ID)
+	csnotes.AssertEqual(StatusCode(err), http.StatusNotFound, t)
+
+	// A token about to expire is refreshed, and then still works after the
+	// old one expired.
+	old := c.Token()
+	now = now.Add(csnotes.TOKEN_LIFETIME - time.Minute)
+	_, err = c.ListNotes(ctx, false)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertUnequal(c.Token(), old, t)
+
+	now = now.Add(10 * time.Minute)
+	_, err = c.ListNotes(ctx, false)
+	csnotes.AssertEqual(err, nil, t)
+
+	// An expired token can't be refreshed.
+	now = now.Add(csnotes.TOKEN_LIFETIME)
+	err = c.Refresh(ctx)
+	csnotes.AssertEqual(StatusCode(err), http.StatusUnauthorized, t)
+}
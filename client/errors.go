@@ -0,0 +1,69 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ErrNoToken is returned for API requests made before logging in or setting
+// a token.
+var ErrNoToken = errors.New("client: no token; log in or set a token first")
+
+// Error is returned when the server refuses a request. Message is the plain
+// text the server responded with.
+type Error struct {
+	StatusCode int
+	Message string
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if len(msg) == 0 {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("client: %d: %v", e.StatusCode, msg)
+}
+
+// ValidationError is returned when the server rejects some of the fields of a
+// request. Fields maps the names of the form fields to what was wrong with
+// them.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	names := []string{}
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := []string{}
+	for _, name := range names {
+		msgs = append(msgs, name + ": " + e.Fields[name])
+	}
+	return "client: invalid fields: " + strings.Join(msgs, "; ")
+}
+
+// SecondFactorError is returned by Login for users with two-factor
+// authentication. The login is finished by LoginTOTP with the challenge.
+type SecondFactorError struct {
+	Challenge string
+}
+
+func (e *SecondFactorError) Error() string {
+	return "client: a second factor is required to log in"
+}
+
+// StatusCode returns the status a request was refused with, or 0 if the error
+// didn't come from the server.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// ListNotes retrieves the logged in user's notes, pinned first. Archived
+// notes are only included if asked for.
+func (c *Client) ListNotes(ctx context.Context, archived bool) (ns []Note, err error) {
+	err = c.do(ctx, "GET", "/note", listForm(archived), &ns)
+	return
+}
+
+// GetNote retrieves a note by ID.
+func (c *Client) GetNote(ctx context.Context, id int64) (n Note, err error) {
+	err = c.one(ctx, "GET", fmt.Sprintf("/note/%d", id), nil, &n)
+	return
+}
+
+// CreateNote creates a note, and returns it as it was saved.
+func (c *Client) CreateNote(ctx context.Context, nn NewNote) (n Note, err error) {
+	form := url.Values {
+		"title": {nn.Title},
+		"content": {nn.Content},
+		"time": {nn.Time},
+		"type": {nn.Type},
+		"color": {nn.Color},
+		"pinned": {strconv.FormatBool(nn.Pinned)},
+	}
+	if nn.UserID != 0 {
+		form.Set("user_id", strconv.FormatInt(nn.UserID, 10))
+	}
+
+	err = c.one(ctx, "POST", "/note", form, &n)
+	return
+}
+
+// UpdateNote changes a note, and returns it as it was saved.
+func (c *Client) UpdateNote(ctx context.Context, id int64, u NoteUpdate) (n Note, err error) {
+	form := url.Values {
+		"title": {u.Title},
+		"content": {u.Content},
+		"time": {u.Time},
+	}
+	if u.Pinned != nil {
+		form.Set("pinned", strconv.FormatBool(*u.Pinned))
+	}
+	if u.Archived != nil {
+		form.Set("archived", strconv.FormatBool(*u.Archived))
+	}
+	if u.Color != nil {
+		form.Set("color", *u.Color)
+	}
+
+	err = c.one(ctx, "PUT", fmt.Sprintf("/note/%d", id), form, &n)
+	return
+}
+
+// DeleteNote deletes a note, along with its items, attachments and reminder.
+func (c *Client) DeleteNote(ctx context.Context, id int64) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/note/%d", id), nil, nil)
+}
+
+// NoteItems retrieves the items of a checklist, in order.
+func (c *Client) NoteItems(ctx context.Context, id int64) (is []ChecklistItem, err error) {
+	err = c.do(ctx, "GET", fmt.Sprintf("/note/%d/item", id), nil, &is)
+	return
+}
+
+// one makes a request that responds with a single model.
+func (c *Client) one(ctx context.Context, method, path string, form url.Values, model interface{}) error {
+	return c.do(ctx, method, path, form, &singleModel{model})
+}
+
+func listForm(archived bool) url.Values {
+	if archived {
+		return url.Values{"archived": {"true"}}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// NoteTags retrieves the tags on a note.
+func (c *Client) NoteTags(ctx context.Context, noteID int64) (ts []Tag, err error) {
+	err = c.do(ctx, "GET", fmt.Sprintf("/note/%d/tag", noteID), nil, &ts)
+	return
+}
+
+// TagNote puts the tag with a title on a note. The note's owner's tag with
+// that title is used, ignoring case, or created if they don't have one.
+func (c *Client) TagNote(ctx context.Context, noteID int64, title string) (t Tag, err error) {
+	err = c.one(ctx, "POST", fmt.Sprintf("/note/%d/tag", noteID), url.Values{"title": {title}}, &t)
+	return
+}
+
+// TagNoteByID puts an existing tag on a note.
+func (c *Client) TagNoteByID(ctx context.Context, noteID, tagID int64) (t Tag, err error) {
+	form := url.Values{"tag_id": {strconv.FormatInt(tagID, 10)}}
+	err = c.one(ctx, "POST", fmt.Sprintf("/note/%d/tag", noteID), form, &t)
+	return
+}
+
+// UntagNote takes a tag off a note. The tag itself is kept.
+func (c *Client) UntagNote(ctx context.Context, noteID, tagID int64) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf("/note/%d/tag/%d", noteID, tagID), nil, nil)
+}
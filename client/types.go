@@ -0,0 +1,178 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The format the server writes times in, always in UTC.
+const DATETIME_FORMAT = "2006-01-02 15:04:05"
+
+// The kinds of note.
+const (
+	NOTE_TYPE_TEXT = "text"
+	NOTE_TYPE_CHECKLIST = "checklist"
+)
+
+// Note is a note as the API returns it.
+type Note struct {
+	ID int64 `json:"id"`
+	Title string `json:"title"`
+	Type string `json:"type"`
+
+	// The body of a text note. Checklists keep theirs as items instead.
+	Content string `json:"content"`
+
+	Time string `json:"time"`
+	Pinned bool `json:"pinned"`
+	Archived bool `json:"archived"`
+	Color string `json:"color"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	// How many of a checklist's items are checked, out of how many.
+	ItemsChecked int `json:"items_checked"`
+	ItemsTotal int `json:"items_total"`
+}
+
+// Tag is a label that a user can put on their notes.
+type Tag struct {
+	ID int64 `json:"id"`
+	Title string `json:"title"`
+}
+
+// User is an account, without any of its secrets.
+type User struct {
+	ID int64 `json:"id"`
+	Username string `json:"username"`
+	Name string `json:"name"`
+	Email string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
+	Admin bool `json:"admin"`
+}
+
+// nullString is how the server writes values that may be missing. Plain
+// strings are read too, so that notes and users encoded by this package can
+// be decoded again.
+type nullString struct {
+	String string
+	Valid bool
+}
+
+func (s *nullString) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*s = nullString{}
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		s.Valid = true
+		return json.Unmarshal(b, &s.String)
+	}
+
+	type plain nullString
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+// time parses a timestamp written by the server, or by this package, or gives
+// the zero time.
+func (s nullString) time() time.Time {
+	if !s.Valid {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(DATETIME_FORMAT, s.String, time.UTC)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s.String)
+	}
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (n *Note) UnmarshalJSON(b []byte) error {
+	// The fields that may be missing are decoded separately, over the
+	// others.
+	type note Note
+	w := struct {
+		*note
+		Content nullString `json:"content"`
+		Time nullString `json:"time"`
+		Created nullString `json:"created"`
+		Updated nullString `json:"updated"`
+	} {note: (*note)(n)}
+
+	err := json.Unmarshal(b, &w)
+	if err != nil {
+		return err
+	}
+
+	n.Content = w.Content.String
+	n.Time = w.Time.String
+	n.Created = w.Created.time()
+	n.Updated = w.Updated.time()
+	return nil
+}
+
+func (u *User) UnmarshalJSON(b []byte) error {
+	type user User
+	w := struct {
+		*user
+		Name nullString `json:"name"`
+		Email nullString `json:"email"`
+	} {user: (*user)(u)}
+
+	err := json.Unmarshal(b, &w)
+	if err != nil {
+		return err
+	}
+
+	u.Name = w.Name.String
+	u.Email = w.Email.String
+	return nil
+}
+
+// NewNote holds the fields of a note to create.
+type NewNote struct {
+	Title string
+	Content string
+	Time string
+
+	// Either NOTE_TYPE_TEXT, the default, or NOTE_TYPE_CHECKLIST, which
+	// makes an item of each line of the content.
+	Type string
+
+	Color string
+	Pinned bool
+
+	// The user to create the note for, for admins. Zero means the logged in
+	// user.
+	UserID int64
+}
+
+// NoteUpdate holds the new fields of a note. The title, content and time are
+// always replaced; the others are only changed when set.
+type NoteUpdate struct {
+	Title string
+	Content string
+	Time string
+
+	Pinned *bool
+	Archived *bool
+	Color *string
+}
+
+// NewUser holds the fields of a user to create.
+type NewUser struct {
+	Username string
+	Name string
+	Password string
+}
+
+// ChecklistItem is one line of a checklist note.
+type ChecklistItem struct {
+	ID int64 `json:"id"`
+	NoteID int64 `json:"note_id"`
+	Text string `json:"text"`
+	Checked bool `json:"checked"`
+	Position int `json:"position"`
+}
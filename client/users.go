@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// ListUsers retrieves every user. Only admins may.
+func (c *Client) ListUsers(ctx context.Context) (us []User, err error) {
+	err = c.do(ctx, "GET", "/user", nil, &us)
+	return
+}
+
+// GetUser retrieves a user by ID.
+func (c *Client) GetUser(ctx context.Context, id int64) (u User, err error) {
+	err = c.one(ctx, "GET", fmt.Sprintf("/user/%d", id), nil, &u)
+	return
+}
+
+// CreateUser creates a user with a password.
+func (c *Client) CreateUser(ctx context.Context, nu NewUser) (u User, err error) {
+	form := url.Values {
+		"username": {nu.Username},
+		"name": {nu.Name},
+		"password": {nu.Password},
+	}
+	err = c.one(ctx, "POST", "/user", form, &u)
+	return
+}
+
+// UpdateUserName changes a user's display name. An empty name removes it.
+func (c *Client) UpdateUserName(ctx context.Context, id int64, name string) (u User, err error) {
+	err = c.one(ctx, "PUT", fmt.Sprintf("/user/%d", id), url.Values{"name": {name}}, &u)
+	return
+}
+
+// UserNotes retrieves a user's notes, as ListNotes does for the logged in
+// user.
+func (c *Client) UserNotes(ctx context.Context, id int64, archived bool) (ns []Note, err error) {
+	err = c.do(ctx, "GET", fmt.Sprintf("/user/%d/note", id), listForm(archived), &ns)
+	return
+}
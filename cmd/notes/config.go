@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// The server used when none was given or logged in to.
+const DEFAULT_SERVER = "http://127.0.0.1:8080"
+
+// Config is what is kept between runs: the server that was logged in to, and
+// the token it issued.
+type Config struct {
+	Server string `json:"server"`
+	Username string `json:"username"`
+	Token string `json:"token"`
+}
+
+// configPath finds the file the login token is cached in. NOTES_CONFIG
+// overrides the default of notes/token.json in the user's config directory.
+func configPath() (string, error) {
+	if path := os.Getenv("NOTES_CONFIG"); len(path) > 0 {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "notes", "token.json"), nil
+}
+
+// LoadConfig reads the cached login. A missing file is an empty config.
+func LoadConfig() (c Config, err error) {
+	path, err := configPath()
+	if err != nil {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(data, &c)
+	return
+}
+
+// Save caches the login, readable only by the user since it holds a token.
+func (c Config) Save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// RemoveConfig forgets the cached login.
+func RemoveConfig() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cpgillem/csnotes/client"
+	"golang.org/x/term"
+)
+
+const SYNTAX = `Syntax: notes [-server <url>] [-json] <command> [args]
+
+Commands:
+  login <username>             Log in, and cache the token.
+  logout                       Forget the cached token.
+  ls [-archived] [-user <id>]  List notes.
+  show <id>                    Show a note and its tags.
+  new [-type text|checklist] [-color <color>] [-pinned] [-tag <title>]...
+      <title> [<content>|-]    Create a note, reading "-" content from stdin.
+  edit <id>                    Edit a note's title and content in $EDITOR.
+  rm <id>...                   Delete notes.
+  tag [-d] <id> [<title>...]   List a note's tags, or add or remove them.
+  search [-archived] <word>... List notes containing every word.
+  users                        List users, for admins.
+
+The server defaults to the one logged in to, or NOTES_SERVER. NOTES_TOKEN may
+be set to a personal access token instead of logging in.`
+
+// ErrSyntax is returned for commands that were not used properly.
+var ErrSyntax = errors.New(SYNTAX)
+
+// editFile opens a file in the user's editor, and waits for them to close it.
+var editFile = func(path string) error {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// CLI holds what every command needs: where to write, and how to reach the
+// server.
+type CLI struct {
+	In *bufio.Reader
+
+	// The terminal that In reads from, if any, so that passwords can be read
+	// without being shown.
+	Terminal *os.File
+
+	Out io.Writer
+	Err io.Writer
+	Config Config
+	Client *client.Client
+	Context context.Context
+
+	// Whether to print results as JSON rather than tables.
+	JSON bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs a command line, returning the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("notes", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	server := fs.String("server", "", "")
+	asJSON := fs.Bool("json", false, "")
+	if fs.Parse(args) != nil || fs.NArg() == 0 {
+		fmt.Fprintln(stderr, SYNTAX)
+		return 2
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintln(stderr, "notes:", err)
+		return 1
+	}
+
+	// Find the server, and use the cached token only if it came from there.
+	if len(*server) == 0 {
+		*server = os.Getenv("NOTES_SERVER")
+	}
+	if len(*server) == 0 {
+		*server = config.Server
+	}
+	if len(*server) == 0 {
+		*server = DEFAULT_SERVER
+	}
+
+	c := CLI {
+		In: bufio.NewReader(stdin),
+		Out: stdout,
+		Err: stderr,
+		Config: config,
+		Client: client.New(*server),
+		Context: context.Background(),
+		JSON: *asJSON,
+	}
+	if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		c.Terminal = f
+	}
+
+	// Refreshed login tokens are cached again.
+	if token := os.Getenv("NOTES_TOKEN"); len(token) > 0 {
+		c.Client.SetToken(token)
+	} else if config.Server == *server {
+		c.Client.SetToken(config.Token)
+		c.Client.OnToken = func(token string) {
+			c.saveToken(token)
+		}
+	}
+
+	commands := map[string]func([]string) error {
+		"login": c.Login,
+		"logout": c.Logout,
+		"ls": c.List,
+		"show": c.Show,
+		"new": c.New,
+		"edit": c.Edit,
+		"rm": c.Remove,
+		"tag": c.Tag,
+		"search": c.Search,
+		"users": c.Users,
+	}
+
+	command, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintln(stderr, SYNTAX)
+		return 2
+	}
+
+	err = command(fs.Args()[1:])
+	if err == client.ErrNoToken {
+		err = errors.New("Not logged in. Run notes login first.")
+	}
+	if err == ErrSyntax {
+		fmt.Fprintln(stderr, SYNTAX)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "notes:", err)
+		return 1
+	}
+	return 0
+}
+
+// Login logs in with a password read from NOTES_PASSWORD or stdin, and caches
+// the token.
+func (c *CLI) Login(args []string) error {
+	if len(args) != 1 {
+		return ErrSyntax
+	}
+
+	password := os.Getenv("NOTES_PASSWORD")
+	if len(password) == 0 {
+		var err error
+		password, err = c.promptPassword("Password: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Users with two-factor authentication are asked for a code too.
+	err := c.Client.Login(c.Context, args[0], password)
+	var second *client.SecondFactorError
+	if errors.As(err, &second) {
+		var code string
+		code, err = c.prompt("Code: ")
+		if err != nil {
+			return err
+		}
+		err = c.Client.LoginTOTP(c.Context, second.Challenge, code)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Config = Config{c.Client.BaseURL, args[0], ""}
+	err = c.saveToken(c.Client.Token())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.Out, "Logged in to %v as %v.\n", c.Client.BaseURL, args[0])
+	return nil
+}
+
+// saveToken caches a new login token.
+func (c *CLI) saveToken(token string) error {
+	c.Config.Token = token
+	return c.Config.Save()
+}
+
+// Logout forgets the cached token. The token itself stays valid until it
+// expires.
+func (c *CLI) Logout(args []string) error {
+	if len(args) != 0 {
+		return ErrSyntax
+	}
+	return RemoveConfig()
+}
+
+// List lists the user's notes, or another user's for admins.
+func (c *CLI) List(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	archived := fs.Bool("archived", false, "")
+	user := fs.Int64("user", 0, "")
+	if fs.Parse(args) != nil || fs.NArg() != 0 {
+		return ErrSyntax
+	}
+
+	var ns []client.Note
+	var err error
+	if *user != 0 {
+		ns, err = c.Client.UserNotes(c.Context, *user, *archived)
+	} else {
+		ns, err = c.Client.ListNotes(c.Context, *archived)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.printNotes(ns)
+}
+
+// NoteDetails is a note as it is shown, with its tags and checklist items.
+type NoteDetails struct {
+	client.Note
+	Tags []client.Tag `json:"tags"`
+	Items []client.ChecklistItem `json:"items,omitempty"`
+}
+
+func (d *NoteDetails) UnmarshalJSON(b []byte) error {
+	// The note's own decoding would otherwise leave out the tags and items.
+	err := json.Unmarshal(b, &d.Note)
+	if err != nil {
+		return err
+	}
+
+	var rest struct {
+		Tags []client.Tag `json:"tags"`
+		Items []client.ChecklistItem `json:"items"`
+	}
+	err = json.Unmarshal(b, &rest)
+	d.Tags, d.Items = rest.Tags, rest.Items
+	return err
+}
+
+// Show prints a note with its tags, and its items if it is a checklist.
+func (c *CLI) Show(args []string) error {
+	if len(args) != 1 {
+		return ErrSyntax
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := c.Client.GetNote(c.Context, id)
+	if err != nil {
+		return err
+	}
+
+	d := NoteDetails{Note: n}
+	d.Tags, err = c.Client.NoteTags(c.Context, id)
+	if err != nil {
+		return err
+	}
+	if n.Type == client.NOTE_TYPE_CHECKLIST {
+		d.Items, err = c.Client.NoteItems(c.Context, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.JSON {
+		return c.printJSON(d)
+	}
+
+	tw := tabwriter.NewWriter(c.Out, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%d\n", d.ID)
+	fmt.Fprintf(tw, "Title:\t%v\n", d.Title)
+	fmt.Fprintf(tw, "Type:\t%v\n", d.Type)
+	fmt.Fprintf(tw, "Color:\t%v\n", d.Color)
+	fmt.Fprintf(tw, "Pinned:\t%v\n", yesNo(d.Pinned))
+	fmt.Fprintf(tw, "Archived:\t%v\n", yesNo(d.Archived))
+	if len(d.Time) > 0 {
+		fmt.Fprintf(tw, "Time:\t%v\n", d.Time)
+	}
+	fmt.Fprintf(tw, "Created:\t%v\n", formatTime(d.Created))
+	fmt.Fprintf(tw, "Updated:\t%v\n", formatTime(d.Updated))
+	fmt.Fprintf(tw, "Tags:\t%v\n", strings.Join(tagTitles(d.Tags), ", "))
+	tw.Flush()
+
+	body := d.Content
+	if d.Type == client.NOTE_TYPE_CHECKLIST {
+		body = formatItems(d.Items)
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(c.Out, "\n%v\n", body)
+	}
+	return nil
+}
+
+// New creates a note, and tags it.
+func (c *CLI) New(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	noteType := fs.String("type", "", "")
+	color := fs.String("color", "", "")
+	pinned := fs.Bool("pinned", false, "")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "")
+	if fs.Parse(args) != nil || fs.NArg() < 1 || fs.NArg() > 2 {
+		return ErrSyntax
+	}
+
+	// Read the content from stdin if asked to.
+	content := fs.Arg(1)
+	if content == "-" {
+		b, err := io.ReadAll(c.In)
+		if err != nil {
+			return err
+		}
+		content = strings.TrimRight(string(b), "\n")
+	}
+
+	n, err := c.Client.CreateNote(c.Context, client.NewNote {
+		Title: fs.Arg(0),
+		Content: content,
+		Type: *noteType,
+		Color: *color,
+		Pinned: *pinned,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, title := range tags {
+		_, err = c.Client.TagNote(c.Context, n.ID, title)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.JSON {
+		return c.printJSON(n)
+	}
+	fmt.Fprintf(c.Out, "Created note %d.\n", n.ID)
+	return nil
+}
+
+// Edit opens a note in the user's editor, as its title on the first line and
+// its content after a blank line, and saves it once the editor is closed.
+// Only the title of a checklist can be edited this way.
+func (c *CLI) Edit(args []string) error {
+	if len(args) != 1 {
+		return ErrSyntax
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := c.Client.GetNote(c.Context, id)
+	if err != nil {
+		return err
+	}
+
+	// Write the note to a temporary file.
+	f, err := os.CreateTemp("", fmt.Sprintf("note-%d-*.md", id))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	before := formatEditText(n)
+	_, err = f.WriteString(before)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = editFile(f.Name())
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	if string(b) == before {
+		fmt.Fprintln(c.Out, "No changes.")
+		return nil
+	}
+
+	title, content := parseEditText(string(b))
+	if len(title) == 0 {
+		return errors.New("The note must have a title.")
+	}
+
+	// The time is cleared unless it is sent again.
+	n, err = c.Client.UpdateNote(c.Context, id, client.NoteUpdate {
+		Title: title,
+		Content: content,
+		Time: n.Time,
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		return c.printJSON(n)
+	}
+	fmt.Fprintf(c.Out, "Saved note %d.\n", id)
+	return nil
+}
+
+// Remove deletes notes, stopping at the first that can't be.
+func (c *CLI) Remove(args []string) error {
+	if len(args) == 0 {
+		return ErrSyntax
+	}
+
+	for _, arg := range args {
+		id, err := parseID(arg)
+		if err != nil {
+			return err
+		}
+
+		err = c.Client.DeleteNote(c.Context, id)
+		if err != nil {
+			return err
+		}
+		if !c.JSON {
+			fmt.Fprintf(c.Out, "Deleted note %d.\n", id)
+		}
+	}
+	return nil
+}
+
+// Tag adds tags to a note by title, creating those the user doesn't have, or
+// removes them with -d. Either way, the note's tags are then listed.
+func (c *CLI) Tag(args []string) error {
+	fs := flag.NewFlagSet("tag", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	remove := fs.Bool("d", false, "")
+	if fs.Parse(args) != nil || fs.NArg() == 0 || (*remove && fs.NArg() == 1) {
+		return ErrSyntax
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var ts []client.Tag
+	if *remove {
+		ts, err = c.Client.NoteTags(c.Context, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, title := range fs.Args()[1:] {
+		if !*remove {
+			_, err = c.Client.TagNote(c.Context, id, title)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Tags are removed by ID, so find the one with the title.
+		found := false
+		for _, t := range ts {
+			if strings.EqualFold(t.Title, title) {
+				found = true
+				err = c.Client.UntagNote(c.Context, id, t.ID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("Note %d is not tagged %v.", id, title)
+		}
+	}
+
+	ts, err = c.Client.NoteTags(c.Context, id)
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		return c.printJSON(ts)
+	}
+	for _, title := range tagTitles(ts) {
+		fmt.Fprintln(c.Out, title)
+	}
+	return nil
+}
+
+// Search lists the notes whose title or content contains every word, ignoring
+// case.
+func (c *CLI) Search(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	archived := fs.Bool("archived", false, "")
+	if fs.Parse(args) != nil || fs.NArg() == 0 {
+		return ErrSyntax
+	}
+
+	ns, err := c.Client.ListNotes(c.Context, *archived)
+	if err != nil {
+		return err
+	}
+
+	found := []client.Note{}
+	for _, n := range ns {
+		if matchesWords(n, fs.Args()) {
+			found = append(found, n)
+		}
+	}
+	return c.printNotes(found)
+}
+
+// Users lists every user. Only admins may.
+func (c *CLI) Users(args []string) error {
+	if len(args) != 0 {
+		return ErrSyntax
+	}
+
+	us, err := c.Client.ListUsers(c.Context)
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		return c.printJSON(us)
+	}
+
+	tw := tabwriter.NewWriter(c.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tNAME\tEMAIL\tADMIN")
+	for _, u := range us {
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%v\n", u.ID, u.Username, u.Name, u.Email, yesNo(u.Admin))
+	}
+	return tw.Flush()
+}
+
+// printNotes prints notes as a table, or as JSON.
+func (c *CLI) printNotes(ns []client.Note) error {
+	if c.JSON {
+		if ns == nil {
+			ns = []client.Note{}
+		}
+		return c.printJSON(ns)
+	}
+
+	tw := tabwriter.NewWriter(c.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tTYPE\tPINNED\tUPDATED")
+	for _, n := range ns {
+		noteType := n.Type
+		if n.Type == client.NOTE_TYPE_CHECKLIST {
+			noteType += fmt.Sprintf(" %d/%d", n.ItemsChecked, n.ItemsTotal)
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%v\n", n.ID, n.Title, noteType, yesNo(n.Pinned), formatTime(n.Updated))
+	}
+	return tw.Flush()
+}
+
+func (c *CLI) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.Out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// prompt asks for a line of input.
+func (c *CLI) prompt(label string) (string, error) {
+	fmt.Fprint(c.Err, label)
+	line, err := c.In.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptPassword asks for a password, without showing it when it is typed at
+// a terminal. Piped input is read like any other line.
+func (c *CLI) promptPassword(label string) (string, error) {
+	if c.Terminal == nil {
+		return c.prompt(label)
+	}
+
+	fmt.Fprint(c.Err, label)
+	password, err := term.ReadPassword(int(c.Terminal.Fd()))
+	fmt.Fprintln(c.Err)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// formatEditText writes a note as it is edited.
+func formatEditText(n client.Note) string {
+	if n.Type == client.NOTE_TYPE_CHECKLIST {
+		return n.Title + "\n"
+	}
+	return n.Title + "\n\n" + n.Content + "\n"
+}
+
+// parseEditText reads the title and content back from an edited note. The
+// title is the first line that isn't blank.
+func parseEditText(text string) (title, content string) {
+	text = strings.TrimLeft(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	lines := strings.SplitN(text, "\n", 2)
+	title = strings.TrimSpace(lines[0])
+	if len(lines) == 2 {
+		content = strings.TrimRight(strings.TrimLeft(lines[1], "\n"), " \t\n")
+	}
+	return
+}
+
+// matchesWords reports whether a note's title or content contains every word,
+// ignoring case.
+func matchesWords(n client.Note, words []string) bool {
+	text := strings.ToLower(n.Title + "\n" + n.Content)
+	for _, word := range words {
+		if !strings.Contains(text, strings.ToLower(word)) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatItems(items []client.ChecklistItem) string {
+	lines := []string{}
+	for _, i := range items {
+		box := "[ ]"
+		if i.Checked {
+			box = "[x]"
+		}
+		lines = append(lines, "- " + box + " " + i.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatTime writes a time as the server does, in UTC.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(client.DATETIME_FORMAT)
+}
+
+func tagTitles(ts []client.Tag) []string {
+	titles := []string{}
+	for _, t := range ts {
+		titles = append(titles, t.Title)
+	}
+	return titles
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("Invalid note ID %v.", s)
+	}
+	return id, nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// stringsFlag is a flag that may be given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cpgillem/csnotes"
+	"github.com/cpgillem/csnotes/client"
+)
+
+// newTestServer starts the app on a seeded database, and points the CLI at
+// it with an empty token cache.
+func newTestServer(t *testing.T) (*httptest.Server, map[string]int64, func()) {
+	db, ids, err := csnotes.SeededTestDB()
+	if err != nil {
+		csnotes.TearDownDbTest(db)
+		t.Fatal(err)
+	}
+
+	_, router := csnotes.NewTestApp(t, db, nil)
+	server := httptest.NewServer(router)
+
+	os.Setenv("NOTES_CONFIG", filepath.Join(t.TempDir(), "token.json"))
+	os.Setenv("NOTES_SERVER", server.URL)
+	os.Unsetenv("NOTES_TOKEN")
+	os.Unsetenv("NOTES_PASSWORD")
+
+	return server, ids, func() {
+		server.Close()
+		csnotes.TearDownDbTest(db)
+	}
+}
+
+// runCLI runs a command line, failing the test unless it succeeds.
+func runCLI(t *testing.T, stdin string, args ...string) string {
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	if status != 0 {
+		t.Fatalf("notes %v: exit %d: %v", strings.Join(args, " "), status, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestCLI(t *testing.T) {
+	server, ids, done := newTestServer(t)
+	defer done()
+
+	// Commands fail until the user logs in.
+	var stderr bytes.Buffer
+	status := run([]string{"ls"}, strings.NewReader(""), &bytes.Buffer{}, &stderr)
+	csnotes.AssertEqual(status, 1, t)
+	csnotes.AssertContains(stderr.String(), "Not logged in", t)
+
+	status = run([]string{"login", "nonadmin"}, strings.NewReader("wrong\n"), &bytes.Buffer{}, &bytes.Buffer{})
+	csnotes.AssertEqual(status, 1, t)
+
+	// Logging in caches the token.
+	out := runCLI(t, "password\n", "login", "nonadmin")
+	csnotes.AssertContains(out, "Logged in", t)
+	config, err := LoadConfig()
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(config.Server, server.URL, t)
+	csnotes.AssertUnequal(config.Token, "", t)
+
+	// The seeded notes are listed as a table.
+	out = runCLI(t, "", "ls")
+	csnotes.AssertContains(out, "TITLE", t)
+	csnotes.AssertContains(out, "note1", t)
+	csnotes.AssertContains(out, "note2", t)
+
+	// And as JSON.
+	var ns []client.Note
+	out = runCLI(t, "", "-json", "ls")
+	err = json.Unmarshal([]byte(out), &ns)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(len(ns), 2, t)
+
+	// A new note takes its content from stdin, and is tagged.
+	out = runCLI(t, "Milk\nEggs\n", "-json", "new", "-tag", "groceries", "-tag", "tag1", "Shopping", "-")
+	var n client.Note
+	err = json.Unmarshal([]byte(out), &n)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(n.Content, "Milk\nEggs", t)
+	id := fmt.Sprint(n.ID)
+
+	var d NoteDetails
+	out = runCLI(t, "", "-json", "show", id)
+	err = json.Unmarshal([]byte(out), &d)
+	csnotes.AssertEqual(err, nil, t)
+	csnotes.AssertEqual(d.Title, "Shopping", t)
+	csnotes.AssertEqual(len(d.Tags), 2, t)
+
+	// The existing tag was reused rather than created again.
+	out = runCLI(t, "", "-json", "show", fmt.Sprint(ids["note.note1"]))
+	json.Unmarshal([]byte(out), &d)
+	for _, tag := range d.Tags {
+		if tag.Title == "tag1" {
+			csnotes.AssertEqual(tag.ID, ids["tag.tag1"], t)
+		}
+	}
+
+	// Tags can be removed by title.
+	out = runCLI(t, "", "tag", "-d", id, "GROCERIES")
+	csnotes.AssertEqual(out, "tag1\n", t)
+
+	// Editing saves what was written in the editor.
+	editFile = func(path string) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		csnotes.AssertEqual(string(b), "Shopping\n\nMilk\nEggs\n", t)
+		return os.WriteFile(path, []byte("Shopping list\n\nMilk\nEggs\nBread\n"), 0600)
+	}
+	runCLI(t, "", "edit", id)
+	out = runCLI(t, "", "show", id)
+	csnotes.AssertContains(out, "Shopping list", t)
+	csnotes.AssertContains(out, "Bread", t)
+
+	// Search matches every word, in any case.
+	out = runCLI(t, "", "search", "BREAD", "milk")
+	csnotes.AssertContains(out, "Shopping list", t)
+	out = runCLI(t, "", "-json", "search", "bread", "butter")
+	csnotes.AssertEqual(out, "[]\n", t)
+
+	// Only admins may list users.
+	stderr.Reset()
+	status = run([]string{"users"}, strings.NewReader(""), &bytes.Buffer{}, &stderr)
+	csnotes.AssertEqual(status, 1, t)
+	csnotes.AssertContains(stderr.String(), "403", t)
+
+	// Removed notes are gone.
+	out = runCLI(t, "", "rm", id)
+	csnotes.AssertContains(out, "Deleted note", t)
+	stderr.Reset()
+	status = run([]string{"show", id}, strings.NewReader(""), &bytes.Buffer{}, &stderr)
+	csnotes.AssertEqual(status, 1, t)
+	csnotes.AssertContains(stderr.String(), "404", t)
+
+	// Logging out forgets the token.
+	runCLI(t, "", "logout")
+	config, _ = LoadConfig()
+	csnotes.AssertEqual(config.Token, "", t)
+}
+
+func TestParseEditText(t *testing.T) {
+	title, content := parseEditText("\n  Title \n\n\n    code\nmore\n\n")
+	csnotes.AssertEqual(title, "Title", t)
+	csnotes.AssertEqual(content, "    code\nmore", t)
+
+	title, content = parseEditText("Only a title")
+	csnotes.AssertEqual(title, "Only a title", t)
+	csnotes.AssertEqual(content, "", t)
+}
+
+func TestMatchesWords(t *testing.T) {
+	n := client.Note{Title: "Trip", Content: "Pack the Tent"}
+
+	csnotes.AssertEqual(matchesWords(n, []string{"trip", "tent"}), true, t)
+	csnotes.AssertEqual(matchesWords(n, []string{"trip", "boots"}), false, t)
+}
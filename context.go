@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
@@ -13,63 +13,93 @@ import (
 // Context is a struct that contains the webapp's global variables.
 type Context struct {
 	DB *sql.DB
-	VerifyKey *rsa.PublicKey
+
+	// The ID of the key that new tokens are signed with. It is sent in the
+	// "kid" header of each token.
+	SignKeyID string
 	SignKey *rsa.PrivateKey
-}
 
-// LoggedInUser parses a JWT and returns a user ID and admin status, if 
-// possible.
-func (c *Context) LoggedInUser(r *http.Request) (uID int64, admin bool, err error) {
-	authHeader := r.Header.Get("Authorization")
+	// Public keys that tokens may be verified with, mapped by key ID. Keys
+	// that were rotated out stay here until their tokens have expired.
+	VerifyKeys map[string]*rsa.PublicKey
 
-	// Check for an authorization header.
-	if len(authHeader) < 8 {
-		err = errors.New("Authorization header not found.")
-		return 
-	}
+	// The "iss" and "aud" claims put into issued tokens and required of
+	// received ones. Either is left unchecked when empty.
+	Issuer string
+	Audience string
 
-	// Check for a token.
-	if strings.Index(authHeader, "Bearer ") != 0 {
-		err = errors.New("No token found in authorization header.")
-		return
-	}
+	// How far token time claims may be off before a token is rejected.
+	ClockSkew time.Duration
 
-	// Read and parse the token.
-	tokenString := authHeader[7:]
-	token, err := jwt.Parse(tokenString, func (*jwt.Token) (interface{}, error) {
-		return c.VerifyKey, nil
-	})
-	if err != nil {
-		return
-	}
+	// The sources of accounts that passwords are checked against, in order.
+	// If empty, only stored passwords are checked.
+	Authenticators []Authenticator
 
-	// Make sure the token is valid.
-	if !token.Valid {
-		err = errors.New("Token invalid.")
-		return
+	// The identity provider for single sign-on. If nil, single sign-on is
+	// disabled.
+	OIDC *OIDCProvider
+
+	// Sends verification and password reset emails.
+	Mailer Mailer
+
+	// The public URL of the app, such as https://notes.example.com, which
+	// emailed links point to.
+	BaseURL string
+
+	// Fires reminders. If nil, reminders cannot be set.
+	Reminders *ReminderScheduler
+
+	// Streams fired reminders to clients. If nil, the stream is disabled.
+	ReminderEvents *ReminderBroker
+
+	// Keeps the contents of note attachments. If nil, attachments are
+	// disabled.
+	Blobs BlobStore
+
+	// Makes the thumbnails of cover images. If nil, covers are disabled.
+	Thumbnails *Thumbnailer
+
+	// Keeps the rendered HTML of notes. If nil, notes are rendered every
+	// time.
+	Markdown *MarkdownCache
+
+	// Tracks failed logins. If nil, logins are never throttled.
+	LoginThrottle *LoginThrottle
+
+	// The source of the current time. If nil, the system clock is used.
+	Clock func() time.Time
+}
+
+// VerificationKey selects the public key for a token by its "kid" header. It
+// can be used directly as a jwt.Keyfunc. Tokens without a key ID are checked
+// against the active signing key.
+func (c *Context) VerificationKey(token *jwt.Token) (interface{}, error) {
+	// Only accept tokens signed the way this app signs them.
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, errors.New("Unexpected signing method.")
 	}
 
-	// Make sure the claims are a valid map.
-	claims, ok := token.Claims.(jwt.MapClaims)
+	kid, ok := token.Header["kid"].(string)
 	if !ok {
-		err = errors.New("Could not get token claims.")
-		return
+		kid = c.SignKeyID
 	}
 
-	// Extract the user ID from the claims.
-	id, ok := claims["user_id"].(float64)
+	key, ok := c.VerifyKeys[kid]
 	if !ok {
-		err = errors.New("Could not load user data from token.")
-		return
+		return nil, errors.New("Unknown signing key.")
 	}
-	uID = int64(id)
 
-	// Extract the admin status.
-	admin, ok = claims["user_admin"].(bool)
+	return key, nil
+}
+
+// LoggedInUser returns the ID and admin status of the user that the
+// authentication middleware found on the request.
+func (c *Context) LoggedInUser(r *http.Request) (uID int64, admin bool, err error) {
+	p, ok := CurrentPrincipal(r)
 	if !ok {
-		err = errors.New("Could not load user data from token.")
+		err = errors.New("Request is not authenticated.")
 		return
 	}
 
-	return
+	return p.UserID, p.Admin, nil
 }
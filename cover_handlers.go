@@ -0,0 +1,222 @@
+package csnotes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// How long browsers may keep a thumbnail. Each upload gets new thumbnail
+// URLs, so a thumbnail never changes.
+const THUMBNAIL_MAX_AGE = 365 * 24 * 60 * 60
+
+// GetNoteCover retrieves a note's cover image, and the URLs of its
+// thumbnails once they are ready.
+func GetNoteCover(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		err := n.LoadCover()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load cover."
+			return
+		}
+		if n.Cover == nil {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "Note has no cover."
+			return
+		}
+
+		resp.Models = append(resp.Models, *n.Cover)
+	}
+}
+
+// PutNoteCover uploads a note's cover image, replacing any it had. The image
+// is uploaded as the "file" field of a multipart form. Its thumbnails are
+// made in the background, so the cover is returned while still processing.
+func PutNoteCover(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		if context.Blobs == nil || context.Thumbnails == nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Cover images are disabled."
+			return
+		}
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		// Leave room for the multipart framing around the file.
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_ATTACHMENT_SIZE + 1 << 20)
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			resp.Fields["file"] = "Image must be uploaded as multipart/form-data."
+			return
+		}
+
+		// Find the image's part, skipping any others.
+		var a Attachment
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				resp.Fields["file"] = "Image must be specified."
+				return
+			}
+			if err != nil {
+				resp.Fields["file"] = "Could not read upload."
+				return
+			}
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+
+			a, err = n.Attach(context.Blobs, part.FileName(), part, context.Now())
+			part.Close()
+			if err == nil && !strings.HasPrefix(a.ContentType, "image/") {
+				a.Remove(context.Blobs)
+				err = ErrAttachmentType
+			}
+
+			switch err {
+			case ErrAttachmentTooLarge:
+				resp.StatusCode = 413
+				resp.ErrorMessage = fmt.Sprintf("Images may be at most %d bytes.", MAX_ATTACHMENT_SIZE)
+				return
+			case ErrAttachmentType:
+				resp.StatusCode = 415
+				resp.ErrorMessage = "Images must be PNG, JPEG, GIF or WebP."
+				return
+			}
+			if err != nil {
+				fmt.Println(err)
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not store image."
+				return
+			}
+			break
+		}
+
+		c, err := n.SetCover(a, context.Blobs)
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not set cover."
+			return
+		}
+
+		// Don't leave the upload waiting behind a full queue. It is removed
+		// rather than kept, since its metadata was never stripped.
+		if !context.Thumbnails.Enqueue(a.ID) {
+			err = n.RemoveCover(context.Blobs)
+			if err != nil {
+				fmt.Println(err)
+			}
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Too many images are being processed. Try again later."
+			return
+		}
+
+		resp.Models = append(resp.Models, c)
+	}
+}
+
+// DeleteNoteCover removes a note's cover image.
+func DeleteNoteCover(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		if context.Blobs == nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Cover images are disabled."
+			return
+		}
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		err := n.RemoveCover(context.Blobs)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not delete cover."
+			return
+		}
+
+		resp.Models = append(resp.Models, n)
+	}
+}
+
+// GetAttachmentThumbnail serves one size of an image attachment's
+// thumbnail. Thumbnails are only made for cover images.
+func GetAttachmentThumbnail(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Errors are answered in JSON, but the thumbnail itself is not.
+		resp := NewJSONResponse()
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			resp.Respond(w)
+			return
+		}
+
+		a, ok := loadNoteAttachment(context, r, &resp, n)
+		if !ok {
+			resp.Respond(w)
+			return
+		}
+
+		if context.Blobs == nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Cover images are disabled."
+			resp.Respond(w)
+			return
+		}
+
+		blob, err := context.Blobs.Get(ThumbnailKey(a, mux.Vars(r)["size"]))
+		if err == ErrBlobNotFound {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "Thumbnail not found."
+			resp.Respond(w)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not read thumbnail."
+			resp.Respond(w)
+			return
+		}
+		defer blob.Close()
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "private, max-age=" + strconv.Itoa(THUMBNAIL_MAX_AGE) + ", immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+
+		_, err = io.Copy(w, blob)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -3,14 +3,20 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/cpgillem/csnotes"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 func main() {
-	syntax := "Syntax: db setup|teardown|regenerate|seed"
+	syntax := "Syntax: db setup|teardown|regenerate|seed\n" +
+		"       db backup <file>\n" +
+		"       db restore [-merge] <file>\n" +
+		"       db import keep|enex|markdown <file> <username>"
 	valid := false
 
 	if len(os.Args) > 1 {
@@ -47,6 +53,36 @@ func main() {
 			}
 		}
 		
+		if os.Args[1] == "backup" && len(os.Args) == 3 {
+			valid = true
+			fmt.Println("Backing up DB...")
+			err = backup(db, os.Args[2])
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		
+		if os.Args[1] == "restore" && (len(os.Args) == 3 || (len(os.Args) == 4 && os.Args[2] == "-merge")) {
+			valid = true
+			fmt.Println("Restoring DB...")
+			err = restore(db, os.Args[len(os.Args) - 1], len(os.Args) == 4)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		
+		if os.Args[1] == "import" && len(os.Args) == 5 && csnotes.ValidImportSource(os.Args[2]) {
+			valid = true
+			fmt.Println("Importing notes...")
+			err = importNotes(db, os.Args[2], os.Args[3], os.Args[4])
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		
 		if !valid {
 			fmt.Println(syntax)
 		} else {
@@ -58,3 +94,126 @@ func main() {
 	}
 
 }
+
+// backup writes a backup of the database to a file. The backup is written
+// beside the file first, so that an existing backup is only replaced by a
+// complete one.
+func backup(db *sql.DB, filename string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	m, err := csnotes.WriteBackup(tmp, db, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	for _, name := range csnotes.BACKUP_FILES {
+		fmt.Printf("%-24s %d rows\n", name, m.Files[name].Rows)
+	}
+
+	return nil
+}
+
+// restore restores a backup from a file, either into an empty database or,
+// merging, into one that is already in use.
+func restore(db *sql.DB, filename string, merge bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	report, err := csnotes.RestoreBackup(f, info.Size(), db, merge)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d users (%d merged), %d notes (%d already there), %d items, %d tags and %d note tags.\n",
+		report.Users, report.MergedUsers, report.Notes, report.SkippedNotes, report.Items, report.Tags, report.NoteTags)
+	for _, w := range report.Warnings {
+		fmt.Println(w)
+	}
+
+	return nil
+}
+
+// importNotes imports a file's notes into a user's account, printing what
+// became of each. Notes imported before are skipped, so an import may be
+// run again.
+func importNotes(db *sql.DB, source, filename, username string) error {
+	var uID int64
+	err := db.QueryRow("SELECT id FROM users WHERE username=?", username).Scan(&uID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("User %q not found.", username)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	im := csnotes.NewImporter(db, blobStore(), uID, time.Now())
+	report, err := csnotes.ImportFile(source, f, info.Size(), im)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range report.Items {
+		fmt.Printf("%-8s %s\n", item.Status, item.File)
+		for _, e := range item.Errors {
+			fmt.Printf("         %s\n", e)
+		}
+	}
+	fmt.Printf("Imported %d, skipped %d, failed %d.\n", report.Imported, report.Skipped, report.Failed)
+
+	return nil
+}
+
+// blobStore stores attachments where the app does, configured by the same
+// environment variables.
+func blobStore() csnotes.BlobStore {
+	if endpoint := os.Getenv("NOTES_S3_ENDPOINT"); len(endpoint) > 0 {
+		region := os.Getenv("NOTES_S3_REGION")
+		if len(region) == 0 {
+			region = "us-east-1"
+		}
+		return &csnotes.S3BlobStore {
+			Endpoint: endpoint,
+			Region: region,
+			Bucket: os.Getenv("NOTES_S3_BUCKET"),
+			AccessKey: os.Getenv("NOTES_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("NOTES_S3_SECRET_KEY"),
+			Client: &http.Client{Timeout: time.Minute},
+		}
+	}
+
+	dir := os.Getenv("NOTES_BLOB_DIR")
+	if len(dir) == 0 {
+		dir = "./blobs"
+	}
+	return &csnotes.LocalBlobStore{Dir: dir}
+}
@@ -2,6 +2,8 @@ package csnotes
 
 import (
 	"database/sql"
+	"sync"
+	"time"
 	//"fmt"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -14,27 +16,183 @@ func SetUpDB(db *sql.DB) error {
 				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
 				name		VARCHAR(191),
 				username	VARCHAR(191) NOT NULL UNIQUE,
+				email		VARCHAR(191) UNIQUE,
+				email_verified	BOOLEAN DEFAULT FALSE NOT NULL,
+				oidc_subject	VARCHAR(191) UNIQUE,
+				auth_source	VARCHAR(16) DEFAULT 'local' NOT NULL,
 				password	VARCHAR(191) NOT NULL,
 				salt		VARCHAR(191) NOT NULL,
 				admin		BOOLEAN DEFAULT FALSE NOT NULL,
+				totp_secret		VARCHAR(191),
+				totp_enabled	BOOLEAN DEFAULT FALSE NOT NULL,
+				totp_last_step	BIGINT DEFAULT 0 NOT NULL,
+				credentials_changed	BIGINT DEFAULT 0 NOT NULL,
+				calendar_token	CHAR(64) UNIQUE,
 				PRIMARY		KEY (id)
 			)`)
 	if err != nil {
 		return err
 	}
+
+	_, err = db.Exec(`CREATE TABLE api_tokens (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				name		VARCHAR(191) NOT NULL,
+				token_hash	CHAR(64) NOT NULL UNIQUE,
+				scopes		VARCHAR(191) NOT NULL,
+				expires		DATETIME,
+				last_used	DATETIME,
+				created		DATETIME NOT NULL,
+				user_id		INT(10) NOT NULL,
+				PRIMARY KEY (id)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE email_tokens (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				user_id		INT(10) NOT NULL,
+				purpose		VARCHAR(32) NOT NULL,
+				token_hash	CHAR(64) NOT NULL UNIQUE,
+				expires		DATETIME NOT NULL,
+				PRIMARY KEY (id)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE recovery_codes (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				user_id		INT(10) NOT NULL,
+				code_hash	CHAR(64) NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY (user_id, code_hash)
+			)`)
+	if err != nil {
+		return err
+	}
 	
 	_, err = db.Exec(`CREATE TABLE notes (
 				id		INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
 				title	VARCHAR(191) NOT NULL,
+				type	VARCHAR(16) DEFAULT 'text' NOT NULL,
 				content TEXT,
 				time	DATETIME,
+				pinned	BOOLEAN DEFAULT FALSE NOT NULL,
+				archived	BOOLEAN DEFAULT FALSE NOT NULL,
+				color	VARCHAR(16) DEFAULT 'default' NOT NULL,
 				user_id	INT(10) NOT NULL,
+				created	DATETIME,
+				updated	DATETIME,
 				PRIMARY KEY (id)
 			)`)
 	if err != nil {
 		return err
 	}
 
+	_, err = db.Exec(`CREATE TABLE reminders (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				note_id		INT(10) NOT NULL UNIQUE,
+				user_id		INT(10) NOT NULL,
+				starts		DATETIME NOT NULL,
+				remind_at	DATETIME NOT NULL,
+				recurrence	VARCHAR(191) DEFAULT '' NOT NULL,
+				channels	VARCHAR(191) NOT NULL,
+				webhook_url	VARCHAR(191),
+				done		BOOLEAN DEFAULT FALSE NOT NULL,
+				PRIMARY KEY (id),
+				KEY (done, remind_at)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE reminder_deliveries (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				reminder_id	INT(10) NOT NULL,
+				channel		VARCHAR(32) NOT NULL,
+				due			DATETIME NOT NULL,
+				attempts	INT(10) DEFAULT 0 NOT NULL,
+				lease_until	DATETIME,
+				PRIMARY KEY (id),
+				UNIQUE KEY (reminder_id, due, channel)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE checklist_items (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				note_id		INT(10) NOT NULL,
+				text		TEXT NOT NULL,
+				checked		BOOLEAN DEFAULT FALSE NOT NULL,
+				position	INT(10) NOT NULL,
+				PRIMARY KEY (id),
+				KEY (note_id, position)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE attachments (
+				id				INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				note_id			INT(10) NOT NULL,
+				user_id			INT(10) NOT NULL,
+				filename		VARCHAR(191) NOT NULL,
+				content_type	VARCHAR(100) NOT NULL,
+				size			BIGINT NOT NULL,
+				sha256			CHAR(64) NOT NULL,
+				storage_key		VARCHAR(191) NOT NULL UNIQUE,
+				created			DATETIME NOT NULL,
+				PRIMARY KEY (id),
+				KEY (note_id)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE note_covers (
+				id				INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				note_id			INT(10) NOT NULL UNIQUE,
+				attachment_id	INT(10) NOT NULL UNIQUE,
+				status			VARCHAR(20) NOT NULL,
+				width			INT(10) DEFAULT 0 NOT NULL,
+				height			INT(10) DEFAULT 0 NOT NULL,
+				PRIMARY KEY (id),
+				KEY (status)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE note_links (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				source_id	INT(10) NOT NULL,
+				target_id	INT(10),
+				ref			VARCHAR(191) NOT NULL,
+				PRIMARY KEY (id),
+				KEY (source_id),
+				KEY (target_id),
+				KEY (ref)
+			)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE note_imports (
+				id			INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
+				user_id		INT(10) NOT NULL,
+				source		VARCHAR(16) NOT NULL,
+				source_key	CHAR(64) NOT NULL,
+				note_id		INT(10) NOT NULL,
+				PRIMARY KEY (id),
+				UNIQUE KEY (user_id, source, source_key),
+				KEY (note_id)
+			)`)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`CREATE TABLE tags (
 				id		INT(10) NOT NULL UNIQUE AUTO_INCREMENT,
 				title	VARCHAR(191) NOT NULL,
@@ -79,19 +237,76 @@ func TearDownDB(db *sql.DB) error {
 		return err
 	}
 
+	_, err = db.Exec("DROP TABLE IF EXISTS recovery_codes")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS api_tokens")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS email_tokens")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS checklist_items")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS reminders")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS reminder_deliveries")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS attachments")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS note_covers")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS note_links")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE IF EXISTS note_imports")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// StorePassword creates a hash and salt for a user.
-func StorePassword(id int64, password string, db *sql.DB) error {
-	// Hash and salt the password.
+// HashPassword hashes and salts a password for storage.
+func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// StorePassword creates a hash and salt for a user, and marks their
+// credentials as changed at now.
+func StorePassword(id int64, password string, now time.Time, db *sql.DB) error {
+	// Hash and salt the password.
+	hash, err := HashPassword(password)
 	if err != nil {
 		return err
 	}
 
 	// Save these values in the database.
-	_, err = db.Exec("UPDATE users SET password=? WHERE id=?", string(hash), id)
+	_, err = db.Exec("UPDATE users SET password=?, credentials_changed=? WHERE id=?", hash, now.Unix(), id)
 
 	return err
 }
@@ -114,6 +329,28 @@ func CheckPassword(id int64, password string, db *sql.DB) (bool, error) {
 	return err == nil, err
 }
 
+// CredentialsChanged returns when a user's password or two-factor
+// authentication last changed.
+func CredentialsChanged(id int64, db *sql.DB) (time.Time, error) {
+	var changed int64
+	err := db.QueryRow("SELECT credentials_changed FROM users WHERE id=?", id).Scan(&changed)
+	return time.Unix(changed, 0), err
+}
+
+// dummyPasswordHash is compared against when a user does not exist. It is
+// generated once, at the same cost as real password hashes.
+var dummyPasswordHash []byte
+var dummyPasswordOnce sync.Once
+
+// ComparePasswordDummy performs a password comparison that always fails. It
+// takes as long as CheckPassword does for a real user.
+func ComparePasswordDummy(password string) {
+	dummyPasswordOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
+
 func SeedDB(db *sql.DB) (ids map[string]int64, err error) {
 	ids = map[string]int64{}
 
@@ -126,7 +363,7 @@ func SeedDB(db *sql.DB) (ids map[string]int64, err error) {
 	if err != nil {
 		return
 	}
-	err = StorePassword(ids["user.nonadmin"], "password", db)
+	err = StorePassword(ids["user.nonadmin"], "password", time.Now(), db)
 	if err != nil {
 		return
 	}
@@ -139,7 +376,7 @@ func SeedDB(db *sql.DB) (ids map[string]int64, err error) {
 	if err != nil {
 		return
 	}
-	err = StorePassword(ids["user.admin"], "password", db)
+	err = StorePassword(ids["user.admin"], "password", time.Now(), db)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,35 @@
+package csnotes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// The request context key of the response writer the server passed in.
+type serverWriterKey struct{}
+
+// KeepServerWriter remembers the response writer the server passes to each
+// request, so that handlers can still reach its connection from behind
+// middleware that wraps the writer without unwrapping, as negroni does. It
+// must be the outermost handler.
+func KeepServerWriter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverWriterKey{}, w)))
+	})
+}
+
+// LiftWriteDeadline removes the server's write timeout from a response, for
+// routes that stream more than can be sent within it.
+func LiftWriteDeadline(w http.ResponseWriter, r *http.Request) error {
+	if sw, ok := r.Context().Value(serverWriterKey{}).(http.ResponseWriter); ok {
+		w = sw
+	}
+
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if errors.Is(err, http.ErrNotSupported) {
+		return errors.New("Response writer has no deadline. Is the handler wrapped in KeepServerWriter?")
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package csnotes
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// The purposes an emailed token can be used for.
+	VERIFY_EMAIL_PURPOSE = "verify_email"
+	RESET_PASSWORD_PURPOSE = "reset_password"
+
+	// How long emailed links stay valid.
+	VERIFY_EMAIL_LIFETIME = time.Hour * 48
+	RESET_PASSWORD_LIFETIME = time.Hour
+)
+
+// ErrTokenInvalid is returned when an emailed token does not exist, has
+// expired, or was already used.
+var ErrTokenInvalid = errors.New("Token is invalid or has expired.")
+
+// hashEmailToken hashes an emailed token for storage.
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateEmailToken creates a single-use token for a user. Any earlier tokens
+// for the same purpose are discarded, so only the latest link works.
+func CreateEmailToken(uID int64, purpose string, lifetime time.Duration, now time.Time, db *sql.DB) (string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec("DELETE FROM email_tokens WHERE user_id=? AND purpose=?", uID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec("INSERT INTO email_tokens (user_id, purpose, token_hash, expires) VALUES (?, ?, ?, ?)",
+		uID, purpose, hashEmailToken(token), now.UTC().Add(lifetime).Format(DATETIME_FORMAT))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeEmailToken checks a token for a purpose and deletes it, so that it
+// cannot be used again. It returns the ID of the user the token was for.
+func ConsumeEmailToken(token, purpose string, now time.Time, db *sql.DB) (uID int64, err error) {
+	var id int64
+	var expires string
+	row := db.QueryRow("SELECT id, user_id, expires FROM email_tokens WHERE token_hash=? AND purpose=?",
+		hashEmailToken(token), purpose)
+	err = row.Scan(&id, &uID, &expires)
+	if err == sql.ErrNoRows {
+		err = ErrTokenInvalid
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	// Delete the token before acting on it. If another request consumed it
+	// first, no row is affected and this one fails.
+	res, err := db.Exec("DELETE FROM email_tokens WHERE id=?", id)
+	if err != nil {
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return 0, ErrTokenInvalid
+	}
+
+	exp, err := time.Parse(DATETIME_FORMAT, expires)
+	if err != nil || !now.UTC().Before(exp) {
+		return 0, ErrTokenInvalid
+	}
+
+	return
+}
+
+// ReleaseUnverifiedEmail frees an email address held by accounts that never
+// verified it once their verification links have expired, so that a signup
+// cannot reserve someone else's address forever.
+func ReleaseUnverifiedEmail(email string, now time.Time, db *sql.DB) error {
+	_, err := db.Exec(`UPDATE users SET email=NULL WHERE email=? AND email_verified=FALSE AND NOT EXISTS
+		(SELECT id FROM email_tokens WHERE email_tokens.user_id=users.id AND purpose=? AND expires>?)`,
+		email, VERIFY_EMAIL_PURPOSE, now.UTC().Format(DATETIME_FORMAT))
+	return err
+}
+
+// RevokeUnverifiedEmail takes an email address away from every account that
+// never verified it, for when its owner has proven it some other way. Their
+// verification links are discarded too, so that the accounts cannot be
+// verified afterwards.
+func RevokeUnverifiedEmail(email string, db *sql.DB) error {
+	_, err := db.Exec(`DELETE FROM email_tokens WHERE purpose=? AND user_id IN
+		(SELECT id FROM users WHERE email=? AND email_verified=FALSE)`, VERIFY_EMAIL_PURPOSE, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET email=NULL WHERE email=? AND email_verified=FALSE", email)
+	return err
+}
+
+// SendVerificationEmail emails a user a link to verify their address.
+func SendVerificationEmail(context *Context, u User) error {
+	token, err := CreateEmailToken(u.ID, VERIFY_EMAIL_PURPOSE, VERIFY_EMAIL_LIFETIME, context.Now(), context.DB)
+	if err != nil {
+		return err
+	}
+
+	return context.Mailer.Send(MailMessage {
+		To: u.Email.String,
+		Subject: "Verify your email address",
+		Body: "Welcome to Notes, " + u.Username + "!\n\n" +
+			"Open this link to verify your email address:\n\n" +
+			context.BaseURL + "/signup/verify?token=" + token + "\n\n" +
+			"The link expires in 48 hours.\n",
+	})
+}
+
+// SendPasswordResetEmail emails a user a link to choose a new password.
+func SendPasswordResetEmail(context *Context, u User) error {
+	token, err := CreateEmailToken(u.ID, RESET_PASSWORD_PURPOSE, RESET_PASSWORD_LIFETIME, context.Now(), context.DB)
+	if err != nil {
+		return err
+	}
+
+	return context.Mailer.Send(MailMessage {
+		To: u.Email.String,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password for " + u.Username + ".\n\n" +
+			"Open this link to choose a new password:\n\n" +
+			context.BaseURL + "/reset.html#token=" + token + "\n\n" +
+			"The link expires in one hour. If you did not ask for this, you can\n" +
+			"ignore this email.\n",
+	})
+}
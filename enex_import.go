@@ -0,0 +1,384 @@
+package csnotes
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// The format of times in an ENEX export.
+const ENEX_TIME_FORMAT = "20060102T150405Z"
+
+// ErrNotENEX is returned for a file that is not an ENEX export.
+var ErrNotENEX = errors.New("File must be an ENEX export.")
+
+// ENEXNote is a note as Evernote exports it in an ENEX file. Its content is
+// ENML, Evernote's own subset of XHTML.
+type ENEXNote struct {
+	Title string `xml:"title"`
+	Content string `xml:"content"`
+	Created string `xml:"created"`
+	Updated string `xml:"updated"`
+	Tags []string `xml:"tag"`
+	Resources []ENEXResource `xml:"resource"`
+}
+
+// ENEXResource is a file attached to an ENEX note, encoded in base64.
+type ENEXResource struct {
+	Data string `xml:"data"`
+	Mime string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// ImportedNote converts an ENEX note to be imported, converting its content
+// to Markdown.
+func (e ENEXNote) ImportedNote() (in ImportedNote, err error) {
+	content, err := ENMLToMarkdown(e.Content)
+	if err != nil {
+		return
+	}
+
+	in = ImportedNote {
+		Source: IMPORT_SOURCE_ENEX,
+		SourceKey: ImportKey(e.Title, e.Created, e.Content),
+		Title: e.Title,
+		Content: content,
+		Tags: e.Tags,
+	}
+
+	// Times that can't be read are left for now.
+	in.Created, _ = time.Parse(ENEX_TIME_FORMAT, strings.TrimSpace(e.Created))
+	in.Updated, _ = time.Parse(ENEX_TIME_FORMAT, strings.TrimSpace(e.Updated))
+
+	for i, res := range e.Resources {
+		filename := strings.TrimSpace(res.FileName)
+		if len(filename) == 0 {
+			filename = fmt.Sprintf("attachment-%d", i + 1)
+			if exts, _ := mime.ExtensionsByType(res.Mime); len(exts) > 0 {
+				filename += exts[0]
+			}
+		}
+
+		// Whitespace breaks up the encoded data.
+		data := strings.Join(strings.Fields(res.Data), "")
+		in.Attachments = append(in.Attachments, ImportedAttachment {
+			Filename: filename,
+			Open: func() (io.ReadCloser, error) {
+				return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))), nil
+			},
+		})
+	}
+
+	return
+}
+
+// ImportENEX imports every note of an ENEX export. Notes are read one at a
+// time. If the file is not an ENEX export at all, ErrNotENEX is returned.
+func ImportENEX(r io.Reader, im *Importer) (ImportReport, error) {
+	report := NewImportReport()
+
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	root := false
+	for i := 1; ; {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !root {
+				return report, ErrNotENEX
+			}
+
+			// The rest of the file can't be read.
+			report.Add(ImportItem {
+				File: fmt.Sprintf("note %d", i),
+				Status: IMPORT_STATUS_FAILED,
+				Errors: []string{"File is not valid XML."},
+			})
+			break
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !root {
+			if se.Name.Local != "en-export" {
+				return report, ErrNotENEX
+			}
+			root = true
+			continue
+		}
+		if se.Name.Local != "note" {
+			continue
+		}
+
+		item := ImportItem {
+			File: fmt.Sprintf("note %d", i),
+		}
+		i++
+
+		var e ENEXNote
+		err = d.DecodeElement(&e, &se)
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = []string{"Note is not valid XML."}
+			report.Add(item)
+			break
+		}
+
+		in, err := e.ImportedNote()
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = []string{"Could not read note's content."}
+			report.Add(item)
+			continue
+		}
+		item.Title = ImportTitle(in)
+
+		n, warnings, err := im.Save(in)
+		if err == ErrAlreadyImported {
+			item.Status = IMPORT_STATUS_SKIPPED
+			item.NoteID = n.ID
+			item.Errors = []string{err.Error()}
+			report.Add(item)
+			continue
+		}
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = []string{"Could not save note."}
+			report.Add(item)
+			continue
+		}
+
+		item.Status = IMPORT_STATUS_IMPORTED
+		item.NoteID = n.ID
+		item.Errors = warnings
+		report.Add(item)
+	}
+
+	if !root {
+		return report, ErrNotENEX
+	}
+	return report, nil
+}
+
+var (
+	// Runs of blank lines, which are collapsed into one.
+	enmlBlankLines = regexp.MustCompile(`\n{3,}`)
+
+	// Whitespace in text, which is collapsed into a space outside of <pre>.
+	enmlSpaces = regexp.MustCompile(`\s+`)
+)
+
+// ENMLToMarkdown converts a note's ENML to Markdown. Formatting Markdown has
+// no syntax for is dropped, keeping its text, as are media, which are
+// imported as attachments instead. Evernote's to-do boxes become task list
+// items.
+func ENMLToMarkdown(enml string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(enml))
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	writeENML(&b, doc)
+
+	// Make to-do boxes at the start of a line into task list items.
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if strings.HasPrefix(line, "[ ] ") || strings.HasPrefix(line, "[x] ") {
+			line = "- " + line
+		}
+		lines[i] = line
+	}
+
+	md := enmlBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(md), nil
+}
+
+// writeENML writes a node and its children as Markdown.
+func writeENML(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		text := enmlSpaces.ReplaceAllString(n.Data, " ")
+		if b.Len() == 0 || strings.HasSuffix(b.String(), "\n") {
+			text = strings.TrimLeft(text, " ")
+		}
+		b.WriteString(text)
+		return
+	case html.ElementNode:
+	default:
+		writeENMLChildren(b, n)
+		return
+	}
+
+	switch n.Data {
+	case "head", "script", "style", "en-crypt":
+		// Encrypted text can't be read.
+	case "en-media":
+		// Media are imported as attachments. Parsed as HTML, a media
+		// element holds whatever follows it.
+		writeENMLChildren(b, n)
+	case "br":
+		b.WriteString("\n")
+	case "hr":
+		b.WriteString("\n\n---\n\n")
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.TrimSpace(enmlInline(n))
+		if n.Data != "p" && len(text) > 0 {
+			text = strings.Repeat("#", int(n.Data[1] - '0')) + " " + text
+		}
+		b.WriteString("\n\n" + text + "\n\n")
+	case "div", "tr":
+		// Evernote writes each line as a div.
+		var inner strings.Builder
+		if n.Data == "tr" {
+			var cells []string
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode {
+					cells = append(cells, strings.TrimSpace(enmlInline(c)))
+				}
+			}
+			inner.WriteString(strings.Join(cells, " | "))
+		} else {
+			writeENMLChildren(&inner, n)
+		}
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString(strings.TrimSpace(inner.String()) + "\n")
+	case "ul", "ol":
+		b.WriteString("\n\n")
+		i := 1
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data != "li" {
+				continue
+			}
+			marker := "- "
+			if n.Data == "ol" {
+				marker = fmt.Sprintf("%d. ", i)
+			}
+			i++
+
+			// Tighten the item and indent any lines after its first, such
+			// as those of a nested list.
+			var item strings.Builder
+			writeENMLChildren(&item, c)
+			text := enmlBlankLines.ReplaceAllString(strings.TrimSpace(item.String()), "\n\n")
+			text = strings.ReplaceAll(text, "\n\n", "\n")
+			text = strings.ReplaceAll(text, "\n", "\n" + strings.Repeat(" ", len(marker)))
+			b.WriteString(marker + text + "\n")
+		}
+		b.WriteString("\n")
+	case "blockquote":
+		var inner strings.Builder
+		writeENMLChildren(&inner, n)
+		text := enmlBlankLines.ReplaceAllString(strings.TrimSpace(inner.String()), "\n\n")
+		b.WriteString("\n\n> " + strings.ReplaceAll(text, "\n", "\n> ") + "\n\n")
+	case "pre":
+		b.WriteString("\n\n```\n" + strings.Trim(enmlText(n), "\n") + "\n```\n\n")
+	case "code":
+		b.WriteString("`" + enmlText(n) + "`")
+	case "b", "strong":
+		writeENMLWrapped(b, n, "**")
+	case "i", "em":
+		writeENMLWrapped(b, n, "*")
+	case "s", "strike", "del":
+		writeENMLWrapped(b, n, "~~")
+	case "a":
+		text := strings.TrimSpace(enmlInline(n))
+		href := enmlAttr(n, "href")
+		switch {
+		case len(href) == 0:
+			b.WriteString(text)
+		case len(text) == 0 || text == href:
+			b.WriteString("<" + href + ">")
+		default:
+			b.WriteString("[" + text + "](" + href + ")")
+		}
+	case "img":
+		src := enmlAttr(n, "src")
+		if len(src) > 0 && !strings.HasPrefix(src, "data:") {
+			b.WriteString("![" + enmlAttr(n, "alt") + "](" + src + ")")
+		}
+	case "en-todo":
+		if enmlAttr(n, "checked") == "true" {
+			b.WriteString("[x] ")
+		} else {
+			b.WriteString("[ ] ")
+		}
+
+		// Like media, a to-do box holds the text after it.
+		writeENMLChildren(b, n)
+	default:
+		writeENMLChildren(b, n)
+	}
+}
+
+func writeENMLChildren(b *strings.Builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeENML(b, c)
+	}
+}
+
+// writeENMLWrapped writes inline text between markers, such as ** for bold.
+// Spaces are kept outside the markers, where Markdown needs them.
+func writeENMLWrapped(b *strings.Builder, n *html.Node, marker string) {
+	text := enmlInline(n)
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) == 0 {
+		b.WriteString(text)
+		return
+	}
+
+	if strings.HasPrefix(text, " ") {
+		b.WriteString(" ")
+	}
+	b.WriteString(marker + trimmed + marker)
+	if strings.HasSuffix(text, " ") {
+		b.WriteString(" ")
+	}
+}
+
+// enmlInline writes a node's children as Markdown on one line.
+func enmlInline(n *html.Node) string {
+	var b strings.Builder
+	writeENMLChildren(&b, n)
+	return enmlSpaces.ReplaceAllString(b.String(), " ")
+}
+
+// enmlText gives the text of a node, keeping its whitespace.
+func enmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && n.Data == "br" {
+		return "\n"
+	}
+
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(enmlText(c))
+	}
+	return b.String()
+}
+
+func enmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
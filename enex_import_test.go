@@ -0,0 +1,87 @@
+package csnotes
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestENMLToMarkdown(t *testing.T) {
+	md, err := ENMLToMarkdown(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note>
+<h2>Plan</h2>
+<div>Some <b>bold</b> and <i>italic </i>text, with <a href="https://example.com">a link</a>.</div>
+<div><br/></div>
+<div><en-todo checked="true"/>Done</div>
+<div><en-todo/>Not done</div>
+<ul><li>One<ul><li>Nested</li></ul></li><li>Two</li></ul>
+<ol><li>First</li><li>Second</li></ol>
+<blockquote><div>Quoted</div><div>text</div></blockquote>
+<pre>  code
+  block</pre>
+<en-media type="image/png" hash="abc"/>
+<table><tr><td>a</td><td>b</td></tr></table>
+</en-note>`)
+	AssertEqual(err, nil, t)
+
+	AssertEqual(md, "## Plan\n\n" +
+		"Some **bold** and *italic* text, with [a link](https://example.com).\n\n" +
+		"- [x] Done\n" +
+		"- [ ] Not done\n\n" +
+		"- One\n  - Nested\n- Two\n\n" +
+		"1. First\n2. Second\n\n" +
+		"> Quoted\n> text\n\n" +
+		"```\n  code\n  block\n```\n\n" +
+		"a | b", t)
+}
+
+func TestENEXNote(t *testing.T) {
+	e := ENEXNote {
+		Title: "Trip",
+		Content: `<en-note><div>Pack</div></en-note>`,
+		Created: "20170101T120000Z",
+		Updated: "20170102T120000Z",
+		Tags: []string{"travel"},
+		Resources: []ENEXResource {
+			{Data: "aGVs\nbG8=\n", Mime: "image/png"},
+			{Data: "", Mime: "image/jpeg", FileName: "photo.jpg"},
+		},
+	}
+
+	in, err := e.ImportedNote()
+	AssertEqual(err, nil, t)
+	AssertEqual(in.Content, "Pack", t)
+	AssertEqual(in.Created, time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC), t)
+	AssertEqual(in.Updated, time.Date(2017, 1, 2, 12, 0, 0, 0, time.UTC), t)
+	AssertEqual(len(in.Attachments), 2, t)
+	if len(in.Attachments) == 2 {
+		AssertEqual(in.Attachments[0].Filename, "attachment-1.png", t)
+		AssertEqual(in.Attachments[1].Filename, "photo.jpg", t)
+
+		r, _ := in.Attachments[0].Open()
+		data, err := io.ReadAll(r)
+		AssertEqual(err, nil, t)
+		AssertEqual(string(data), "hello", t)
+	}
+
+	// The same note gives the same key, so it isn't imported twice.
+	again, _ := e.ImportedNote()
+	AssertEqual(again.SourceKey, in.SourceKey, t)
+	e.Content = `<en-note><div>Pack light</div></en-note>`
+	changed, _ := e.ImportedNote()
+	AssertUnequal(changed.SourceKey, in.SourceKey, t)
+}
+
+func TestImportENEXFormat(t *testing.T) {
+	_, err := ImportENEX(strings.NewReader("not xml"), nil)
+	AssertEqual(err, ErrNotENEX, t)
+
+	_, err = ImportENEX(strings.NewReader(`<?xml version="1.0"?><html></html>`), nil)
+	AssertEqual(err, ErrNotENEX, t)
+
+	report, err := ImportENEX(strings.NewReader(`<?xml version="1.0"?><en-export></en-export>`), nil)
+	AssertEqual(err, nil, t)
+	AssertEqual(len(report.Items), 0, t)
+}
@@ -0,0 +1,364 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The formats notes can be exported in. Each is a zip archive.
+const (
+	// A single notes.json file holding every note.
+	EXPORT_FORMAT_JSON = "json"
+
+	// A Markdown file per note, with YAML front matter.
+	EXPORT_FORMAT_MARKDOWN = "markdown"
+
+	// An HTML page per note, with an index page.
+	EXPORT_FORMAT_HTML = "html"
+)
+
+// ValidExportFormat reports whether notes can be exported in a format.
+func ValidExportFormat(format string) bool {
+	return format == EXPORT_FORMAT_JSON || format == EXPORT_FORMAT_MARKDOWN || format == EXPORT_FORMAT_HTML
+}
+
+// ExportNote is a note as it is written to an export.
+type ExportNote struct {
+	ID int64 `json:"id"`
+	Title string `json:"title"`
+	Type string `json:"type"`
+
+	// The note's Markdown. Checklists are written as task lists.
+	Content string `json:"content"`
+
+	Time string `json:"time,omitempty"`
+	Created string `json:"created,omitempty"`
+	Updated string `json:"updated,omitempty"`
+	Pinned bool `json:"pinned"`
+	Archived bool `json:"archived"`
+	Color string `json:"color"`
+	Tags []string `json:"tags"`
+}
+
+// LoadExportNote loads a note and its tags for an export.
+func LoadExportNote(id int64, db *sql.DB) (e ExportNote, err error) {
+	n, err := LoadNote(id, db)
+	if err != nil {
+		return
+	}
+
+	content, err := n.MarkdownSource()
+	if err != nil {
+		return
+	}
+
+	ts, err := n.Tags()
+	if err != nil {
+		return
+	}
+
+	e = ExportNote {
+		ID: n.ID,
+		Title: n.Title,
+		Type: n.Type,
+		Content: content,
+		Time: n.Time.String,
+		Created: n.Created.String,
+		Updated: n.Updated.String,
+		Pinned: n.Pinned,
+		Archived: n.Archived,
+		Color: n.Color,
+		Tags: []string{},
+	}
+	for _, t := range ts {
+		e.Tags = append(e.Tags, t.Title)
+	}
+
+	return
+}
+
+// WriteExport writes all of a user's notes, archived or not, to a zip
+// archive in a format. Notes are loaded and written one at a time, so an
+// export can be streamed without holding every note in memory.
+func WriteExport(out io.Writer, format string, uID int64, db *sql.DB, now time.Time) error {
+	// Collect the IDs first, so that the connection is free to load each
+	// note.
+	rows, err := db.Query("SELECT id FROM notes WHERE user_id=? ORDER BY id", uID)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	zw := zip.NewWriter(out)
+
+	switch format {
+	case EXPORT_FORMAT_JSON:
+		err = writeJSONExport(zw, ids, db, now)
+	case EXPORT_FORMAT_MARKDOWN:
+		err = writeMarkdownExport(zw, ids, db, now)
+	case EXPORT_FORMAT_HTML:
+		err = writeHTMLExport(zw, ids, db, now)
+	default:
+		err = fmt.Errorf("Unknown export format %q.", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// createExportFile adds a compressed file to an export, dated when its note
+// was last changed.
+func createExportFile(zw *zip.Writer, name string, modified string, now time.Time) (io.Writer, error) {
+	t, err := time.Parse(DATETIME_FORMAT, modified)
+	if err != nil {
+		t = now
+	}
+
+	return zw.CreateHeader(&zip.FileHeader {
+		Name: name,
+		Method: zip.Deflate,
+		Modified: t,
+	})
+}
+
+func writeJSONExport(zw *zip.Writer, ids []int64, db *sql.DB, now time.Time) error {
+	f, err := createExportFile(zw, "notes.json", "", now)
+	if err != nil {
+		return err
+	}
+
+	// Write the array a note at a time.
+	_, err = io.WriteString(f, "[")
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		e, err := LoadExportNote(id, db)
+		if err != nil {
+			return err
+		}
+
+		b, err := json.MarshalIndent(e, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		sep := ",\n  "
+		if i == 0 {
+			sep = "\n  "
+		}
+		_, err = io.WriteString(f, sep)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(f, "\n]\n")
+
+	return err
+}
+
+func writeMarkdownExport(zw *zip.Writer, ids []int64, db *sql.DB, now time.Time) error {
+	for _, id := range ids {
+		e, err := LoadExportNote(id, db)
+		if err != nil {
+			return err
+		}
+
+		f, err := createExportFile(zw, "notes/" + ExportFilename(e) + ".md", e.Updated, now)
+		if err != nil {
+			return err
+		}
+
+		err = WriteMarkdownNote(f, e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteMarkdownNote writes a note as Markdown, with its other fields in YAML
+// front matter.
+func WriteMarkdownNote(out io.Writer, e ExportNote) error {
+	var b strings.Builder
+
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %d\n", e.ID)
+	fmt.Fprintf(&b, "title: %s\n", yamlQuote(e.Title))
+	fmt.Fprintf(&b, "type: %s\n", e.Type)
+	if len(e.Tags) == 0 {
+		b.WriteString("tags: []\n")
+	} else {
+		b.WriteString("tags:\n")
+		for _, t := range e.Tags {
+			fmt.Fprintf(&b, "  - %s\n", yamlQuote(t))
+		}
+	}
+	for _, field := range []struct{ name, value string } {
+		{"time", e.Time},
+		{"created", e.Created},
+		{"updated", e.Updated},
+	} {
+		if len(field.value) > 0 {
+			fmt.Fprintf(&b, "%s: %s\n", field.name, yamlQuote(field.value))
+		}
+	}
+	fmt.Fprintf(&b, "pinned: %t\n", e.Pinned)
+	fmt.Fprintf(&b, "archived: %t\n", e.Archived)
+	fmt.Fprintf(&b, "color: %s\n", e.Color)
+	b.WriteString("---\n\n")
+
+	b.WriteString(e.Content)
+	if len(e.Content) > 0 && !strings.HasSuffix(e.Content, "\n") {
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// yamlQuote writes a string as a double-quoted YAML scalar, which is safe
+// whatever the string holds.
+func yamlQuote(s string) string {
+	var b strings.Builder
+
+	b.WriteString(`"`)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString(`"`)
+
+	return b.String()
+}
+
+// exportPage is an HTML page of an export.
+var exportPage = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+{{if .Note}}<p><a href="../index.html">All notes</a></p>
+<h1>{{.Note.Title}}</h1>
+<p>{{if .Note.Time}}{{.Note.Time}}{{end}}{{range .Note.Tags}} <span class="tag">#{{.}}</span>{{end}}</p>
+{{.Body}}{{else}}<h1>{{.Title}}</h1>
+<ul>
+{{range .Index}}<li><a href="notes/{{.Filename}}.html">{{.Title}}</a></li>
+{{end}}</ul>{{end}}
+</body>
+</html>
+`))
+
+// exportIndexEntry is a note listed on the index page of an HTML export.
+type exportIndexEntry struct {
+	Filename string
+	Title string
+}
+
+func writeHTMLExport(zw *zip.Writer, ids []int64, db *sql.DB, now time.Time) error {
+	// Only the titles are kept for the index, not the notes.
+	var index []exportIndexEntry
+
+	for _, id := range ids {
+		e, err := LoadExportNote(id, db)
+		if err != nil {
+			return err
+		}
+
+		// The rendered HTML is already sanitized.
+		body, err := RenderMarkdown(e.Content)
+		if err != nil {
+			return err
+		}
+
+		filename := ExportFilename(e)
+		f, err := createExportFile(zw, "notes/" + filename + ".html", e.Updated, now)
+		if err != nil {
+			return err
+		}
+
+		err = exportPage.Execute(f, map[string]interface{} {
+			"Title": e.Title,
+			"Note": e,
+			"Body": template.HTML(body),
+		})
+		if err != nil {
+			return err
+		}
+
+		index = append(index, exportIndexEntry{filename, e.Title})
+	}
+
+	f, err := createExportFile(zw, "index.html", "", now)
+	if err != nil {
+		return err
+	}
+
+	return exportPage.Execute(f, map[string]interface{} {
+		"Title": "Notes",
+		"Index": index,
+	})
+}
+
+// ExportFilename names a note's file in an export, without an extension.
+// The ID keeps names unique, and the title keeps them readable.
+func ExportFilename(e ExportNote) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(e.Title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+
+		if b.Len() >= 60 {
+			break
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) == 0 {
+		return fmt.Sprintf("%04d", e.ID)
+	}
+	return fmt.Sprintf("%04d-%s", e.ID, slug)
+}
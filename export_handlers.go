@@ -0,0 +1,79 @@
+package csnotes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// GetExport downloads all of the logged in user's notes as a zip archive in
+// the "format" json (the default), markdown or html. Admins may export
+// another user's notes with "user_id". The archive is streamed as it is
+// written.
+func GetExport(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Errors are answered in JSON, but the archive itself is not.
+		resp := NewJSONResponse()
+
+		format := r.FormValue("format")
+		if len(format) == 0 {
+			format = EXPORT_FORMAT_JSON
+		}
+		if !ValidExportFormat(format) {
+			resp.Fields["format"] = "Format must be json, markdown or html."
+			resp.Respond(w)
+			return
+		}
+
+		// Get the logged in user's data.
+		currentUserID, currentUserAdmin, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			resp.Respond(w)
+			return
+		}
+
+		// Only admins may export someone else's notes.
+		uID := currentUserID
+		if s := r.FormValue("user_id"); len(s) > 0 {
+			uID, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				resp.Fields["user_id"] = "Improper user ID."
+				resp.Respond(w)
+				return
+			}
+			if uID != currentUserID && !currentUserAdmin {
+				resp.StatusCode = 403
+				resp.ErrorMessage = "Access denied."
+				resp.Respond(w)
+				return
+			}
+		}
+
+		u, err := LoadUser(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "User not found."
+			resp.Respond(w)
+			return
+		}
+
+		// A large export may outlast the server's write timeout.
+		err = LiftWriteDeadline(w, r)
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		now := context.Now()
+		filename := fmt.Sprintf("notes-%s-%s.zip", ExportFilename(ExportNote{ID: u.ID, Title: u.Username}), now.Format("2006-01-02"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="` + filename + `"`)
+
+		// Once streaming has begun, an error can only cut the archive short.
+		err = WriteExport(w, format, u.ID, context.DB, now)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/negroni"
+)
+
+func TestExportFilename(t *testing.T) {
+	AssertEqual(ExportFilename(ExportNote{ID: 3, Title: "Shopping List"}), "0003-shopping-list", t)
+	AssertEqual(ExportFilename(ExportNote{ID: 12, Title: "  ../Ünïcode: notes!  "}), "0012-ünïcode-notes", t)
+	AssertEqual(ExportFilename(ExportNote{ID: 7, Title: "???"}), "0007", t)
+	AssertEqual(len(ExportFilename(ExportNote{ID: 1, Title: strings.Repeat("a", 100)})), 65, t)
+}
+
+func TestWriteMarkdownNote(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteMarkdownNote(&buf, ExportNote {
+		ID: 3,
+		Title: `Say "hi"`,
+		Type: NOTE_TYPE_TEXT,
+		Content: "# Heading\n\nBody",
+		Time: "2017-01-01 12:00:00",
+		Color: "blue",
+		Tags: []string{"work", "a: b"},
+	})
+	AssertEqual(err, nil, t)
+
+	AssertEqual(buf.String(), `---
+id: 3
+title: "Say \"hi\""
+type: text
+tags:
+  - "work"
+  - "a: b"
+time: "2017-01-01 12:00:00"
+pinned: false
+archived: false
+color: blue
+---
+
+# Heading
+
+Body
+`, t)
+
+	AssertEqual(yamlQuote("a\\b\n\x01"), `"a\\b\n\x01"`, t)
+}
+
+func TestWriteExport(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := func(format string) map[string]string {
+		var buf bytes.Buffer
+		err := WriteExport(&buf, format, ids["user.nonadmin"], db, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents := map[string]string{}
+		for _, f := range zr.File {
+			r, _ := f.Open()
+			b, _ := io.ReadAll(r)
+			r.Close()
+			contents[f.Name] = string(b)
+		}
+		return contents
+	}
+
+	// JSON exports hold every note, with its tags.
+	var notes []ExportNote
+	err = json.Unmarshal([]byte(files(EXPORT_FORMAT_JSON)["notes.json"]), &notes)
+	AssertEqual(err, nil, t)
+	AssertEqual(len(notes), 2, t)
+	if len(notes) == 2 {
+		AssertEqual(notes[0].Title, "note1", t)
+		AssertEqual(len(notes[0].Tags), 2, t)
+	}
+
+	// Markdown exports have a file per note.
+	md := files(EXPORT_FORMAT_MARKDOWN)
+	AssertEqual(len(md), 2, t)
+	AssertContains(md["notes/" + ExportFilename(ExportNote{ID: ids["note.note1"], Title: "note1"}) + ".md"], "title: \"note1\"", t)
+
+	// HTML exports add an index.
+	html := files(EXPORT_FORMAT_HTML)
+	AssertEqual(len(html), 3, t)
+	AssertContains(html["index.html"], ">note2</a>", t)
+}
+
+// TestExportDeadline ensures that exports are not cut off by the server's
+// write timeout, behind the same middleware as the app.
+func TestExportDeadline(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context, router := NewTestApp(t, db, nil)
+	n := negroni.Classic()
+	n.UseHandler(router)
+
+	// The timeout is over before the handler starts.
+	server := httptest.NewUnstartedServer(KeepServerWriter(n))
+	server.Config.WriteTimeout = time.Nanosecond
+	server.Start()
+	defer server.Close()
+
+	u, err := LoadUser(ids["user.nonadmin"], db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := context.IssueToken(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL + "/api/export", nil)
+	req.Header.Set("Authorization", "Bearer " + token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	AssertEqual(res.StatusCode, 200, t)
+
+	b, err := io.ReadAll(res.Body)
+	AssertEqual(err, nil, t)
+	_, err = zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	AssertEqual(err, nil, t)
+}
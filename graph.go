@@ -0,0 +1,281 @@
+package csnotes
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The kinds of node and edge in a note graph.
+const (
+	GRAPH_NODE_NOTE = "note"
+	GRAPH_NODE_TAG = "tag"
+
+	// A note carries a tag.
+	GRAPH_EDGE_TAG = "tag"
+
+	// A note links to another note.
+	GRAPH_EDGE_LINK = "link"
+)
+
+// GraphNode is a note or tag in a graph. Its ID is unique across both kinds,
+// such as "note-3" or "tag-2".
+type GraphNode struct {
+	ID string `json:"id"`
+	Kind string `json:"kind"`
+	RefID int64 `json:"ref_id"`
+	Label string `json:"label"`
+}
+
+// GraphEdge joins two nodes of a graph. Tag edges lead from a note to its
+// tag, and link edges from a note to the note it links to.
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Kind string `json:"kind"`
+}
+
+// Graph is how a user's notes and tags relate to each other.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+func noteNodeID(id int64) string {
+	return fmt.Sprintf("note-%d", id)
+}
+
+func tagNodeID(id int64) string {
+	return fmt.Sprintf("tag-%d", id)
+}
+
+// LoadGraph builds the graph of a user's notes and tags. Broken links are
+// left out.
+func LoadGraph(uID int64, db *sql.DB) (g Graph, err error) {
+	g = Graph {
+		Nodes: []GraphNode{},
+		Edges: []GraphEdge{},
+	}
+
+	// Add the nodes.
+	for _, q := range []struct {
+		kind string
+		query string
+		nodeID func(int64) string
+	} {
+		{GRAPH_NODE_NOTE, "SELECT id, title FROM notes WHERE user_id=? ORDER BY id", noteNodeID},
+		{GRAPH_NODE_TAG, "SELECT id, title FROM tags WHERE user_id=? ORDER BY id", tagNodeID},
+	} {
+		rows, err := db.Query(q.query, uID)
+		if err != nil {
+			return g, err
+		}
+		for rows.Next() {
+			n := GraphNode{Kind: q.kind}
+			if err = rows.Scan(&n.RefID, &n.Label); err != nil {
+				rows.Close()
+				return g, err
+			}
+			n.ID = q.nodeID(n.RefID)
+			g.Nodes = append(g.Nodes, n)
+		}
+		rows.Close()
+	}
+
+	// Add the edges. Both ends must belong to the user.
+	for _, q := range []struct {
+		kind string
+		query string
+		targetID func(int64) string
+	} {
+		{GRAPH_EDGE_TAG, `SELECT note_tag.note_id, note_tag.tag_id FROM note_tag
+			JOIN notes ON notes.id=note_tag.note_id
+			JOIN tags ON tags.id=note_tag.tag_id
+			WHERE notes.user_id=? AND tags.user_id=notes.user_id
+			ORDER BY note_tag.note_id, note_tag.tag_id`, tagNodeID},
+		{GRAPH_EDGE_LINK, `SELECT DISTINCT note_links.source_id, note_links.target_id FROM note_links
+			JOIN notes s ON s.id=note_links.source_id
+			JOIN notes t ON t.id=note_links.target_id
+			WHERE s.user_id=? AND t.user_id=s.user_id
+			ORDER BY note_links.source_id, note_links.target_id`, noteNodeID},
+	} {
+		rows, err := db.Query(q.query, uID)
+		if err != nil {
+			return g, err
+		}
+		for rows.Next() {
+			var source, target int64
+			if err = rows.Scan(&source, &target); err != nil {
+				rows.Close()
+				return g, err
+			}
+			g.Edges = append(g.Edges, GraphEdge {
+				Source: noteNodeID(source),
+				Target: q.targetID(target),
+				Kind: q.kind,
+			})
+		}
+		rows.Close()
+	}
+
+	return
+}
+
+// FilterTags keeps only the notes that carry at least one of the tags, by
+// title, along with the tags of those notes.
+func (g Graph) FilterTags(titles []string) Graph {
+	wanted := map[string]bool{}
+	for _, n := range g.Nodes {
+		for _, title := range titles {
+			if n.Kind == GRAPH_NODE_TAG && strings.EqualFold(n.Label, title) {
+				wanted[n.ID] = true
+			}
+		}
+	}
+
+	// Keep the tagged notes, then their tags.
+	keep := map[string]bool{}
+	for _, e := range g.Edges {
+		if e.Kind == GRAPH_EDGE_TAG && wanted[e.Target] {
+			keep[e.Source] = true
+		}
+	}
+	for _, e := range g.Edges {
+		if e.Kind == GRAPH_EDGE_TAG && keep[e.Source] {
+			keep[e.Target] = true
+		}
+	}
+
+	return g.subgraph(keep)
+}
+
+// Neighborhood keeps only the nodes within depth edges of a starting node,
+// following edges either way. A note's tags are one edge away, so notes
+// sharing a tag are two apart.
+func (g Graph) Neighborhood(start string, depth int) Graph {
+	// Find each node's neighbors.
+	adjacent := map[string][]string{}
+	for _, e := range g.Edges {
+		adjacent[e.Source] = append(adjacent[e.Source], e.Target)
+		adjacent[e.Target] = append(adjacent[e.Target], e.Source)
+	}
+
+	keep := map[string]bool{}
+	for _, n := range g.Nodes {
+		if n.ID == start {
+			keep[start] = true
+		}
+	}
+
+	// Search outwards, one edge at a time.
+	frontier := []string{}
+	if keep[start] {
+		frontier = append(frontier, start)
+	}
+	for d := 0; d < depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, id := range frontier {
+			for _, neighbor := range adjacent[id] {
+				if !keep[neighbor] {
+					keep[neighbor] = true
+					next = append(next, neighbor)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return g.subgraph(keep)
+}
+
+// subgraph keeps only the given nodes and the edges between them.
+func (g Graph) subgraph(keep map[string]bool) Graph {
+	sub := Graph {
+		Nodes: []GraphNode{},
+		Edges: []GraphEdge{},
+	}
+
+	for _, n := range g.Nodes {
+		if keep[n.ID] {
+			sub.Nodes = append(sub.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		if keep[e.Source] && keep[e.Target] {
+			sub.Edges = append(sub.Edges, e)
+		}
+	}
+
+	return sub
+}
+
+// WriteGraphML writes a graph as a GraphML document.
+func WriteGraphML(out io.Writer, g Graph) error {
+	w := bufio.NewWriter(out)
+
+	w.WriteString(xml.Header)
+	w.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	w.WriteString(`  <key id="label" for="node" attr.name="label" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="kind" for="node" attr.name="kind" attr.type="string"/>` + "\n")
+	w.WriteString(`  <key id="ref_id" for="node" attr.name="ref_id" attr.type="long"/>` + "\n")
+	w.WriteString(`  <key id="edge_kind" for="edge" attr.name="kind" attr.type="string"/>` + "\n")
+	w.WriteString(`  <graph id="notes" edgedefault="directed">` + "\n")
+
+	for _, n := range g.Nodes {
+		fmt.Fprintf(w, "    <node id=\"%s\">\n", xmlEscape(n.ID))
+		fmt.Fprintf(w, "      <data key=\"label\">%s</data>\n", xmlEscape(n.Label))
+		fmt.Fprintf(w, "      <data key=\"kind\">%s</data>\n", xmlEscape(n.Kind))
+		fmt.Fprintf(w, "      <data key=\"ref_id\">%d</data>\n", n.RefID)
+		w.WriteString("    </node>\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\">\n", xmlEscape(e.Source), xmlEscape(e.Target))
+		fmt.Fprintf(w, "      <data key=\"edge_kind\">%s</data>\n", xmlEscape(e.Kind))
+		w.WriteString("    </edge>\n")
+	}
+
+	w.WriteString("  </graph>\n</graphml>\n")
+	return w.Flush()
+}
+
+// xmlEscape escapes text for XML content or attributes.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// WriteDOT writes a graph in the Graphviz DOT language. Notes are drawn as
+// boxes and tags as ellipses, with dashed edges to tags.
+func WriteDOT(out io.Writer, g Graph) error {
+	w := bufio.NewWriter(out)
+
+	w.WriteString("digraph notes {\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		if n.Kind == GRAPH_NODE_TAG {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(w, "  %s [label=%s, shape=%s];\n", dotQuote(n.ID), dotQuote(n.Label), shape)
+	}
+	for _, e := range g.Edges {
+		style := "solid"
+		if e.Kind == GRAPH_EDGE_TAG {
+			style = "dashed"
+		}
+		fmt.Fprintf(w, "  %s -> %s [style=%s];\n", dotQuote(e.Source), dotQuote(e.Target), style)
+	}
+	w.WriteString("}\n")
+
+	return w.Flush()
+}
+
+// dotQuote quotes a DOT identifier. Line breaks become DOT's own "\n", so
+// that a label can't break out of its statement.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "", "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
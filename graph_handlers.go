@@ -0,0 +1,102 @@
+package csnotes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// How far from a starting note the graph reaches by default.
+	DEFAULT_GRAPH_DEPTH = 1
+
+	// The farthest the graph may reach from a starting note.
+	MAX_GRAPH_DEPTH = 10
+)
+
+// GetGraph retrieves the logged in user's notes and tags as a graph, in the
+// "format" json (the default), graphml or dot. Notes can be limited to those
+// with any of the "tag" titles, and to those within "depth" edges of the
+// "note" with an ID.
+func GetGraph(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Errors are answered in JSON, and so is the graph by default.
+		resp := NewJSONResponse()
+
+		// Retrieve and validate the options.
+		format := r.FormValue("format")
+		if format != "" && format != "json" && format != "graphml" && format != "dot" {
+			resp.Fields["format"] = "Format must be json, graphml or dot."
+		}
+
+		var start int64
+		if s := r.FormValue("note"); len(s) > 0 {
+			var err error
+			start, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				resp.Fields["note"] = "Improper note ID."
+			}
+		}
+
+		depth := DEFAULT_GRAPH_DEPTH
+		if d := r.FormValue("depth"); len(d) > 0 {
+			var err error
+			depth, err = strconv.Atoi(d)
+			if err != nil || depth < 0 || depth > MAX_GRAPH_DEPTH {
+				resp.Fields["depth"] = fmt.Sprintf("Depth must be from 0 to %d.", MAX_GRAPH_DEPTH)
+			}
+		}
+
+		if len(resp.Fields) > 0 {
+			resp.Respond(w)
+			return
+		}
+
+		// Get the logged in user's ID.
+		currentUserID, _, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			resp.Respond(w)
+			return
+		}
+
+		g, err := LoadGraph(currentUserID, context.DB)
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load graph."
+			resp.Respond(w)
+			return
+		}
+
+		// Apply the filters.
+		if tags := r.URL.Query()["tag"]; len(tags) > 0 {
+			g = g.FilterTags(tags)
+		}
+		if start != 0 {
+			g = g.Neighborhood(noteNodeID(start), depth)
+			if len(g.Nodes) == 0 {
+				resp.StatusCode = 404
+				resp.ErrorMessage = "Note not found."
+				resp.Respond(w)
+				return
+			}
+		}
+
+		switch format {
+		case "graphml":
+			w.Header().Set("Content-Type", "application/graphml+xml; charset=utf-8")
+			err = WriteGraphML(w, g)
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			err = WriteDOT(w, g)
+		default:
+			resp.Models = append(resp.Models, g)
+			resp.Respond(w)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
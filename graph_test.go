@@ -0,0 +1,95 @@
+package csnotes
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+// testGraph has three notes: 1 and 2 are tagged "work", 2 links to 3, and
+// 3 is tagged "home".
+func testGraph() Graph {
+	return Graph {
+		Nodes: []GraphNode {
+			{"note-1", GRAPH_NODE_NOTE, 1, "Plan"},
+			{"note-2", GRAPH_NODE_NOTE, 2, "Meeting \"notes\""},
+			{"note-3", GRAPH_NODE_NOTE, 3, "Groceries <weekly>"},
+			{"tag-1", GRAPH_NODE_TAG, 1, "work"},
+			{"tag-2", GRAPH_NODE_TAG, 2, "home"},
+		},
+		Edges: []GraphEdge {
+			{"note-1", "tag-1", GRAPH_EDGE_TAG},
+			{"note-2", "tag-1", GRAPH_EDGE_TAG},
+			{"note-3", "tag-2", GRAPH_EDGE_TAG},
+			{"note-2", "note-3", GRAPH_EDGE_LINK},
+		},
+	}
+}
+
+func TestGraphFilterTags(t *testing.T) {
+	g := testGraph().FilterTags([]string{"Work"})
+	AssertEqual(len(g.Nodes), 3, t)
+	AssertEqual(len(g.Edges), 2, t)
+
+	// The link to an untagged note is left out with it.
+	for _, e := range g.Edges {
+		AssertEqual(e.Kind, GRAPH_EDGE_TAG, t)
+	}
+
+	g = testGraph().FilterTags([]string{"missing"})
+	AssertEqual(len(g.Nodes), 0, t)
+}
+
+func TestGraphNeighborhood(t *testing.T) {
+	g := testGraph().Neighborhood("note-3", 1)
+	AssertEqual(len(g.Nodes), 3, t)
+	AssertEqual(len(g.Edges), 2, t)
+
+	// Notes sharing a tag are two edges apart.
+	g = testGraph().Neighborhood("note-3", 3)
+	AssertEqual(len(g.Nodes), 5, t)
+
+	g = testGraph().Neighborhood("note-1", 0)
+	AssertEqual(len(g.Nodes), 1, t)
+	AssertEqual(len(g.Edges), 0, t)
+
+	g = testGraph().Neighborhood("note-9", 2)
+	AssertEqual(len(g.Nodes), 0, t)
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	AssertEqual(WriteGraphML(&buf, testGraph()), nil, t)
+
+	out := buf.String()
+	AssertContains(out, `<node id="note-2">`, t)
+	AssertContains(out, `<data key="label">Groceries &lt;weekly&gt;</data>`, t)
+	AssertContains(out, `<edge source="note-2" target="note-3">`, t)
+
+	// The document must be well formed.
+	d := xml.NewDecoder(&buf)
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	AssertEqual(WriteDOT(&buf, testGraph()), nil, t)
+
+	out := buf.String()
+	AssertContains(out, "digraph notes {\n", t)
+	AssertContains(out, `"note-2" [label="Meeting \"notes\"", shape=box];`, t)
+	AssertContains(out, `"tag-1" [label="work", shape=ellipse];`, t)
+	AssertContains(out, `"note-1" -> "tag-1" [style=dashed];`, t)
+	AssertContains(out, `"note-2" -> "note-3" [style=solid];`, t)
+
+	AssertEqual(dotQuote("a\nb\\"), `"a\nb\\"`, t)
+}
@@ -0,0 +1,41 @@
+package csnotes
+
+import (
+	"net/http"
+)
+
+// GetHealthz is a liveness probe. It only confirms that the process is able
+// to serve HTTP requests, so it never touches the database.
+func GetHealthz(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+	}
+}
+
+// GetReadyz is a readiness probe. It responds with a 503 if the database
+// cannot be reached or if the keys needed to sign and verify tokens have not
+// been loaded.
+func GetReadyz(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Make sure the database connection is alive.
+		if context.DB == nil || context.DB.Ping() != nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Database unavailable."
+			return
+		}
+
+		// Make sure tokens can be signed, and verified with the key of the
+		// one they are signed with.
+		if context.SignKey == nil || context.VerifyKeys[context.SignKeyID] == nil {
+			resp.StatusCode = 503
+			resp.ErrorMessage = "Signing keys unavailable."
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package csnotes
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// readyzStatus requests /readyz with a context and returns the status code.
+func readyzStatus(context *Context) int {
+	rec := httptest.NewRecorder()
+	GetReadyz(context)(rec, httptest.NewRequest("GET", "/readyz", nil))
+	return rec.Code
+}
+
+func TestHealthz(t *testing.T) {
+	// The liveness probe never touches the database.
+	rec := httptest.NewRecorder()
+	GetHealthz(&Context {})(rec, httptest.NewRequest("GET", "/healthz", nil))
+	AssertEqual(200, rec.Code, t)
+}
+
+// TestReadyzUnavailable ensures that the readiness probe fails without a
+// database or signing keys.
+func TestReadyzUnavailable(t *testing.T) {
+	now := time.Now()
+	context := newTestAuthContext(t, &now)
+
+	// No database.
+	AssertEqual(503, readyzStatus(context), t)
+
+	// A database that can't be pinged.
+	db, err := sql.Open("mysql", "notes_app:notes_app@/notes_app_testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	context.DB = db
+	AssertEqual(503, readyzStatus(context), t)
+}
+
+func TestReadyz(t *testing.T) {
+	db := SetUpDbTest()
+	defer TearDownDbTest(db)
+
+	now := time.Now()
+	context := newTestAuthContext(t, &now)
+	context.DB = db
+	AssertEqual(200, readyzStatus(context), t)
+
+	// Without a signing key.
+	key := context.SignKey
+	context.SignKey = nil
+	AssertEqual(503, readyzStatus(context), t)
+
+	// Or any keys to verify tokens with.
+	context.SignKey = key
+	verifyKeys := context.VerifyKeys
+	context.VerifyKeys = nil
+	AssertEqual(503, readyzStatus(context), t)
+
+	// Or one for the signing key.
+	context.VerifyKeys = verifyKeys
+	context.SignKeyID = "other"
+	AssertEqual(503, readyzStatus(context), t)
+}
@@ -1,8 +1,11 @@
 package csnotes
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -10,9 +13,15 @@ import (
 // GetURLResource retrieves the ID of a resource requested via URL. Returns
 // the value of the ID, and whether it was successful.
 func GetURLID(req *http.Request, resp *JSONResponse) (int64, bool) {
+	return GetURLVarID(req, resp, "id")
+}
+
+// GetURLVarID retrieves an ID from a named variable in the URL, for routes
+// that name more than one resource.
+func GetURLVarID(req *http.Request, resp *JSONResponse, name string) (int64, bool) {
 	// Retrieve the ID.
 	vars := mux.Vars(req)
-	idStr, ok := vars["id"]
+	idStr, ok := vars[name]
 	if !ok {
 		resp.StatusCode = 404
 		resp.ErrorMessage = "No ID specified."
@@ -29,3 +38,29 @@ func GetURLID(req *http.Request, resp *JSONResponse) (int64, bool) {
 
 	return int64(id), true
 }
+
+// RandomToken generates a hex-encoded string from n cryptographically secure
+// random bytes.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// ParseIDList reads a comma-separated list of IDs, as sent by endpoints that
+// act on many resources at once.
+func ParseIDList(s string) (ids []int64, err error) {
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return
+}
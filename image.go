@@ -0,0 +1,312 @@
+package csnotes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// The largest image, in pixels, that will be decoded. This guards against
+// small files that decode to huge images.
+const MAX_IMAGE_PIXELS = 40 * 1000 * 1000
+
+// The quality thumbnails and re-encoded photos are saved at.
+const (
+	THUMBNAIL_QUALITY = 85
+	REENCODE_QUALITY = 92
+)
+
+// ThumbnailSize is one of the sizes thumbnails are made in. Thumbnails fit
+// within a square of Max pixels, and are never larger than the original.
+type ThumbnailSize struct {
+	Name string
+	Max int
+}
+
+// THUMBNAIL_SIZES lists the thumbnails made of each cover image.
+var THUMBNAIL_SIZES = []ThumbnailSize {
+	{"small", 160},
+	{"medium", 480},
+	{"large", 1080},
+}
+
+// ErrImageTooLarge is returned for images with more than MAX_IMAGE_PIXELS.
+var ErrImageTooLarge = errors.New("Image is too large.")
+
+// DecodeImage decodes a PNG, JPEG, GIF or WebP image, turned upright
+// according to its EXIF orientation. It also returns the image's format.
+func DecodeImage(data []byte) (img image.Image, format string, err error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	if config.Width * config.Height > MAX_IMAGE_PIXELS {
+		return nil, format, ErrImageTooLarge
+	}
+
+	img, format, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	if format == "jpeg" {
+		img = Orient(img, JPEGOrientation(data))
+	}
+
+	return
+}
+
+// JPEGOrientation reads the EXIF orientation of a JPEG file, from 1 to 8.
+// Files without one are reported as 1, which means upright.
+func JPEGOrientation(data []byte) int {
+	for _, seg := range jpegSegments(data) {
+		if seg.marker == 0xE1 && bytes.HasPrefix(seg.payload, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg.payload[6:])
+		}
+	}
+
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first IFD of EXIF data.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd + 2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		entry := ifd + 2 + 12 * k
+		if entry + 12 > len(tiff) {
+			return 1
+		}
+
+		// The orientation is a single SHORT.
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry + 2:]) == 3 {
+			o := int(order.Uint16(tiff[entry + 8:]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+
+	return 1
+}
+
+// jpegSegment is one marker segment from the header of a JPEG file.
+type jpegSegment struct {
+	marker byte
+
+	// The segment's bytes, including its marker, and its payload.
+	raw []byte
+	payload []byte
+
+	// The offset of the byte after the segment.
+	end int
+}
+
+// jpegSegments splits a JPEG file's header into segments, up to the start
+// of the image data. It stops early at anything malformed.
+func jpegSegments(data []byte) (segs []jpegSegment) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	i := 2
+	for i + 4 <= len(data) && data[i] == 0xFF {
+		marker := data[i + 1]
+
+		// Skip fill bytes and markers that stand alone.
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8) {
+			i += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i + 2:]))
+		if size < 2 || i + 2 + size > len(data) {
+			return
+		}
+
+		segs = append(segs, jpegSegment {
+			marker: marker,
+			raw: data[i : i + 2 + size],
+			payload: data[i + 4 : i + 2 + size],
+			end: i + 2 + size,
+		})
+		i += 2 + size
+	}
+
+	return
+}
+
+// StripJPEGMetadata removes EXIF, XMP, IPTC and comment segments from a JPEG
+// file without decoding it, so the image itself is unchanged. Color profiles
+// are kept.
+func StripJPEGMetadata(data []byte) ([]byte, error) {
+	segs := jpegSegments(data)
+
+	// The image data must follow the header.
+	if len(segs) == 0 {
+		return nil, errors.New("Malformed JPEG file.")
+	}
+	end := segs[len(segs) - 1].end
+	if end + 2 > len(data) || data[end] != 0xFF || data[end + 1] != 0xDA {
+		return nil, errors.New("Malformed JPEG file.")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	for _, seg := range segs {
+		switch seg.marker {
+		case 0xE1, 0xED, 0xFE:
+			continue
+		}
+		out = append(out, seg.raw...)
+	}
+
+	return append(out, data[end:]...), nil
+}
+
+// Orient turns an image upright, given its EXIF orientation.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	in := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(in, in.Bounds(), src, b.Min, draw.Src)
+
+	// Orientations 5 to 8 swap the width and height.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Flip horizontally.
+				dx, dy = w - 1 - x, y
+			case 3: // Turn halfway.
+				dx, dy = w - 1 - x, h - 1 - y
+			case 4: // Flip vertically.
+				dx, dy = x, h - 1 - y
+			case 5: // Flip across the main diagonal.
+				dx, dy = y, x
+			case 6: // Turn a quarter clockwise.
+				dx, dy = h - 1 - y, x
+			case 7: // Flip across the other diagonal.
+				dx, dy = h - 1 - y, w - 1 - x
+			case 8: // Turn a quarter counter-clockwise.
+				dx, dy = y, w - 1 - x
+			}
+			copy(out.Pix[out.PixOffset(dx, dy):][:4], in.Pix[in.PixOffset(x, y):][:4])
+		}
+	}
+
+	return out
+}
+
+// Thumbnail scales an image down to fit within a square of max pixels,
+// over a white background.
+func Thumbnail(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Keep the aspect ratio, and never scale up.
+	if w > max || h > max {
+		if w >= h {
+			w, h = max, h * max / w
+		} else {
+			w, h = w * max / h, max
+		}
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, b, xdraw.Over, nil)
+
+	return dst
+}
+
+// EncodeJPEG encodes an image as a JPEG file, with no metadata.
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	return buf.Bytes(), err
+}
+
+// EncodePNG encodes an image as a PNG file, with no metadata.
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// StripImageMetadata returns a copy of an image file without metadata, as
+// the given upright image. JPEG files are only re-encoded when they had to
+// be turned or cannot be stripped as they are. PNG and WebP files are saved
+// as PNG, which loses nothing. GIF files carry no metadata and are kept as
+// they are. It also returns the new file's content type.
+func StripImageMetadata(data []byte, img image.Image, format string) ([]byte, string, error) {
+	switch format {
+	case "jpeg":
+		if JPEGOrientation(data) == 1 {
+			stripped, err := StripJPEGMetadata(data)
+			if err == nil {
+				return stripped, "image/jpeg", nil
+			}
+		}
+		encoded, err := EncodeJPEG(img, REENCODE_QUALITY)
+		return encoded, "image/jpeg", err
+	case "png", "webp":
+		encoded, err := EncodePNG(img)
+		return encoded, "image/png", err
+	case "gif":
+		return data, "image/gif", nil
+	}
+
+	return nil, "", errors.New("Unsupported image format.")
+}
@@ -0,0 +1,260 @@
+package csnotes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testJPEG encodes a w by h JPEG, red on its left half and blue on its right,
+// with an EXIF orientation if one is given.
+func testJPEG(w, h, orientation int, order binary.ByteOrder, t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w / 2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	data, err := EncodeJPEG(img, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orientation == 0 {
+		return data
+	}
+
+	// Build EXIF data with a single orientation entry.
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload) + 2))
+	segment = append(segment, payload...)
+
+	// Put the segment straight after the start of the file.
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	AssertEqual(JPEGOrientation(testJPEG(4, 2, 0, nil, t)), 1, t)
+	AssertEqual(JPEGOrientation(testJPEG(4, 2, 6, binary.LittleEndian, t)), 6, t)
+	AssertEqual(JPEGOrientation(testJPEG(4, 2, 8, binary.BigEndian, t)), 8, t)
+	AssertEqual(JPEGOrientation([]byte("not a jpeg")), 1, t)
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	data := testJPEG(4, 2, 6, binary.LittleEndian, t)
+
+	stripped, err := StripJPEGMetadata(data)
+	AssertEqual(err, nil, t)
+	AssertEqual(bytes.Contains(stripped, []byte("Exif")), false, t)
+	AssertEqual(JPEGOrientation(stripped), 1, t)
+
+	// The image itself is unchanged.
+	AssertEqual(bytes.Equal(stripped, testJPEG(4, 2, 0, nil, t)), true, t)
+
+	_, err = StripJPEGMetadata([]byte("not a jpeg"))
+	AssertUnequal(err, nil, t)
+}
+
+func TestOrient(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	// A quarter turn clockwise puts the left side on top.
+	out := Orient(src, 6)
+	AssertEqual(out.Bounds(), image.Rect(0, 0, 1, 2), t)
+	AssertEqual(color.RGBAModel.Convert(out.At(0, 0)), red, t)
+	AssertEqual(color.RGBAModel.Convert(out.At(0, 1)), blue, t)
+
+	// A quarter turn counter-clockwise puts it on the bottom.
+	out = Orient(src, 8)
+	AssertEqual(color.RGBAModel.Convert(out.At(0, 0)), blue, t)
+	AssertEqual(color.RGBAModel.Convert(out.At(0, 1)), red, t)
+
+	out = Orient(src, 2)
+	AssertEqual(out.Bounds(), image.Rect(0, 0, 2, 1), t)
+	AssertEqual(color.RGBAModel.Convert(out.At(0, 0)), blue, t)
+
+	// Upright images are left alone.
+	AssertEqual(Orient(src, 1), image.Image(src), t)
+}
+
+func TestDecodeImage(t *testing.T) {
+	img, format, err := DecodeImage(testJPEG(40, 20, 6, binary.BigEndian, t))
+	AssertEqual(err, nil, t)
+	AssertEqual(format, "jpeg", t)
+	AssertEqual(img.Bounds().Dx(), 20, t)
+	AssertEqual(img.Bounds().Dy(), 40, t)
+
+	_, _, err = DecodeImage([]byte("not an image"))
+	AssertUnequal(err, nil, t)
+}
+
+func TestThumbnail(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	AssertEqual(Thumbnail(src, 160).Bounds(), image.Rect(0, 0, 160, 80), t)
+
+	src = image.NewRGBA(image.Rect(0, 0, 300, 1200))
+	AssertEqual(Thumbnail(src, 480).Bounds(), image.Rect(0, 0, 120, 480), t)
+
+	// Small images are not scaled up.
+	src = image.NewRGBA(image.Rect(0, 0, 100, 50))
+	AssertEqual(Thumbnail(src, 160).Bounds(), image.Rect(0, 0, 100, 50), t)
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	// Turned JPEG files are re-encoded upright.
+	data := testJPEG(40, 20, 6, binary.LittleEndian, t)
+	img, format, _ := DecodeImage(data)
+	stripped, contentType, err := StripImageMetadata(data, img, format)
+	AssertEqual(err, nil, t)
+	AssertEqual(contentType, "image/jpeg", t)
+	AssertEqual(JPEGOrientation(stripped), 1, t)
+	config, _, _ := image.DecodeConfig(bytes.NewReader(stripped))
+	AssertEqual(config.Width, 20, t)
+
+	// PNG files are re-encoded without their extra chunks.
+	encoded, _ := EncodePNG(image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	img, format, _ = DecodeImage(encoded)
+	_, contentType, err = StripImageMetadata(encoded, img, format)
+	AssertEqual(err, nil, t)
+	AssertEqual(contentType, "image/png", t)
+}
+
+func TestThumbnailerProcess(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &LocalBlobStore{Dir: t.TempDir()}
+	n, err := LoadNote(ids["note.note1"], db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := n.Attach(store, "whiteboard.jpg", bytes.NewReader(testJPEG(400, 200, 6, binary.LittleEndian, t)), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = n.SetCover(a, store)
+	AssertEqual(err, nil, t)
+
+	thumbnailer := NewThumbnailer(db, store, 1)
+	AssertEqual(thumbnailer.Process(a.ID), nil, t)
+
+	// The cover is ready, upright.
+	AssertEqual(n.LoadCover(), nil, t)
+	AssertEqual(n.Cover.Status, COVER_STATUS_READY, t)
+	AssertEqual(n.Cover.Width, 200, t)
+	AssertEqual(n.Cover.Height, 400, t)
+	AssertEqual(len(n.Cover.Thumbnails), len(THUMBNAIL_SIZES), t)
+
+	// The original no longer has its metadata.
+	a, _ = LoadAttachment(a.ID, db)
+	blob, err := store.Get(a.StorageKey)
+	AssertEqual(err, nil, t)
+	original, _ := io.ReadAll(blob)
+	blob.Close()
+	AssertEqual(JPEGOrientation(original), 1, t)
+	AssertEqual(a.Size, int64(len(original)), t)
+
+	// Each thumbnail exists and fits its size.
+	for _, size := range THUMBNAIL_SIZES {
+		blob, err := store.Get(ThumbnailKey(a, size.Name))
+		AssertEqual(err, nil, t)
+		if err != nil {
+			continue
+		}
+		config, _, err := image.DecodeConfig(blob)
+		blob.Close()
+		AssertEqual(err, nil, t)
+		AssertEqual(config.Height <= size.Max, true, t)
+	}
+
+	// Removing the cover removes its thumbnails.
+	AssertEqual(n.RemoveCover(store), nil, t)
+	_, err = store.Get(ThumbnailKey(a, "small"))
+	AssertEqual(err, ErrBlobNotFound, t)
+	AssertEqual(n.LoadCover(), nil, t)
+	AssertEqual(n.Cover == nil, true, t)
+}
+
+// TestCoverOriginalHidden ensures that a cover image cannot be downloaded
+// with its metadata before it has been processed.
+func TestCoverOriginalHidden(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	context := newTestAuthContext(t, &now)
+	context.DB = db
+	context.Blobs = &LocalBlobStore{Dir: t.TempDir()}
+	router := CreateRouter(context)
+	token, err := context.IssueToken(User{Resource: Resource{ID: ids["user.nonadmin"]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := LoadNote(ids["note.note1"], db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := n.Attach(context.Blobs, "whiteboard.jpg", bytes.NewReader(testJPEG(40, 20, 6, binary.LittleEndian, t)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = n.SetCover(a, context.Blobs)
+	AssertEqual(err, nil, t)
+
+	download := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/note/%d/attachment/%d", n.ID, a.ID), nil)
+		req.Header.Set("Authorization", "Bearer " + token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The original still has its metadata, so it is refused.
+	AssertEqual(download().Code, 409, t)
+
+	// Once processed, it is served without it.
+	AssertEqual(NewThumbnailer(db, context.Blobs, 1).Process(a.ID), nil, t)
+	rec := download()
+	AssertEqual(rec.Code, 200, t)
+	AssertEqual(JPEGOrientation(rec.Body.Bytes()), 1, t)
+}
@@ -0,0 +1,440 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The most an uploaded import archive may hold, in bytes. An upload is read
+// and imported within the server's 15 second timeouts, so larger archives are
+// imported from the command line instead, which has no limit.
+const MAX_IMPORT_SIZE = 16 << 20
+
+// The most a single note's file in an import may hold, in bytes.
+const MAX_IMPORT_NOTE_SIZE = 4 << 20
+
+// The longest a note's title may be, in characters.
+const MAX_NOTE_TITLE_LENGTH = 191
+
+// What became of each item of an import.
+const (
+	IMPORT_STATUS_IMPORTED = "imported"
+	IMPORT_STATUS_SKIPPED = "skipped"
+	IMPORT_STATUS_FAILED = "failed"
+)
+
+// The apps notes can be imported from.
+const (
+	IMPORT_SOURCE_KEEP = "keep"
+	IMPORT_SOURCE_ENEX = "enex"
+	IMPORT_SOURCE_MARKDOWN = "markdown"
+)
+
+// ErrAlreadyImported is returned when a note has been imported before.
+var ErrAlreadyImported = errors.New("Note was already imported.")
+
+// ErrNotZip is returned for an import that should be a zip archive, but
+// isn't.
+var ErrNotZip = errors.New("File must be a zip archive.")
+
+// ValidImportSource reports whether notes can be imported from a source.
+func ValidImportSource(source string) bool {
+	return source == IMPORT_SOURCE_KEEP || source == IMPORT_SOURCE_ENEX || source == IMPORT_SOURCE_MARKDOWN
+}
+
+// ImportFile imports the notes of a file from a source: a Takeout archive
+// for Keep, an ENEX file for Evernote, or a zip archive of Markdown files.
+// An error is returned only if the file can't be read as one of these at
+// all. What became of each note is in the report.
+func ImportFile(source string, r io.ReaderAt, size int64, im *Importer) (ImportReport, error) {
+	if source == IMPORT_SOURCE_ENEX {
+		return ImportENEX(io.NewSectionReader(r, 0, size), im)
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return NewImportReport(), ErrNotZip
+	}
+
+	switch source {
+	case IMPORT_SOURCE_KEEP:
+		return ImportKeep(zr, im), nil
+	case IMPORT_SOURCE_MARKDOWN:
+		return ImportMarkdown(zr, im), nil
+	}
+
+	return NewImportReport(), fmt.Errorf("Unknown import source %q.", source)
+}
+
+// ImportedNote is a note read from another app, before it is saved.
+type ImportedNote struct {
+	// Where the note came from, and a key that is the same each time the
+	// same note is read from there. Together they keep a note from being
+	// imported twice.
+	Source string
+	SourceKey string
+
+	Title string
+	Content string
+
+	// A note with items is saved as a checklist, and its content is ignored.
+	Items []ImportedItem
+
+	Tags []string
+	Pinned bool
+	Archived bool
+	Color string
+
+	// When the note was created and last changed, if known.
+	Created time.Time
+	Updated time.Time
+
+	Attachments []ImportedAttachment
+}
+
+// ImportedItem is an item of an imported checklist.
+type ImportedItem struct {
+	Text string
+	Checked bool
+}
+
+// ImportedAttachment is a file attached to an imported note. It is only
+// opened when the note is saved.
+type ImportedAttachment struct {
+	Filename string
+	Open func() (io.ReadCloser, error)
+}
+
+// ImportItem reports what became of one file of an import. Errors holds why
+// an item failed or was skipped, or what could not be kept of an imported
+// note, such as an attachment of an unsupported type.
+type ImportItem struct {
+	File string `json:"file"`
+	Status string `json:"status"`
+	NoteID int64 `json:"note_id,omitempty"`
+	Title string `json:"title,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+// ImportReport sums up an import, with an item for each file read.
+type ImportReport struct {
+	Imported int `json:"imported"`
+	Skipped int `json:"skipped"`
+	Failed int `json:"failed"`
+	Items []ImportItem `json:"items"`
+}
+
+// NewImportReport creates an empty report.
+func NewImportReport() ImportReport {
+	return ImportReport {
+		Items: []ImportItem{},
+	}
+}
+
+// Add records an item, counting it by its status.
+func (r *ImportReport) Add(item ImportItem) {
+	if item.Errors == nil {
+		item.Errors = []string{}
+	}
+
+	switch item.Status {
+	case IMPORT_STATUS_IMPORTED:
+		r.Imported++
+	case IMPORT_STATUS_SKIPPED:
+		r.Skipped++
+	case IMPORT_STATUS_FAILED:
+		r.Failed++
+	}
+
+	r.Items = append(r.Items, item)
+}
+
+// Importer saves imported notes to a user's account. Tags are shared between
+// the notes of an import and the user's existing tags, matched by title.
+type Importer struct {
+	DB *sql.DB
+
+	// Where attachments are stored. If nil, attachments are left out.
+	Blobs BlobStore
+
+	UserID int64
+	Now time.Time
+
+	// The IDs of the tags found or created so far, by lowercased title.
+	tags map[string]int64
+}
+
+// NewImporter creates an importer for a user's notes.
+func NewImporter(db *sql.DB, blobs BlobStore, uID int64, now time.Time) *Importer {
+	return &Importer {
+		DB: db,
+		Blobs: blobs,
+		UserID: uID,
+		Now: now,
+		tags: map[string]int64{},
+	}
+}
+
+// Save stores an imported note with its items, tags and attachments. The
+// note, its items, tags and links are saved in one transaction, so a note
+// that cannot be saved leaves nothing behind and an error is returned.
+// Attachments are stored once the note is, and any that cannot be kept are
+// described by the returned warnings instead. A note that was imported
+// before, and still exists, is not saved again; ErrAlreadyImported is
+// returned with its ID.
+func (im *Importer) Save(in ImportedNote) (n Note, warnings []string, err error) {
+	warnings = []string{}
+
+	n = NewNote(im.DB)
+
+	if len(in.SourceKey) > 0 {
+		err = im.DB.QueryRow(`SELECT note_imports.note_id FROM note_imports
+			JOIN notes ON notes.id=note_imports.note_id
+			WHERE note_imports.user_id=? AND note_imports.source=? AND note_imports.source_key=?`,
+			im.UserID, in.Source, in.SourceKey).Scan(&n.ID)
+		if err == nil {
+			return n, warnings, ErrAlreadyImported
+		}
+		if err != sql.ErrNoRows {
+			return
+		}
+	}
+
+	n.Title = ImportTitle(in)
+	n.UserID = im.UserID
+	n.SetPinned(in.Pinned)
+	n.SetArchived(in.Archived)
+	if ValidNoteColor(in.Color) {
+		n.Color = in.Color
+	}
+	if len(in.Items) > 0 {
+		n.Type = NOTE_TYPE_CHECKLIST
+	} else {
+		n.Content = sql.NullString{String: in.Content, Valid: true}
+	}
+
+	// Keep the note's own times, if it has them.
+	created, updated := in.Created, in.Updated
+	if created.IsZero() {
+		created = updated
+	}
+	if created.IsZero() {
+		created = im.Now
+	}
+	if updated.IsZero() || updated.Before(created) {
+		updated = created
+	}
+	n.Created = sql.NullString{String: created.UTC().Format(DATETIME_FORMAT), Valid: true}
+	n.Updated = sql.NullString{String: updated.UTC().Format(DATETIME_FORMAT), Valid: true}
+
+	tx, err := im.DB.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO notes (title, type, content, time, pinned, archived, color, user_id, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		n.Title, n.Type, n.Content, n.Time, n.Pinned, n.Archived, n.Color, n.UserID, n.Created, n.Updated)
+	if err != nil {
+		return
+	}
+	n.ID, err = res.LastInsertId()
+	if err != nil {
+		return
+	}
+
+	for i, item := range in.Items {
+		_, err = tx.Exec("INSERT INTO checklist_items (note_id, text, checked, position) VALUES (?, ?, ?, ?)",
+			n.ID, cleanItemText(item.Text), item.Checked, i)
+		if err != nil {
+			return
+		}
+	}
+
+	// Tags created for the note are only shared with later notes once it is
+	// saved.
+	tags := map[string]int64{}
+	for _, title := range in.Tags {
+		var tID int64
+		tID, err = im.tag(tx, title, tags)
+		if err != nil {
+			return
+		}
+		if tID == 0 {
+			continue
+		}
+
+		// A note may list the same tag twice.
+		_, err = tx.Exec("INSERT IGNORE INTO note_tag (note_id, tag_id) VALUES (?, ?)", n.ID, tID)
+		if err != nil {
+			return
+		}
+	}
+
+	err = n.syncLinks(tx)
+	if err != nil {
+		return
+	}
+
+	// Remember the note, replacing any record of a deleted one.
+	if len(in.SourceKey) > 0 {
+		_, err = tx.Exec(`INSERT INTO note_imports (user_id, source, source_key, note_id) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE note_id=VALUES(note_id)`, im.UserID, in.Source, in.SourceKey, n.ID)
+		if err != nil {
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	for key, id := range tags {
+		im.tags[key] = id
+	}
+
+	for _, at := range in.Attachments {
+		if w := im.attach(&n, at); len(w) > 0 {
+			warnings = append(warnings, w)
+		}
+	}
+
+	return
+}
+
+// tag finds the user's tag with a title, creating it if there is none. A
+// blank title gives an ID of 0. Tags found or created in the transaction are
+// added to created, by lowercased title.
+func (im *Importer) tag(tx *sql.Tx, title string, created map[string]int64) (id int64, err error) {
+	title = strings.Join(strings.Fields(title), " ")
+	title = truncateRunes(title, MAX_NOTE_TITLE_LENGTH)
+	if len(title) == 0 {
+		return 0, nil
+	}
+
+	key := strings.ToLower(title)
+	if id, ok := im.tags[key]; ok {
+		return id, nil
+	}
+	if id, ok := created[key]; ok {
+		return id, nil
+	}
+
+	err = tx.QueryRow("SELECT id FROM tags WHERE user_id=? AND LOWER(title)=? ORDER BY id LIMIT 1", im.UserID, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		var res sql.Result
+		res, err = tx.Exec("INSERT INTO tags (title, user_id) VALUES (?, ?)", title, im.UserID)
+		if err == nil {
+			id, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	created[key] = id
+	return id, nil
+}
+
+// attach stores one of a note's attachments, describing why if it can't be.
+func (im *Importer) attach(n *Note, at ImportedAttachment) string {
+	if im.Blobs == nil {
+		return fmt.Sprintf("%s: attachments are disabled.", at.Filename)
+	}
+
+	r, err := at.Open()
+	if err != nil {
+		return fmt.Sprintf("%s: %v", at.Filename, err)
+	}
+	defer r.Close()
+
+	_, err = n.Attach(im.Blobs, at.Filename, r, im.Now)
+	switch err {
+	case nil:
+		return ""
+	case ErrAttachmentTooLarge:
+		return fmt.Sprintf("%s: files may be at most %d bytes.", at.Filename, MAX_ATTACHMENT_SIZE)
+	case ErrAttachmentType:
+		return fmt.Sprintf("%s: files must be one of: %s.", at.Filename, strings.Join(ATTACHMENT_CONTENT_TYPES, ", "))
+	default:
+		return fmt.Sprintf("%s: could not store attachment.", at.Filename)
+	}
+}
+
+// ImportKey hashes the parts of a source note that identify it into a key
+// for ImportedNote.SourceKey.
+func ImportKey(parts ...string) string {
+	hash := sha256.New()
+	for _, p := range parts {
+		// Prefix each part with its length, so parts can't run together.
+		fmt.Fprintf(hash, "%d:", len(p))
+		io.WriteString(hash, p)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ImportTitle gives an imported note a title. Notes without one are named
+// after the start of their first line, since every note needs a title.
+func ImportTitle(in ImportedNote) string {
+	title := strings.Join(strings.Fields(in.Title), " ")
+
+	if len(title) == 0 {
+		first := in.Content
+		if len(in.Items) > 0 {
+			first = in.Items[0].Text
+		}
+		for _, line := range strings.Split(first, "\n") {
+			line = strings.Join(strings.Fields(line), " ")
+			if len(line) > 0 {
+				title = truncateRunes(line, 60)
+				break
+			}
+		}
+	}
+
+	if len(title) == 0 {
+		return "Untitled"
+	}
+	return truncateRunes(title, MAX_NOTE_TITLE_LENGTH)
+}
+
+// readImportFile reads a note's file from an import archive.
+func readImportFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > MAX_IMPORT_NOTE_SIZE {
+		return nil, fmt.Errorf("File is larger than %d bytes.", MAX_IMPORT_NOTE_SIZE)
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return nil, errors.New("Could not read file.")
+	}
+	defer r.Close()
+
+	// The size in the header can't be trusted.
+	data, err := io.ReadAll(io.LimitReader(r, MAX_IMPORT_NOTE_SIZE + 1))
+	if err != nil {
+		return nil, errors.New("Could not read file.")
+	}
+	if len(data) > MAX_IMPORT_NOTE_SIZE {
+		return nil, fmt.Errorf("File is larger than %d bytes.", MAX_IMPORT_NOTE_SIZE)
+	}
+
+	return data, nil
+}
+
+// truncateRunes cuts a string to at most n characters.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,127 @@
+package csnotes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// PostImportKeep imports the logged in user's notes from a Google Takeout
+// archive of Keep, uploaded as the multipart "file". The response's model is
+// an import report, with what became of each note.
+func PostImportKeep(context *Context) http.HandlerFunc {
+	return postImport(context, IMPORT_SOURCE_KEEP)
+}
+
+// PostImportENEX imports the logged in user's notes from an Evernote ENEX
+// export, uploaded as the multipart "file". The response's model is an
+// import report.
+func PostImportENEX(context *Context) http.HandlerFunc {
+	return postImport(context, IMPORT_SOURCE_ENEX)
+}
+
+// PostImportMarkdown imports the logged in user's notes from a zip archive
+// of Markdown files, uploaded as the multipart "file". The response's model
+// is an import report.
+func PostImportMarkdown(context *Context) http.HandlerFunc {
+	return postImport(context, IMPORT_SOURCE_MARKDOWN)
+}
+
+// postImport imports an uploaded file from a source. Notes imported before
+// are skipped, so an upload may be retried.
+func postImport(context *Context, source string) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Get the logged in user's data.
+		currentUserID, _, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+
+		tmp, ok := readImportUpload(w, r, &resp)
+		if !ok {
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		info, err := tmp.Stat()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not read upload."
+			return
+		}
+
+		im := NewImporter(context.DB, context.Blobs, currentUserID, context.Now())
+		report, err := ImportFile(source, tmp, info.Size(), im)
+		if err != nil {
+			resp.Fields["file"] = err.Error()
+			return
+		}
+
+		resp.Models = append(resp.Models, report)
+	}
+}
+
+// readImportUpload spools the multipart "file" of an import to a temporary
+// file, which the caller must close and remove.
+func readImportUpload(w http.ResponseWriter, r *http.Request, resp *JSONResponse) (tmp *os.File, ok bool) {
+	// Leave room for the multipart framing around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_IMPORT_SIZE + 1 << 20)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		resp.Fields["file"] = "File must be uploaded as multipart/form-data."
+		return nil, false
+	}
+
+	// Find the file's part, skipping any others.
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			resp.Fields["file"] = "File must be specified."
+			return nil, false
+		}
+		if err != nil {
+			resp.Fields["file"] = "Could not read upload."
+			return nil, false
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		tmp, err = os.CreateTemp("", "import-*")
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not store upload."
+			return nil, false
+		}
+
+		// Copy one byte more than allowed, to tell whether the file is too
+		// big.
+		size, err := io.Copy(tmp, io.LimitReader(part, MAX_IMPORT_SIZE + 1))
+		var tooLarge *http.MaxBytesError
+		if size > MAX_IMPORT_SIZE || errors.As(err, &tooLarge) {
+			resp.StatusCode = 413
+			resp.ErrorMessage = fmt.Sprintf("Imports may be at most %d bytes.", MAX_IMPORT_SIZE)
+		} else if err != nil {
+			resp.Fields["file"] = "Could not read upload."
+		}
+		if resp.StatusCode != 200 || len(resp.Fields) > 0 {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, false
+		}
+
+		return tmp, true
+	}
+}
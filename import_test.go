@@ -0,0 +1,154 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportTitle(t *testing.T) {
+	AssertEqual(ImportTitle(ImportedNote{Title: "  Shopping \n list "}), "Shopping list", t)
+	AssertEqual(ImportTitle(ImportedNote{Content: "\n\n  First line  \nSecond"}), "First line", t)
+	AssertEqual(ImportTitle(ImportedNote{Content: "ignored", Items: []ImportedItem{{"Milk", false}}}), "Milk", t)
+	AssertEqual(ImportTitle(ImportedNote{}), "Untitled", t)
+	AssertEqual(len([]rune(ImportTitle(ImportedNote{Content: strings.Repeat("é", 100)}))), 60, t)
+}
+
+func TestParseKeepNote(t *testing.T) {
+	k, err := ParseKeepNote([]byte(`{
+		"color": "CERULEAN",
+		"isTrashed": false,
+		"isPinned": true,
+		"isArchived": false,
+		"listContent": [
+			{"text": "Milk", "isChecked": true},
+			{"text": "Eggs", "isChecked": false}
+		],
+		"title": "Groceries",
+		"userEditedTimestampUsec": 1500000060000000,
+		"createdTimestampUsec": 1500000000000000,
+		"labels": [{"name": "home"}],
+		"attachments": [{"filePath": "photo.jpeg", "mimetype": "image/jpeg"}]
+	}`))
+	AssertEqual(err, nil, t)
+
+	in := k.ImportedNote()
+	AssertEqual(in.Title, "Groceries", t)
+	AssertEqual(in.Color, "darkblue", t)
+	AssertEqual(in.Pinned, true, t)
+	AssertEqual(len(in.Items), 2, t)
+	if len(in.Items) == 2 {
+		AssertEqual(in.Items[0].Text, "Milk", t)
+		AssertEqual(in.Items[0].Checked, true, t)
+	}
+	AssertEqual(len(in.Tags), 1, t)
+	AssertEqual(in.Created, time.Unix(1500000000, 0).UTC(), t)
+	AssertEqual(in.Updated, time.Unix(1500000060, 0).UTC(), t)
+
+	// Unknown colors are left for the default.
+	k, err = ParseKeepNote([]byte(`{"title": "", "textContent": "Hi", "color": "MAUVE"}`))
+	AssertEqual(err, nil, t)
+	AssertEqual(k.ImportedNote().Color, "", t)
+
+	// Other JSON files in an archive are not notes.
+	_, err = ParseKeepNote([]byte(`{"name": "Labels"}`))
+	AssertEqual(err, ErrNotKeepNote, t)
+	_, err = ParseKeepNote([]byte(`{"title": `))
+	AssertUnequal(err, nil, t)
+}
+
+func TestFindKeepAttachment(t *testing.T) {
+	files := map[string]*zip.File{}
+	for _, name := range []string{"Takeout/Keep/note.json", "Takeout/Keep/photo.jpg", "Takeout/Other/audio.3gp"} {
+		files[name] = &zip.File{FileHeader: zip.FileHeader{Name: name}}
+	}
+
+	f := findKeepAttachment(files, "Takeout/Keep", "photo.jpg")
+	AssertEqual(f.Name, "Takeout/Keep/photo.jpg", t)
+
+	// The extension may differ from the one the note gives.
+	f = findKeepAttachment(files, "Takeout/Keep", "photo.jpeg")
+	AssertEqual(f.Name, "Takeout/Keep/photo.jpg", t)
+
+	// Files are only looked for next to the note.
+	AssertEqual(findKeepAttachment(files, "Takeout/Keep", "audio.3gp") == nil, true, t)
+	AssertEqual(findKeepAttachment(files, "Takeout/Keep", "note.png") == nil, true, t)
+}
+
+func TestImportKeep(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string {
+		"Takeout/Keep/a.json": `{"title": "Plan", "textContent": "See [[note2]]", "labels": [{"name": "TAG1"}, {"name": "new"}], "createdTimestampUsec": 1500000000000000}`,
+		"Takeout/Keep/b.json": `{"title": "List", "listContent": [{"text": "Milk", "isChecked": true}], "labels": [{"name": "new"}], "attachments": [{"filePath": "missing.png"}]}`,
+		"Takeout/Keep/c.json": `{"title": "Old", "textContent": "", "isTrashed": true}`,
+		"Takeout/Keep/d.json": `not json`,
+		"Takeout/Keep/Labels.txt": "new\n",
+	} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	zw.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := ImportKeep(zr, NewImporter(db, nil, ids["user.nonadmin"], time.Now()))
+	AssertEqual(report.Imported, 2, t)
+	AssertEqual(report.Skipped, 1, t)
+	AssertEqual(report.Failed, 1, t)
+	if len(report.Items) != 4 {
+		t.Fatal(report.Items)
+	}
+
+	// Labels reuse the user's tags, whatever their case.
+	plan, _ := LoadNote(report.Items[0].NoteID, db)
+	AssertEqual(plan.Created.String, "2017-07-14 02:40:00", t)
+	ts, _ := plan.Tags()
+	AssertEqual(len(ts), 2, t)
+	if len(ts) == 2 {
+		AssertEqual(ts[0].ID, ids["tag.tag1"], t)
+	}
+	ls, _ := plan.Links()
+	AssertEqual(len(ls), 1, t)
+
+	list, _ := LoadNote(report.Items[1].NoteID, db)
+	AssertEqual(list.Type, NOTE_TYPE_CHECKLIST, t)
+	items, _ := list.Items()
+	AssertEqual(len(items), 1, t)
+	ts2, _ := list.Tags()
+	if len(ts) == 2 && len(ts2) == 1 {
+		AssertEqual(ts2[0].ID, ts[1].ID, t)
+	}
+	AssertEqual(len(report.Items[1].Errors), 1, t)
+
+	AssertEqual(report.Items[2].Status, IMPORT_STATUS_SKIPPED, t)
+	AssertEqual(report.Items[3].Status, IMPORT_STATUS_FAILED, t)
+
+	// A note that fails partway through leaves nothing behind.
+	buf.Reset()
+	zw = zip.NewWriter(&buf)
+	f, _ := zw.Create("Takeout/Keep/e.json")
+	f.Write([]byte(`{"title": "Trip", "textContent": "Pack", "labels": [{"name": "travel"}]}`))
+	zw.Close()
+	zr, _ = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	db.Exec("DROP TABLE note_tag")
+	report = ImportKeep(zr, NewImporter(db, nil, ids["user.nonadmin"], time.Now()))
+	AssertEqual(report.Failed, 1, t)
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM notes WHERE title='Trip'").Scan(&count)
+	AssertEqual(count, 0, t)
+	db.QueryRow("SELECT COUNT(*) FROM tags WHERE title='travel'").Scan(&count)
+	AssertEqual(count, 0, t)
+}
@@ -0,0 +1,233 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KEEP_COLORS maps Google Keep's note colors to ours.
+var KEEP_COLORS = map[string]string {
+	"DEFAULT": DEFAULT_NOTE_COLOR,
+	"RED": "red",
+	"ORANGE": "orange",
+	"YELLOW": "yellow",
+	"GREEN": "green",
+	"TEAL": "teal",
+	"BLUE": "blue",
+	"CERULEAN": "darkblue",
+	"PURPLE": "purple",
+	"PINK": "pink",
+	"BROWN": "brown",
+	"GRAY": "gray",
+}
+
+// ErrNotKeepNote is returned for a JSON file that is not a Keep note.
+var ErrNotKeepNote = errors.New("Not a Keep note.")
+
+// KeepNote is a note as Google Takeout exports it from Keep, one JSON file
+// per note.
+type KeepNote struct {
+	Title *string `json:"title"`
+	TextContent *string `json:"textContent"`
+	ListContent []struct {
+		Text string `json:"text"`
+		IsChecked bool `json:"isChecked"`
+	} `json:"listContent"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Attachments []struct {
+		FilePath string `json:"filePath"`
+		MimeType string `json:"mimetype"`
+	} `json:"attachments"`
+	Color string `json:"color"`
+	IsPinned bool `json:"isPinned"`
+	IsArchived bool `json:"isArchived"`
+	IsTrashed bool `json:"isTrashed"`
+	CreatedTimestampUsec int64 `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64 `json:"userEditedTimestampUsec"`
+}
+
+// ParseKeepNote reads a Keep note's JSON. Files that are not Keep notes give
+// ErrNotKeepNote.
+func ParseKeepNote(data []byte) (k KeepNote, err error) {
+	err = json.Unmarshal(data, &k)
+	if err != nil {
+		return
+	}
+
+	// Every note has a title and either text or a list, even if empty.
+	if k.Title == nil || (k.TextContent == nil && k.ListContent == nil) {
+		return k, ErrNotKeepNote
+	}
+
+	return
+}
+
+// ImportedNote converts a Keep note to be imported. Its attachments are left
+// out, since they are found next to the note's file.
+func (k KeepNote) ImportedNote() ImportedNote {
+	in := ImportedNote {
+		Pinned: k.IsPinned,
+		Archived: k.IsArchived,
+		Color: KEEP_COLORS[k.Color],
+		Tags: []string{},
+	}
+
+	if k.Title != nil {
+		in.Title = *k.Title
+	}
+	if k.TextContent != nil {
+		in.Content = *k.TextContent
+	}
+	for _, i := range k.ListContent {
+		in.Items = append(in.Items, ImportedItem{i.Text, i.IsChecked})
+	}
+	for _, l := range k.Labels {
+		in.Tags = append(in.Tags, l.Name)
+	}
+
+	if k.CreatedTimestampUsec > 0 {
+		in.Created = time.UnixMicro(k.CreatedTimestampUsec).UTC()
+	}
+	if k.UserEditedTimestampUsec > 0 {
+		in.Updated = time.UnixMicro(k.UserEditedTimestampUsec).UTC()
+	}
+
+	return in
+}
+
+// ImportKeep imports every note of a Google Takeout archive of Keep. Each
+// note's JSON file is read, along with the files attached to it. Trashed
+// notes are skipped.
+func ImportKeep(zr *zip.Reader, im *Importer) ImportReport {
+	report := NewImportReport()
+
+	files := map[string]*zip.File{}
+	var names []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files[f.Name] = f
+		if strings.EqualFold(path.Ext(f.Name), ".json") {
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		item := ImportItem {
+			File: name,
+		}
+
+		k, data, err := readKeepNote(files[name])
+		if err == ErrNotKeepNote {
+			item.Status = IMPORT_STATUS_SKIPPED
+			item.Errors = []string{err.Error()}
+			report.Add(item)
+			continue
+		}
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = []string{err.Error()}
+			report.Add(item)
+			continue
+		}
+
+		in := k.ImportedNote()
+		in.Source = IMPORT_SOURCE_KEEP
+		in.SourceKey = ImportKey(name, string(data))
+		item.Title = ImportTitle(in)
+		if k.IsTrashed {
+			item.Status = IMPORT_STATUS_SKIPPED
+			item.Errors = []string{"Note is in the trash."}
+			report.Add(item)
+			continue
+		}
+
+		// Attachments sit next to the note's file.
+		dir := path.Dir(name)
+		for _, a := range k.Attachments {
+			f := findKeepAttachment(files, dir, a.FilePath)
+			if f == nil {
+				item.Errors = append(item.Errors, fmt.Sprintf("%s: file not found in archive.", a.FilePath))
+				continue
+			}
+			in.Attachments = append(in.Attachments, ImportedAttachment {
+				Filename: path.Base(f.Name),
+				Open: func() (io.ReadCloser, error) {
+					return f.Open()
+				},
+			})
+		}
+
+		n, warnings, err := im.Save(in)
+		if err == ErrAlreadyImported {
+			item.Status = IMPORT_STATUS_SKIPPED
+			item.NoteID = n.ID
+			item.Errors = []string{err.Error()}
+			report.Add(item)
+			continue
+		}
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = append(item.Errors, "Could not save note.")
+			report.Add(item)
+			continue
+		}
+
+		item.Status = IMPORT_STATUS_IMPORTED
+		item.NoteID = n.ID
+		item.Errors = append(item.Errors, warnings...)
+		report.Add(item)
+	}
+
+	return report
+}
+
+// readKeepNote reads and parses a note's file from an archive.
+func readKeepNote(f *zip.File) (k KeepNote, data []byte, err error) {
+	data, err = readImportFile(f)
+	if err != nil {
+		return
+	}
+
+	k, err = ParseKeepNote(data)
+	if err != nil && err != ErrNotKeepNote {
+		return k, data, errors.New("File is not valid JSON.")
+	}
+
+	return
+}
+
+// findKeepAttachment finds an attached file in a note's directory. Takeout
+// sometimes names a file with a different extension than its note gives,
+// such as .jpg for .jpeg, so a file with the same base name will also do.
+func findKeepAttachment(files map[string]*zip.File, dir, filePath string) *zip.File {
+	filePath = path.Base(filePath)
+	if f, ok := files[path.Join(dir, filePath)]; ok {
+		return f
+	}
+
+	base := strings.TrimSuffix(filePath, path.Ext(filePath))
+	var names []string
+	for name := range files {
+		if path.Dir(name) == dir && strings.TrimSuffix(path.Base(name), path.Ext(name)) == base && !strings.EqualFold(path.Ext(name), ".json") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	sort.Strings(names)
+	return files[names[0]]
+}
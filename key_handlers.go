@@ -0,0 +1,88 @@
+package csnotes
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+)
+
+// JWK is the JSON Web Key representation of an RSA public key, as described
+// in RFC 7517.
+type JWK struct {
+	KeyType string `json:"kty"`
+	Use string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID string `json:"kid"`
+	Modulus string `json:"n"`
+	Exponent string `json:"e"`
+}
+
+// NewJWK encodes an RSA public key as a JWK with the given key ID.
+func NewJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK {
+		KeyType: "RSA",
+		Use: "sig",
+		Algorithm: "RS256",
+		KeyID: kid,
+		Modulus: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		Exponent: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// PublicKey decodes an RSA JWK back into a public key.
+func (j JWK) PublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(j.Modulus)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(j.Exponent)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > math.MaxInt32 {
+		return nil, errors.New("Key exponent too large.")
+	}
+
+	return &rsa.PublicKey {
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// GetJWKS publishes every verification key as a JSON Web Key Set, so that
+// other services can verify this app's tokens.
+func GetJWKS(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Sort the key IDs so that the output is stable.
+		kids := []string{}
+		for kid := range context.VerifyKeys {
+			kids = append(kids, kid)
+		}
+		sort.Strings(kids)
+
+		jwks := struct {
+			Keys []JWK `json:"keys"`
+		} {[]JWK{}}
+		for _, kid := range kids {
+			jwks.Keys = append(jwks.Keys, NewJWK(kid, context.VerifyKeys[kid]))
+		}
+
+		res, err := json.Marshal(jwks)
+		if err != nil {
+			http.Error(w, "Could not create key set.", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(res)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cpgillem/csnotes"
+)
+
+// The size of newly generated RSA keys, in bits.
+const KEY_BITS = 4096
+
+func main() {
+	syntax := "Syntax: keygen generate|rotate|list <dir>\n        keygen activate|remove <dir> <kid>"
+	valid := false
+
+	if len(os.Args) > 2 {
+		dir := os.Args[2]
+
+		if os.Args[1] == "generate" || os.Args[1] == "rotate" {
+			valid = true
+			fmt.Println("Generating key...")
+			id, err := csnotes.GenerateKeyPair(dir, KEY_BITS)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Created key " + id + ".")
+
+			// Generating a key only makes it active if there is nothing else
+			// to sign with. Rotating always makes it active, while keeping
+			// the old public keys so that existing tokens remain valid.
+			activeID, err := csnotes.ActiveKeyID(dir)
+			if err != nil {
+				panic(err)
+			}
+			_, statErr := os.Stat(filepath.Join(dir, activeID + csnotes.PRIVATE_KEY_EXT))
+			if os.Args[1] == "rotate" || statErr != nil {
+				err = csnotes.SetActiveKeyID(dir, id)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Println("Activated key " + id + ".")
+			}
+		}
+
+		if os.Args[1] == "list" {
+			valid = true
+			ids, err := csnotes.ListKeyIDs(dir)
+			if err != nil {
+				panic(err)
+			}
+			activeID, err := csnotes.ActiveKeyID(dir)
+			if err != nil {
+				panic(err)
+			}
+			for _, id := range ids {
+				if id == activeID {
+					fmt.Println(id + " (active)")
+				} else {
+					fmt.Println(id)
+				}
+			}
+		}
+
+		if os.Args[1] == "activate" && len(os.Args) > 3 {
+			valid = true
+			fmt.Println("Activating key...")
+			err := csnotes.SetActiveKeyID(dir, os.Args[3])
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		if os.Args[1] == "remove" && len(os.Args) > 3 {
+			valid = true
+			fmt.Println("Removing key...")
+			err := csnotes.RemoveKeyPair(dir, os.Args[3])
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		if !valid {
+			fmt.Println(syntax)
+		} else {
+			fmt.Println("Done.")
+		}
+
+	} else {
+		fmt.Println(syntax)
+	}
+
+}
@@ -0,0 +1,210 @@
+package csnotes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// The file inside a key directory that names the active signing key.
+	ACTIVE_KEY_FILE = "active"
+
+	// The ID given to the original single key pair, app.rsa and app.rsa.pub.
+	LEGACY_KEY_ID = "app"
+
+	PRIVATE_KEY_EXT = ".rsa"
+	PUBLIC_KEY_EXT = ".rsa.pub"
+)
+
+// KeySet holds every key that tokens can be verified with, along with the
+// single private key that new tokens are signed with.
+type KeySet struct {
+	// The ID of the key used for signing. This is sent in the "kid" header
+	// of every token.
+	SignKeyID string
+
+	SignKey *rsa.PrivateKey
+
+	// Public keys mapped by their key IDs.
+	VerifyKeys map[string]*rsa.PublicKey
+}
+
+// LoadKeySet reads all key pairs from a directory. Each pair is stored as
+// <kid>.rsa and <kid>.rsa.pub. The active signing key is taken from
+// activeID if it is non-empty, and otherwise from the directory's active
+// file. A directory holding only the legacy app.rsa pair needs no active
+// file.
+func LoadKeySet(dir string, activeID string) (ks KeySet, err error) {
+	ks.VerifyKeys = map[string]*rsa.PublicKey{}
+
+	ids, err := ListKeyIDs(dir)
+	if err != nil {
+		return
+	}
+
+	// Load every public key.
+	for _, id := range ids {
+		var raw []byte
+		raw, err = ioutil.ReadFile(filepath.Join(dir, id + PUBLIC_KEY_EXT))
+		if err != nil {
+			return
+		}
+
+		var key *rsa.PublicKey
+		key, err = jwt.ParseRSAPublicKeyFromPEM(raw)
+		if err != nil {
+			return
+		}
+		ks.VerifyKeys[id] = key
+	}
+
+	// Determine which key is active.
+	if len(activeID) == 0 {
+		activeID, err = ActiveKeyID(dir)
+		if err != nil {
+			return
+		}
+	}
+	if _, ok := ks.VerifyKeys[activeID]; !ok {
+		err = errors.New("Active signing key not found: " + activeID)
+		return
+	}
+
+	// Load the private half of the active key.
+	raw, err := ioutil.ReadFile(filepath.Join(dir, activeID + PRIVATE_KEY_EXT))
+	if err != nil {
+		return
+	}
+	ks.SignKey, err = jwt.ParseRSAPrivateKeyFromPEM(raw)
+	if err != nil {
+		return
+	}
+	ks.SignKeyID = activeID
+
+	return
+}
+
+// ListKeyIDs returns the IDs of all public keys in a directory, sorted.
+func ListKeyIDs(dir string) (ids []string, err error) {
+	ids = []string{}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), PUBLIC_KEY_EXT) {
+			ids = append(ids, strings.TrimSuffix(f.Name(), PUBLIC_KEY_EXT))
+		}
+	}
+	sort.Strings(ids)
+
+	return
+}
+
+// ActiveKeyID reads the ID of the active signing key from a directory. If
+// there is no active file, the legacy key ID is assumed.
+func ActiveKeyID(dir string) (string, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, ACTIVE_KEY_FILE))
+	if os.IsNotExist(err) {
+		return LEGACY_KEY_ID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// SetActiveKeyID marks a key in a directory as the one used for signing.
+func SetActiveKeyID(dir string, id string) error {
+	// Only allow keys that have a private half.
+	if _, err := os.Stat(filepath.Join(dir, id + PRIVATE_KEY_EXT)); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, ACTIVE_KEY_FILE), []byte(id + "\n"), 0600)
+}
+
+// GenerateKeyPair creates a new RSA key pair in a directory, and returns its
+// ID. The key is not made active.
+func GenerateKeyPair(dir string, bits int) (id string, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return
+	}
+
+	// Create an ID that sorts by creation date and is unlikely to collide.
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return
+	}
+	id = time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(suffix)
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return
+	}
+
+	// Write the private key.
+	privatePEM := pem.EncodeToMemory(&pem.Block {
+		Type: "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	err = ioutil.WriteFile(filepath.Join(dir, id + PRIVATE_KEY_EXT), privatePEM, 0600)
+	if err != nil {
+		return
+	}
+
+	// Write the public key.
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block {
+		Type: "PUBLIC KEY",
+		Bytes: publicDER,
+	})
+	err = ioutil.WriteFile(filepath.Join(dir, id + PUBLIC_KEY_EXT), publicPEM, 0644)
+
+	return
+}
+
+// RemoveKeyPair deletes a key pair from a directory. The active key cannot
+// be removed, since tokens would no longer be able to be signed.
+func RemoveKeyPair(dir string, id string) error {
+	activeID, err := ActiveKeyID(dir)
+	if err != nil {
+		return err
+	}
+	if id == activeID {
+		return errors.New("Cannot remove the active signing key.")
+	}
+
+	err = os.Remove(filepath.Join(dir, id + PUBLIC_KEY_EXT))
+	if err != nil {
+		return err
+	}
+
+	// The private half may already have been discarded.
+	err = os.Remove(filepath.Join(dir, id + PRIVATE_KEY_EXT))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package csnotes
+
+import (
+	"testing"
+)
+
+// TestKeyRotation ensures that rotating keys keeps old public keys available
+// for verification while signing with the new key.
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	// Create and activate the first key.
+	oldID, err := GenerateKeyPair(dir, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetActiveKeyID(dir, oldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to a second key.
+	newID, err := GenerateKeyPair(dir, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetActiveKeyID(dir, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := LoadKeySet(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertEqual(newID, ks.SignKeyID, t)
+	AssertEqual(2, len(ks.VerifyKeys), t)
+	AssertEqual(true, ks.VerifyKeys[oldID] != nil, t)
+
+	// The active key cannot be removed, but the old one can.
+	AssertUnequal(nil, RemoveKeyPair(dir, newID), t)
+	AssertEqual(nil, RemoveKeyPair(dir, oldID), t)
+
+	// A removed key can no longer be selected for signing.
+	_, err = LoadKeySet(dir, oldID)
+	AssertUnequal(nil, err, t)
+}
@@ -0,0 +1,232 @@
+package csnotes
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// The auth_source of users provisioned from LDAP.
+const LDAP_AUTH_SOURCE = "ldap"
+
+// LDAPConfig describes how to find and authenticate users in a directory.
+type LDAPConfig struct {
+	// The server, such as ldap://ldap.example.com:389 or ldaps://...
+	URL string
+
+	// Upgrade a plain connection with StartTLS before binding.
+	StartTLS bool
+
+	// A service account used to search for users. If empty, the search is
+	// done anonymously.
+	BindDN string
+	BindPassword string
+
+	// Where to search for users, and the filter that finds one by username.
+	// The filter has a single %s, which is replaced with the escaped
+	// username. Defaults to (uid=%s).
+	BaseDN string
+	UserFilter string
+
+	// The attributes holding a user's display name, email and groups.
+	// Default to cn, mail and memberOf.
+	NameAttribute string
+	EmailAttribute string
+	GroupAttribute string
+
+	// Members of this group DN are made admins. If empty, admin status is
+	// never changed by LDAP logins.
+	AdminGroupDN string
+
+	// How long to wait for the server. Defaults to ten seconds.
+	Timeout time.Duration
+}
+
+// LDAPIdentity is what the directory says about an authenticated user.
+type LDAPIdentity struct {
+	DN string
+	Name string
+	Email string
+	Groups []string
+}
+
+// LDAPAuthenticator authenticates users with a bind against a directory.
+// Users are created on their first successful login.
+type LDAPAuthenticator struct {
+	Config LDAPConfig
+}
+
+// NewLDAPAuthenticator fills in the defaults of a configuration.
+func NewLDAPAuthenticator(config LDAPConfig) *LDAPAuthenticator {
+	if len(config.UserFilter) == 0 {
+		config.UserFilter = "(uid=%s)"
+	}
+	if len(config.NameAttribute) == 0 {
+		config.NameAttribute = "cn"
+	}
+	if len(config.EmailAttribute) == 0 {
+		config.EmailAttribute = "mail"
+	}
+	if len(config.GroupAttribute) == 0 {
+		config.GroupAttribute = "memberOf"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &LDAPAuthenticator{Config: config}
+}
+
+// Lookup finds a user in the directory and verifies their password by
+// binding as them.
+func (a *LDAPAuthenticator) Lookup(username, password string) (id LDAPIdentity, err error) {
+	// An empty password would be an unauthenticated bind, which servers
+	// accept for any DN.
+	if len(password) == 0 {
+		err = errors.New("No password.")
+		return
+	}
+
+	conn, err := ldap.DialURL(a.Config.URL)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetTimeout(a.Config.Timeout)
+
+	if a.Config.StartTLS {
+		u, err := url.Parse(a.Config.URL)
+		if err != nil {
+			return id, err
+		}
+		err = conn.StartTLS(&tls.Config{ServerName: u.Hostname()})
+		if err != nil {
+			return id, err
+		}
+	}
+
+	// Bind as the service account to search for the user.
+	if len(a.Config.BindDN) > 0 {
+		err = conn.Bind(a.Config.BindDN, a.Config.BindPassword)
+		if err != nil {
+			return
+		}
+	}
+
+	req := ldap.NewSearchRequest(
+		a.Config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.Config.Timeout / time.Second), false,
+		fmt.Sprintf(a.Config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.Config.NameAttribute, a.Config.EmailAttribute, a.Config.GroupAttribute},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil {
+		return
+	}
+
+	// The filter must identify exactly one user.
+	if len(res.Entries) != 1 {
+		err = ErrUnknownUser
+		return
+	}
+	entry := res.Entries[0]
+
+	// Bind as the user to check their password.
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		return
+	}
+
+	id.DN = entry.DN
+	id.Name = entry.GetAttributeValue(a.Config.NameAttribute)
+	id.Email = entry.GetAttributeValue(a.Config.EmailAttribute)
+	id.Groups = entry.GetAttributeValues(a.Config.GroupAttribute)
+
+	return
+}
+
+// IsAdmin reports whether an identity belongs to the configured admin group.
+// DNs are compared without regard to case.
+func (a *LDAPAuthenticator) IsAdmin(id LDAPIdentity) bool {
+	for _, g := range id.Groups {
+		if strings.EqualFold(g, a.Config.AdminGroupDN) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate checks credentials against the directory and returns the
+// matching user, creating them on their first login.
+func (a *LDAPAuthenticator) Authenticate(username, password string, db *sql.DB) (u User, err error) {
+	id, err := a.Lookup(username, password)
+	if err != nil {
+		return
+	}
+
+	u = NewUser(db)
+
+	// Find the user this directory entry was provisioned as. A local
+	// account with the same username is never taken over.
+	var source string
+	err = db.QueryRow("SELECT id, auth_source FROM users WHERE username=?", username).Scan(&u.ID, &source)
+	if err == sql.ErrNoRows {
+		err = createLDAPUser(&u, username, id, db)
+		if err != nil {
+			return
+		}
+	} else if err != nil {
+		return
+	} else if source != LDAP_AUTH_SOURCE {
+		err = errors.New("Username belongs to an account that is not managed by LDAP.")
+		return
+	} else {
+		err = u.Load()
+		if err != nil {
+			return
+		}
+	}
+
+	// Keep admin status in sync with the directory's groups.
+	if len(a.Config.AdminGroupDN) > 0 && u.Admin != a.IsAdmin(id) {
+		u.Admin = a.IsAdmin(id)
+		err = u.Save()
+	}
+
+	return
+}
+
+// createLDAPUser creates a user for a directory entry. The user gets an
+// unusable random password, since the directory checks their password.
+func createLDAPUser(u *User, username string, id LDAPIdentity, db *sql.DB) error {
+	password, err := RandomToken(32)
+	if err != nil {
+		return err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	u.Username = username
+	u.Name.String = id.Name
+	u.Name.Valid = len(id.Name) > 0
+	u.Email.String = id.Email
+	u.Email.Valid = len(id.Email) > 0
+	u.EmailVerified = u.Email.Valid
+
+	res, err := db.Exec("INSERT INTO users (username, name, email, email_verified, auth_source, password, salt) VALUES (?, ?, ?, ?, ?, ?, '')",
+		u.Username, u.Name, u.Email, u.EmailVerified, LDAP_AUTH_SOURCE, hash)
+	if err != nil {
+		return err
+	}
+	u.ID, err = res.LastInsertId()
+
+	return err
+}
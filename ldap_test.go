@@ -0,0 +1,254 @@
+package csnotes
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testLDAPEntry is a user in the test directory.
+type testLDAPEntry struct {
+	dn string
+	password string
+	attributes map[string][]string
+}
+
+// testLDAPServer is an in-process LDAP server that understands just enough
+// of the protocol for simple binds and equality searches.
+type testLDAPServer struct {
+	listener net.Listener
+	entries []testLDAPEntry
+}
+
+func newTestLDAPServer(t *testing.T, entries []testLDAPEntry) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testLDAPServer{listener: listener, entries: entries}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// serve answers requests on a connection until the client unbinds or
+// disconnects.
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+
+			code := ldap.LDAPResultInvalidCredentials
+			if s.find(name, password) {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(testLDAPResult(id, ldap.ApplicationBindResponse, code).Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			for _, e := range s.entries {
+				if e.matches(filter) {
+					conn.Write(testLDAPEntryPacket(id, e).Bytes())
+				}
+			}
+			conn.Write(testLDAPResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+
+		default:
+			return
+		}
+	}
+}
+
+// find checks a DN and password against the directory, including the
+// service account.
+func (s *testLDAPServer) find(dn, password string) bool {
+	for _, e := range s.entries {
+		if strings.EqualFold(e.dn, dn) && e.password == password {
+			return true
+		}
+	}
+	return false
+}
+
+// matches supports filters of the form (attribute=value).
+func (e testLDAPEntry) matches(filter string) bool {
+	parts := strings.SplitN(strings.Trim(filter, "()"), "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	for _, v := range e.attributes[parts[0]] {
+		if v == parts[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func testLDAPResult(id int64, tag ber.Tag, code int) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	packet.AppendChild(result)
+
+	return packet
+}
+
+func testLDAPEntryPacket(id int64, e testLDAPEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	entry.AppendChild(attributes)
+	packet.AppendChild(entry)
+
+	return packet
+}
+
+// newTestDirectory starts a directory with a service account, an admin and
+// a regular user.
+func newTestDirectory(t *testing.T) (*testLDAPServer, *LDAPAuthenticator) {
+	server := newTestLDAPServer(t, []testLDAPEntry {
+		{dn: "cn=service,dc=example,dc=com", password: "service"},
+		{
+			dn: "uid=alice,ou=people,dc=example,dc=com",
+			password: "alicepass",
+			attributes: map[string][]string {
+				"uid": {"alice"},
+				"cn": {"Alice"},
+				"mail": {"alice@example.com"},
+				"memberOf": {"CN=Admins,OU=Groups,DC=example,DC=com"},
+			},
+		},
+		{
+			dn: "uid=bob,ou=people,dc=example,dc=com",
+			password: "bobpass",
+			attributes: map[string][]string {
+				"uid": {"bob"},
+				"cn": {"Bob"},
+				"memberOf": {"cn=staff,ou=groups,dc=example,dc=com"},
+			},
+		},
+	})
+
+	a := NewLDAPAuthenticator(LDAPConfig {
+		URL: server.URL(),
+		BindDN: "cn=service,dc=example,dc=com",
+		BindPassword: "service",
+		BaseDN: "ou=people,dc=example,dc=com",
+		AdminGroupDN: "cn=admins,ou=groups,dc=example,dc=com",
+	})
+
+	return server, a
+}
+
+// TestLDAPLookup ensures that users are found by username, authenticated by
+// binding as them, and mapped to admins by group.
+func TestLDAPLookup(t *testing.T) {
+	server, a := newTestDirectory(t)
+	defer server.listener.Close()
+
+	id, err := a.Lookup("alice", "alicepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual("uid=alice,ou=people,dc=example,dc=com", id.DN, t)
+	AssertEqual("Alice", id.Name, t)
+	AssertEqual("alice@example.com", id.Email, t)
+	AssertEqual(true, a.IsAdmin(id), t)
+
+	id, err = a.Lookup("bob", "bobpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(false, a.IsAdmin(id), t)
+
+	// Wrong and empty passwords are refused.
+	_, err = a.Lookup("bob", "alicepass")
+	AssertUnequal(nil, err, t)
+	_, err = a.Lookup("bob", "")
+	AssertUnequal(nil, err, t)
+
+	// Unknown users let the next authenticator try.
+	_, err = a.Lookup("carol", "carolpass")
+	AssertEqual(ErrUnknownUser, err, t)
+}
+
+// TestLDAPAuthenticate ensures that LDAP users are provisioned on their
+// first login and are not confused with local accounts.
+func TestLDAPAuthenticate(t *testing.T) {
+	db, _, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, a := newTestDirectory(t)
+	defer server.listener.Close()
+
+	// The first login creates the user.
+	u, err := ValidateUser("alice", "alicepass", db, PasswordAuthenticator{}, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUnequal(int64(0), u.ID, t)
+	AssertEqual("Alice", u.Name.String, t)
+	AssertEqual(true, u.Admin, t)
+
+	// Later logins find the same user.
+	again, err := ValidateUser("alice", "alicepass", db, PasswordAuthenticator{}, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(u.ID, again.ID, t)
+
+	// Local accounts still work.
+	_, err = ValidateUser("nonadmin", "password", db, PasswordAuthenticator{}, a)
+	AssertEqual(nil, err, t)
+}
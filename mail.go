@@ -0,0 +1,94 @@
+package csnotes
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailMessage is a plain text email.
+type MailMessage struct {
+	To string
+	Subject string
+	Body string
+}
+
+// Mailer sends email. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(m MailMessage) error
+}
+
+// BuildMessage formats a message with its headers, ready to be sent over
+// SMTP.
+func BuildMessage(from string, m MailMessage, now time.Time) []byte {
+	var b bytes.Buffer
+
+	// Header values must not contain line breaks, or they could be used to
+	// inject other headers.
+	clean := func(s string) string {
+		return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+	}
+
+	fmt.Fprintf(&b, "From: %s\r\n", clean(from))
+	fmt.Fprintf(&b, "To: %s\r\n", clean(m.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", clean(m.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+
+	return b.Bytes()
+}
+
+// SMTPMailer sends email through an SMTP server.
+type SMTPMailer struct {
+	// The server's host and port, such as smtp.example.com:587.
+	Addr string
+
+	// The sender address.
+	From string
+
+	// Credentials for the server. If nil, no authentication is done.
+	Auth smtp.Auth
+}
+
+func (s *SMTPMailer) Send(m MailMessage) error {
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{m.To}, BuildMessage(s.From, m, time.Now()))
+}
+
+// LogMailer writes email to a log instead of sending it, and keeps every
+// message it was given. It is meant for development and tests.
+type LogMailer struct {
+	// Where messages are written. If nil, they are only kept in memory.
+	Out io.Writer
+
+	mu sync.Mutex
+	messages []MailMessage
+}
+
+func (l *LogMailer) Send(m MailMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, m)
+	if l.Out != nil {
+		_, err := fmt.Fprintf(l.Out, "To: %s\nSubject: %s\n\n%s\n\n", m.To, m.Subject, m.Body)
+		return err
+	}
+
+	return nil
+}
+
+// Messages returns every message sent so far.
+func (l *LogMailer) Messages() []MailMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]MailMessage{}, l.messages...)
+}
@@ -0,0 +1,41 @@
+package csnotes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuildMessage ensures that messages have their headers, use CRLF line
+// endings, and cannot have headers injected.
+func TestBuildMessage(t *testing.T) {
+	now := time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC)
+	msg := string(BuildMessage("notes@example.com", MailMessage {
+		To: "alice@example.com\r\nBcc: eve@example.com",
+		Subject: "Hello",
+		Body: "Line one\nLine two\n",
+	}, now))
+
+	AssertContains(msg, "From: notes@example.com\r\n", t)
+	AssertContains(msg, "To: alice@example.comBcc: eve@example.com\r\n", t)
+	AssertContains(msg, "Subject: Hello\r\n", t)
+	AssertContains(msg, "Date: Wed, 01 Nov 2017 12:00:00 +0000\r\n", t)
+	AssertContains(msg, "\r\n\r\nLine one\r\nLine two\r\n", t)
+	AssertEqual(false, strings.Contains(msg, "\nBcc:"), t)
+}
+
+// TestLogMailer ensures that sent messages are kept and written out.
+func TestLogMailer(t *testing.T) {
+	var out bytes.Buffer
+	m := &LogMailer{Out: &out}
+
+	err := m.Send(MailMessage{To: "bob@example.com", Subject: "Hi", Body: "Body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertEqual(1, len(m.Messages()), t)
+	AssertEqual("bob@example.com", m.Messages()[0].To, t)
+	AssertContains(out.String(), "Subject: Hi", t)
+}
@@ -0,0 +1,189 @@
+package csnotes
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// How many notes' rendered HTML is kept by default.
+const MARKDOWN_CACHE_SIZE = 1000
+
+// markdown converts CommonMark, with tables and task lists, to HTML. Raw HTML
+// in the source is left out rather than passed through.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.TaskList),
+)
+
+// markdownPolicy is the HTML that rendered notes may contain. Anything else,
+// such as scripts, styles or javascript: links, is removed.
+var markdownPolicy = newMarkdownPolicy()
+
+func newMarkdownPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	// Allow the disabled checkboxes of task lists.
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AllowElements("input")
+
+	// Allow table cells to be aligned, and code blocks to name their
+	// language for highlighting.
+	p.AllowStyles("text-align").MatchingEnum("left", "center", "right").OnElements("th", "td")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+
+	return p
+}
+
+// RenderMarkdown converts Markdown to sanitized HTML.
+func RenderMarkdown(src string) (string, error) {
+	var buf bytes.Buffer
+	err := markdown.Convert([]byte(src), &buf)
+	if err != nil {
+		return "", err
+	}
+
+	return markdownPolicy.Sanitize(buf.String()), nil
+}
+
+// MarkdownSource gives the Markdown a note is rendered from. Checklists are
+// rendered as task lists of their items.
+func (n *Note) MarkdownSource() (string, error) {
+	if n.IsChecklist() {
+		is, err := n.Items()
+		if err != nil {
+			return "", err
+		}
+		return FormatChecklistText(is), nil
+	}
+
+	return n.Content.String, nil
+}
+
+// MarkdownCache keeps the rendered HTML of recently viewed notes. Entries are
+// checked against a hash of their source, so a stale entry is never served
+// even if it was not invalidated; invalidating frees it sooner. It is safe
+// for concurrent use.
+type MarkdownCache struct {
+	// The most notes kept. Once full, the least recently used is dropped.
+	Size int
+
+	lock sync.Mutex
+	entries map[int64]*list.Element
+	order *list.List
+}
+
+// markdownCacheEntry is the rendered HTML of one note.
+type markdownCacheEntry struct {
+	noteID int64
+	sum [sha256.Size]byte
+	html string
+}
+
+// NewMarkdownCache creates an empty cache that keeps up to size notes.
+func NewMarkdownCache(size int) *MarkdownCache {
+	return &MarkdownCache {
+		Size: size,
+		entries: map[int64]*list.Element{},
+		order: list.New(),
+	}
+}
+
+// Render gives the HTML of a note's Markdown, from the cache if it holds
+// the same source.
+func (c *MarkdownCache) Render(noteID int64, src string) (string, error) {
+	sum := sha256.Sum256([]byte(src))
+
+	c.lock.Lock()
+	if e, ok := c.entries[noteID]; ok {
+		entry := e.Value.(*markdownCacheEntry)
+		if entry.sum == sum {
+			c.order.MoveToFront(e)
+			c.lock.Unlock()
+			return entry.html, nil
+		}
+	}
+	c.lock.Unlock()
+
+	// Render without holding the lock, since it may take a while.
+	html, err := RenderMarkdown(src)
+	if err != nil {
+		return "", err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[noteID]; ok {
+		e.Value = &markdownCacheEntry{noteID, sum, html}
+		c.order.MoveToFront(e)
+	} else {
+		c.entries[noteID] = c.order.PushFront(&markdownCacheEntry{noteID, sum, html})
+	}
+
+	// Drop the least recently used notes.
+	for c.order.Len() > c.Size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*markdownCacheEntry).noteID)
+	}
+
+	return html, nil
+}
+
+// Invalidate drops a note's rendered HTML.
+func (c *MarkdownCache) Invalidate(noteID int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[noteID]; ok {
+		c.order.Remove(e)
+		delete(c.entries, noteID)
+	}
+}
+
+// Len reports how many notes are cached.
+func (c *MarkdownCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
+
+// RenderNote fills in a note's rendered HTML, through the cache if there is
+// one.
+func (c *Context) RenderNote(n *Note) error {
+	src, err := n.MarkdownSource()
+	if err != nil {
+		return err
+	}
+
+	if c.Markdown != nil {
+		n.ContentHTML, err = c.Markdown.Render(n.ID, src)
+	} else {
+		n.ContentHTML, err = RenderMarkdown(src)
+	}
+
+	return err
+}
+
+// readRenderOption reads whether a request asked for notes' content as HTML,
+// with "render=html". Any other format is a field error.
+func readRenderOption(r *http.Request, resp *JSONResponse) bool {
+	switch r.FormValue("render") {
+	case "":
+		return false
+	case "html":
+		return true
+	}
+
+	resp.Fields["render"] = "Render must be html."
+	return false
+}
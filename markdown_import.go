@@ -0,0 +1,350 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The formats front matter times may be written in. Times without a zone
+// are in UTC.
+var FRONT_MATTER_TIME_FORMATS = []string {
+	DATETIME_FORMAT, time.RFC3339, "2006-01-02T15:04:05", "2006-01-02",
+}
+
+// ErrFrontMatter is returned for front matter that can't be read.
+var ErrFrontMatter = errors.New("Front matter is not valid.")
+
+// ParseFrontMatter splits YAML front matter, between "---" lines, from the
+// start of a Markdown document. Only the YAML front matter is usually
+// written in is understood: each field is a scalar, which may be quoted, or
+// a list, either in brackets or as "- " lines. Every field is given as a
+// list, so a scalar is a list of one. A document without front matter is
+// all body.
+func ParseFrontMatter(text string) (fields map[string][]string, body string, err error) {
+	fields = map[string][]string{}
+
+	text = strings.TrimPrefix(text, "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		return fields, text, nil
+	}
+
+	// Front matter that is never closed is not front matter.
+	lines := strings.Split(text[4:], "\n")
+	end := -1
+	for i, line := range lines {
+		if line == "---" || line == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return fields, text, nil
+	}
+	body = strings.TrimPrefix(strings.Join(lines[end + 1:], "\n"), "\n")
+
+	key := ""
+	for _, line := range lines[:end] {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// An item of the last field's list.
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if len(key) == 0 {
+				return nil, "", ErrFrontMatter
+			}
+			value, err := yamlScalar(strings.TrimPrefix(trimmed, "-"))
+			if err != nil {
+				return nil, "", err
+			}
+			fields[key] = append(fields[key], value)
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 1 || line[0] == ' ' {
+			return nil, "", ErrFrontMatter
+		}
+		key = strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon + 1:])
+
+		switch {
+		case len(value) == 0:
+			fields[key] = []string{}
+		case strings.HasPrefix(value, "["):
+			fields[key], err = yamlFlowList(value)
+			if err != nil {
+				return nil, "", err
+			}
+		default:
+			value, err = yamlScalar(value)
+			if err != nil {
+				return nil, "", err
+			}
+			fields[key] = []string{value}
+		}
+	}
+
+	return fields, body, nil
+}
+
+// yamlScalar reads a single-quoted, double-quoted or plain YAML scalar.
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case strings.HasPrefix(s, `"`):
+		value, rest, err := yamlDoubleQuoted(s)
+		rest = strings.TrimSpace(rest)
+		if err != nil || (len(rest) > 0 && !strings.HasPrefix(rest, "#")) {
+			return "", ErrFrontMatter
+		}
+		return value, nil
+	case strings.HasPrefix(s, "'"):
+		end := 1
+		for {
+			i := strings.Index(s[end:], "'")
+			if i < 0 {
+				return "", ErrFrontMatter
+			}
+			end += i
+			if !strings.HasPrefix(s[end:], "''") {
+				break
+			}
+			end += 2
+		}
+		return strings.ReplaceAll(s[1:end], "''", "'"), nil
+	}
+
+	// Plain scalars end at a comment.
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// yamlDoubleQuoted reads a double-quoted YAML scalar from the start of s,
+// returning what follows it.
+func yamlDoubleQuoted(s string) (value, rest string, err error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i + 1:], nil
+		case '\\':
+			if i + 1 >= len(s) {
+				return "", "", ErrFrontMatter
+			}
+			i++
+			switch c := s[i]; c {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case '"', '\\', '/', ' ':
+				b.WriteByte(c)
+			case 'x', 'u', 'U':
+				digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+				if i + digits >= len(s) {
+					return "", "", ErrFrontMatter
+				}
+				r, err := strconv.ParseUint(s[i + 1:i + 1 + digits], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", "", ErrFrontMatter
+				}
+				b.WriteRune(rune(r))
+				i += digits
+			default:
+				return "", "", ErrFrontMatter
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", "", ErrFrontMatter
+}
+
+// yamlFlowList reads a YAML list written in brackets, such as [a, "b"].
+func yamlFlowList(s string) (values []string, err error) {
+	values = []string{}
+
+	s = strings.TrimSpace(s)
+	if !strings.HasSuffix(s, "]") {
+		return nil, ErrFrontMatter
+	}
+	s = strings.TrimSpace(s[1:len(s) - 1])
+
+	for len(s) > 0 {
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			value, s, err = yamlDoubleQuoted(s)
+			if err != nil {
+				return nil, err
+			}
+			s = strings.TrimSpace(s)
+		} else {
+			end := strings.Index(s, ",")
+			if end < 0 {
+				end = len(s)
+			}
+			value, err = yamlScalar(s[:end])
+			if err != nil {
+				return nil, err
+			}
+			s = s[end:]
+		}
+		values = append(values, value)
+
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return nil, ErrFrontMatter
+			}
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+
+	return
+}
+
+// ParseMarkdownNote reads a note from a Markdown file, with any of its other
+// fields in front matter as WriteMarkdownNote writes them. A note without a
+// title is named after its file.
+func ParseMarkdownNote(filename string, data []byte) (in ImportedNote, err error) {
+	if !utf8.Valid(data) {
+		return in, errors.New("File is not UTF-8 text.")
+	}
+
+	fields, body, err := ParseFrontMatter(string(data))
+	if err != nil {
+		return
+	}
+
+	first := func(key string) string {
+		if len(fields[key]) > 0 {
+			return fields[key][0]
+		}
+		return ""
+	}
+
+	in = ImportedNote {
+		Source: IMPORT_SOURCE_MARKDOWN,
+		Title: first("title"),
+		Content: strings.TrimRight(body, "\n"),
+		Tags: fields["tags"],
+		Color: first("color"),
+	}
+	if len(in.Title) == 0 {
+		in.Title = strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	}
+
+	in.Pinned, _ = strconv.ParseBool(first("pinned"))
+	in.Archived, _ = strconv.ParseBool(first("archived"))
+
+	for _, field := range []struct{ key string; t *time.Time } {
+		{"created", &in.Created},
+		{"updated", &in.Updated},
+	} {
+		if value := first(field.key); len(value) > 0 {
+			*field.t, err = parseFrontMatterTime(value)
+			if err != nil {
+				return in, fmt.Errorf("Field %q is not a time.", field.key)
+			}
+		}
+	}
+
+	// Checklists are written as task lists.
+	if first("type") == NOTE_TYPE_CHECKLIST {
+		for _, i := range ParseChecklistText(in.Content) {
+			in.Items = append(in.Items, ImportedItem{i.Text, i.Checked})
+		}
+	}
+
+	return
+}
+
+func parseFrontMatterTime(value string) (t time.Time, err error) {
+	for _, format := range FRONT_MATTER_TIME_FORMATS {
+		t, err = time.Parse(format, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return
+}
+
+// ImportMarkdown imports every Markdown file of a zip archive as a note.
+func ImportMarkdown(zr *zip.Reader, im *Importer) ImportReport {
+	report := NewImportReport()
+
+	var files []*zip.File
+	for _, f := range zr.File {
+		ext := strings.ToLower(path.Ext(f.Name))
+		if !f.FileInfo().IsDir() && (ext == ".md" || ext == ".markdown") {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	for _, f := range files {
+		name := f.Name
+		item := ImportItem {
+			File: name,
+		}
+
+		data, err := readImportFile(f)
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = []string{err.Error()}
+			report.Add(item)
+			continue
+		}
+
+		in, err := ParseMarkdownNote(name, data)
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = []string{err.Error()}
+			report.Add(item)
+			continue
+		}
+		in.SourceKey = ImportKey(name, string(data))
+		item.Title = ImportTitle(in)
+
+		n, warnings, err := im.Save(in)
+		if err == ErrAlreadyImported {
+			item.Status = IMPORT_STATUS_SKIPPED
+			item.NoteID = n.ID
+			item.Errors = []string{err.Error()}
+			report.Add(item)
+			continue
+		}
+		if err != nil {
+			item.Status = IMPORT_STATUS_FAILED
+			item.Errors = []string{"Could not save note."}
+			report.Add(item)
+			continue
+		}
+
+		item.Status = IMPORT_STATUS_IMPORTED
+		item.NoteID = n.ID
+		item.Errors = warnings
+		report.Add(item)
+	}
+
+	return report
+}
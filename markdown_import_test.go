@@ -0,0 +1,128 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	fields, body, err := ParseFrontMatter(`---
+title: "Say \"hi\" é"
+tags:
+  - work
+  - 'it''s'
+aliases: [one, "two, three", four]
+empty:
+plain: value # comment
+---
+
+Body
+`)
+	AssertEqual(err, nil, t)
+	AssertEqual(fields["title"][0], `Say "hi" é`, t)
+	AssertEqual(len(fields["tags"]), 2, t)
+	if len(fields["tags"]) == 2 {
+		AssertEqual(fields["tags"][1], "it's", t)
+	}
+	AssertEqual(len(fields["aliases"]), 3, t)
+	if len(fields["aliases"]) == 3 {
+		AssertEqual(fields["aliases"][1], "two, three", t)
+	}
+	AssertEqual(len(fields["empty"]), 0, t)
+	AssertEqual(fields["plain"][0], "value", t)
+	AssertEqual(body, "Body\n", t)
+
+	// Documents without front matter are all body.
+	fields, body, err = ParseFrontMatter("# Title\n")
+	AssertEqual(err, nil, t)
+	AssertEqual(len(fields), 0, t)
+	AssertEqual(body, "# Title\n", t)
+
+	fields, body, err = ParseFrontMatter("---\nnever closed\n")
+	AssertEqual(err, nil, t)
+	AssertEqual(len(fields), 0, t)
+
+	_, _, err = ParseFrontMatter("---\ntitle: \"open\n---\n")
+	AssertEqual(err, ErrFrontMatter, t)
+}
+
+func TestParseMarkdownNote(t *testing.T) {
+	// Notes are read back as they are exported.
+	var buf bytes.Buffer
+	WriteMarkdownNote(&buf, ExportNote {
+		ID: 3,
+		Title: "Groceries",
+		Type: NOTE_TYPE_CHECKLIST,
+		Content: "- [x] Milk\n- [ ] Eggs",
+		Created: "2017-01-01 12:00:00",
+		Pinned: true,
+		Color: "green",
+		Tags: []string{"home", "a: b"},
+	})
+
+	in, err := ParseMarkdownNote("notes/0003-groceries.md", buf.Bytes())
+	AssertEqual(err, nil, t)
+	AssertEqual(in.Title, "Groceries", t)
+	AssertEqual(in.Pinned, true, t)
+	AssertEqual(in.Color, "green", t)
+	AssertEqual(in.Created, time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC), t)
+	AssertEqual(len(in.Tags), 2, t)
+	AssertEqual(len(in.Items), 2, t)
+	if len(in.Items) == 2 {
+		AssertEqual(in.Items[0].Checked, true, t)
+		AssertEqual(in.Items[1].Text, "Eggs", t)
+	}
+
+	// Plain Markdown files are named after the file.
+	in, err = ParseMarkdownNote("ideas/Side projects.md", []byte("Build a thing\n"))
+	AssertEqual(err, nil, t)
+	AssertEqual(in.Title, "Side projects", t)
+	AssertEqual(in.Content, "Build a thing", t)
+
+	_, err = ParseMarkdownNote("bad.md", []byte("---\ncreated: yesterday\n---\n"))
+	AssertUnequal(err, nil, t)
+}
+
+func TestImportMarkdownTwice(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"a.md", "b.markdown", "c.txt"} {
+		f, _ := zw.Create(name)
+		f.Write([]byte("---\ntags: [tag1]\n---\n\nNote " + name + "\n"))
+	}
+	zw.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := ImportMarkdown(zr, NewImporter(db, nil, ids["user.nonadmin"], time.Now()))
+	AssertEqual(report.Imported, 2, t)
+
+	// Importing the same files again changes nothing.
+	again := ImportMarkdown(zr, NewImporter(db, nil, ids["user.nonadmin"], time.Now()))
+	AssertEqual(again.Imported, 0, t)
+	AssertEqual(again.Skipped, 2, t)
+	if len(again.Items) == 2 {
+		AssertEqual(again.Items[0].NoteID, report.Items[0].NoteID, t)
+	}
+
+	// Unless the note was deleted since.
+	n, _ := LoadNote(report.Items[0].NoteID, db)
+	n.Delete()
+	again = ImportMarkdown(zr, NewImporter(db, nil, ids["user.nonadmin"], time.Now()))
+	AssertEqual(again.Imported, 1, t)
+
+	// Another user may import the same files.
+	other := ImportMarkdown(zr, NewImporter(db, nil, ids["user.admin"], time.Now()))
+	AssertEqual(other.Imported, 2, t)
+}
@@ -0,0 +1,81 @@
+package csnotes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	html, err := RenderMarkdown("# Title\n\nSome *emphasis* and `code`.")
+	AssertEqual(err, nil, t)
+	AssertContains(html, "<h1>Title</h1>", t)
+	AssertContains(html, "<em>emphasis</em>", t)
+	AssertContains(html, "<code>code</code>", t)
+
+	// Tables keep their alignment.
+	html, _ = RenderMarkdown("| a | b |\n|:--|:-:|\n| 1 | 2 |")
+	AssertContains(html, "<table>", t)
+	AssertContains(html, `<td style="text-align: center">2</td>`, t)
+
+	// Task lists become disabled checkboxes.
+	html, _ = RenderMarkdown("- [x] Milk\n- [ ] Eggs")
+	AssertContains(html, `<input checked="" disabled="" type="checkbox"> Milk`, t)
+	AssertContains(html, `<input disabled="" type="checkbox"> Eggs`, t)
+
+	// Code blocks name their language.
+	html, _ = RenderMarkdown("```go\nfmt.Println()\n```")
+	AssertContains(html, `<code class="language-go">`, t)
+}
+
+func TestRenderMarkdownSanitizes(t *testing.T) {
+	for _, src := range []string {
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"[link](javascript:alert(1))",
+		"<a href=\"javascript:alert(1)\">link</a>",
+		"<iframe src=\"http://example.com\"></iframe>",
+		"<p style=\"position: fixed\">text</p>",
+	} {
+		html, err := RenderMarkdown(src)
+		AssertEqual(err, nil, t)
+		for _, bad := range []string{"<script", "onerror", "javascript:", "<iframe", "position"} {
+			if strings.Contains(html, bad) {
+				t.Errorf("Rendering %q kept %q: %s", src, bad, html)
+			}
+		}
+	}
+
+	// Links are kept, but not followed by search engines.
+	html, _ := RenderMarkdown("[site](http://example.com)")
+	AssertContains(html, `<a href="http://example.com" rel="nofollow">site</a>`, t)
+}
+
+func TestMarkdownCache(t *testing.T) {
+	c := NewMarkdownCache(2)
+
+	html, err := c.Render(1, "*one*")
+	AssertEqual(err, nil, t)
+	AssertEqual(html, "<p><em>one</em></p>\n", t)
+	AssertEqual(c.Len(), 1, t)
+
+	// A changed source is never served from the old entry.
+	html, _ = c.Render(1, "*uno*")
+	AssertEqual(html, "<p><em>uno</em></p>\n", t)
+	AssertEqual(c.Len(), 1, t)
+
+	// Invalidating drops the entry.
+	c.Invalidate(1)
+	AssertEqual(c.Len(), 0, t)
+	c.Invalidate(1)
+
+	// The least recently used note is dropped once the cache is full.
+	c.Render(1, "one")
+	c.Render(2, "two")
+	c.Render(1, "one")
+	c.Render(3, "three")
+	AssertEqual(c.Len(), 2, t)
+	_, ok := c.entries[2]
+	AssertEqual(ok, false, t)
+	_, ok = c.entries[1]
+	AssertEqual(ok, true, t)
+}
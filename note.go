@@ -2,14 +2,51 @@ package csnotes
 
 import (
 	"database/sql"
+	"time"
 )
 
+const (
+	// The kinds of note. Text notes keep their body in Content, while
+	// checklists keep it as a list of items.
+	NOTE_TYPE_TEXT = "text"
+	NOTE_TYPE_CHECKLIST = "checklist"
+
+	// The color of a note that has not been given one.
+	DEFAULT_NOTE_COLOR = "default"
+)
+
+// NOTE_COLORS lists the colors a note may be labelled with.
+var NOTE_COLORS = []string {
+	DEFAULT_NOTE_COLOR, "red", "orange", "yellow", "green", "teal",
+	"blue", "darkblue", "purple", "pink", "brown", "gray",
+}
+
 type Note struct {
 	Resource
 	Title string `json:"title"`
+	Type string `json:"type"`
 	Content sql.NullString `json:"content"`
 	Time sql.NullString	`json:"time"`
+	Pinned bool `json:"pinned"`
+	Archived bool `json:"archived"`
+	Color string `json:"color"`
 	UserID int64 `json:"-"`
+
+	// When the note was created and last changed. Both are set by Save.
+	Created sql.NullString `json:"created"`
+	Updated sql.NullString `json:"updated"`
+
+	// How many of a checklist's items are checked, out of how many. These
+	// are only filled in by CountItems.
+	ItemsChecked int `json:"items_checked"`
+	ItemsTotal int `json:"items_total"`
+
+	// The note's cover image, or nil. This is only filled in by LoadCover.
+	Cover *NoteCover `json:"cover"`
+
+	// The note's content rendered from Markdown. This is only filled in by
+	// RenderNote.
+	ContentHTML string `json:"content_html,omitempty"`
 }
 
 // NewNote creates a new note model with no ID or any fields set.
@@ -19,6 +56,8 @@ func NewNote(db *sql.DB) (n Note) {
 			DB: db,
 			Table: "notes",
 		},
+		Type: NOTE_TYPE_TEXT,
+		Color: DEFAULT_NOTE_COLOR,
 	}
 }
 
@@ -32,11 +71,71 @@ func LoadNote(id int64, db *sql.DB) (n Note, err error) {
 }
 
 func (n *Note) Load() error {
-	return n.Select([]string{"title", "content", "time", "user_id"}, &n.Title, &n.Content, &n.Time, &n.UserID)
+	return n.Select([]string{"title", "type", "content", "time", "pinned", "archived", "color", "user_id", "created", "updated"},
+		&n.Title, &n.Type, &n.Content, &n.Time, &n.Pinned, &n.Archived, &n.Color, &n.UserID, &n.Created, &n.Updated)
+}
+
+// Save stores the note, marking it as updated at now. New notes are also
+// marked as created at now.
+func (n *Note) Save(now time.Time) error {
+	if len(n.Type) == 0 {
+		n.Type = NOTE_TYPE_TEXT
+	}
+	if len(n.Color) == 0 {
+		n.Color = DEFAULT_NOTE_COLOR
+	}
+
+	stamp := sql.NullString{String: now.UTC().Format(DATETIME_FORMAT), Valid: true}
+	if !n.Created.Valid {
+		n.Created = stamp
+	}
+	n.Updated = stamp
+
+	return n.Sync([]string{"title", "type", "content", "time", "pinned", "archived", "color", "user_id", "created", "updated"},
+		n.Title, n.Type, n.Content.String, n.Time.String, n.Pinned, n.Archived, n.Color, n.UserID, n.Created, n.Updated)
+}
+
+// SetTimestamps overwrites when the saved note was created and last changed,
+// such as to keep the times of an imported note.
+func (n *Note) SetTimestamps(created, updated time.Time) error {
+	n.Created = sql.NullString{String: created.UTC().Format(DATETIME_FORMAT), Valid: true}
+	n.Updated = sql.NullString{String: updated.UTC().Format(DATETIME_FORMAT), Valid: true}
+
+	_, err := n.DB.Exec("UPDATE notes SET created=?, updated=? WHERE id=?", n.Created, n.Updated, n.ID)
+	return err
+}
+
+// SetPinned pins or unpins the note. Pinning an archived note brings it
+// back out of the archive.
+func (n *Note) SetPinned(pinned bool) {
+	n.Pinned = pinned
+	if pinned {
+		n.Archived = false
+	}
+}
+
+// SetArchived archives or restores the note. Archived notes are never
+// pinned.
+func (n *Note) SetArchived(archived bool) {
+	n.Archived = archived
+	if archived {
+		n.Pinned = false
+	}
+}
+
+// ValidNoteColor reports whether a color is one a note may be labelled with.
+func ValidNoteColor(color string) bool {
+	for _, c := range NOTE_COLORS {
+		if c == color {
+			return true
+		}
+	}
+	return false
 }
 
-func (n *Note) Save() error {
-	return n.Sync([]string{"title", "content", "time", "user_id"}, n.Title, n.Content.String, n.Time.String, n.UserID)
+// IsChecklist reports whether the note is a checklist.
+func (n *Note) IsChecklist() bool {
+	return n.Type == NOTE_TYPE_CHECKLIST
 }
 
 func (n *Note) User() (u User, err error) {
@@ -87,8 +186,9 @@ func (n *Note) Tags() (ts []Tag, err error) {
 
 // AddTag attaches a tag to this note.
 func (n *Note) AddTag(id int64) error {
-	// Insert a new row in the note_tag table.
-	_, err := n.DB.Exec("INSERT INTO note_tag (note_id, tag_id) VALUES (?, ?)", n.ID, id)
+	// Insert a new row in the note_tag table, unless the note already has
+	// the tag.
+	_, err := n.DB.Exec("INSERT IGNORE INTO note_tag (note_id, tag_id) VALUES (?, ?)", n.ID, id)
 	return err
 }
 
@@ -0,0 +1,146 @@
+package csnotes
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// The most notes a bulk request may change at once.
+const BULK_NOTE_LIMIT = 500
+
+// PostNotesPin pins or unpins many notes at once. The form takes the note
+// "ids", comma-separated, and "pinned" as true or false.
+func PostNotesPin(context *Context) http.HandlerFunc {
+	return bulkNoteHandler(context, func (r *http.Request, resp *JSONResponse) func (n *Note) {
+		pinned, err := strconv.ParseBool(r.FormValue("pinned"))
+		if err != nil {
+			resp.Fields["pinned"] = "Pinned must be true or false."
+			return nil
+		}
+
+		return func (n *Note) {
+			n.SetPinned(pinned)
+		}
+	})
+}
+
+// PostNotesArchive archives or restores many notes at once. The form takes
+// the note "ids", comma-separated, and "archived" as true or false.
+func PostNotesArchive(context *Context) http.HandlerFunc {
+	return bulkNoteHandler(context, func (r *http.Request, resp *JSONResponse) func (n *Note) {
+		archived, err := strconv.ParseBool(r.FormValue("archived"))
+		if err != nil {
+			resp.Fields["archived"] = "Archived must be true or false."
+			return nil
+		}
+
+		return func (n *Note) {
+			n.SetArchived(archived)
+		}
+	})
+}
+
+// PostNotesColor labels many notes with a color at once. The form takes the
+// note "ids", comma-separated, and the "color".
+func PostNotesColor(context *Context) http.HandlerFunc {
+	return bulkNoteHandler(context, func (r *http.Request, resp *JSONResponse) func (n *Note) {
+		color := r.FormValue("color")
+		if !ValidNoteColor(color) {
+			resp.Fields["color"] = "Unknown color."
+			return nil
+		}
+
+		return func (n *Note) {
+			n.Color = color
+		}
+	})
+}
+
+// bulkNoteHandler builds a handler that changes many notes the same way.
+// The change is read from the request first; if it is invalid, it adds to the
+// response's fields and returns nil. If the user may not change one of the
+// notes, none of them are changed.
+func bulkNoteHandler(context *Context, readChange func (r *http.Request, resp *JSONResponse) func (n *Note)) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Read the note IDs.
+		ids, err := ParseIDList(r.FormValue("ids"))
+		if err != nil {
+			resp.Fields["ids"] = "IDs must be a comma-separated list of numbers."
+		} else if len(ids) > BULK_NOTE_LIMIT {
+			resp.Fields["ids"] = fmt.Sprintf("At most %d notes may be changed at once.", BULK_NOTE_LIMIT)
+		}
+
+		change := readChange(r, &resp)
+		if len(resp.Fields) > 0 {
+			return
+		}
+
+		// Get the logged in user's data.
+		currentUserID, currentUserAdmin, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+
+		// Load every note before changing any, so that a note the user may
+		// not change stops the whole request.
+		var ns []Note
+		for _, id := range ids {
+			n, err := LoadNote(id, context.DB)
+			if err != nil {
+				resp.StatusCode = 404
+				resp.ErrorMessage = fmt.Sprintf("Note %d not found.", id)
+				return
+			}
+			if !currentUserAdmin && currentUserID != n.UserID {
+				resp.StatusCode = 403
+				resp.ErrorMessage = "Access denied."
+				return
+			}
+			ns = append(ns, n)
+		}
+
+		// Save the notes in one transaction, so that a failure partway
+		// through leaves all of them as they were.
+		tx, err := context.DB.Begin()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save notes."
+			return
+		}
+		defer tx.Rollback()
+
+		updated := sql.NullString{String: context.Now().UTC().Format(DATETIME_FORMAT), Valid: true}
+		for i := range ns {
+			change(&ns[i])
+			ns[i].Updated = updated
+
+			_, err = tx.Exec("UPDATE notes SET pinned=?, archived=?, color=?, updated=? WHERE id=?",
+				ns[i].Pinned, ns[i].Archived, ns[i].Color, ns[i].Updated, ns[i].ID)
+			if err != nil {
+				fmt.Println(err)
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not save notes."
+				return
+			}
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save notes."
+			return
+		}
+
+		for _, n := range ns {
+			resp.Models = append(resp.Models, n)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package csnotes
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+)
+
+// The states of a cover image. Thumbnails are made in the background, after
+// the image is uploaded.
+const (
+	COVER_STATUS_PROCESSING = "processing"
+	COVER_STATUS_READY = "ready"
+	COVER_STATUS_FAILED = "failed"
+)
+
+// NoteCover is the image shown for a note, such as a photo of a whiteboard.
+// The original image is one of the note's attachments.
+type NoteCover struct {
+	Resource
+	NoteID int64 `json:"note_id"`
+	AttachmentID int64 `json:"attachment_id"`
+	Status string `json:"status"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+
+	// The URLs of the thumbnails by size, once they are ready. These are
+	// only filled in by LoadCover.
+	Thumbnails map[string]string `json:"thumbnails"`
+}
+
+// NewNoteCover creates a new cover model with no ID or any fields set.
+func NewNoteCover(db *sql.DB) NoteCover {
+	return NoteCover {
+		Resource: Resource {
+			DB: db,
+			Table: "note_covers",
+		},
+		Status: COVER_STATUS_PROCESSING,
+	}
+}
+
+// LoadNoteCover attempts to load a cover's fields from the database, given
+// its ID.
+func LoadNoteCover(id int64, db *sql.DB) (c NoteCover, err error) {
+	c = NewNoteCover(db)
+	c.ID = id
+	err = c.Load()
+
+	return
+}
+
+func (c *NoteCover) Load() error {
+	return c.Select([]string{"note_id", "attachment_id", "status", "width", "height"},
+		&c.NoteID, &c.AttachmentID, &c.Status, &c.Width, &c.Height)
+}
+
+func (c *NoteCover) Save() error {
+	return c.Sync([]string{"note_id", "attachment_id", "status", "width", "height"},
+		c.NoteID, c.AttachmentID, c.Status, c.Width, c.Height)
+}
+
+// LoadCover fills in the note's cover, which is left nil if it has none.
+func (n *Note) LoadCover() error {
+	n.Cover = nil
+
+	var id int64
+	err := n.DB.QueryRow("SELECT id FROM note_covers WHERE note_id=?", n.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := LoadNoteCover(id, n.DB)
+	if err != nil {
+		return err
+	}
+
+	if c.Status == COVER_STATUS_READY {
+		c.Thumbnails = map[string]string{}
+		for _, size := range THUMBNAIL_SIZES {
+			c.Thumbnails[size.Name] = fmt.Sprintf("/api/note/%d/attachment/%d/thumbnail/%s", n.ID, c.AttachmentID, size.Name)
+		}
+	}
+
+	n.Cover = &c
+	return nil
+}
+
+// SetCover makes an attachment the note's cover, to be processed. The old
+// cover's attachment is removed.
+func (n *Note) SetCover(a Attachment, store BlobStore) (c NoteCover, err error) {
+	err = n.LoadCover()
+	if err != nil {
+		return
+	}
+
+	c = NewNoteCover(n.DB)
+	if n.Cover != nil {
+		c = *n.Cover
+		c.Thumbnails = nil
+	}
+	old := c.AttachmentID
+
+	c.NoteID = n.ID
+	c.AttachmentID = a.ID
+	c.Status = COVER_STATUS_PROCESSING
+	c.Width = 0
+	c.Height = 0
+	err = c.Save()
+	if err != nil {
+		return
+	}
+	n.Cover = &c
+
+	if old != 0 {
+		oldAttachment, err := LoadAttachment(old, n.DB)
+		if err == nil {
+			err = oldAttachment.Remove(store)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// RemoveCover removes the note's cover, along with its attachment.
+func (n *Note) RemoveCover(store BlobStore) error {
+	err := n.LoadCover()
+	if err != nil || n.Cover == nil {
+		return err
+	}
+
+	a, err := LoadAttachment(n.Cover.AttachmentID, n.DB)
+	if err == sql.ErrNoRows {
+		return n.Cover.Delete()
+	}
+	if err != nil {
+		return err
+	}
+
+	n.Cover = nil
+	return a.Remove(store)
+}
+
+// AttachmentCoverStatus gives the status of the cover an attachment is the
+// image of, or an empty string if it is not a cover.
+func AttachmentCoverStatus(aID int64, db *sql.DB) (status string, err error) {
+	err = db.QueryRow("SELECT status FROM note_covers WHERE attachment_id=?", aID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return
+}
+
+// ThumbnailKey gives the blob key of one size of an attachment's thumbnail.
+func ThumbnailKey(a Attachment, size string) string {
+	return "thumbnails/" + path.Base(a.StorageKey) + "-" + size + ".jpg"
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 func GetNotes(context *Context) http.HandlerFunc {
@@ -12,6 +14,12 @@ func GetNotes(context *Context) http.HandlerFunc {
 		resp := NewJSONResponse()
 		defer resp.Respond(w)
 
+		// Check whether the content is wanted as HTML.
+		renderHTML := readRenderOption(r, &resp)
+		if len(resp.Fields) > 0 {
+			return
+		}
+
 		// Get the logged in user's ID.
 		currentUserID, _, err := context.LoggedInUser(r)
 		if err != nil {
@@ -28,16 +36,38 @@ func GetNotes(context *Context) http.HandlerFunc {
 			return
 		}
 
-		// Load the notes from the user model.
-		ns, err := u.Notes()
+		// Load the notes from the user model, pinned first. Archived notes
+		// are only included when asked for.
+		ns, err := u.ListNotes(r.FormValue("archived") == "true")
 		if err != nil {
 			resp.StatusCode = 500
 			resp.ErrorMessage = "Could not load user's notes."
 			return
 		}
 
-		// Add the notes to the response.
+		// Add the notes to the response, with their checklist progress and
+		// cover.
 		for _, n := range ns {
+			err = n.CountItems()
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not count note's items."
+				return
+			}
+			err = n.LoadCover()
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not load note's cover."
+				return
+			}
+			if renderHTML {
+				err = context.RenderNote(&n)
+				if err != nil {
+					resp.StatusCode = 500
+					resp.ErrorMessage = "Could not render note."
+					return
+				}
+			}
 			resp.Models = append(resp.Models, n)
 		}
 	}
@@ -51,6 +81,12 @@ func GetNote(context *Context) http.HandlerFunc {
 		resp := NewJSONResponse()
 		defer resp.Respond(w)
 
+		// Check whether the content is wanted as HTML.
+		renderHTML := readRenderOption(r, &resp)
+		if len(resp.Fields) > 0 {
+			return
+		}
+
 		// Get the note ID.
 		nID, ok := GetURLID(r, &resp)
 		if !ok {
@@ -93,6 +129,30 @@ func GetNote(context *Context) http.HandlerFunc {
 			return
 		}
 
+		// Count the note's checklist items.
+		err = n.CountItems()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not count note's items."
+			return
+		}
+
+		err = n.LoadCover()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load note's cover."
+			return
+		}
+
+		if renderHTML {
+			err = context.RenderNote(&n)
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not render note."
+				return
+			}
+		}
+
 		// Add the note model to the response.
 		resp.Models = append(resp.Models, n)
 	}
@@ -111,6 +171,8 @@ func PostNote(context *Context) http.HandlerFunc {
 		title := r.FormValue("title")
 		content := r.FormValue("content")
 		time := r.FormValue("time")
+		noteType := r.FormValue("type")
+		color := r.FormValue("color")
 		readUserID := r.FormValue("user_id")
 
 		// Perform validation on the form values.
@@ -118,6 +180,14 @@ func PostNote(context *Context) http.HandlerFunc {
 			resp.Fields["title"] = "Title must be specified."
 		}
 
+		if len(noteType) > 0 && noteType != NOTE_TYPE_TEXT && noteType != NOTE_TYPE_CHECKLIST {
+			resp.Fields["type"] = "Type must be text or checklist."
+		}
+
+		if len(color) > 0 && !ValidNoteColor(color) {
+			resp.Fields["color"] = "Unknown color."
+		}
+
 		if len(resp.Fields) > 0 {
 			return
 		}
@@ -161,16 +231,49 @@ func PostNote(context *Context) http.HandlerFunc {
 		n.Time.String = time
 		n.Time.Valid = len(time) > 0
 
+		if len(color) > 0 {
+			n.Color = color
+		}
+		n.Pinned = r.FormValue("pinned") == "true"
+
 		n.UserID = userID
 
 		// Save the new note.
-		err = n.Save()
+		err = n.Save(context.Now())
 		if err != nil {
 			resp.StatusCode = 500
 			resp.ErrorMessage = "Could not save note."
 			return
 		}
 
+		// A new checklist takes its items from the content, one per line.
+		// Without them, the note is removed again rather than left as text.
+		if noteType == NOTE_TYPE_CHECKLIST {
+			err = n.ConvertToChecklist(context.Now())
+			if err != nil {
+				fmt.Println(err)
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not create checklist."
+				n.Delete()
+				return
+			}
+
+			err = n.CountItems()
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not count items."
+				return
+			}
+		}
+
+		// Record the note's links to other notes.
+		err = n.SyncLinks()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save note's links."
+			return
+		}
+
 		// Add the note model to the response.
 		resp.Models = append(resp.Models, n)
 	}
@@ -236,22 +339,52 @@ func PutNote(context *Context) http.HandlerFunc {
 			return
 		}
 
-		// Update the note's values.
+		// Update the note's values. Checklists keep their body as items, so
+		// their content is left alone.
 		n.Title = title
 
-		n.Content.String = content
-		n.Content.Valid = len(content) > 0
+		if !n.IsChecklist() {
+			n.Content.String = content
+			n.Content.Valid = len(content) > 0
+		}
 
 		n.Time.String = time
 		n.Time.Valid = len(time) > 0
 
-		// Save the note.
-		err = n.Save()
+		// Pinning, archiving and color are only changed when sent.
+		if _, ok := r.Form["pinned"]; ok {
+			n.SetPinned(r.FormValue("pinned") == "true")
+		}
+		if _, ok := r.Form["archived"]; ok {
+			n.SetArchived(r.FormValue("archived") == "true")
+		}
+		if color, ok := r.Form["color"]; ok {
+			if !ValidNoteColor(color[0]) {
+				resp.Fields["color"] = "Unknown color."
+				return
+			}
+			n.Color = color[0]
+		}
+
+		// Save the note, and drop its old rendered HTML.
+		err = n.Save(context.Now())
 		if err != nil {
 			resp.StatusCode = 500
 			resp.ErrorMessage = "Could not save note."
 			return
 		}
+		if context.Markdown != nil {
+			context.Markdown.Invalidate(n.ID)
+		}
+
+		// Record the note's links, which may have changed with its content
+		// or title.
+		err = n.SyncLinks()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save note's links."
+			return
+		}
 
 		// Add the newly updated note to the response.
 		resp.Models = append(resp.Models, n)
@@ -307,6 +440,17 @@ func DeleteNote(context *Context) http.HandlerFunc {
 			return
 		}
 
+		// Remove the note's attachments first, since their blobs cannot be
+		// found once the note is gone.
+		if context.Blobs != nil {
+			err = n.RemoveAttachments(context.Blobs)
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not delete note's attachments."
+				return
+			}
+		}
+
 		// Delete the note.
 		err = n.Delete()
 		if err != nil {
@@ -314,6 +458,31 @@ func DeleteNote(context *Context) http.HandlerFunc {
 			resp.ErrorMessage = "Could not delete note."
 			return
 		}
+		if context.Markdown != nil {
+			context.Markdown.Invalidate(n.ID)
+		}
+
+		// Remove the note's items and reminder along with it.
+		_, err = context.DB.Exec("DELETE FROM checklist_items WHERE note_id=?", n.ID)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not delete note's items."
+			return
+		}
+
+		_, err = context.DB.Exec("DELETE FROM reminders WHERE note_id=?", n.ID)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not delete note's reminder."
+			return
+		}
+
+		err = n.RemoveLinks()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not delete note's links."
+			return
+		}
 
 		// Add the old note's data to the response.
 		resp.Models = append(resp.Models, n)
@@ -364,6 +533,7 @@ func GetNoteTags(context *Context) http.HandlerFunc {
 		if !currentUserAdmin && currentUserID != n.UserID {
 			resp.StatusCode = 403
 			resp.ErrorMessage = "Access denied."
+			return
 		}
 
 		// Retrieve the note's tags.
@@ -382,57 +552,63 @@ func GetNoteTags(context *Context) http.HandlerFunc {
 	}
 }
 
-// PostNoteTags attaches a tag to a note through an intermediate table. The tag
-// ID is sent through a form parameter.
+// PostNoteTag attaches a tag to a note through an intermediate table. The tag
+// is either given by ID through the tag_id form parameter, or by title, in
+// which case the note owner's tag with that title is used or created.
 func PostNoteTag(context *Context) http.HandlerFunc {
 	return func (w http.ResponseWriter, r *http.Request) {
 		// Create a response.
 		resp := NewJSONResponse()
 		defer resp.Respond(w)
-		
-		// Retrieve the note ID.
-		nID, ok := GetURLID(r, &resp)
-		if !ok {
+
+		// Retrieve the tag ID or title.
+		tIDform := r.FormValue("tag_id")
+		title := strings.Join(strings.Fields(r.FormValue("title")), " ")
+
+		// Ensure a tag was given.
+		if len(tIDform) == 0 && len(title) == 0 {
+			resp.Fields["tag_id"] = "No tag ID or title given."
 			return
 		}
 
-		// Retrieve the tag ID.
-		tIDform := r.FormValue("tag_id")
-		
-		// Ensure a tag ID was given.
-		if len(tIDform) == 0 {
-			resp.Fields["tag_id"] = "No tag ID given."
+		if utf8.RuneCountInString(title) > MAX_NOTE_TITLE_LENGTH {
+			resp.Fields["title"] = "Title is too long."
 			return
 		}
 
-		// Ensure that the tag ID is a valid int.
-		tIDint, err := strconv.Atoi(tIDform)
-		if err != nil {
-			resp.Fields["tag_id"] = "Invalid tag ID."
+		// Load the note, which the user must own or be admin.
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
 			return
 		}
-		tID := int64(tIDint)
 
-		// Make sure the note exists.
-		if e, err := CheckExistence(nID, "notes", context.DB); !e {
-			if err == nil {
-				resp.StatusCode = 404
-				resp.ErrorMessage = "Note not found."
+		// A tag given by title belongs to the note's owner.
+		if len(tIDform) == 0 {
+			t, err := FindOrCreateTag(n.UserID, title, context.DB)
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not load tag."
 				return
-			} else {
+			}
+
+			err = n.AddTag(t.ID)
+			if err != nil {
 				resp.StatusCode = 500
-				resp.ErrorMessage = "Could not verify existence of note."
+				resp.ErrorMessage = "Could not add tag to note."
 				return
 			}
+
+			resp.Models = append(resp.Models, t)
+			return
 		}
 
-		// Load the note model.
-		n, err := LoadNote(nID, context.DB)
+		// Ensure that the tag ID is a valid int.
+		tIDint, err := strconv.Atoi(tIDform)
 		if err != nil {
-			resp.StatusCode = 500
-			resp.ErrorMessage = "Could not load note."
+			resp.Fields["tag_id"] = "Invalid tag ID."
 			return
 		}
+		tID := int64(tIDint)
 
 		// Make sure the tag exists.
 		if e, err := CheckExistence(tID, "tags", context.DB); !e {
@@ -462,8 +638,8 @@ func PostNoteTag(context *Context) http.HandlerFunc {
 			return
 		}
 
-		// Make sure the user is either admin or owns the note and the tag.
-		if !currentUserAdmin && (currentUserID != t.UserID || currentUserID != n.UserID) {
+		// Make sure the user is either admin or owns the tag.
+		if !currentUserAdmin && currentUserID != t.UserID {
 			resp.StatusCode = 403
 			resp.ErrorMessage = "Access Denied."
 			return
@@ -481,3 +657,56 @@ func PostNoteTag(context *Context) http.HandlerFunc {
 		resp.Models = append(resp.Models, t)
 	}
 }
+
+// DeleteNoteTag detaches a tag from a note. The tag itself is kept.
+func DeleteNoteTag(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create a response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Load the note, which the user must own or be admin.
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		// Retrieve the tag ID.
+		tID, ok := GetURLVarID(r, &resp, "tag_id")
+		if !ok {
+			return
+		}
+
+		// Make sure the note has the tag.
+		var count int
+		err := context.DB.QueryRow("SELECT COUNT(*) FROM note_tag WHERE note_id=? AND tag_id=?", n.ID, tID).Scan(&count)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load note's tags."
+			return
+		}
+		if count == 0 {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "Tag not found."
+			return
+		}
+
+		t, err := LoadTag(tID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load tag."
+			return
+		}
+
+		// Remove the tag from the note.
+		err = n.RemoveTag(t.ID)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not remove tag from note."
+			return
+		}
+
+		// Add the removed tag to the response.
+		resp.Models = append(resp.Models, t)
+	}
+}
@@ -0,0 +1,76 @@
+package csnotes
+
+import (
+	"testing"
+	"time"
+)
+
+// TestListNotes ensures that pinned notes come first, and that archived
+// notes are only listed when asked for.
+func TestListNotes(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := LoadUser(ids["user.nonadmin"], db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a pinned note and an archived one.
+	pinned := NewNote(db)
+	pinned.Title = "pinned"
+	pinned.UserID = u.ID
+	pinned.SetPinned(true)
+	if err = pinned.Save(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	archived := NewNote(db)
+	archived.Title = "archived"
+	archived.UserID = u.ID
+	archived.Color = "red"
+	archived.SetArchived(true)
+	if err = archived.Save(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := u.ListNotes(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual("pinned", ns[0].Title, t)
+	for _, n := range ns {
+		AssertUnequal(archived.ID, n.ID, t)
+	}
+
+	all, err := u.ListNotes(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(len(ns) + 1, len(all), t)
+
+	// The color is kept.
+	n, err := LoadNote(archived.ID, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual("red", n.Color, t)
+	AssertEqual(true, n.Archived, t)
+}
+
+// TestNoteArchivePin ensures that archived notes are never pinned.
+func TestNoteArchivePin(t *testing.T) {
+	n := NewNote(nil)
+	n.SetPinned(true)
+	n.SetArchived(true)
+	AssertEqual(false, n.Pinned, t)
+
+	n.SetPinned(true)
+	AssertEqual(false, n.Archived, t)
+
+	AssertEqual(true, ValidNoteColor("teal"), t)
+	AssertEqual(false, ValidNoteColor("chartreuse"), t)
+}
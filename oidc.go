@@ -0,0 +1,405 @@
+package csnotes
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// The auth_source of users provisioned through single sign-on.
+const OIDC_AUTH_SOURCE = "oidc"
+
+// OIDCConfig describes the identity provider used for single sign-on.
+type OIDCConfig struct {
+	// The issuer URL. The provider's metadata is discovered from
+	// <issuer>/.well-known/openid-configuration.
+	IssuerURL string
+
+	ClientID string
+	ClientSecret string
+
+	// The URL of this app's /login/oidc/callback route, as registered with
+	// the provider.
+	RedirectURL string
+
+	// Members of this group are made admins. If empty, admin status is never
+	// changed by single sign-on.
+	AdminGroup string
+
+	// The ID token claim listing the user's groups. Defaults to "groups".
+	GroupsClaim string
+
+	// The client used to reach the provider. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// OIDCIdentity is the verified content of an ID token.
+type OIDCIdentity struct {
+	Subject string
+	Email string
+	EmailVerified bool
+	PreferredUsername string
+	Name string
+	Groups []string
+}
+
+// OIDCProvider performs the authorization code flow against an identity
+// provider. It is safe for concurrent use.
+type OIDCProvider struct {
+	Config OIDCConfig
+
+	AuthorizationEndpoint string
+	TokenEndpoint string
+	JWKSURI string
+
+	mu sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// DiscoverOIDCProvider reads a provider's metadata from its discovery
+// document.
+func DiscoverOIDCProvider(config OIDCConfig) (*OIDCProvider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if len(config.GroupsClaim) == 0 {
+		config.GroupsClaim = "groups"
+	}
+
+	p := &OIDCProvider{Config: config}
+
+	var metadata struct {
+		Issuer string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint string `json:"token_endpoint"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	err := p.getJSON(strings.TrimSuffix(config.IssuerURL, "/") + "/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider must identify itself with the configured issuer, or ID
+	// tokens from it would fail verification anyway.
+	if metadata.Issuer != config.IssuerURL {
+		return nil, fmt.Errorf("Provider issuer %q does not match %q.", metadata.Issuer, config.IssuerURL)
+	}
+
+	p.AuthorizationEndpoint = metadata.AuthorizationEndpoint
+	p.TokenEndpoint = metadata.TokenEndpoint
+	p.JWKSURI = metadata.JWKSURI
+
+	return p, nil
+}
+
+// getJSON fetches and decodes a JSON document from the provider.
+func (p *OIDCProvider) getJSON(u string, v interface{}) error {
+	res, err := p.Config.HTTPClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("Provider responded to %s with %d.", u, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// PKCEChallenge derives the S256 code challenge for a code verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL builds the URL that a user is sent to in order to log in with
+// the provider.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.Config.ClientID)
+	params.Set("redirect_uri", p.Config.RedirectURL)
+	params.Set("scope", "openid email profile")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", PKCEChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange trades an authorization code for the provider's tokens, and
+// returns the raw ID token.
+func (p *OIDCProvider) Exchange(code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("client_id", p.Config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(p.Config.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	res, err := p.Config.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error string `json:"error"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&tokens)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != 200 || len(tokens.Error) > 0 {
+		return "", fmt.Errorf("Token exchange failed with %d: %s", res.StatusCode, tokens.Error)
+	}
+	if len(tokens.IDToken) == 0 {
+		return "", errors.New("Provider did not return an ID token.")
+	}
+
+	return tokens.IDToken, nil
+}
+
+// key returns the provider's public key with an ID. The key set is fetched
+// again when an unknown key is requested, since the provider may have
+// rotated its keys.
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	err := p.getJSON(p.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (len(jwk.Use) > 0 && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.KeyID] = key
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, errors.New("Unknown provider signing key.")
+	}
+
+	return key, nil
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry and
+// nonce, and returns the identity it asserts.
+func (p *OIDCProvider) VerifyIDToken(raw, nonce string, now time.Time, skew time.Duration) (id OIDCIdentity, err error) {
+	parser := jwt.Parser {
+		ValidMethods: []string{jwt.SigningMethodRS256.Alg()},
+		SkipClaimsValidation: true,
+	}
+	token, err := parser.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		err = errors.New("ID token invalid.")
+		return
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Config.IssuerURL {
+		err = errors.New("ID token issuer invalid.")
+		return
+	}
+	if !claimHasAudience(claims, p.Config.ClientID) {
+		err = errors.New("ID token audience invalid.")
+		return
+	}
+	exp, ok := claimTime(claims, "exp")
+	if !ok || now.After(exp.Add(skew)) {
+		err = errors.New("ID token expired.")
+		return
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		err = errors.New("ID token nonce invalid.")
+		return
+	}
+
+	id.Subject, _ = claims["sub"].(string)
+	if len(id.Subject) == 0 {
+		err = errors.New("ID token has no subject.")
+		return
+	}
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.PreferredUsername, _ = claims["preferred_username"].(string)
+	id.Name, _ = claims["name"].(string)
+
+	// Groups may be a list or, with some providers, a single string.
+	switch groups := claims[p.Config.GroupsClaim].(type) {
+	case string:
+		id.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+
+	return
+}
+
+// InGroup reports whether an identity is a member of a group.
+func (id OIDCIdentity) InGroup(group string) bool {
+	for _, g := range id.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// usernameChars matches characters that are not allowed in generated
+// usernames.
+var usernameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// ProvisionOIDCUser finds the user for a verified identity, creating one if
+// this is their first login. Users are matched by subject, and then by
+// verified email address. If adminGroup is set, the user's admin status is
+// updated to match their membership.
+func ProvisionOIDCUser(id OIDCIdentity, adminGroup string, db *sql.DB) (u User, err error) {
+	u = NewUser(db)
+
+	// Look for a user who has logged in with this subject before.
+	err = db.QueryRow("SELECT id FROM users WHERE oidc_subject=?", id.Subject).Scan(&u.ID)
+	if err == sql.ErrNoRows && len(id.Email) > 0 && id.EmailVerified {
+		// Otherwise, link an existing account with the same email address,
+		// as long as that account proved it owns the address. Anyone can sign
+		// up with an address, so an unverified one proves nothing.
+		err = db.QueryRow("SELECT id FROM users WHERE email=? AND email_verified=TRUE", id.Email).Scan(&u.ID)
+		if err == nil {
+			_, err = db.Exec("UPDATE users SET oidc_subject=? WHERE id=?", id.Subject, u.ID)
+		} else if err == sql.ErrNoRows {
+			// The provider verified the address, so unverified signups lose
+			// it to the new account.
+			err = RevokeUnverifiedEmail(id.Email, db)
+			if err == nil {
+				err = sql.ErrNoRows
+			}
+		}
+	}
+
+	if err == nil {
+		err = u.Load()
+	} else if err == sql.ErrNoRows {
+		err = createOIDCUser(&u, id, db)
+	}
+	if err != nil {
+		return
+	}
+
+	// Keep admin status in sync with the provider's groups.
+	if len(adminGroup) > 0 && u.Admin != id.InGroup(adminGroup) {
+		u.Admin = id.InGroup(adminGroup)
+		err = u.Save()
+	}
+
+	return
+}
+
+// createOIDCUser creates a new user for an identity that has not logged in
+// before. The user gets an unusable random password, so they can only log in
+// through the provider until they set one.
+func createOIDCUser(u *User, id OIDCIdentity, db *sql.DB) error {
+	// Choose a username from the identity, making it unique if needed.
+	base := id.PreferredUsername
+	if len(base) == 0 {
+		base = strings.SplitN(id.Email, "@", 2)[0]
+	}
+	base = usernameChars.ReplaceAllString(base, "")
+	if len(base) == 0 {
+		base = "user"
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		exists, err := CheckUsernameExists(username, db)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			break
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+
+	password, err := RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	u.Username = username
+	u.Name.String = id.Name
+	u.Name.Valid = len(id.Name) > 0
+	u.Email.String = id.Email
+	u.Email.Valid = len(id.Email) > 0 && id.EmailVerified
+	u.EmailVerified = u.Email.Valid
+
+	// The password column is required, so the user is created with their
+	// unusable password in place.
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec("INSERT INTO users (username, name, email, email_verified, oidc_subject, auth_source, password, salt) VALUES (?, ?, ?, ?, ?, ?, ?, '')",
+		u.Username, u.Name, u.Email, u.EmailVerified, id.Subject, OIDC_AUTH_SOURCE, hash)
+	if err != nil {
+		return err
+	}
+	u.ID, err = res.LastInsertId()
+
+	return err
+}
@@ -0,0 +1,153 @@
+package csnotes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// The cookie that carries the state of a single sign-on attempt between
+	// leaving for the provider and returning to the callback.
+	OIDC_STATE_COOKIE = "oidc_state"
+	OIDC_STATE_PURPOSE = "oidc_state"
+
+	// How long a user has to log in at the provider.
+	OIDC_STATE_LIFETIME = time.Minute * 10
+)
+
+// GetLoginOIDC starts a single sign-on login by redirecting to the identity
+// provider. The state, nonce and PKCE verifier are kept in a signed cookie,
+// so no server-side session is needed.
+func GetLoginOIDC(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if context.OIDC == nil {
+			http.Error(w, "Single sign-on is not configured.", http.StatusNotFound)
+			return
+		}
+
+		// Create the values that tie the callback to this attempt.
+		values := map[string]string{}
+		for _, name := range []string{"state", "nonce", "verifier"} {
+			v, err := RandomToken(32)
+			if err != nil {
+				http.Error(w, "Could not start login.", http.StatusInternalServerError)
+				return
+			}
+			values[name] = v
+		}
+
+		cookie, err := context.signClaims(jwt.MapClaims {
+			"purpose": OIDC_STATE_PURPOSE,
+			"state": values["state"],
+			"nonce": values["nonce"],
+			"verifier": values["verifier"],
+		}, OIDC_STATE_LIFETIME)
+		if err != nil {
+			http.Error(w, "Could not start login.", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie {
+			Name: OIDC_STATE_COOKIE,
+			Value: cookie,
+			Path: "/login/oidc",
+			MaxAge: int(OIDC_STATE_LIFETIME / time.Second),
+			HttpOnly: true,
+			Secure: r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		authURL := context.OIDC.AuthCodeURL(values["state"], values["nonce"], values["verifier"])
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// GetLoginOIDCCallback finishes a single sign-on login. The authorization
+// code is exchanged for an ID token, the user is found or created, and the
+// browser is sent back to the login page with the app's own token in the URL
+// fragment, where it never reaches server logs.
+func GetLoginOIDCCallback(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if context.OIDC == nil {
+			http.Error(w, "Single sign-on is not configured.", http.StatusNotFound)
+			return
+		}
+
+		// Report errors from the provider, such as the user declining.
+		if e := r.FormValue("error"); len(e) > 0 {
+			http.Error(w, "Login failed: " + e, http.StatusForbidden)
+			return
+		}
+
+		// Recover the state of this attempt from the cookie.
+		state, nonce, verifier, err := readOIDCState(context, r)
+		if err != nil || r.FormValue("state") != state {
+			http.Error(w, "Invalid login state.", http.StatusBadRequest)
+			return
+		}
+
+		// The state is single-use.
+		http.SetCookie(w, &http.Cookie {
+			Name: OIDC_STATE_COOKIE,
+			Path: "/login/oidc",
+			MaxAge: -1,
+		})
+
+		rawIDToken, err := context.OIDC.Exchange(r.FormValue("code"), verifier)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Could not complete login.", http.StatusBadGateway)
+			return
+		}
+
+		identity, err := context.OIDC.VerifyIDToken(rawIDToken, nonce, context.Now(), context.ClockSkew)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Could not verify identity.", http.StatusForbidden)
+			return
+		}
+
+		user, err := ProvisionOIDCUser(identity, context.OIDC.Config.AdminGroup, context.DB)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Could not load user.", http.StatusInternalServerError)
+			return
+		}
+
+		tokenString, err := context.IssueToken(user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/login.html#token=" + url.QueryEscape(tokenString), http.StatusFound)
+	}
+}
+
+// readOIDCState verifies the state cookie and returns its values.
+func readOIDCState(context *Context, r *http.Request) (state, nonce, verifier string, err error) {
+	cookie, err := r.Cookie(OIDC_STATE_COOKIE)
+	if err != nil {
+		return
+	}
+
+	claims, err := context.parseClaims(cookie.Value)
+	if err != nil {
+		return
+	}
+	if purpose, _ := claims["purpose"].(string); purpose != OIDC_STATE_PURPOSE {
+		err = errors.New("Not a login state token.")
+		return
+	}
+
+	state, _ = claims["state"].(string)
+	nonce, _ = claims["nonce"].(string)
+	verifier, _ = claims["verifier"].(string)
+
+	return
+}
@@ -0,0 +1,204 @@
+package csnotes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// mockOIDCProvider is a minimal identity provider that issues ID tokens for
+// authorization codes registered by the test.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key *rsa.PrivateKey
+
+	// Codes mapped to the PKCE challenge and nonce they were issued for.
+	codes map[string][2]string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockOIDCProvider{key: key, codes: map[string][2]string{}}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string {
+			"issuer": m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint": m.server.URL + "/token",
+			"jwks_uri": m.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string][]JWK{"keys": {NewJWK("mock", &key.PublicKey)}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		issued, ok := m.codes[r.FormValue("code")]
+		if !ok || PKCEChallenge(r.FormValue("code_verifier")) != issued[0] {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims {
+			"iss": m.server.URL,
+			"aud": "client",
+			"sub": "subject-1",
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"nonce": issued[1],
+			"email": "user@example.com",
+			"email_verified": true,
+			"groups": []string{"staff", "notes-admins"},
+		})
+		token.Header["kid"] = "mock"
+		idToken, _ := token.SignedString(key)
+		writeJSON(w, map[string]string{"id_token": idToken})
+	})
+
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// TestOIDCFlow runs the authorization code flow against the mock provider,
+// up to the point where the user would be provisioned.
+func TestOIDCFlow(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	defer mock.server.Close()
+
+	now := time.Now()
+	context := newTestAuthContext(t, &now)
+
+	provider, err := DiscoverOIDCProvider(OIDCConfig {
+		IssuerURL: mock.server.URL,
+		ClientID: "client",
+		RedirectURL: "http://localhost/login/oidc/callback",
+		AdminGroup: "notes-admins",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	context.OIDC = provider
+
+	// Start the login and follow the redirect's parameters.
+	rec := httptest.NewRecorder()
+	GetLoginOIDC(context)(rec, httptest.NewRequest("GET", "/login/oidc", nil))
+	AssertEqual(302, rec.Code, t)
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := location.Query()
+	AssertEqual("S256", params.Get("code_challenge_method"), t)
+
+	// The provider issues a code for this challenge and nonce.
+	mock.codes["code-1"] = [2]string{params.Get("code_challenge"), params.Get("nonce")}
+
+	// The callback recovers the state from the cookie.
+	req := httptest.NewRequest("GET", "/login/oidc/callback?code=code-1&state=" + params.Get("state"), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	state, nonce, verifier, err := readOIDCState(context, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(params.Get("state"), state, t)
+
+	// A wrong verifier is refused by the provider.
+	_, err = provider.Exchange("code-1", "wrong")
+	AssertUnequal(nil, err, t)
+
+	rawIDToken, err := provider.Exchange("code-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The nonce must match the one sent with this attempt.
+	_, err = provider.VerifyIDToken(rawIDToken, "other", now, time.Minute)
+	AssertUnequal(nil, err, t)
+
+	identity, err := provider.VerifyIDToken(rawIDToken, nonce, now, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual("subject-1", identity.Subject, t)
+	AssertEqual("user@example.com", identity.Email, t)
+	AssertEqual(true, identity.InGroup("notes-admins"), t)
+}
+
+// TestOIDCUnverifiedEmail ensures that an account which never verified an
+// email address cannot capture the single sign-on login of its owner.
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// Someone signs up with another person's address, but never verifies it.
+	_, err = db.Exec("UPDATE users SET email=?, email_verified=FALSE WHERE id=?", "victim@example.com", ids["user.nonadmin"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateEmailToken(ids["user.nonadmin"], VERIFY_EMAIL_PURPOSE, VERIFY_EMAIL_LIFETIME, now, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While the link is valid, the address stays reserved for signups.
+	err = ReleaseUnverifiedEmail("victim@example.com", now, db)
+	AssertEqual(nil, err, t)
+	_, err = LoadUserByEmail("victim@example.com", db)
+	AssertEqual(nil, err, t)
+
+	// The owner's first single sign-on gets a new account, not that one.
+	u, err := ProvisionOIDCUser(OIDCIdentity {
+		Subject: "subject-1",
+		Email: "victim@example.com",
+		EmailVerified: true,
+		PreferredUsername: "victim",
+	}, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUnequal(ids["user.nonadmin"], u.ID, t)
+	AssertEqual("victim@example.com", u.Email.String, t)
+	AssertEqual(true, u.EmailVerified, t)
+
+	// And the impostor lost the address, along with their link.
+	impostor, err := LoadUser(ids["user.nonadmin"], db)
+	AssertEqual(nil, err, t)
+	AssertEqual(false, impostor.Email.Valid, t)
+	var links int
+	db.QueryRow("SELECT COUNT(*) FROM email_tokens WHERE user_id=?", ids["user.nonadmin"]).Scan(&links)
+	AssertEqual(0, links, t)
+
+	// An unverified signup also gives up its address once its link expires.
+	_, err = db.Exec("UPDATE users SET email=?, email_verified=FALSE WHERE id=?", "other@example.com", ids["user.admin"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CreateEmailToken(ids["user.admin"], VERIFY_EMAIL_PURPOSE, VERIFY_EMAIL_LIFETIME, now, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ReleaseUnverifiedEmail("other@example.com", now.Add(VERIFY_EMAIL_LIFETIME + time.Minute), db)
+	AssertEqual(nil, err, t)
+	_, err = LoadUserByEmail("other@example.com", db)
+	AssertUnequal(nil, err, t)
+}
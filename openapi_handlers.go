@@ -0,0 +1,20 @@
+package csnotes
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The OpenAPI 3 description of every route in CreateRouter. TestOpenAPIRoutes
+// fails when a route is added without being described here.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// GetOpenAPI serves the OpenAPI description of the app's routes.
+func GetOpenAPI(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	}
+}
@@ -0,0 +1,720 @@
+package csnotes
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The parts of the OpenAPI document that the tests check. Schemas are kept as
+// decoded JSON, and checked with schemaErrors.
+type openAPIMedia struct {
+	Schema map[string]interface{} `json:"schema"`
+	Example interface{} `json:"example"`
+}
+
+type openAPIOperation struct {
+	RequestBody *struct {
+		Content map[string]openAPIMedia `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]openAPIMedia `json:"content"`
+	} `json:"responses"`
+}
+
+// jsonResponse returns how the operation describes a successful JSON
+// response. The second value is false if it has none.
+func (o openAPIOperation) jsonResponse() (openAPIMedia, bool) {
+	media, ok := o.Responses["200"].Content["application/json"]
+	return media, ok
+}
+
+type openAPIPath struct {
+	Parameters []struct {
+		Name string `json:"name"`
+		In string `json:"in"`
+	} `json:"parameters"`
+	Operations map[string]openAPIOperation `json:"-"`
+}
+
+type openAPISpec struct {
+	Paths map[string]openAPIPath
+
+	// The shared schemas, which others refer to with $ref.
+	Schemas map[string]interface{}
+}
+
+// loadOpenAPI parses the served document into its paths and schemas.
+func loadOpenAPI(t *testing.T) openAPISpec {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	err := json.Unmarshal(openAPIDocument, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := openAPISpec {
+		Paths: map[string]openAPIPath{},
+		Schemas: doc.Components.Schemas,
+	}
+	for p, item := range doc.Paths {
+		path := openAPIPath {Operations: map[string]openAPIOperation{}}
+		for key, raw := range item {
+			if key == "parameters" {
+				err = json.Unmarshal(raw, &path.Parameters)
+			} else {
+				var o openAPIOperation
+				err = json.Unmarshal(raw, &o)
+				path.Operations[strings.ToUpper(key)] = o
+			}
+			if err != nil {
+				t.Fatalf("%s %s: %v", key, p, err)
+			}
+		}
+		spec.Paths[p] = path
+	}
+	return spec
+}
+
+// resolve follows a schema's $ref, and merges the schemas of an allOf into
+// one.
+func (spec openAPISpec) resolve(schema map[string]interface{}) map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, _ := spec.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+		return spec.resolve(target)
+	}
+
+	all, ok := schema["allOf"].([]interface{})
+	if !ok {
+		return schema
+	}
+	merged := map[string]interface{}{}
+	properties := map[string]interface{}{}
+	required := []interface{}{}
+	for _, part := range all {
+		p, _ := part.(map[string]interface{})
+		for k, v := range spec.resolve(p) {
+			switch k {
+			case "properties":
+				for name, prop := range v.(map[string]interface{}) {
+					properties[name] = prop
+				}
+			case "required":
+				required = append(required, v.([]interface{})...)
+			default:
+				merged[k] = v
+			}
+		}
+	}
+	for k, v := range schema {
+		if k != "allOf" {
+			merged[k] = v
+		}
+	}
+	merged["properties"] = properties
+	merged["required"] = required
+	return merged
+}
+
+// schemaErrors lists the ways in which a decoded JSON value does not match a
+// schema.
+func (spec openAPISpec) schemaErrors(v interface{}, schema map[string]interface{}, where string) (errs []string) {
+	schema = spec.resolve(schema)
+	if v == nil {
+		if schema["nullable"] == true || len(schema) == 0 {
+			return nil
+		}
+		return []string{where + " is null."}
+	}
+
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		for _, s := range one {
+			option, _ := s.(map[string]interface{})
+			if len(spec.schemaErrors(v, option, where)) == 0 {
+				return nil
+			}
+		}
+		return []string{where + " matches none of its schemas."}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		allowed := false
+		for _, e := range enum {
+			allowed = allowed || e == v
+		}
+		if !allowed {
+			errs = append(errs, fmt.Sprintf("%s: %v is not one of %v.", where, v, enum))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: got %T, expected an object.", where, v))
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := o[r.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: %s is missing.", where, r))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for k, field := range o {
+			if p, ok := properties[k].(map[string]interface{}); ok {
+				errs = append(errs, spec.schemaErrors(field, p, where + "." + k)...)
+			} else if additional != nil {
+				errs = append(errs, spec.schemaErrors(field, additional, where + "." + k)...)
+			} else if properties != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s is not described.", where, k))
+			}
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: got %T, expected an array.", where, v))
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, e := range a {
+			errs = append(errs, spec.schemaErrors(e, items, fmt.Sprintf("%s[%d]", where, i))...)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			errs = append(errs, fmt.Sprintf("%s: got %T, expected a string.", where, v))
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != math.Trunc(f) {
+			errs = append(errs, fmt.Sprintf("%s: got %v, expected an integer.", where, v))
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: got %T, expected a number.", where, v))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: got %T, expected a boolean.", where, v))
+		}
+	}
+	return
+}
+
+var routeVariable = regexp.MustCompile(`{([a-z_]+):[^}]*}`)
+
+// routeOperations lists each method and path of a router, as "GET /api/note",
+// with variable patterns left out of the paths as OpenAPI writes them.
+func routeOperations(router *mux.Router, t *testing.T) []string {
+	var ops []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Prefixes that other routers are mounted on have no methods.
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			ops = append(ops, m + " " + routeVariable.ReplaceAllString(template, "{$1}"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+// TestOpenAPIRoutes ensures that the document describes exactly the routes the
+// app serves, and that its examples match their schemas.
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadOpenAPI(t)
+	context := &Context {}
+
+	routed := map[string]bool{}
+	ops := append(routeOperations(CreateRouter(context), t), routeOperations(createAPIRouter(context), t)...)
+	for _, op := range ops {
+		routed[op] = true
+		parts := strings.SplitN(op, " ", 2)
+		if _, ok := spec.Paths[parts[1]].Operations[parts[0]]; !ok {
+			t.Errorf("%s is not documented.", op)
+		}
+	}
+
+	for p, path := range spec.Paths {
+		// Every variable of the path must be described.
+		declared := map[string]bool{}
+		for _, param := range path.Parameters {
+			if param.In == "path" {
+				declared[param.Name] = true
+			}
+		}
+		for _, m := range regexp.MustCompile(`{([a-z_]+)}`).FindAllStringSubmatch(p, -1) {
+			if !declared[m[1]] {
+				t.Errorf("%s does not describe the {%s} parameter.", p, m[1])
+			}
+		}
+
+		for method, o := range path.Operations {
+			op := method + " " + p
+			if !routed[op] {
+				t.Errorf("%s is documented, but not routed.", op)
+			}
+
+			// Every JSON response has an example, which TestOpenAPIExamples
+			// compares to what the handler sends.
+			if media, ok := o.jsonResponse(); ok {
+				if media.Example == nil {
+					t.Errorf("%s has no example response.", op)
+				} else {
+					for _, err := range spec.schemaErrors(media.Example, media.Schema, op + " example response") {
+						t.Error(err)
+					}
+				}
+			}
+
+			// Example forms must have every required field, of the right
+			// type, and no others.
+			if o.RequestBody == nil {
+				continue
+			}
+			for _, media := range o.RequestBody.Content {
+				if media.Example == nil {
+					continue
+				}
+				for _, err := range spec.schemaErrors(media.Example, media.Schema, op + " example form") {
+					t.Error(err)
+				}
+			}
+		}
+	}
+}
+
+// assertSameShape fails unless two decoded JSON values have the same keys and
+// types, comparing the first elements of arrays. Maps, which a schema gives
+// additionalProperties rather than properties, are compared by their values
+// instead of their keys.
+func (spec openAPISpec) assertSameShape(actual, expected interface{}, schema map[string]interface{}, where string, t *testing.T) {
+	schema = spec.resolve(schema)
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			if actual != nil || schema["nullable"] != true {
+				t.Errorf("%s: got %T, expected an object.", where, actual)
+			}
+			return
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		if properties == nil {
+			additional, _ := schema["additionalProperties"].(map[string]interface{})
+			if additional == nil {
+				return
+			}
+			for k, v := range a {
+				for _, ev := range e {
+					spec.assertSameShape(v, ev, additional, where + "." + k, t)
+					break
+				}
+			}
+			return
+		}
+
+		for k, v := range e {
+			if _, ok := a[k]; !ok {
+				t.Errorf("%s: %s is missing.", where, k)
+				continue
+			}
+			p, _ := properties[k].(map[string]interface{})
+			spec.assertSameShape(a[k], v, p, where + "." + k, t)
+		}
+		for k := range a {
+			if _, ok := e[k]; !ok {
+				t.Errorf("%s: %s is not in the example.", where, k)
+			}
+		}
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			t.Errorf("%s: got %T, expected an array.", where, actual)
+			return
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		if len(e) > 0 && len(a) > 0 {
+			spec.assertSameShape(a[0], e[0], items, where + "[0]", t)
+		}
+	case nil:
+		// A missing value says nothing of the shape of one that is there.
+	default:
+		if fmt.Sprintf("%T", actual) != fmt.Sprintf("%T", expected) {
+			t.Errorf("%s: got %T, expected %T.", where, actual, expected)
+		}
+	}
+}
+
+// exampleRequest is an example request that is about to be sent, which a
+// fixture can change to suit what it put in the database.
+type exampleRequest struct {
+	context *Context
+	ids map[string]int64
+
+	// The user who sends the request.
+	userID int64
+
+	// The values of the path's variables, by name.
+	vars map[string]int64
+
+	form url.Values
+
+	// A file to upload as the "file" field of a multipart form.
+	filename string
+	file []byte
+}
+
+// note loads the seeded note that examples are sent for.
+func (r *exampleRequest) note(t *testing.T) Note {
+	n, err := LoadNote(r.ids["note.note1"], r.context.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// checklist turns the note into a checklist with one item.
+func (r *exampleRequest) checklist(t *testing.T) {
+	n := r.note(t)
+	err := n.ConvertToChecklist(r.context.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	is, err := n.Items()
+	if err != nil || len(is) == 0 {
+		t.Fatal("No items.", err)
+	}
+	r.vars["item_id"] = is[0].ID
+}
+
+// attach attaches a file to the note, and makes it the note's cover if it
+// is an image.
+func (r *exampleRequest) attach(filename string, data []byte, t *testing.T) {
+	n := r.note(t)
+	a, err := n.Attach(r.context.Blobs, filename, bytes.NewReader(data), r.context.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.vars["attachment_id"] = a.ID
+
+	if strings.HasSuffix(filename, ".jpg") {
+		_, err = n.SetCover(a, r.context.Blobs)
+		if err == nil {
+			err = r.context.Thumbnails.Process(a.ID)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// beginTOTP starts two-factor enrollment for the user, and returns a valid
+// code.
+func (r *exampleRequest) beginTOTP(t *testing.T) string {
+	secret, err := BeginTOTPEnrollment(r.userID, r.context.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := TOTPCode(secret, TOTPStep(r.context.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// zipOf builds a zip archive of files.
+func zipOf(files map[string]string, t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exampleFixtures prepare the database for the examples that need more than
+// the seeded notes and tags, by operation.
+var exampleFixtures = map[string]func(r *exampleRequest, t *testing.T) {
+	"POST /login/totp": func(r *exampleRequest, t *testing.T) {
+		code := r.beginTOTP(t)
+		_, err := r.context.DB.Exec("UPDATE users SET totp_enabled=TRUE WHERE id=?", r.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		challenge, err := r.context.IssueChallenge(r.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.form.Set("challenge", challenge)
+		r.form.Set("code", code)
+	},
+	"POST /password/reset": func(r *exampleRequest, t *testing.T) {
+		token, err := CreateEmailToken(r.userID, RESET_PASSWORD_PURPOSE, time.Hour, r.context.Now(), r.context.DB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.form.Set("token", token)
+	},
+	"GET /api/user": func(r *exampleRequest, t *testing.T) {
+		r.userID = r.ids["user.admin"]
+	},
+	"POST /api/user": func(r *exampleRequest, t *testing.T) {
+		r.userID = r.ids["user.admin"]
+	},
+	"DELETE /api/user/{id}/totp": func(r *exampleRequest, t *testing.T) {
+		r.userID = r.ids["user.admin"]
+	},
+	"POST /api/user/{id}/totp/confirm": func(r *exampleRequest, t *testing.T) {
+		r.form.Set("code", r.beginTOTP(t))
+	},
+	"GET /api/token": func(r *exampleRequest, t *testing.T) {
+		at := NewAPIToken(r.context.DB)
+		at.Name = "backup script"
+		at.Scopes = []string{"notes:read"}
+		at.Created = r.context.Now().UTC().Format(DATETIME_FORMAT)
+		at.UserID = r.userID
+		if err := at.Save(); err != nil {
+			t.Fatal(err)
+		}
+		r.vars["id"] = at.ID
+	},
+	"POST /api/note/pin": func(r *exampleRequest, t *testing.T) {
+		r.form.Set("ids", fmt.Sprintf("%d,%d", r.ids["note.note1"], r.ids["note.note2"]))
+	},
+	"GET /api/note/{id}/item": func(r *exampleRequest, t *testing.T) {
+		r.checklist(t)
+	},
+	"PUT /api/note/{id}/item/order": func(r *exampleRequest, t *testing.T) {
+		r.checklist(t)
+		r.form.Set("ids", strconv.FormatInt(r.vars["item_id"], 10))
+	},
+	"GET /api/note/{id}/attachment": func(r *exampleRequest, t *testing.T) {
+		r.attach("notes.txt", []byte("Pack light."), t)
+	},
+	"POST /api/note/{id}/attachment": func(r *exampleRequest, t *testing.T) {
+		r.filename, r.file = "notes.txt", []byte("Pack light.")
+	},
+	"GET /api/note/{id}/cover": func(r *exampleRequest, t *testing.T) {
+		r.attach("whiteboard.jpg", testJPEG(40, 20, 0, nil, t), t)
+	},
+	"PUT /api/note/{id}/cover": func(r *exampleRequest, t *testing.T) {
+		r.filename, r.file = "whiteboard.jpg", testJPEG(40, 20, 0, nil, t)
+	},
+	"GET /api/note/{id}/links": func(r *exampleRequest, t *testing.T) {
+		// Each seeded note links to the other.
+		for _, pair := range [][2]string{{"note.note1", "note2"}, {"note.note2", "note1"}} {
+			n, err := LoadNote(r.ids[pair[0]], r.context.DB)
+			if err == nil {
+				n.Content.String = "See [[" + pair[1] + "]]."
+				err = n.Save(r.context.Now())
+			}
+			if err == nil {
+				err = n.SyncLinks()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	},
+	"GET /api/note/{id}/reminder": func(r *exampleRequest, t *testing.T) {
+		rc, _ := ParseRecurrence("weekly")
+		rm := NewReminder(r.context.DB)
+		rm.NoteID = r.ids["note.note1"]
+		rm.UserID = r.userID
+		rm.Recurrence = "weekly"
+		rm.Channels = []string{"events"}
+		rm.Schedule(r.context.Now().Add(time.Hour), rc, r.context.Now())
+		if err := rm.Save(); err != nil {
+			t.Fatal(err)
+		}
+	},
+	"POST /api/import/keep": func(r *exampleRequest, t *testing.T) {
+		r.filename = "takeout.zip"
+		r.file = zipOf(map[string]string {
+			"Takeout/Keep/trip.json": `{"title": "Trip", "textContent": "Pack", "labels": [{"name": "travel"}]}`,
+		}, t)
+	},
+	"POST /api/import/enex": func(r *exampleRequest, t *testing.T) {
+		r.filename = "notes.enex"
+		r.file = []byte(`<?xml version="1.0"?><en-export><note><title>Trip</title>` +
+			`<content><![CDATA[<?xml version="1.0"?><en-note><div>Pack</div></en-note>]]></content></note></en-export>`)
+	},
+	"POST /api/import/markdown": func(r *exampleRequest, t *testing.T) {
+		r.filename = "notes.zip"
+		r.file = zipOf(map[string]string{"Trip.md": "---\ntags: [travel]\n---\n\nPack\n"}, t)
+	},
+}
+
+func init() {
+	// Some examples need the same things as others.
+	for op, like := range map[string]string {
+		"DELETE /api/token/{id}": "GET /api/token",
+		"POST /api/note/archive": "POST /api/note/pin",
+		"POST /api/note/color": "POST /api/note/pin",
+		"POST /api/note/{id}/item": "GET /api/note/{id}/item",
+		"PUT /api/note/{id}/item/{item_id}": "GET /api/note/{id}/item",
+		"DELETE /api/note/{id}/item/{item_id}": "GET /api/note/{id}/item",
+		"POST /api/note/{id}/item/{item_id}/toggle": "GET /api/note/{id}/item",
+		"DELETE /api/note/{id}/attachment/{attachment_id}": "GET /api/note/{id}/attachment",
+		"DELETE /api/note/{id}/cover": "GET /api/note/{id}/cover",
+		"GET /api/note/{id}/backlinks": "GET /api/note/{id}/links",
+		"DELETE /api/note/{id}/reminder": "GET /api/note/{id}/reminder",
+		"GET /api/reminder": "GET /api/note/{id}/reminder",
+	} {
+		exampleFixtures[op] = exampleFixtures[like]
+	}
+}
+
+// TestOpenAPIExamples ensures that the example responses look like what the
+// handlers send for the example requests.
+func TestOpenAPIExamples(t *testing.T) {
+	spec := loadOpenAPI(t)
+
+	// Run the examples in a fixed order, so that failures are repeatable.
+	var ops []string
+	for p, path := range spec.Paths {
+		for method, o := range path.Operations {
+			if _, ok := o.jsonResponse(); ok {
+				ops = append(ops, method + " " + p)
+			}
+		}
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		parts := strings.SplitN(op, " ", 2)
+		method, p := parts[0], parts[1]
+		o := spec.Paths[p].Operations[method]
+
+		// Each example runs against a freshly seeded database.
+		db, ids, err := SeededTestDB()
+		if err != nil {
+			TearDownDbTest(db)
+			t.Fatal(err)
+		}
+		now := time.Now()
+		context, router := NewTestApp(t, db, &now)
+		context.Mailer = &LogMailer{}
+		context.Blobs = &LocalBlobStore{Dir: t.TempDir()}
+		context.Thumbnails = NewThumbnailer(db, context.Blobs, 1)
+		context.ReminderEvents = NewReminderBroker()
+		context.Reminders = &ReminderScheduler {
+			DB: db,
+			Channels: map[string]ReminderChannel{"events": context.ReminderEvents},
+		}
+
+		// Send the example form, for the seeded notes and tags.
+		r := &exampleRequest {
+			context: context,
+			ids: ids,
+			userID: ids["user.nonadmin"],
+			vars: map[string]int64{"id": ids["note.note1"], "tag_id": ids["tag.tag1"]},
+			form: url.Values{},
+		}
+		if strings.HasPrefix(p, "/api/user/") {
+			r.vars["id"] = ids["user.nonadmin"]
+		}
+		if o.RequestBody != nil {
+			example, _ := o.RequestBody.Content["application/x-www-form-urlencoded"].Example.(map[string]interface{})
+			for k, v := range example {
+				r.form.Set(k, fmt.Sprint(v))
+			}
+		}
+		if fixture, ok := exampleFixtures[op]; ok {
+			fixture(r, t)
+		}
+
+		target := p
+		for name, id := range r.vars {
+			target = strings.Replace(target, "{" + name + "}", strconv.FormatInt(id, 10), 1)
+		}
+
+		var body io.Reader = strings.NewReader(r.form.Encode())
+		contentType := "application/x-www-form-urlencoded"
+		if r.file != nil {
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			fw, _ := mw.CreateFormFile("file", r.filename)
+			fw.Write(r.file)
+			mw.Close()
+			body, contentType = &buf, mw.FormDataContentType()
+		}
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("Content-Type", contentType)
+
+		u, err := LoadUser(r.userID, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := context.IssueToken(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer " + token)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		TearDownDbTest(db)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got %d: %s", op, rec.Code, rec.Body.String())
+			continue
+		}
+		var actual interface{}
+		err = json.Unmarshal(rec.Body.Bytes(), &actual)
+		if err != nil {
+			t.Errorf("%s: %v", op, err)
+			continue
+		}
+
+		// Rejected fields are still sent with a 200.
+		if m, ok := actual.(map[string]interface{}); ok {
+			if fields, _ := m["fields"].(map[string]interface{}); len(fields) > 0 {
+				t.Errorf("%s: the example form was rejected: %v", op, fields)
+				continue
+			}
+		}
+
+		media, _ := o.jsonResponse()
+		for _, err := range spec.schemaErrors(actual, media.Schema, op + " response") {
+			t.Error(err)
+		}
+		spec.assertSameShape(actual, media.Example, media.Schema, op, t)
+	}
+}
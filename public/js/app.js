@@ -38,9 +38,9 @@ function getNoteShowFunc(id) {
     $('#note-editor').hide();
     $('#note-viewer').show();
     
-    // Request the note's data.
+    // Request the note's data, with its content rendered from Markdown.
     $.ajax({
-      url: getNoteUrl(id),
+      url: getNoteUrl(id) + '?render=html',
       type: 'GET',
       headers: {
         'Authorization': getAuthHeader()
@@ -51,7 +51,8 @@ function getNoteShowFunc(id) {
         // Display the note's data.
         $('#note-title').text(data.models[0].title);
         $('#note-date').text(data.models[0].date);
-        $('#note-content').text(data.models[0].content.String);
+        // The server sanitizes the rendered HTML.
+        $('#note-content').html(data.models[0].content_html || '');
       } else if (data.errors.length > 0) {
         // Display any errors.
         console.log(data.errors);
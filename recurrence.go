@@ -0,0 +1,237 @@
+package csnotes
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The most occurrences Next will step through before giving up. This bounds
+// the work done for rules that never produce another occurrence.
+const RECURRENCE_MAX_STEPS = 100000
+
+// Recurrence is a repeating schedule. It supports the common subset of RFC
+// 5545 recurrence rules: FREQ, INTERVAL, BYDAY (weekly rules only), UNTIL and
+// COUNT.
+type Recurrence struct {
+	// DAILY, WEEKLY, MONTHLY or YEARLY. Empty for a one-off reminder.
+	Freq string
+
+	// The number of periods between occurrences. At least 1.
+	Interval int
+
+	// The days of the week a weekly rule falls on. If empty, the day of the
+	// first occurrence is used.
+	ByDay []time.Weekday
+
+	// The last time an occurrence may fall on. Zero for no limit.
+	Until time.Time
+
+	// The total number of occurrences, including the first. Zero for no
+	// limit.
+	Count int
+}
+
+var rruleWeekdays = map[string]time.Weekday {
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// ParseRecurrence reads a recurrence. It accepts an empty string for none,
+// the shorthands "daily" and "weekly", or an RRULE such as
+// "RRULE:FREQ=WEEKLY;BYDAY=MO,WE". The "RRULE:" prefix is optional.
+func ParseRecurrence(s string) (rc Recurrence, err error) {
+	rc.Interval = 1
+
+	switch strings.ToLower(s) {
+	case "":
+		return
+	case "daily":
+		rc.Freq = "DAILY"
+		return
+	case "weekly":
+		rc.Freq = "WEEKLY"
+		return
+	}
+
+	rule := strings.TrimPrefix(strings.ToUpper(s), "RRULE:")
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rc, errors.New("Invalid recurrence rule part: " + part)
+		}
+
+		switch kv[0] {
+		case "FREQ":
+			switch kv[1] {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rc.Freq = kv[1]
+			default:
+				return rc, errors.New("Unsupported recurrence frequency: " + kv[1])
+			}
+
+		case "INTERVAL":
+			rc.Interval, err = strconv.Atoi(kv[1])
+			if err != nil || rc.Interval < 1 {
+				return rc, errors.New("Invalid recurrence interval.")
+			}
+
+		case "BYDAY":
+			for _, d := range strings.Split(kv[1], ",") {
+				wd, ok := rruleWeekdays[d]
+				if !ok {
+					return rc, errors.New("Invalid recurrence day: " + d)
+				}
+				rc.ByDay = append(rc.ByDay, wd)
+			}
+
+		case "UNTIL":
+			rc.Until, err = parseRRULETime(kv[1])
+			if err != nil {
+				return rc, errors.New("Invalid recurrence end time.")
+			}
+
+		case "COUNT":
+			rc.Count, err = strconv.Atoi(kv[1])
+			if err != nil || rc.Count < 1 {
+				return rc, errors.New("Invalid recurrence count.")
+			}
+
+		default:
+			return rc, errors.New("Unsupported recurrence rule part: " + kv[0])
+		}
+	}
+
+	if len(rc.Freq) == 0 {
+		return rc, errors.New("Recurrence rule has no frequency.")
+	}
+	if len(rc.ByDay) > 0 && rc.Freq != "WEEKLY" {
+		return rc, errors.New("BYDAY is only supported for weekly rules.")
+	}
+
+	return
+}
+
+// parseRRULETime reads an UNTIL value, which is either a date or a UTC date
+// and time.
+func parseRRULETime(s string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102", s); err == nil {
+		// A date includes the whole of that day.
+		return t.Add(24 * time.Hour - time.Second), nil
+	}
+	return time.Parse("20060102T150405", s)
+}
+
+// Repeats reports whether the recurrence has more than one occurrence.
+func (rc Recurrence) Repeats() bool {
+	return len(rc.Freq) > 0 && rc.Count != 1
+}
+
+// Next finds the first occurrence after a time, given the time of the first
+// occurrence. It also returns how many occurrences came before it, counting
+// the first. If the schedule has ended, ok is false.
+func (rc Recurrence) Next(start, after time.Time) (next time.Time, n int, ok bool) {
+	if !rc.Repeats() {
+		return start, 0, start.After(after)
+	}
+
+	interval := rc.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	// Weekly rules step through each period's days in order.
+	days := []int{0}
+	if rc.Freq == "WEEKLY" && len(rc.ByDay) > 0 {
+		days = nil
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			for _, d := range rc.ByDay {
+				if d == wd {
+					days = append(days, int(wd - start.Weekday()))
+					break
+				}
+			}
+		}
+	}
+
+	for period := 0; period < RECURRENCE_MAX_STEPS; period++ {
+		var base time.Time
+		switch rc.Freq {
+		case "DAILY":
+			base = start.AddDate(0, 0, period * interval)
+		case "WEEKLY":
+			base = start.AddDate(0, 0, period * interval * 7)
+		case "MONTHLY":
+			base = start.AddDate(0, period * interval, 0)
+			// Months without the start's day are skipped, rather than
+			// spilling into the next month.
+			if base.Day() != start.Day() {
+				continue
+			}
+		case "YEARLY":
+			base = start.AddDate(period * interval, 0, 0)
+			if base.Day() != start.Day() {
+				continue
+			}
+		}
+
+		for _, d := range days {
+			t := base.AddDate(0, 0, d)
+
+			// Days of the first week that come before the start are not
+			// occurrences.
+			if t.Before(start) {
+				continue
+			}
+			if !rc.Until.IsZero() && t.After(rc.Until) {
+				return next, n, false
+			}
+			if rc.Count > 0 && n >= rc.Count {
+				return next, n, false
+			}
+			if t.After(after) {
+				return t, n, true
+			}
+			n++
+		}
+	}
+
+	return next, n, false
+}
+
+// String formats the recurrence as an RRULE value, without the "RRULE:"
+// prefix. It is empty for a one-off reminder.
+func (rc Recurrence) String() string {
+	if len(rc.Freq) == 0 {
+		return ""
+	}
+
+	parts := []string{"FREQ=" + rc.Freq}
+	if rc.Interval > 1 {
+		parts = append(parts, "INTERVAL=" + strconv.Itoa(rc.Interval))
+	}
+	if len(rc.ByDay) > 0 {
+		var days []string
+		for _, d := range rc.ByDay {
+			days = append(days, strings.ToUpper(d.String()[:2]))
+		}
+		parts = append(parts, "BYDAY=" + strings.Join(days, ","))
+	}
+	if !rc.Until.IsZero() {
+		parts = append(parts, "UNTIL=" + rc.Until.UTC().Format("20060102T150405Z"))
+	}
+	if rc.Count > 0 {
+		parts = append(parts, "COUNT=" + strconv.Itoa(rc.Count))
+	}
+
+	return strings.Join(parts, ";")
+}
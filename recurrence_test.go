@@ -0,0 +1,80 @@
+package csnotes
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseRecurrence ensures that shorthands and RRULEs are read, and that
+// unsupported rules are refused.
+func TestParseRecurrence(t *testing.T) {
+	rc, err := ParseRecurrence("daily")
+	AssertEqual(nil, err, t)
+	AssertEqual("DAILY", rc.Freq, t)
+	AssertEqual(1, rc.Interval, t)
+
+	rc, err = ParseRecurrence("RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=4")
+	AssertEqual(nil, err, t)
+	AssertEqual("WEEKLY", rc.Freq, t)
+	AssertEqual(2, rc.Interval, t)
+	AssertEqual(2, len(rc.ByDay), t)
+	AssertEqual(4, rc.Count, t)
+
+	rc, err = ParseRecurrence("")
+	AssertEqual(nil, err, t)
+	AssertEqual(false, rc.Repeats(), t)
+
+	for _, s := range []string{"hourly", "FREQ=SECONDLY", "FREQ=DAILY;BYDAY=MO", "INTERVAL=2", "FREQ=DAILY;INTERVAL=0"} {
+		_, err = ParseRecurrence(s)
+		AssertUnequal(nil, err, t)
+	}
+}
+
+// TestRecurrenceNext ensures that occurrences are found after a time, and
+// that rules stop at their end.
+func TestRecurrenceNext(t *testing.T) {
+	// A Wednesday.
+	start := time.Date(2017, 11, 1, 9, 0, 0, 0, time.UTC)
+
+	// One-off reminders only occur once.
+	rc, _ := ParseRecurrence("")
+	next, _, ok := rc.Next(start, start.Add(-time.Minute))
+	AssertEqual(true, ok, t)
+	AssertEqual(start, next, t)
+	_, _, ok = rc.Next(start, start)
+	AssertEqual(false, ok, t)
+
+	// Daily reminders skip to the next day after a time.
+	rc, _ = ParseRecurrence("daily")
+	next, n, ok := rc.Next(start, start.Add(50 * time.Hour))
+	AssertEqual(true, ok, t)
+	AssertEqual(time.Date(2017, 11, 4, 9, 0, 0, 0, time.UTC), next, t)
+	AssertEqual(3, n, t)
+
+	// Weekly rules with days fall on those days, from the start onwards.
+	rc, _ = ParseRecurrence("FREQ=WEEKLY;BYDAY=MO,FR")
+	next, _, _ = rc.Next(start, start)
+	AssertEqual(time.Date(2017, 11, 3, 9, 0, 0, 0, time.UTC), next, t)
+	next, _, _ = rc.Next(start, next)
+	AssertEqual(time.Date(2017, 11, 6, 9, 0, 0, 0, time.UTC), next, t)
+
+	// Monthly rules skip months without the start's day.
+	rc, _ = ParseRecurrence("FREQ=MONTHLY")
+	jan31 := time.Date(2018, 1, 31, 9, 0, 0, 0, time.UTC)
+	next, _, _ = rc.Next(jan31, jan31)
+	AssertEqual(time.Date(2018, 3, 31, 9, 0, 0, 0, time.UTC), next, t)
+
+	// Counts include the first occurrence.
+	rc, _ = ParseRecurrence("FREQ=DAILY;COUNT=2")
+	_, _, ok = rc.Next(start, start)
+	AssertEqual(true, ok, t)
+	_, _, ok = rc.Next(start, start.Add(24 * time.Hour))
+	AssertEqual(false, ok, t)
+
+	// Nothing falls after the end.
+	rc, _ = ParseRecurrence("FREQ=DAILY;UNTIL=20171102")
+	_, _, ok = rc.Next(start, start)
+	AssertEqual(true, ok, t)
+	_, _, ok = rc.Next(start, start.Add(24 * time.Hour))
+	AssertEqual(false, ok, t)
+}
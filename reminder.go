@@ -0,0 +1,151 @@
+package csnotes
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// The channel reminders are delivered through when none are chosen.
+const DEFAULT_REMINDER_CHANNEL = "events"
+
+// Reminder schedules a note to be brought to its owner's attention, once or
+// repeatedly. A note has at most one reminder.
+type Reminder struct {
+	Resource
+	NoteID int64 `json:"note_id"`
+	UserID int64 `json:"-"`
+
+	// The first occurrence, which recurrences are counted from.
+	Starts string `json:"starts"`
+
+	// The next occurrence that has not fired yet.
+	RemindAt string `json:"remind_at"`
+
+	// How the reminder repeats, as accepted by ParseRecurrence.
+	Recurrence string `json:"recurrence"`
+
+	// The names of the channels the reminder is delivered through.
+	Channels []string `json:"channels"`
+
+	// Where the webhook channel posts the reminder.
+	WebhookURL sql.NullString `json:"webhook_url"`
+
+	// Set once the last occurrence has fired.
+	Done bool `json:"done"`
+}
+
+// NewReminder creates a new reminder model with no ID or any fields set.
+func NewReminder(db *sql.DB) Reminder {
+	return Reminder {
+		Resource: Resource {
+			DB: db,
+			Table: "reminders",
+		},
+	}
+}
+
+// LoadReminder attempts to load a reminder's fields from the database, given
+// its ID.
+func LoadReminder(id int64, db *sql.DB) (rm Reminder, err error) {
+	rm = NewReminder(db)
+	rm.ID = id
+	err = rm.Load()
+
+	return
+}
+
+// LoadNoteReminder loads the reminder of a note.
+func LoadNoteReminder(nID int64, db *sql.DB) (rm Reminder, err error) {
+	rm = NewReminder(db)
+	err = db.QueryRow("SELECT id FROM reminders WHERE note_id=?", nID).Scan(&rm.ID)
+	if err != nil {
+		return
+	}
+	err = rm.Load()
+
+	return
+}
+
+func (rm *Reminder) Load() error {
+	var channels string
+	err := rm.Select([]string{"note_id", "user_id", "starts", "remind_at", "recurrence", "channels", "webhook_url", "done"},
+		&rm.NoteID, &rm.UserID, &rm.Starts, &rm.RemindAt, &rm.Recurrence, &channels, &rm.WebhookURL, &rm.Done)
+	if err != nil {
+		return err
+	}
+
+	rm.Channels = splitChannels(channels)
+	return nil
+}
+
+func (rm *Reminder) Save() error {
+	return rm.Sync([]string{"note_id", "user_id", "starts", "remind_at", "recurrence", "channels", "webhook_url", "done"},
+		rm.NoteID, rm.UserID, rm.Starts, rm.RemindAt, rm.Recurrence, strings.Join(rm.Channels, ","), rm.WebhookURL, rm.Done)
+}
+
+// Schedule sets when the reminder first fires and how it repeats, and
+// points it at its next occurrence after now.
+func (rm *Reminder) Schedule(starts time.Time, rc Recurrence, now time.Time) {
+	rm.Starts = starts.UTC().Format(DATETIME_FORMAT)
+
+	next, _, ok := rc.Next(starts.UTC(), now.UTC())
+	if !ok {
+		// Nothing is left to fire. The start is kept as the next time so
+		// that the reminder still sorts sensibly.
+		next = starts.UTC()
+	}
+	rm.RemindAt = next.Format(DATETIME_FORMAT)
+	rm.Done = !ok
+}
+
+// LoadUpcomingReminders loads a user's reminders that have not finished,
+// soonest first. Admins may pass a user ID of zero to see everyone's.
+func LoadUpcomingReminders(uID int64, before time.Time, db *sql.DB) (rms []Reminder, err error) {
+	rms = []Reminder{}
+
+	query := "SELECT id FROM reminders WHERE done=FALSE AND remind_at<=?"
+	args := []interface{}{before.UTC().Format(DATETIME_FORMAT)}
+	if uID != 0 {
+		query += " AND user_id=?"
+		args = append(args, uID)
+	}
+	query += " ORDER BY remind_at"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return
+	}
+
+	// Collect the IDs first, so that the connection is free to load each
+	// reminder.
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		rm, err := LoadReminder(id, db)
+		if err != nil {
+			return rms, err
+		}
+		rms = append(rms, rm)
+	}
+
+	return
+}
+
+// ParseReminderTime reads a time sent by a client, either as RFC 3339 or in
+// the database's layout, which is taken to be UTC.
+func ParseReminderTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(DATETIME_FORMAT, s)
+}
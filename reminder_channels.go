@@ -0,0 +1,247 @@
+package csnotes
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How many recent events the event stream keeps for clients that reconnect.
+const REMINDER_EVENT_BACKLOG = 100
+
+// ReminderBroker is the "events" channel. It passes reminders to clients
+// listening on the event stream, and keeps the most recent ones so that a
+// client that reconnects can catch up on what it missed.
+type ReminderBroker struct {
+	mu sync.Mutex
+	seq int64
+	recent []ReminderEvent
+
+	// Closed and replaced whenever an event arrives, which wakes every
+	// waiting listener.
+	changed chan struct{}
+
+	closed chan struct{}
+	closeOnce sync.Once
+}
+
+// NewReminderBroker creates an event stream with no listeners.
+func NewReminderBroker() *ReminderBroker {
+	return &ReminderBroker {
+		changed: make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+func (b *ReminderBroker) Deliver(e ReminderEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.EventID = b.seq
+	b.recent = append(b.recent, e)
+	if len(b.recent) > REMINDER_EVENT_BACKLOG {
+		b.recent = b.recent[len(b.recent) - REMINDER_EVENT_BACKLOG:]
+	}
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+
+	return nil
+}
+
+// Since returns a user's events that came after an event ID, and a channel
+// that is closed when more events arrive.
+func (b *ReminderBroker) Since(uID int64, eventID int64) (es []ReminderEvent, wait <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, e := range b.recent {
+		if e.UserID == uID && e.EventID > eventID {
+			es = append(es, e)
+		}
+	}
+
+	return es, b.changed
+}
+
+// LastEventID returns the ID of the newest event, which new listeners start
+// from.
+func (b *ReminderBroker) LastEventID() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.seq
+}
+
+// Done returns a channel that is closed when the broker is closed.
+func (b *ReminderBroker) Done() <-chan struct{} {
+	return b.closed
+}
+
+// Close ends every listener's stream. It is called when the server shuts
+// down, which would otherwise wait on the open streams.
+func (b *ReminderBroker) Close() {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+}
+
+// EmailReminderChannel emails reminders to their owners. Users without a
+// verified email address are skipped.
+type EmailReminderChannel struct {
+	DB *sql.DB
+	Mailer Mailer
+
+	// The public URL of the app, which emails link to.
+	BaseURL string
+}
+
+func (c *EmailReminderChannel) Deliver(e ReminderEvent) error {
+	u, err := LoadUser(e.UserID, c.DB)
+	if err != nil {
+		return err
+	}
+	if !u.Email.Valid || !u.EmailVerified {
+		return nil
+	}
+
+	return c.Mailer.Send(MailMessage {
+		To: u.Email.String,
+		Subject: "Reminder: " + e.Title,
+		Body: fmt.Sprintf("This is your reminder for \"%s\", due %s UTC.\n\n%s/index.html\n",
+			e.Title, e.Due, c.BaseURL),
+	})
+}
+
+// WebhookReminderChannel posts reminders as JSON to the URL set on each
+// reminder.
+type WebhookReminderChannel struct {
+	// The client requests are made with. It should have a timeout, and since
+	// users choose the URLs, it should refuse internal addresses, as the
+	// client from NewWebhookClient does.
+	Client *http.Client
+}
+
+// ErrWebhookAddress is returned when a webhook URL leads to an address that
+// webhooks may not be sent to.
+var ErrWebhookAddress = errors.New("Webhooks cannot be sent to local or private addresses.")
+
+// Address ranges webhooks may not be sent to, besides those that net.IP
+// classifies as loopback, private, link-local or multicast.
+var webhookDeniedNetworks = []*net.IPNet {
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// WebhookAddressAllowed reports whether webhooks may be sent to an address.
+// Users choose webhook URLs, so the server must not be made to reach
+// itself, its network, or cloud metadata services on their behalf.
+func WebhookAddressAllowed(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range webhookDeniedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckWebhookURL validates a webhook URL as it is set. Host names are only
+// resolved when the webhook is sent, so this catches local addresses that are
+// written out, and the client from NewWebhookClient catches the rest.
+func CheckWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Hostname()) == 0 {
+		return errors.New("Webhook URL must be an http or https URL.")
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrWebhookAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && !WebhookAddressAllowed(ip) {
+		return ErrWebhookAddress
+	}
+
+	return nil
+}
+
+// NewWebhookClient creates a client for sending webhooks. Every connection it
+// makes, including to redirects, is checked after the host name is resolved,
+// so that a name that resolves to an internal address is refused even if it
+// resolved to a public one when the URL was set.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer {
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !WebhookAddressAllowed(ip) {
+				return ErrWebhookAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client {
+		Timeout: timeout,
+		Transport: &http.Transport {
+			// A proxy would make the connections instead, unchecked.
+			Proxy: nil,
+			DialContext: dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+func (c *WebhookReminderChannel) Deliver(e ReminderEvent) error {
+	if len(e.WebhookURL) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.Client.Post(e.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New("Webhook responded with " + res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,336 @@
+package csnotes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How far ahead GET /api/reminder looks by default.
+	REMINDER_UPCOMING_WINDOW = 30 * 24 * time.Hour
+
+	// How long an event stream stays open. It ends before the server's write
+	// timeout, and clients reconnect with the last event ID they saw, so no
+	// events are missed.
+	REMINDER_STREAM_DURATION = 10 * time.Second
+)
+
+// GetReminders lists the logged in user's reminders that fire before a time,
+// soonest first. The time is given by the "before" parameter and defaults to
+// 30 days from now. Admins may add "all=true" to see every user's.
+func GetReminders(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Get the logged in user's data.
+		currentUserID, currentUserAdmin, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+
+		// Read the end of the window.
+		before := context.Now().Add(REMINDER_UPCOMING_WINDOW)
+		if b := r.FormValue("before"); len(b) > 0 {
+			before, err = ParseReminderTime(b)
+			if err != nil {
+				resp.Fields["before"] = "Invalid time."
+				return
+			}
+		}
+
+		uID := currentUserID
+		if r.FormValue("all") == "true" {
+			if !currentUserAdmin {
+				resp.StatusCode = 403
+				resp.ErrorMessage = "Access denied."
+				return
+			}
+			uID = 0
+		}
+
+		rms, err := LoadUpcomingReminders(uID, before, context.DB)
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load reminders."
+			return
+		}
+
+		// Add the reminders to the response.
+		for _, rm := range rms {
+			resp.Models = append(resp.Models, rm)
+		}
+	}
+}
+
+// GetReminderEvents streams the logged in user's reminders as they fire, as
+// server-sent events.
+func GetReminderEvents(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if context.ReminderEvents == nil {
+			http.Error(w, "Reminder events are disabled.", http.StatusNotFound)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+			return
+		}
+
+		currentUserID, _, err := context.LoggedInUser(r)
+		if err != nil {
+			http.Error(w, "Could not retrieve logged in user.", http.StatusInternalServerError)
+			return
+		}
+
+		// Pick up after the last event the client saw, or from now.
+		last := context.ReminderEvents.LastEventID()
+		if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			last = id
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 1000\n\n")
+		flusher.Flush()
+
+		end := time.NewTimer(REMINDER_STREAM_DURATION)
+		defer end.Stop()
+
+		for {
+			es, wait := context.ReminderEvents.Since(currentUserID, last)
+			for _, e := range es {
+				data, err := json.Marshal(e)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "id: %d\nevent: reminder\ndata: %s\n\n", e.EventID, data)
+				last = e.EventID
+			}
+			flusher.Flush()
+
+			select {
+			case <-wait:
+			case <-end.C:
+				return
+			case <-r.Context().Done():
+				return
+			case <-context.ReminderEvents.Done():
+				return
+			}
+		}
+	}
+}
+
+// GetNoteReminder retrieves the reminder of a note.
+func GetNoteReminder(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		rm, err := LoadNoteReminder(n.ID, context.DB)
+		if err == sql.ErrNoRows {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "Note has no reminder."
+			return
+		}
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load reminder."
+			return
+		}
+
+		resp.Models = append(resp.Models, rm)
+	}
+}
+
+// PutNoteReminder sets the reminder of a note, replacing any it had. The
+// form takes the first occurrence as "time", an optional "recurrence", the
+// delivery "channels" as a comma-separated list, and a "webhook_url" for the
+// webhook channel.
+func PutNoteReminder(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		if context.Reminders == nil {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "Reminders are disabled."
+			return
+		}
+
+		// Retrieve and validate the form values.
+		starts, err := ParseReminderTime(r.FormValue("time"))
+		if err != nil {
+			resp.Fields["time"] = "Time must be RFC 3339 or YYYY-MM-DD HH:MM:SS."
+		}
+
+		recurrence := r.FormValue("recurrence")
+		rc, err := ParseRecurrence(recurrence)
+		if err != nil {
+			resp.Fields["recurrence"] = err.Error()
+		}
+
+		channels := splitChannels(strings.Replace(r.FormValue("channels"), " ", "", -1))
+		if len(channels) == 0 {
+			channels = []string{DEFAULT_REMINDER_CHANNEL}
+		}
+		for _, c := range channels {
+			if !context.Reminders.HasChannel(c) {
+				resp.Fields["channels"] = "Unknown channel: " + c
+			}
+		}
+
+		webhookURL := r.FormValue("webhook_url")
+		if len(webhookURL) > 0 {
+			err = CheckWebhookURL(webhookURL)
+			if err != nil {
+				resp.Fields["webhook_url"] = err.Error()
+			}
+		}
+		for _, c := range channels {
+			if c == "webhook" && len(webhookURL) == 0 {
+				resp.Fields["webhook_url"] = "Webhook URL is required for the webhook channel."
+			}
+		}
+
+		if len(resp.Fields) > 0 {
+			return
+		}
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		// Replace the note's existing reminder, if it has one.
+		rm, err := LoadNoteReminder(n.ID, context.DB)
+		if err == sql.ErrNoRows {
+			rm = NewReminder(context.DB)
+		} else if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load reminder."
+			return
+		}
+
+		rm.NoteID = n.ID
+		rm.UserID = n.UserID
+		rm.Recurrence = recurrence
+		rm.Channels = channels
+		rm.WebhookURL.String = webhookURL
+		rm.WebhookURL.Valid = len(webhookURL) > 0
+		rm.Schedule(starts, rc, context.Now())
+
+		err = rm.Save()
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save reminder."
+			return
+		}
+
+		resp.Models = append(resp.Models, rm)
+	}
+}
+
+// DeleteNoteReminder removes the reminder of a note.
+func DeleteNoteReminder(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		rm, err := LoadNoteReminder(n.ID, context.DB)
+		if err == sql.ErrNoRows {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "Note has no reminder."
+			return
+		}
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load reminder."
+			return
+		}
+
+		err = rm.Delete()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not delete reminder."
+			return
+		}
+
+		resp.Models = append(resp.Models, rm)
+	}
+}
+
+// loadOwnedNote loads the note named in the URL, as long as the logged in
+// user owns it or is an admin. If not, the response is filled in and ok is
+// false.
+func loadOwnedNote(context *Context, r *http.Request, resp *JSONResponse) (n Note, ok bool) {
+	// Get the note ID.
+	nID, ok := GetURLID(r, resp)
+	if !ok {
+		return
+	}
+	ok = false
+
+	// Check for the note's existence.
+	if e, err := CheckExistence(nID, "notes", context.DB); !e {
+		if err == nil {
+			resp.StatusCode = 404
+			resp.ErrorMessage = "Note not found."
+		} else {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not verify note's existence."
+		}
+		return
+	}
+
+	// Load the note model.
+	n, err := LoadNote(nID, context.DB)
+	if err != nil {
+		resp.StatusCode = 500
+		resp.ErrorMessage = "Could not load note."
+		return
+	}
+
+	// Get the logged in user's data.
+	currentUserID, currentUserAdmin, err := context.LoggedInUser(r)
+	if err != nil {
+		resp.StatusCode = 500
+		resp.ErrorMessage = "Could not retrieve logged in user."
+		return
+	}
+
+	// Only the owner of the note or an admin may access it.
+	if !currentUserAdmin && currentUserID != n.UserID {
+		resp.StatusCode = 403
+		resp.ErrorMessage = "Access denied."
+		return
+	}
+
+	return n, true
+}
@@ -0,0 +1,309 @@
+package csnotes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// How often the scheduler looks for due reminders by default.
+	REMINDER_INTERVAL = 15 * time.Second
+
+	// How many reminders are claimed in one pass.
+	REMINDER_BATCH_SIZE = 100
+
+	// How long a scheduler has to finish a delivery before another may try
+	// it again.
+	REMINDER_DELIVERY_LEASE = time.Minute
+
+	// How many times a delivery is tried before it is given up on.
+	REMINDER_MAX_ATTEMPTS = 5
+)
+
+// ReminderEvent is a reminder that has come due, as handed to a channel.
+type ReminderEvent struct {
+	ReminderID int64 `json:"reminder_id"`
+	NoteID int64 `json:"note_id"`
+	UserID int64 `json:"-"`
+	Title string `json:"title"`
+	Due string `json:"due"`
+
+	// Where the webhook channel posts the event.
+	WebhookURL string `json:"-"`
+
+	// Set by the event stream to order events.
+	EventID int64 `json:"-"`
+}
+
+// ReminderChannel delivers reminders to their owners. Implementations must
+// be safe for concurrent use.
+type ReminderChannel interface {
+	Deliver(e ReminderEvent) error
+}
+
+// ReminderScheduler fires due reminders. Firing happens in two steps, both
+// kept in the database so that nothing is lost across restarts or between
+// several app servers: each due occurrence is claimed once by advancing the
+// reminder and recording a delivery per channel, then each delivery is
+// attempted until it succeeds.
+//
+// Deliveries are at least once, not exactly once. If a server stops after a
+// channel accepted a reminder but before its delivery was cleared, or a
+// delivery outlasts its lease, the reminder is delivered again. Receivers
+// can recognize repeats by the reminder ID and due time of the event.
+type ReminderScheduler struct {
+	DB *sql.DB
+
+	// The channels reminders can be delivered through, by name.
+	Channels map[string]ReminderChannel
+
+	// How often to look for due reminders. Defaults to REMINDER_INTERVAL.
+	Interval time.Duration
+
+	// The source of the current time. If nil, the system clock is used.
+	Clock func() time.Time
+}
+
+// Now returns the current time from the scheduler's clock.
+func (s *ReminderScheduler) Now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// HasChannel reports whether a channel name is known to the scheduler.
+func (s *ReminderScheduler) HasChannel(name string) bool {
+	_, ok := s.Channels[name]
+	return ok
+}
+
+// Run fires reminders until the context is cancelled. The first pass is
+// made immediately, which catches up on anything that came due while the
+// app was down.
+func (s *ReminderScheduler) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = REMINDER_INTERVAL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Tick(s.Now()); err != nil {
+			fmt.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick claims every reminder due at a time, then attempts every delivery
+// that is waiting.
+func (s *ReminderScheduler) Tick(now time.Time) error {
+	err := s.claimDue(now)
+	if err != nil {
+		return err
+	}
+
+	return s.deliverPending(now)
+}
+
+// claimDue advances each due reminder to its next occurrence and records a
+// delivery of the occurrence that came due.
+func (s *ReminderScheduler) claimDue(now time.Time) error {
+	type due struct {
+		id int64
+		starts string
+		remindAt string
+		recurrence string
+		channels string
+	}
+
+	rows, err := s.DB.Query("SELECT id, starts, remind_at, recurrence, channels FROM reminders WHERE done=FALSE AND remind_at<=? ORDER BY remind_at LIMIT ?",
+		now.UTC().Format(DATETIME_FORMAT), REMINDER_BATCH_SIZE)
+	if err != nil {
+		return err
+	}
+
+	var dues []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.starts, &d.remindAt, &d.recurrence, &d.channels); err != nil {
+			rows.Close()
+			return err
+		}
+		dues = append(dues, d)
+	}
+	rows.Close()
+
+	for _, d := range dues {
+		// Occurrences missed while the app was down are folded into this
+		// one, rather than each firing late.
+		next, done := d.remindAt, true
+		rc, err := ParseRecurrence(d.recurrence)
+		starts, serr := time.Parse(DATETIME_FORMAT, d.starts)
+		if err == nil && serr == nil {
+			if t, _, ok := rc.Next(starts, now.UTC()); ok {
+				next, done = t.Format(DATETIME_FORMAT), false
+			}
+		}
+
+		err = s.claim(d.id, d.remindAt, next, done, splitChannels(d.channels))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// claim moves a reminder on from an occurrence and records its deliveries
+// in one transaction. If another scheduler claimed the occurrence first,
+// nothing is done.
+func (s *ReminderScheduler) claim(id int64, remindAt, next string, done bool, channels []string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE reminders SET remind_at=?, done=? WHERE id=? AND remind_at=? AND done=FALSE",
+		next, done, id, remindAt)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return err
+	}
+
+	for _, c := range channels {
+		_, err = tx.Exec("INSERT IGNORE INTO reminder_deliveries (reminder_id, channel, due) VALUES (?, ?, ?)",
+			id, c, remindAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deliverPending attempts each delivery that has not succeeded, is not being
+// attempted elsewhere, and has attempts left. Deliveries are deleted once
+// they succeed, or their last attempt fails.
+func (s *ReminderScheduler) deliverPending(now time.Time) error {
+	type pending struct {
+		id int64
+		reminderID int64
+		channel string
+		due string
+		attempts int
+	}
+
+	nowString := now.UTC().Format(DATETIME_FORMAT)
+	rows, err := s.DB.Query("SELECT id, reminder_id, channel, due, attempts FROM reminder_deliveries WHERE attempts<? AND (lease_until IS NULL OR lease_until<?) ORDER BY due LIMIT ?",
+		REMINDER_MAX_ATTEMPTS, nowString, REMINDER_BATCH_SIZE)
+	if err != nil {
+		return err
+	}
+
+	var ps []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.reminderID, &p.channel, &p.due, &p.attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		ps = append(ps, p)
+	}
+	rows.Close()
+
+	for _, p := range ps {
+		// Take a lease on the delivery, so that no other scheduler attempts
+		// it at the same time.
+		res, err := s.DB.Exec("UPDATE reminder_deliveries SET attempts=attempts+1, lease_until=? WHERE id=? AND (lease_until IS NULL OR lease_until<?)",
+			now.UTC().Add(REMINDER_DELIVERY_LEASE).Format(DATETIME_FORMAT), p.id, nowString)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			continue
+		}
+
+		err = s.deliver(p.reminderID, p.channel, p.due)
+		if err != nil {
+			fmt.Println(err)
+
+			// Let the next pass try again, unless that was the last attempt.
+			if p.attempts + 1 < REMINDER_MAX_ATTEMPTS {
+				_, err = s.DB.Exec("UPDATE reminder_deliveries SET lease_until=NULL WHERE id=?", p.id)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("Gave up delivering reminder %d due %s through %s after %d attempts.\n",
+				p.reminderID, p.due, p.channel, REMINDER_MAX_ATTEMPTS)
+		}
+
+		_, err = s.DB.Exec("DELETE FROM reminder_deliveries WHERE id=?", p.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliver sends one occurrence of a reminder through a channel. Reminders
+// and notes deleted since the occurrence was claimed are skipped.
+func (s *ReminderScheduler) deliver(reminderID int64, channel, due string) error {
+	c, ok := s.Channels[channel]
+	if !ok {
+		return errors.New("Unknown reminder channel: " + channel)
+	}
+
+	rm, err := LoadReminder(reminderID, s.DB)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := LoadNote(rm.NoteID, s.DB)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Deliver(ReminderEvent {
+		ReminderID: rm.ID,
+		NoteID: n.ID,
+		UserID: rm.UserID,
+		Title: n.Title,
+		Due: due,
+		WebhookURL: rm.WebhookURL.String,
+	})
+}
+
+// splitChannels reads a stored list of channel names.
+func splitChannels(s string) (cs []string) {
+	for _, c := range strings.Split(s, ",") {
+		if len(c) > 0 {
+			cs = append(cs, c)
+		}
+	}
+	return
+}
@@ -0,0 +1,202 @@
+package csnotes
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testReminderChannel records the reminders it was given.
+type testReminderChannel struct {
+	events []ReminderEvent
+}
+
+func (c *testReminderChannel) Deliver(e ReminderEvent) error {
+	c.events = append(c.events, e)
+	return nil
+}
+
+// failingReminderChannel fails every delivery, counting the attempts.
+type failingReminderChannel struct {
+	attempts int
+}
+
+func (c *failingReminderChannel) Deliver(e ReminderEvent) error {
+	c.attempts++
+	return errors.New("Unreachable.")
+}
+
+// TestReminderBroker ensures that listeners only see their own events, and
+// can catch up on events after one they saw.
+func TestReminderBroker(t *testing.T) {
+	b := NewReminderBroker()
+	start := b.LastEventID()
+
+	_, wait := b.Since(1, start)
+	b.Deliver(ReminderEvent{UserID: 1, Title: "first"})
+	b.Deliver(ReminderEvent{UserID: 2, Title: "other"})
+	b.Deliver(ReminderEvent{UserID: 1, Title: "second"})
+
+	// Waiting listeners are woken.
+	select {
+	case <-wait:
+	default:
+		t.Error("Listener was not woken.")
+	}
+
+	es, _ := b.Since(1, start)
+	AssertEqual(2, len(es), t)
+	AssertEqual("first", es[0].Title, t)
+
+	es, _ = b.Since(1, es[0].EventID)
+	AssertEqual(1, len(es), t)
+	AssertEqual("second", es[0].Title, t)
+
+	b.Close()
+	<-b.Done()
+}
+
+// TestWebhookReminderChannel ensures that reminders are posted as JSON, and
+// that failed responses are reported.
+func TestWebhookReminderChannel(t *testing.T) {
+	var received ReminderEvent
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c := &WebhookReminderChannel{Client: server.Client()}
+	e := ReminderEvent{NoteID: 3, Title: "Call", WebhookURL: server.URL}
+
+	err := c.Deliver(e)
+	AssertEqual(nil, err, t)
+	AssertEqual(int64(3), received.NoteID, t)
+	AssertEqual("Call", received.Title, t)
+
+	status = http.StatusInternalServerError
+	err = c.Deliver(e)
+	AssertUnequal(nil, err, t)
+}
+
+// TestWebhookAddresses ensures that webhooks cannot be sent to the server
+// itself or its network.
+func TestWebhookAddresses(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:10.0.0.1"} {
+		AssertEqual(false, WebhookAddressAllowed(net.ParseIP(addr)), t)
+	}
+	for _, addr := range []string{"93.184.216.34", "2606:4700::1111"} {
+		AssertEqual(true, WebhookAddressAllowed(net.ParseIP(addr)), t)
+	}
+
+	// Addresses that are written out are refused when the URL is set.
+	AssertEqual(nil, CheckWebhookURL("https://example.com/hook"), t)
+	AssertEqual(ErrWebhookAddress, CheckWebhookURL("http://169.254.169.254/latest/meta-data/"), t)
+	AssertEqual(ErrWebhookAddress, CheckWebhookURL("http://[::1]:8080/"), t)
+	AssertEqual(ErrWebhookAddress, CheckWebhookURL("http://LOCALHOST./"), t)
+	AssertUnequal(nil, CheckWebhookURL("ftp://example.com/"), t)
+
+	// Others are refused when they are resolved, when the webhook is sent.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Webhook reached a local address.")
+	}))
+	defer server.Close()
+
+	c := &WebhookReminderChannel{Client: NewWebhookClient(time.Second)}
+	err := c.Deliver(ReminderEvent{NoteID: 3, WebhookURL: server.URL})
+	AssertUnequal(nil, err, t)
+	AssertContains(err.Error(), ErrWebhookAddress.Error(), t)
+}
+
+// TestReminderScheduler ensures that each occurrence is claimed and delivered
+// once when nothing fails, and that recurring reminders move on to their next
+// occurrence.
+func TestReminderScheduler(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2017, 11, 1, 9, 0, 0, 0, time.UTC)
+	channel := &testReminderChannel{}
+	s := &ReminderScheduler {
+		DB: db,
+		Channels: map[string]ReminderChannel{"events": channel},
+	}
+
+	rc, _ := ParseRecurrence("daily")
+	rm := NewReminder(db)
+	rm.NoteID = ids["note.note1"]
+	rm.UserID = ids["user.nonadmin"]
+	rm.Recurrence = "daily"
+	rm.Channels = []string{"events"}
+	rm.Schedule(now, rc, now.Add(-time.Minute))
+	err = rm.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is due yet.
+	AssertEqual(nil, s.Tick(now.Add(-time.Second)), t)
+	AssertEqual(0, len(channel.events), t)
+
+	// Ticking twice at the same time only fires once.
+	AssertEqual(nil, s.Tick(now), t)
+	AssertEqual(nil, s.Tick(now), t)
+	AssertEqual(1, len(channel.events), t)
+	AssertEqual("note1", channel.events[0].Title, t)
+
+	// Missed occurrences fire once, and the reminder moves past them.
+	AssertEqual(nil, s.Tick(now.Add(72 * time.Hour + time.Minute)), t)
+	AssertEqual(2, len(channel.events), t)
+
+	rm, err = LoadReminder(rm.ID, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual("2017-11-05 09:00:00", rm.RemindAt, t)
+	AssertEqual(false, rm.Done, t)
+}
+
+// TestReminderDeliveryGivenUp ensures that a delivery is retried until it
+// runs out of attempts, and is then deleted.
+func TestReminderDeliveryGivenUp(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2017, 11, 1, 9, 0, 0, 0, time.UTC)
+	channel := &failingReminderChannel{}
+	s := &ReminderScheduler {
+		DB: db,
+		Channels: map[string]ReminderChannel{"events": channel},
+	}
+
+	rc, _ := ParseRecurrence("")
+	rm := NewReminder(db)
+	rm.NoteID = ids["note.note1"]
+	rm.UserID = ids["user.nonadmin"]
+	rm.Channels = []string{"events"}
+	rm.Schedule(now, rc, now.Add(-time.Minute))
+	err = rm.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < REMINDER_MAX_ATTEMPTS + 2; i++ {
+		AssertEqual(nil, s.Tick(now.Add(time.Duration(i) * time.Minute)), t)
+	}
+	AssertEqual(REMINDER_MAX_ATTEMPTS, channel.attempts, t)
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM reminder_deliveries").Scan(&count)
+	AssertEqual(0, count, t)
+}
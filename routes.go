@@ -3,52 +3,119 @@ package csnotes
 import (
 	"net/http"
 
-	"github.com/auth0/go-jwt-middleware"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
 )
 
 func CreateRouter(context *Context) *mux.Router {
 	router := mux.NewRouter()
-	api := mux.NewRouter().PathPrefix("/api").Subrouter().StrictSlash(true)
+	api := createAPIRouter(context)
 
-	// Create middleware
-	jwtMiddleware := jwtmiddleware.New(jwtmiddleware.Options {
-		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
-			return context.VerifyKey, nil
-		},
-		SigningMethod: jwt.SigningMethodRS256,
-	})
+	// Health Checks
+	router.HandleFunc("/healthz", GetHealthz(context)).Methods("GET")
+	router.HandleFunc("/readyz", GetReadyz(context)).Methods("GET")
 
-	// Public assets
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./public")))
+	// Public Keys
+	router.HandleFunc("/.well-known/jwks.json", GetJWKS(context)).Methods("GET")
+
+	// API Description. This is matched before the authenticated API routes,
+	// so that it can be read without logging in.
+	router.HandleFunc("/api/openapi.json", GetOpenAPI(context)).Methods("GET")
 
 	// Public Routes (non-GET)
 	router.HandleFunc("/login", PostLogin(context)).Methods("POST")
+	router.HandleFunc("/login/totp", PostLoginTOTP(context)).Methods("POST")
+	router.HandleFunc("/login/refresh", PostLoginRefresh(context)).Methods("POST")
+	router.HandleFunc("/login/oidc", GetLoginOIDC(context)).Methods("GET")
+	router.HandleFunc("/login/oidc/callback", GetLoginOIDCCallback(context)).Methods("GET")
+	router.HandleFunc("/signup", PostSignup(context)).Methods("POST")
+	router.HandleFunc("/signup/verify", GetSignupVerify(context)).Methods("GET")
+	router.HandleFunc("/password/forgot", PostPasswordForgot(context)).Methods("POST")
+	router.HandleFunc("/password/reset", PostPasswordReset(context)).Methods("POST")
+	router.HandleFunc("/calendar/{token:[0-9a-f]+}.ics", GetCalendarFeed(context)).Methods("GET")
 	//router.HandleFunc("/logout", PostLogout(context)).Methods("POST")
 
+	// Authenticated API Routes
+	router.PathPrefix("/api").Handler(negroni.New(
+		negroni.HandlerFunc(context.AuthMiddleware),
+		negroni.Wrap(api),
+	))
+
+	// Public assets. These are matched last, since the prefix matches every
+	// path.
+	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./public")))
+
+	return router
+}
+
+// createAPIRouter creates the router for the authenticated routes under /api.
+func createAPIRouter(context *Context) *mux.Router {
+	api := mux.NewRouter().PathPrefix("/api").Subrouter().StrictSlash(true)
+
 	// User Routes
 	api.HandleFunc("/user", GetUsers(context)).Methods("GET")
 	api.HandleFunc("/user", PostUser(context)).Methods("POST")
 	api.HandleFunc("/user/{id}", GetUser(context)).Methods("GET")
 	api.HandleFunc("/user/{id}", PutUser(context)).Methods("PUT")
 	api.HandleFunc("/user/{id}/note", GetUserNotes(context)).Methods("GET")
+	api.HandleFunc("/user/{id}/totp", PostUserTOTP(context)).Methods("POST")
+	api.HandleFunc("/user/{id}/totp", DeleteUserTOTP(context)).Methods("DELETE")
+	api.HandleFunc("/user/{id}/totp/confirm", PostUserTOTPConfirm(context)).Methods("POST")
+	api.HandleFunc("/user/{id}/calendar", PostUserCalendar(context)).Methods("POST")
+	api.HandleFunc("/user/{id}/calendar", DeleteUserCalendar(context)).Methods("DELETE")
+
+	// Personal Access Token Routes
+	api.HandleFunc("/token", GetAPITokens(context)).Methods("GET")
+	api.HandleFunc("/token", PostAPIToken(context)).Methods("POST")
+	api.HandleFunc("/token/{id}", DeleteAPIToken(context)).Methods("DELETE")
 
 	// Note Routes
 	api.HandleFunc("/note", GetNotes(context)).Methods("GET")
 	api.HandleFunc("/note", PostNote(context)).Methods("POST")
+	api.HandleFunc("/note/pin", PostNotesPin(context)).Methods("POST")
+	api.HandleFunc("/note/archive", PostNotesArchive(context)).Methods("POST")
+	api.HandleFunc("/note/color", PostNotesColor(context)).Methods("POST")
 	api.HandleFunc("/note/{id}", GetNote(context)).Methods("GET")
 	api.HandleFunc("/note/{id}", PutNote(context)).Methods("PUT")
 	api.HandleFunc("/note/{id}", DeleteNote(context)).Methods("DELETE")
-	//router.HandleFunc("/note/{id}/tag", GetNoteTags(context)).Methods("GET")
-	//router.HandleFunc("/note/{id}/tag", PostNoteTag(context)).Methods("POST")
+	api.HandleFunc("/note/{id}/convert", PostNoteConvert(context)).Methods("POST")
+	api.HandleFunc("/note/{id}/item", GetNoteItems(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/item", PostNoteItem(context)).Methods("POST")
+	api.HandleFunc("/note/{id}/item/order", PutNoteItemOrder(context)).Methods("PUT")
+	api.HandleFunc("/note/{id}/item/{item_id:[0-9]+}", PutNoteItem(context)).Methods("PUT")
+	api.HandleFunc("/note/{id}/item/{item_id:[0-9]+}", DeleteNoteItem(context)).Methods("DELETE")
+	api.HandleFunc("/note/{id}/item/{item_id:[0-9]+}/toggle", PostNoteItemToggle(context)).Methods("POST")
+	api.HandleFunc("/note/{id}/attachment", GetNoteAttachments(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/attachment", PostNoteAttachment(context)).Methods("POST")
+	api.HandleFunc("/note/{id}/attachment/{attachment_id:[0-9]+}", GetNoteAttachment(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/attachment/{attachment_id:[0-9]+}", DeleteNoteAttachment(context)).Methods("DELETE")
+	api.HandleFunc("/note/{id}/attachment/{attachment_id:[0-9]+}/thumbnail/{size:small|medium|large}", GetAttachmentThumbnail(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/cover", GetNoteCover(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/cover", PutNoteCover(context)).Methods("PUT")
+	api.HandleFunc("/note/{id}/cover", DeleteNoteCover(context)).Methods("DELETE")
+	api.HandleFunc("/note/{id}/links", GetNoteLinks(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/backlinks", GetNoteBacklinks(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/reminder", GetNoteReminder(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/reminder", PutNoteReminder(context)).Methods("PUT")
+	api.HandleFunc("/note/{id}/reminder", DeleteNoteReminder(context)).Methods("DELETE")
+	api.HandleFunc("/note/{id}/tag", GetNoteTags(context)).Methods("GET")
+	api.HandleFunc("/note/{id}/tag", PostNoteTag(context)).Methods("POST")
+	api.HandleFunc("/note/{id}/tag/{tag_id:[0-9]+}", DeleteNoteTag(context)).Methods("DELETE")
 
-	// Authenticated API Routes
-	router.PathPrefix("/api").Handler(negroni.New(
-		negroni.HandlerFunc(jwtMiddleware.HandlerWithNext),
-		negroni.Wrap(api),
-	))
+	// Reminder Routes
+	api.HandleFunc("/reminder", GetReminders(context)).Methods("GET")
+	api.HandleFunc("/reminder/events", GetReminderEvents(context)).Methods("GET")
 
-	return router
+	// Graph Routes
+	api.HandleFunc("/graph", GetGraph(context)).Methods("GET")
+
+	// Export Routes
+	api.HandleFunc("/export", GetExport(context)).Methods("GET")
+
+	// Import Routes
+	api.HandleFunc("/import/keep", PostImportKeep(context)).Methods("POST")
+	api.HandleFunc("/import/enex", PostImportENEX(context)).Methods("POST")
+	api.HandleFunc("/import/markdown", PostImportMarkdown(context)).Methods("POST")
+
+	return api
 }
@@ -0,0 +1,187 @@
+package csnotes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The payload hash sent when a body is streamed rather than hashed up front.
+const S3_UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+
+// S3BlobStore keeps blobs in a bucket of an S3-compatible service, such as
+// Amazon S3 or MinIO. Requests are signed with AWS Signature Version 4 and
+// use path-style URLs.
+type S3BlobStore struct {
+	// The service's base URL, such as https://s3.us-east-1.amazonaws.com.
+	Endpoint string
+	Region string
+	Bucket string
+
+	AccessKey string
+	SecretKey string
+
+	// The client requests are made with. It should have a timeout.
+	Client *http.Client
+
+	// The source of the current time. If nil, the system clock is used.
+	Clock func() time.Time
+}
+
+func (s *S3BlobStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest("PUT", key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+func (s *S3BlobStore) Get(key string) (io.ReadCloser, error) {
+	req, err := s.newRequest("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+func (s *S3BlobStore) Delete(key string) error {
+	req, err := s.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req)
+	if err == ErrBlobNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// newRequest creates a signed request for an object.
+func (s *S3BlobStore) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	if !validBlobKey(key) {
+		return nil, errors.New("Invalid blob key.")
+	}
+
+	url := strings.TrimSuffix(s.Endpoint, "/") + "/" + awsURIEscape(s.Bucket) + "/" + awsURIEscape(key)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if s.Clock != nil {
+		now = s.Clock()
+	}
+	SignS3Request(req, S3_UNSIGNED_PAYLOAD, s.AccessKey, s.SecretKey, s.Region, now)
+
+	return req, nil
+}
+
+// do sends a request, turning error responses into errors.
+func (s *S3BlobStore) do(req *http.Request) (*http.Response, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, errors.New("Blob store responded with " + res.Status)
+	}
+
+	return res, nil
+}
+
+// SignS3Request adds AWS Signature Version 4 headers to a request for the S3
+// service. The signed headers are host, x-amz-content-sha256 and x-amz-date.
+func SignS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string {
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4" + secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=" + accessKey + "/" + scope +
+		", SignedHeaders=" + signedHeaders + ", Signature=" + signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEscape escapes a path the way AWS signatures expect: everything but
+// unreserved characters and slashes is percent-encoded.
+func awsURIEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
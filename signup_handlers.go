@@ -0,0 +1,185 @@
+package csnotes
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+)
+
+// PostSignup lets anyone create an account. The account cannot log in until
+// its email address is verified through the link that is sent to it.
+func PostSignup(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Retrieve the form values.
+		username := r.FormValue("username")
+		name := r.FormValue("name")
+		email := r.FormValue("email")
+		password := r.FormValue("password")
+
+		// Validate the input data.
+		validateNewUser(username, password, &resp, context.DB)
+
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			resp.Fields["email"] = "Email address is invalid."
+		} else if err := ReleaseUnverifiedEmail(email, context.Now(), context.DB); err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not check email address."
+		} else if _, err := LoadUserByEmail(email, context.DB); err == nil {
+			resp.Fields["email"] = "Email address is already in use."
+		}
+
+		// If one or more fields were invalid, respond early.
+		if len(resp.Fields) > 0 || resp.StatusCode != 200 {
+			return
+		}
+
+		// Create a user model.
+		u := NewUser(context.DB)
+		if len(name) > 0 {
+			u.Name.String = name
+			u.Name.Valid = true
+		}
+		u.Username = username
+		u.Email.String = email
+		u.Email.Valid = true
+
+		// Save the model.
+		err := u.Save()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not save new user."
+			return
+		}
+
+		// Hash and store the user's password.
+		err = StorePassword(u.ID, password, context.Now(), context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not store password."
+			u.Delete()
+			return
+		}
+
+		// Send the verification link. Without it, the account is unusable,
+		// so it is removed if the email cannot be sent.
+		err = SendVerificationEmail(context, u)
+		if err != nil {
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not send verification email."
+			context.DB.Exec("DELETE FROM email_tokens WHERE user_id=?", u.ID)
+			u.Delete()
+			return
+		}
+
+		resp.Models = append(resp.Models, u)
+	}
+}
+
+// GetSignupVerify verifies an email address from the link in a verification
+// email, then sends the user on to log in.
+func GetSignupVerify(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		uID, err := ConsumeEmailToken(r.FormValue("token"), VERIFY_EMAIL_PURPOSE, context.Now(), context.DB)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err = context.DB.Exec("UPDATE users SET email_verified=TRUE WHERE id=?", uID)
+		if err != nil {
+			http.Error(w, "Could not verify email address.", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/login.html#verified", http.StatusFound)
+	}
+}
+
+// PostPasswordForgot emails a password reset link to the owner of an email
+// address. The response is the same whether or not the address belongs to
+// anyone, so that it cannot be used to discover accounts.
+func PostPasswordForgot(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		email := r.FormValue("email")
+		if len(email) == 0 {
+			resp.Fields["email"] = "Email address must be specified."
+			return
+		}
+
+		u, err := LoadUserByEmail(email, context.DB)
+		if err != nil {
+			return
+		}
+
+		// Only accounts with passwords stored here can have them reset.
+		var source string
+		err = context.DB.QueryRow("SELECT auth_source FROM users WHERE id=?", u.ID).Scan(&source)
+		if err != nil || source != "local" {
+			return
+		}
+
+		err = SendPasswordResetEmail(context, u)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// PostPasswordReset sets a new password using the token from a password
+// reset email.
+func PostPasswordReset(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		token := r.FormValue("token")
+		password := r.FormValue("password")
+
+		// Validate the password before the token is used up.
+		if len(password) < 8 {
+			resp.Fields["password"] = "Password must be longer than 8 characters."
+			return
+		}
+
+		uID, err := ConsumeEmailToken(token, RESET_PASSWORD_PURPOSE, context.Now(), context.DB)
+		if err != nil {
+			resp.Fields["token"] = err.Error()
+			return
+		}
+
+		err = StorePassword(uID, password, context.Now(), context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not store password."
+			return
+		}
+
+		// Receiving the email proves the address belongs to the user.
+		_, err = context.DB.Exec("UPDATE users SET email_verified=TRUE WHERE id=?", uID)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not verify email address."
+			return
+		}
+
+		u, err := LoadUser(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load user."
+			return
+		}
+
+		resp.Models = append(resp.Models, u)
+	}
+}
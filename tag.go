@@ -2,6 +2,7 @@ package csnotes
 
 import (
 	"database/sql"
+	"strings"
 )
 
 type Tag struct {
@@ -80,3 +81,22 @@ func LoadTag(id int64, db *sql.DB) (t Tag, err error) {
 
 	return
 }
+
+// FindOrCreateTag loads the user's tag with a title, ignoring case, or creates
+// it if the user has none.
+func FindOrCreateTag(uID int64, title string, db *sql.DB) (t Tag, err error) {
+	t = NewTag(db)
+	err = db.QueryRow("SELECT id FROM tags WHERE user_id=? AND LOWER(title)=? ORDER BY id LIMIT 1", uID, strings.ToLower(title)).Scan(&t.ID)
+	if err == sql.ErrNoRows {
+		t.Title = title
+		t.UserID = uID
+		err = t.Save()
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	err = t.Load()
+	return
+}
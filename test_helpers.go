@@ -1,9 +1,13 @@
 package csnotes
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"database/sql"
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -43,6 +47,31 @@ func SeededTestDB() (db *sql.DB, ids map[string]int64, err error) {
 	return
 }
 
+// NewTestApp creates a context for tests on a database, with a fresh signing
+// key, and the app's router for it. The context's clock reads now, or the
+// system clock if now is nil.
+func NewTestApp(t *testing.T, db *sql.DB, now *time.Time) (*Context, http.Handler) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context := &Context {
+		DB: db,
+		SignKeyID: "test",
+		SignKey: key,
+		VerifyKeys: map[string]*rsa.PublicKey{"test": &key.PublicKey},
+		Issuer: "issuer",
+		Audience: "audience",
+		ClockSkew: time.Minute,
+	}
+	if now != nil {
+		context.Clock = func() time.Time { return *now }
+	}
+
+	return context, CreateRouter(context)
+}
+
 func AssertEqual(expected interface{}, received interface{}, t *testing.T) {
 	if expected != received {
 		t.Errorf("Expected %v, received %v.", expected, received)
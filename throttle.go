@@ -0,0 +1,143 @@
+package csnotes
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How many failed logins are allowed before any delay is imposed.
+	LOGIN_FREE_ATTEMPTS = 3
+
+	// The delay after the first throttled failure. Each further failure
+	// doubles it, up to the maximum.
+	LOGIN_BASE_DELAY = time.Second
+	LOGIN_MAX_DELAY = time.Minute
+
+	// After this many failures, the account or address is locked out.
+	LOGIN_LOCKOUT_FAILURES = 10
+	LOGIN_LOCKOUT_DURATION = time.Minute * 15
+)
+
+// loginFailures tracks the failed logins for a single username or address.
+type loginFailures struct {
+	count int
+	last time.Time
+	blockedUntil time.Time
+}
+
+// LoginThrottle tracks failed logins per username and per client address,
+// and decides how long a client must wait before trying again. It is safe
+// for concurrent use. A nil throttle never blocks anyone.
+type LoginThrottle struct {
+	mu sync.Mutex
+	failures map[string]*loginFailures
+}
+
+// NewLoginThrottle creates an empty login throttle.
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle {
+		failures: map[string]*loginFailures{},
+	}
+}
+
+// UsernameThrottleKey and AddressThrottleKey create the keys that failures
+// are tracked under, so that usernames and addresses cannot collide.
+func UsernameThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func AddressThrottleKey(r *http.Request) string {
+	// The app listens directly rather than behind a proxy, so the remote
+	// address is the client's.
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Wait returns how long a client must wait before logging in with any of
+// the given keys. A zero duration means the attempt may proceed.
+func (t *LoginThrottle) Wait(now time.Time, keys ...string) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok {
+			continue
+		}
+		if d := f.blockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// Fail records a failed login for each of the given keys.
+func (t *LoginThrottle) Fail(now time.Time, keys ...string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			t.failures[key] = f
+		}
+		f.count++
+		f.last = now
+
+		// Lock out after too many failures, otherwise back off
+		// exponentially once the free attempts are used up.
+		if f.count >= LOGIN_LOCKOUT_FAILURES {
+			f.blockedUntil = now.Add(LOGIN_LOCKOUT_DURATION)
+		} else if f.count >= LOGIN_FREE_ATTEMPTS {
+			delay := LOGIN_BASE_DELAY << uint(f.count - LOGIN_FREE_ATTEMPTS)
+			if delay > LOGIN_MAX_DELAY {
+				delay = LOGIN_MAX_DELAY
+			}
+			f.blockedUntil = now.Add(delay)
+		}
+	}
+}
+
+// Succeed clears the failures recorded for a key. It should only be called
+// with the username key: clearing an address on success would let an
+// attacker with one valid account reset their budget for guessing others.
+func (t *LoginThrottle) Succeed(key string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, key)
+}
+
+// prune forgets failures that are old enough to no longer matter, so that
+// the map does not grow without bound. The lock must be held.
+func (t *LoginThrottle) prune(now time.Time) {
+	for key, f := range t.failures {
+		if now.Sub(f.last) > LOGIN_LOCKOUT_DURATION && now.After(f.blockedUntil) {
+			delete(t.failures, key)
+		}
+	}
+}
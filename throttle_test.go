@@ -0,0 +1,39 @@
+package csnotes
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLoginThrottleBackoff ensures that failures are free at first, then
+// back off exponentially, and then lock the key out.
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle := NewLoginThrottle()
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// The free attempts impose no delay.
+	for i := 1; i < LOGIN_FREE_ATTEMPTS; i++ {
+		throttle.Fail(now, "user:a")
+	}
+	AssertEqual(time.Duration(0), throttle.Wait(now, "user:a"), t)
+
+	// The next failures double the delay each time.
+	throttle.Fail(now, "user:a")
+	AssertEqual(LOGIN_BASE_DELAY, throttle.Wait(now, "user:a"), t)
+	throttle.Fail(now, "user:a")
+	AssertEqual(2 * LOGIN_BASE_DELAY, throttle.Wait(now, "user:a"), t)
+
+	// Other keys are unaffected, but the longest wait of several applies.
+	AssertEqual(time.Duration(0), throttle.Wait(now, "ip:127.0.0.1"), t)
+	AssertEqual(2 * LOGIN_BASE_DELAY, throttle.Wait(now, "ip:127.0.0.1", "user:a"), t)
+
+	// Enough failures lock the key out.
+	for i := LOGIN_FREE_ATTEMPTS + 2; i <= LOGIN_LOCKOUT_FAILURES; i++ {
+		throttle.Fail(now, "user:a")
+	}
+	AssertEqual(LOGIN_LOCKOUT_DURATION, throttle.Wait(now, "user:a"), t)
+
+	// A successful login clears the failures.
+	throttle.Succeed("user:a")
+	AssertEqual(time.Duration(0), throttle.Wait(now, "user:a"), t)
+}
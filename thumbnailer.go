@@ -0,0 +1,202 @@
+package csnotes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// How many cover images are processed at once by default.
+	THUMBNAIL_WORKERS = 2
+
+	// How many cover images may wait to be processed. Uploads beyond this
+	// are turned away rather than left waiting.
+	THUMBNAIL_QUEUE_SIZE = 64
+)
+
+// Thumbnailer processes cover images in the background on a fixed number of
+// workers: it strips the original's metadata, turns it upright and makes
+// its thumbnails.
+type Thumbnailer struct {
+	DB *sql.DB
+	Blobs BlobStore
+
+	// How many images are processed at once.
+	Workers int
+
+	queue chan int64
+}
+
+// NewThumbnailer creates a thumbnailer with an empty queue. If workers is
+// not positive, THUMBNAIL_WORKERS are used.
+func NewThumbnailer(db *sql.DB, blobs BlobStore, workers int) *Thumbnailer {
+	if workers <= 0 {
+		workers = THUMBNAIL_WORKERS
+	}
+
+	return &Thumbnailer {
+		DB: db,
+		Blobs: blobs,
+		Workers: workers,
+		queue: make(chan int64, THUMBNAIL_QUEUE_SIZE),
+	}
+}
+
+// Enqueue adds a cover's attachment to the queue. It reports false, without
+// waiting, if the queue is full.
+func (t *Thumbnailer) Enqueue(attachmentID int64) bool {
+	select {
+	case t.queue <- attachmentID:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run processes queued images until the context is cancelled, then waits
+// for the images being processed to finish. Covers left processing by an
+// earlier run are queued again first.
+func (t *Thumbnailer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < t.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-t.queue:
+					err := t.Process(id)
+					if err != nil {
+						fmt.Println(err)
+					}
+				}
+			}
+		}()
+	}
+
+	err := t.resume(ctx)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	wg.Wait()
+}
+
+// resume queues covers that are still waiting to be processed, waiting for
+// room in the queue as needed.
+func (t *Thumbnailer) resume(ctx context.Context) error {
+	rows, err := t.DB.Query("SELECT attachment_id FROM note_covers WHERE status=?", COVER_STATUS_PROCESSING)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		select {
+		case t.queue <- id:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Process makes the thumbnails of a cover image and replaces the original
+// with a copy without metadata. The cover is marked ready, or failed if the
+// image cannot be processed.
+func (t *Thumbnailer) Process(attachmentID int64) (err error) {
+	a, err := LoadAttachment(attachmentID, t.DB)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			t.DB.Exec("UPDATE note_covers SET status=? WHERE attachment_id=?", COVER_STATUS_FAILED, a.ID)
+		}
+	}()
+
+	// Read and decode the original.
+	blob, err := t.Blobs.Get(a.StorageKey)
+	if err != nil {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(blob, MAX_ATTACHMENT_SIZE))
+	blob.Close()
+	if err != nil {
+		return
+	}
+
+	img, format, err := DecodeImage(data)
+	if err != nil {
+		return
+	}
+
+	// Replace the original with a copy without metadata.
+	stripped, contentType, err := StripImageMetadata(data, img, format)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(stripped, data) {
+		err = t.Blobs.Put(a.StorageKey, bytes.NewReader(stripped), int64(len(stripped)), contentType)
+		if err != nil {
+			return
+		}
+
+		hash := sha256.Sum256(stripped)
+		a.ContentType = contentType
+		a.Size = int64(len(stripped))
+		a.SHA256 = hex.EncodeToString(hash[:])
+		err = a.Save()
+		if err != nil {
+			return
+		}
+	}
+
+	// Make each size of thumbnail.
+	for _, size := range THUMBNAIL_SIZES {
+		thumb, err := EncodeJPEG(Thumbnail(img, size.Max), THUMBNAIL_QUALITY)
+		if err != nil {
+			return err
+		}
+
+		err = t.Blobs.Put(ThumbnailKey(a, size.Name), bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg")
+		if err != nil {
+			return err
+		}
+	}
+
+	b := img.Bounds()
+	_, err = t.DB.Exec("UPDATE note_covers SET status=?, width=?, height=? WHERE attachment_id=?",
+		COVER_STATUS_READY, b.Dx(), b.Dy(), a.ID)
+	if err != nil {
+		return
+	}
+
+	// If the cover was removed while it was processed, clean up what was
+	// written since.
+	if e, _ := CheckExistence(a.ID, "attachments", t.DB); !e {
+		return a.Remove(t.Blobs)
+	}
+
+	return nil
+}
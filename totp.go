@@ -0,0 +1,114 @@
+package csnotes
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// The TOTP parameters, as used by common authenticator apps.
+	TOTP_PERIOD = 30
+	TOTP_DIGITS = 6
+
+	// How many periods before or after the current one a code is accepted
+	// from, to allow for clock drift on the user's device.
+	TOTP_SKEW_STEPS = 1
+
+	// The size of a TOTP secret, in bytes.
+	TOTP_SECRET_SIZE = 20
+)
+
+// totpEncoding is unpadded base32, which authenticator apps expect.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a random base32-encoded TOTP secret.
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, TOTP_SECRET_SIZE)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPProvisioningURI creates an otpauth:// URI that authenticator apps can
+// import, usually from a QR code.
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	params.Set("period", fmt.Sprint(TOTP_PERIOD))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPStep returns the time step that a moment falls in.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD
+}
+
+// TOTPCode computes the code for a secret at a time step, as described in
+// RFC 6238.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	// HMAC the big-endian step counter.
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamically truncate the hash to a number.
+	offset := sum[len(sum) - 1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset + 4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value % mod), nil
+}
+
+// ValidateTOTP checks a code against a secret at a time, allowing for some
+// drift. Codes from steps at or before lastStep are refused, so that a code
+// cannot be used twice. If the code is valid, its step is returned.
+func ValidateTOTP(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+
+	current := TOTPStep(t)
+	for step := current - TOTP_SKEW_STEPS; step <= current + TOTP_SKEW_STEPS; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package csnotes
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPCode checks codes against the SHA-1 test vectors from RFC 6238,
+// truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string {
+		59: "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for seconds, expected := range vectors {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(seconds, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertEqual(expected, code, t)
+	}
+}
+
+// TestValidateTOTP ensures that codes are accepted from neighboring steps,
+// but not from further away or twice.
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	step := TOTPStep(now)
+
+	previous, _ := TOTPCode(secret, step - 1)
+	stale, _ := TOTPCode(secret, step - 2)
+
+	accepted, ok := ValidateTOTP(secret, previous, now, 0)
+	AssertEqual(true, ok, t)
+	AssertEqual(step - 1, accepted, t)
+
+	_, ok = ValidateTOTP(secret, stale, now, 0)
+	AssertEqual(false, ok, t)
+
+	// A code at or before the last accepted step is a replay.
+	_, ok = ValidateTOTP(secret, previous, now, accepted)
+	AssertEqual(false, ok, t)
+}
+
+// TestChallengeToken ensures that challenge tokens expire quickly and cannot
+// be used as login tokens.
+func TestChallengeToken(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	context := newTestAuthContext(t, &now)
+
+	challenge, err := context.IssueChallenge(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uID, err := context.ParseChallenge(challenge)
+	AssertEqual(nil, err, t)
+	AssertEqual(int64(3), uID, t)
+
+	_, err = context.ParseToken(challenge)
+	AssertUnequal(nil, err, t)
+
+	// Login tokens are not challenges either.
+	token, err := context.IssueToken(User{Resource: Resource{ID: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = context.ParseChallenge(token)
+	AssertUnequal(nil, err, t)
+
+	// The challenge expires.
+	now = now.Add(CHALLENGE_LIFETIME + 2 * time.Minute)
+	_, err = context.ParseChallenge(challenge)
+	AssertUnequal(nil, err, t)
+}
+
+// TestProvisioningURI ensures that the URI carries the secret and issuer.
+func TestProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("notes-app", "nonadmin", "ABCDEF")
+	AssertEqual(true, strings.HasPrefix(uri, "otpauth://totp/notes-app:nonadmin?"), t)
+	AssertContains(uri, "secret=ABCDEF", t)
+	AssertContains(uri, "issuer=notes-app", t)
+}
+
+// TestSecondFactorReplay ensures that a code is only accepted once, even by
+// logins that loaded the user's state before either of them recorded it.
+func TestSecondFactorReplay(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uID := ids["user.nonadmin"]
+	now := time.Now()
+
+	secret, err := BeginTOTPEnrollment(uID, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := TOTPCode(secret, TOTPStep(now))
+	_, err = ConfirmTOTPEnrollment(uID, code, now, db)
+	AssertEqual(nil, err, t)
+
+	// The code that confirmed the enrollment can't be used to log in.
+	ok, err := CheckSecondFactor(uID, code, "", now, db)
+	AssertEqual(nil, err, t)
+	AssertEqual(false, ok, t)
+
+	// The next one can, once.
+	code, _ = TOTPCode(secret, TOTPStep(now) + 1)
+	ok, err = CheckSecondFactor(uID, code, "", now, db)
+	AssertEqual(nil, err, t)
+	AssertEqual(true, ok, t)
+
+	// A concurrent login that validated the same code loses the race to
+	// record it.
+	ok, err = recordTOTPStep(uID, TOTPStep(now) + 1, db, "")
+	AssertEqual(nil, err, t)
+	AssertEqual(false, ok, t)
+
+	// Wrong codes are reported as such, not as database errors.
+	_, err = ConfirmTOTPEnrollment(uID, "000000", now, db)
+	AssertEqual(ErrInvalidTOTPCode, err, t)
+}
@@ -0,0 +1,220 @@
+package csnotes
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// How long a user has to enter their second factor after their password.
+	CHALLENGE_LIFETIME = time.Minute * 5
+
+	// The "purpose" claim of a challenge token. Tokens with any purpose are
+	// refused by the authentication middleware.
+	CHALLENGE_PURPOSE = "totp_challenge"
+
+	// How many recovery codes are issued at once.
+	RECOVERY_CODE_COUNT = 10
+)
+
+var (
+	ErrNoTOTPEnrollment = errors.New("No pending two-factor enrollment.")
+	ErrInvalidTOTPCode = errors.New("Invalid code.")
+)
+
+// TOTPStatus describes a user's two-factor state.
+type TOTPStatus struct {
+	Secret string
+	Enabled bool
+
+	// The time step of the last accepted code, to prevent replays.
+	LastStep int64
+}
+
+// LoadTOTPStatus retrieves a user's two-factor state.
+func LoadTOTPStatus(uID int64, db *sql.DB) (s TOTPStatus, err error) {
+	var secret sql.NullString
+	row := db.QueryRow("SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id=?", uID)
+	err = row.Scan(&secret, &s.Enabled, &s.LastStep)
+	s.Secret = secret.String
+
+	return
+}
+
+// BeginTOTPEnrollment stores a new, unconfirmed TOTP secret for a user. Two
+// factor authentication is not required until the secret is confirmed.
+func BeginTOTPEnrollment(uID int64, db *sql.DB) (string, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec("UPDATE users SET totp_secret=?, totp_enabled=FALSE, totp_last_step=0 WHERE id=?", secret, uID)
+
+	return secret, err
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication if the code
+// matches the pending secret, and returns a fresh set of recovery codes. The
+// user's credentials are marked as changed at now.
+func ConfirmTOTPEnrollment(uID int64, code string, now time.Time, db *sql.DB) (codes []string, err error) {
+	status, err := LoadTOTPStatus(uID, db)
+	if err != nil {
+		return
+	}
+	if len(status.Secret) == 0 {
+		err = ErrNoTOTPEnrollment
+		return
+	}
+
+	step, ok := ValidateTOTP(status.Secret, code, now, status.LastStep)
+	if !ok {
+		err = ErrInvalidTOTPCode
+		return
+	}
+
+	ok, err = recordTOTPStep(uID, step, db, "totp_enabled=TRUE, credentials_changed=?, ", now.Unix())
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = ErrInvalidTOTPCode
+		return
+	}
+
+	return GenerateRecoveryCodes(uID, db)
+}
+
+// ResetTOTP turns off two-factor authentication for a user, and discards
+// their secret and recovery codes. Their credentials are marked as changed at
+// now.
+func ResetTOTP(uID int64, now time.Time, db *sql.DB) error {
+	_, err := db.Exec("UPDATE users SET totp_secret=NULL, totp_enabled=FALSE, totp_last_step=0, credentials_changed=? WHERE id=?", now.Unix(), uID)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM recovery_codes WHERE user_id=?", uID)
+	return err
+}
+
+// GenerateRecoveryCodes replaces a user's recovery codes. Only hashes are
+// stored, so the returned codes cannot be shown again.
+func GenerateRecoveryCodes(uID int64, db *sql.DB) (codes []string, err error) {
+	codes = []string{}
+
+	_, err = db.Exec("DELETE FROM recovery_codes WHERE user_id=?", uID)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		var code string
+		code, err = RandomToken(5)
+		if err != nil {
+			return
+		}
+
+		// Format the code in two halves so it is easier to copy by hand.
+		code = code[:5] + "-" + code[5:]
+
+		_, err = db.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", uID, hashRecoveryCode(code))
+		if err != nil {
+			return
+		}
+		codes = append(codes, code)
+	}
+
+	return
+}
+
+// hashRecoveryCode hashes a recovery code for storage. Recovery codes are
+// random, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// UseRecoveryCode consumes one of a user's recovery codes. It returns false
+// if the code does not exist or was already used.
+func UseRecoveryCode(uID int64, code string, db *sql.DB) (bool, error) {
+	res, err := db.Exec("DELETE FROM recovery_codes WHERE user_id=? AND code_hash=?", uID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// CheckSecondFactor validates either a TOTP code or a recovery code for a
+// user who has two-factor authentication enabled.
+func CheckSecondFactor(uID int64, code, recoveryCode string, now time.Time, db *sql.DB) (bool, error) {
+	if len(recoveryCode) > 0 {
+		return UseRecoveryCode(uID, recoveryCode, db)
+	}
+
+	status, err := LoadTOTPStatus(uID, db)
+	if err != nil {
+		return false, err
+	}
+	if !status.Enabled {
+		return false, errors.New("Two-factor authentication is not enabled.")
+	}
+
+	step, ok := ValidateTOTP(status.Secret, code, now, status.LastStep)
+	if !ok {
+		return false, nil
+	}
+
+	return recordTOTPStep(uID, step, db, "")
+}
+
+// recordTOTPStep stores the step of an accepted code, along with any other
+// assignments and their arguments, so that the same code cannot be replayed.
+// It returns false if the step was already used, such as by a concurrent
+// login with the same code.
+func recordTOTPStep(uID int64, step int64, db *sql.DB, set string, args ...interface{}) (bool, error) {
+	res, err := db.Exec("UPDATE users SET " + set + "totp_last_step=? WHERE id=? AND totp_last_step<?", append(args, step, uID, step)...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// IssueChallenge creates a short-lived token proving that a user has given
+// their password, to be exchanged for a login token along with a second
+// factor.
+func (c *Context) IssueChallenge(uID int64) (string, error) {
+	return c.signClaims(jwt.MapClaims {
+		"user_id": uID,
+		"purpose": CHALLENGE_PURPOSE,
+	}, CHALLENGE_LIFETIME)
+}
+
+// ParseChallenge verifies a challenge token and returns its user ID.
+func (c *Context) ParseChallenge(tokenString string) (int64, error) {
+	claims, err := c.parseClaims(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	if purpose, _ := claims["purpose"].(string); purpose != CHALLENGE_PURPOSE {
+		return 0, errors.New("Not a challenge token.")
+	}
+
+	id, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, errors.New("Could not load user data from token.")
+	}
+
+	return int64(id), nil
+}
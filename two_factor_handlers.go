@@ -0,0 +1,189 @@
+package csnotes
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TOTPEnrollment is returned when a user begins enrolling in two-factor
+// authentication. The URI can be shown as a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI string `json:"uri"`
+}
+
+// RecoveryCodes is returned when two-factor authentication is confirmed. The
+// codes are only ever shown this once.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// PostUserTOTP begins two-factor enrollment for the logged in user. A new
+// secret is created, but it is not required at login until it is confirmed.
+func PostUserTOTP(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Retrieve the user ID.
+		uID, ok := GetURLID(r, &resp)
+		if !ok {
+			return
+		}
+
+		// Only users may enroll themselves.
+		currentUserID, _, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+		if currentUserID != uID {
+			resp.StatusCode = 403
+			resp.ErrorMessage = "Access denied. Must be logged in as this user."
+			return
+		}
+
+		u, err := LoadUser(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load user."
+			return
+		}
+
+		// Refuse to replace a secret that is already in use. An admin must
+		// reset it first.
+		status, err := LoadTOTPStatus(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not check two-factor status."
+			return
+		}
+		if status.Enabled {
+			resp.StatusCode = 409
+			resp.ErrorMessage = "Two-factor authentication is already enabled."
+			return
+		}
+
+		secret, err := BeginTOTPEnrollment(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not store two-factor secret."
+			return
+		}
+
+		issuer := context.Issuer
+		if len(issuer) == 0 {
+			issuer = "notes-app"
+		}
+		resp.Models = append(resp.Models, TOTPEnrollment {
+			Secret: secret,
+			URI: TOTPProvisioningURI(issuer, u.Username, secret),
+		})
+	}
+}
+
+// PostUserTOTPConfirm enables two-factor authentication once the user proves
+// their authenticator works, and returns their recovery codes.
+func PostUserTOTPConfirm(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		code := r.FormValue("code")
+		if len(code) == 0 {
+			resp.Fields["code"] = "Code must be specified."
+			return
+		}
+
+		// Retrieve the user ID.
+		uID, ok := GetURLID(r, &resp)
+		if !ok {
+			return
+		}
+
+		// Only users may confirm their own enrollment.
+		currentUserID, _, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+		if currentUserID != uID {
+			resp.StatusCode = 403
+			resp.ErrorMessage = "Access denied. Must be logged in as this user."
+			return
+		}
+
+		codes, err := ConfirmTOTPEnrollment(uID, code, context.Now(), context.DB)
+		switch err {
+		case nil:
+			resp.Models = append(resp.Models, RecoveryCodes{codes})
+		case ErrNoTOTPEnrollment, ErrInvalidTOTPCode:
+			resp.Fields["code"] = err.Error()
+		default:
+			fmt.Println(err)
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not confirm two-factor enrollment."
+		}
+	}
+}
+
+// DeleteUserTOTP resets a user's two-factor authentication, for when they
+// have lost their device and recovery codes. Only admins may do this.
+func DeleteUserTOTP(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		// Retrieve the user ID.
+		uID, ok := GetURLID(r, &resp)
+		if !ok {
+			return
+		}
+
+		// Make sure the logged in user is an admin.
+		_, currentUserAdmin, err := context.LoggedInUser(r)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not retrieve logged in user."
+			return
+		}
+		if !currentUserAdmin {
+			resp.StatusCode = 403
+			resp.ErrorMessage = "Only admins can reset two-factor authentication."
+			return
+		}
+
+		// Check for the user's existence.
+		if e, err := CheckExistence(uID, "users", context.DB); !e {
+			if err == nil {
+				resp.StatusCode = 404
+				resp.ErrorMessage = "User not found."
+			} else {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not verify user's existence."
+			}
+			return
+		}
+
+		err = ResetTOTP(uID, context.Now(), context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not reset two-factor authentication."
+			return
+		}
+
+		u, err := LoadUser(uID, context.DB)
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load user."
+			return
+		}
+
+		resp.Models = append(resp.Models, u)
+	}
+}
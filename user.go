@@ -8,6 +8,8 @@ type User struct {
 	Resource 
 	Name sql.NullString `json:"name"`
 	Username string `json:"username"`
+	Email sql.NullString `json:"email"`
+	EmailVerified bool `json:"email_verified"`
 	Admin bool `json:"admin"`
 }
 
@@ -24,6 +26,18 @@ func CheckUsernameExists(username string, db *sql.DB) (bool, error) {
 	return rows.Next(), nil
 }
 
+// LoadUserByEmail finds a user by their email address.
+func LoadUserByEmail(email string, db *sql.DB) (u User, err error) {
+	u = NewUser(db)
+	err = db.QueryRow("SELECT id FROM users WHERE email=?", email).Scan(&u.ID)
+	if err != nil {
+		return
+	}
+	err = u.Load()
+
+	return
+}
+
 func NewUser(db *sql.DB) (u User) {
 	return User {
 		Resource: Resource {
@@ -46,7 +60,7 @@ func LoadAllUsers(db *sql.DB) (us []User, err error) {
 	us = []User{}
 
 	// Query the database for users.
-	rows, err := db.Query("SELECT id, name, username, admin FROM users")
+	rows, err := db.Query("SELECT id, name, username, email, email_verified, admin FROM users")
 	if err != nil {
 		return
 	}
@@ -57,11 +71,13 @@ func LoadAllUsers(db *sql.DB) (us []User, err error) {
 		var id int64
 		var name sql.NullString
 		var username string
+		var email sql.NullString
+		var emailVerified bool
 		var admin bool
 
 		// Scan the data. If the user data could not be scanned, do not add
 		// a new model.
-		err = rows.Scan(&id, &name, &username, &admin)
+		err = rows.Scan(&id, &name, &username, &email, &emailVerified, &admin)
 		if err != nil {
 			continue
 		}
@@ -71,6 +87,8 @@ func LoadAllUsers(db *sql.DB) (us []User, err error) {
 		u.ID = id
 		u.Name = name
 		u.Username = username
+		u.Email = email
+		u.EmailVerified = emailVerified
 		u.Admin = admin
 
 		// Add the user model.
@@ -80,41 +98,53 @@ func LoadAllUsers(db *sql.DB) (us []User, err error) {
 	return
 }
 
-// ValidateUser takes a username and password and attempts to load a 
-// user model from this information. If the user could not be found, or if
-// the password is incorrect, an error is returned.
-func ValidateUser(username, password string, db *sql.DB) (u User, err error) {
-	// Create a new user model with an ID. If the user was not found,
-	// return an empty user and and error.
-	u = NewUser(db)
-	row := db.QueryRow("SELECT id FROM users WHERE username=?", username)
-	err = row.Scan(&u.ID)
-	if err != nil {
-		return u, err
-	}
+func (u *User) Load() error {
+	return u.Select([]string{"username", "name", "email", "email_verified", "admin"}, &u.Username, &u.Name, &u.Email, &u.EmailVerified, &u.Admin)
+}
+
+func (u *User) Save() error {
+	return u.Sync([]string{"username", "name", "email", "email_verified", "admin"}, u.Username, u.Name, u.Email, u.EmailVerified, u.Admin)
+}
+
+// ListNotes retrieves the user's notes as they are listed to them: pinned
+// notes first, then the rest, each oldest first. Archived notes are left out
+// unless asked for.
+func (u *User) ListNotes(archived bool) (ns []Note, err error) {
+	ns = []Note{}
 
-	// Validate the user's password. If the password is not valid, do not load 
-	// the model but return an error.
-	valid, err := CheckPassword(u.ID, password, db)
-	if !valid || err != nil {
-		return u, err
+	query := "SELECT id FROM notes WHERE user_id=?"
+	if !archived {
+		query += " AND archived=FALSE"
 	}
+	query += " ORDER BY pinned DESC, id"
 
-	// Load the user model.
-	err = u.Load()
+	rows, err := u.DB.Query(query, u.ID)
 	if err != nil {
-		return u, err
+		return
 	}
 
-	return
-}
+	// Collect the IDs first, so that the connection is free to load each
+	// note.
+	var ids []int64
+	for rows.Next() {
+		var nID int64
+		if err = rows.Scan(&nID); err != nil {
+			rows.Close()
+			return
+		}
+		ids = append(ids, nID)
+	}
+	rows.Close()
 
-func (u *User) Load() error {
-	return u.Select([]string{"username", "name", "admin"}, &u.Username, &u.Name, &u.Admin)
-}
+	for _, nID := range ids {
+		n, err := LoadNote(nID, u.DB)
+		if err != nil {
+			return ns, err
+		}
+		ns = append(ns, n)
+	}
 
-func (u *User) Save() error {
-	return u.Sync([]string{"username", "name", "admin"}, u.Username, u.Name, u.Admin)
+	return
 }
 
 func (u *User) Notes() (ns []Note, err error) {
@@ -1,6 +1,7 @@
 package csnotes
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
 )
@@ -17,21 +18,7 @@ func PostUser(context *Context) http.HandlerFunc {
 		password := r.FormValue("password")
 
 		// Validate the input data.
-		if len(username) < 8 {
-			resp.Fields["username"] = "Username must be longer than 8 characters."
-		}
-
-		if exists, err := CheckUsernameExists(username, context.DB); exists {
-			if err != nil {
-				resp.StatusCode = 500
-				resp.ErrorMessage = "Could not check for username existence."
-			}
-			resp.Fields["username"] = "Username already exists."
-		}
-
-		if len(password) < 8 {
-			resp.Fields["password"] = "Password must be longer than 8 characters."
-		}
+		validateNewUser(username, password, &resp, context.DB)
 
 		// If one or more fields were invalid, respond early.
 		if len(resp.Fields) > 0 {
@@ -57,7 +44,7 @@ func PostUser(context *Context) http.HandlerFunc {
 		}
 
 		// Hash and store the user's password.
-		err = StorePassword(u.ID, password, context.DB)
+		err = StorePassword(u.ID, password, context.Now(), context.DB)
 		if err != nil {
 			resp.StatusCode = 500
 			resp.ErrorMessage = "Could not store password."
@@ -74,6 +61,26 @@ func PostUser(context *Context) http.HandlerFunc {
 	}
 }
 
+// validateNewUser checks the username and password of a user about to be
+// created, and adds any problems to the response's fields.
+func validateNewUser(username, password string, resp *JSONResponse, db *sql.DB) {
+	if len(username) < 8 {
+		resp.Fields["username"] = "Username must be longer than 8 characters."
+	}
+
+	if exists, err := CheckUsernameExists(username, db); exists {
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not check for username existence."
+		}
+		resp.Fields["username"] = "Username already exists."
+	}
+
+	if len(password) < 8 {
+		resp.Fields["password"] = "Password must be longer than 8 characters."
+	}
+}
+
 // GetUsers retrieves all users and returns them as an array of user models.
 func GetUsers(context *Context) http.HandlerFunc {
 	return func (w http.ResponseWriter, r *http.Request) {
@@ -227,6 +234,12 @@ func GetUserNotes(context *Context) http.HandlerFunc {
 		resp := NewJSONResponse()
 		defer resp.Respond(w)
 
+		// Check whether the content is wanted as HTML.
+		renderHTML := readRenderOption(r, &resp)
+		if len(resp.Fields) > 0 {
+			return
+		}
+
 		// Get the user ID from the URL.
 		uID, ok := GetURLID(r, &resp)
 		if !ok {
@@ -268,16 +281,38 @@ func GetUserNotes(context *Context) http.HandlerFunc {
 			return
 		}
 
-		// Get the user's notes.
-		ns, err := u.Notes()
+		// Get the user's notes, pinned first. Archived notes are only
+		// included when asked for.
+		ns, err := u.ListNotes(r.FormValue("archived") == "true")
 		if err != nil {
 			resp.StatusCode = 500
 			resp.ErrorMessage = "Could not load notes."
 			return
 		}
 
-		// Add the notes to the response.
+		// Add the notes to the response, with their checklist progress and
+		// cover.
 		for _, n := range ns {
+			err = n.CountItems()
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not count note's items."
+				return
+			}
+			err = n.LoadCover()
+			if err != nil {
+				resp.StatusCode = 500
+				resp.ErrorMessage = "Could not load note's cover."
+				return
+			}
+			if renderHTML {
+				err = context.RenderNote(&n)
+				if err != nil {
+					resp.StatusCode = 500
+					resp.ErrorMessage = "Could not render note."
+					return
+				}
+			}
 			resp.Models = append(resp.Models, n)
 		}
 	}
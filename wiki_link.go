@@ -0,0 +1,201 @@
+package csnotes
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The longest reference a link may have, to fit its column.
+const MAX_LINK_REF_LENGTH = 191
+
+// wikiLinkPattern finds links such as [[Note Title]] or [[#12]].
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// NoteLink is a link from one note to another, written in the source note's
+// content. Links are stored by the target's ID once resolved, so they keep
+// working when the target is renamed.
+type NoteLink struct {
+	SourceID int64 `json:"source_id"`
+	SourceTitle string `json:"source_title"`
+
+	// The note linked to, or 0 if no note matches.
+	TargetID int64 `json:"target_id"`
+	TargetTitle string `json:"target_title"`
+
+	// What was written between the brackets: a title, or "#" and an ID.
+	Ref string `json:"ref"`
+
+	// Whether the link leads nowhere, because no note matched or the note
+	// it led to was deleted.
+	Broken bool `json:"broken"`
+}
+
+// ParseWikiLinks finds the references of the links in some content, in
+// order. Each reference is only given once, ignoring case.
+func ParseWikiLinks(content string) (refs []string) {
+	seen := map[string]bool{}
+
+	for _, m := range wikiLinkPattern.FindAllStringSubmatch(content, -1) {
+		ref := strings.Join(strings.Fields(m[1]), " ")
+		if len(ref) == 0 || len(ref) > MAX_LINK_REF_LENGTH {
+			continue
+		}
+
+		key := strings.ToLower(ref)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		refs = append(refs, ref)
+	}
+
+	return
+}
+
+// linkRefID reads the note ID of a reference such as "#12".
+func linkRefID(ref string) (int64, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(ref[1:], 10, 64)
+	return id, err == nil && id > 0
+}
+
+// SyncLinks replaces the note's links with those in its content, and
+// resolves other notes' broken links that name the note's title. Links only
+// resolve to notes of the same owner.
+func (n *Note) SyncLinks() error {
+	tx, err := n.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = n.syncLinks(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// syncLinks syncs the note's links within a transaction, such as the one that
+// saves the note.
+func (n *Note) syncLinks(tx *sql.Tx) error {
+	_, err := tx.Exec("DELETE FROM note_links WHERE source_id=?", n.ID)
+	if err != nil {
+		return err
+	}
+
+	var refs []string
+	if n.Content.Valid {
+		refs = ParseWikiLinks(n.Content.String)
+	}
+
+	for _, ref := range refs {
+		var target sql.NullInt64
+		if id, ok := linkRefID(ref); ok {
+			err = tx.QueryRow("SELECT id FROM notes WHERE id=? AND user_id=?", id, n.UserID).Scan(&target)
+		} else {
+			err = tx.QueryRow("SELECT id FROM notes WHERE title=? AND user_id=? ORDER BY id LIMIT 1", ref, n.UserID).Scan(&target)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO note_links (source_id, target_id, ref) VALUES (?, ?, ?)", n.ID, target, ref)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Links written before this note had its title now lead to it.
+	_, err = tx.Exec(`UPDATE note_links l JOIN notes s ON s.id=l.source_id
+		SET l.target_id=?
+		WHERE l.target_id IS NULL AND l.ref=? AND s.user_id=?`, n.ID, n.Title, n.UserID)
+	return err
+}
+
+// RemoveLinks deletes the note's links. Links to the note by title are
+// left broken, to be resolved again by another note with that title; links
+// to it by ID stay broken.
+func (n *Note) RemoveLinks() error {
+	_, err := n.DB.Exec("DELETE FROM note_links WHERE source_id=?", n.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.DB.Exec("UPDATE note_links SET target_id=NULL WHERE target_id=? AND ref NOT LIKE '#%'", n.ID)
+	return err
+}
+
+// Links retrieves the links written in the note, in order, with broken
+// links marked.
+func (n *Note) Links() (ls []NoteLink, err error) {
+	ls = []NoteLink{}
+
+	rows, err := n.DB.Query(`SELECT l.ref, l.target_id, t.title FROM note_links l
+		LEFT JOIN notes t ON t.id=l.target_id
+		WHERE l.source_id=? ORDER BY l.id`, n.ID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var targetID sql.NullInt64
+		var targetTitle sql.NullString
+		l := NoteLink {
+			SourceID: n.ID,
+			SourceTitle: n.Title,
+		}
+
+		err = rows.Scan(&l.Ref, &targetID, &targetTitle)
+		if err != nil {
+			return
+		}
+
+		// A link is broken if it never matched a note, or its note is gone.
+		l.Broken = !targetTitle.Valid
+		if !l.Broken {
+			l.TargetID = targetID.Int64
+			l.TargetTitle = targetTitle.String
+		}
+
+		ls = append(ls, l)
+	}
+
+	return ls, rows.Err()
+}
+
+// Backlinks retrieves the links to the note from other notes.
+func (n *Note) Backlinks() (ls []NoteLink, err error) {
+	ls = []NoteLink{}
+
+	rows, err := n.DB.Query(`SELECT l.source_id, s.title, l.ref FROM note_links l
+		JOIN notes s ON s.id=l.source_id
+		WHERE l.target_id=? ORDER BY l.source_id`, n.ID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l := NoteLink {
+			TargetID: n.ID,
+			TargetTitle: n.Title,
+		}
+
+		err = rows.Scan(&l.SourceID, &l.SourceTitle, &l.Ref)
+		if err != nil {
+			return
+		}
+
+		ls = append(ls, l)
+	}
+
+	return ls, rows.Err()
+}
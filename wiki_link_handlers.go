@@ -0,0 +1,58 @@
+package csnotes
+
+import (
+	"net/http"
+)
+
+// GetNoteLinks retrieves the links written in a note, with links that lead
+// nowhere marked as broken.
+func GetNoteLinks(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		ls, err := n.Links()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load links."
+			return
+		}
+
+		// Add the links to the response.
+		for _, l := range ls {
+			resp.Models = append(resp.Models, l)
+		}
+	}
+}
+
+// GetNoteBacklinks retrieves the links to a note from other notes.
+func GetNoteBacklinks(context *Context) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		// Create the response.
+		resp := NewJSONResponse()
+		defer resp.Respond(w)
+
+		n, ok := loadOwnedNote(context, r, &resp)
+		if !ok {
+			return
+		}
+
+		ls, err := n.Backlinks()
+		if err != nil {
+			resp.StatusCode = 500
+			resp.ErrorMessage = "Could not load backlinks."
+			return
+		}
+
+		// Add the links to the response.
+		for _, l := range ls {
+			resp.Models = append(resp.Models, l)
+		}
+	}
+}
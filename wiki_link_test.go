@@ -0,0 +1,92 @@
+package csnotes
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseWikiLinks(t *testing.T) {
+	refs := ParseWikiLinks("See [[Shopping List]] and [[#12]].\nAlso [[ shopping   list ]], [[]] and [[Other\nNote]].")
+	AssertEqual(len(refs), 2, t)
+	if len(refs) == 2 {
+		AssertEqual(refs[0], "Shopping List", t)
+		AssertEqual(refs[1], "#12", t)
+	}
+
+	AssertEqual(len(ParseWikiLinks("No links [here].")), 0, t)
+
+	id, ok := linkRefID("#12")
+	AssertEqual(ok, true, t)
+	AssertEqual(id, int64(12), t)
+	_, ok = linkRefID("#twelve")
+	AssertEqual(ok, false, t)
+	_, ok = linkRefID("12")
+	AssertEqual(ok, false, t)
+}
+
+func TestNoteLinks(t *testing.T) {
+	db, ids, err := SeededTestDB()
+	defer TearDownDbTest(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note1, _ := LoadNote(ids["note.note1"], db)
+	note2, _ := LoadNote(ids["note.note2"], db)
+
+	// Link by title, by ID, and to a note that doesn't exist yet.
+	note1.Content.String = "[[note2]] [[#" + strconv.FormatInt(note2.ID, 10) + "]] [[Later]]"
+	note1.Content.Valid = true
+	AssertEqual(note1.SyncLinks(), nil, t)
+
+	ls, err := note1.Links()
+	AssertEqual(err, nil, t)
+	AssertEqual(len(ls), 3, t)
+	if len(ls) == 3 {
+		AssertEqual(ls[0].TargetID, note2.ID, t)
+		AssertEqual(ls[1].TargetID, note2.ID, t)
+		AssertEqual(ls[2].Broken, true, t)
+	}
+
+	bs, err := note2.Backlinks()
+	AssertEqual(err, nil, t)
+	AssertEqual(len(bs), 2, t)
+
+	// Links follow a renamed note.
+	note2.Title = "renamed"
+	note2.Save(time.Now())
+	AssertEqual(note2.SyncLinks(), nil, t)
+	ls, _ = note1.Links()
+	AssertEqual(ls[0].TargetTitle, "renamed", t)
+	AssertEqual(ls[0].Broken, false, t)
+
+	// A new note resolves links waiting for its title.
+	later := NewNote(db)
+	later.Title = "Later"
+	later.UserID = ids["user.nonadmin"]
+	later.Save(time.Now())
+	AssertEqual(later.SyncLinks(), nil, t)
+	ls, _ = note1.Links()
+	AssertEqual(ls[2].TargetID, later.ID, t)
+	AssertEqual(ls[2].Broken, false, t)
+
+	// Deleting a note breaks the links to it.
+	note2.Delete()
+	AssertEqual(note2.RemoveLinks(), nil, t)
+	ls, _ = note1.Links()
+	AssertEqual(ls[0].Broken, true, t)
+	AssertEqual(ls[1].Broken, true, t)
+
+	// Links never lead to another user's notes.
+	other := NewNote(db)
+	other.Title = "theirs"
+	other.UserID = ids["user.admin"]
+	other.Save(time.Now())
+	note1.Content.String = "[[theirs]] [[#" + strconv.FormatInt(other.ID, 10) + "]]"
+	AssertEqual(note1.SyncLinks(), nil, t)
+	ls, _ = note1.Links()
+	for _, l := range ls {
+		AssertEqual(l.Broken, true, t)
+	}
+}